@@ -0,0 +1,263 @@
+// Package client connects Go programs to an mkdb server using the protocol
+// described in package wire.
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+
+	"github.com/mk6i/mkdb/wire"
+)
+
+// Error is an error the server reported for a query.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Conn is a connection to an mkdb server. Each connection has its own
+// session on the server, so statements such as USE and BEGIN only affect
+// the connection they're run on. A Conn must not be used concurrently.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	// rows is the result set currently being read, if any.
+	rows *Rows
+	// err is set once the connection can no longer be used.
+	err error
+}
+
+// Dial connects to the server listening on address of the given network,
+// which is either tcp or unix.
+func Dial(network, address string) (*Conn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	c := &Conn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+	if err := c.waitReady(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close ends the connection's session and closes the connection.
+func (c *Conn) Close() error {
+	if c.err == nil {
+		if err := wire.WriteFrame(c.w, wire.MsgTerminate, nil); err == nil {
+			c.w.Flush()
+		}
+		c.err = net.ErrClosed
+	}
+	return c.conn.Close()
+}
+
+// Exec runs a statement and returns its status message. Rows returned by the
+// statement are discarded.
+func (c *Conn) Exec(q string) (string, error) {
+	rows, err := c.Query(q)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+	}
+	return rows.Message(), rows.Err()
+}
+
+// Query runs a statement and returns its result set. Rows are read from the
+// connection as the caller iterates over them, so the result set must be
+// consumed or closed before the connection is used for anything else;
+// starting another query closes it implicitly.
+func (c *Conn) Query(q string) (*Rows, error) {
+	if c.rows != nil {
+		if err := c.rows.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if err := wire.WriteFrame(c.w, wire.MsgQuery, []byte(q)); err != nil {
+		return nil, c.fail(err)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, c.fail(err)
+	}
+
+	t, payload, err := wire.ReadFrame(c.r)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+
+	rows := &Rows{c: c}
+
+	switch t {
+	case wire.MsgColumns:
+		cols, err := wire.DecodeColumns(payload)
+		if err != nil {
+			return nil, c.fail(err)
+		}
+		rows.cols = cols
+		c.rows = rows
+	case wire.MsgComplete:
+		rows.msg = string(payload)
+		rows.done = true
+		if err := c.waitReady(); err != nil {
+			return nil, err
+		}
+	case wire.MsgError:
+		if err := c.waitReady(); err != nil {
+			return nil, err
+		}
+		return nil, &Error{Message: string(payload)}
+	default:
+		return nil, c.fail(fmt.Errorf("unexpected message type %q", byte(t)))
+	}
+
+	return rows, nil
+}
+
+// waitReady reads the server's Ready message.
+func (c *Conn) waitReady() error {
+	t, _, err := wire.ReadFrame(c.r)
+	if err != nil {
+		return c.fail(err)
+	}
+	if t != wire.MsgReady {
+		return c.fail(fmt.Errorf("expected ready message, got %q", byte(t)))
+	}
+	return nil
+}
+
+// fail marks the connection as broken. The protocol can't recover from a
+// failed read or write, since the stream position is unknown afterwards.
+func (c *Conn) fail(err error) error {
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	return err
+}
+
+// Rows is the result set of a query.
+type Rows struct {
+	c    *Conn
+	cols []wire.Column
+	vals []interface{}
+	msg  string
+	err  error
+	done bool
+}
+
+// Columns returns the names of the result set's columns. It returns nil for
+// statements that don't return rows.
+func (r *Rows) Columns() []string {
+	if r.cols == nil {
+		return nil
+	}
+	names := make([]string, len(r.cols))
+	for i, col := range r.cols {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// ColumnTypes returns the type tags of the result set's columns, e.g.
+// wire.TypeInt, which tell the type of a column's values even if the result
+// set has no rows. It returns nil for statements that don't return rows.
+func (r *Rows) ColumnTypes() []byte {
+	if r.cols == nil {
+		return nil
+	}
+	types := make([]byte, len(r.cols))
+	for i, col := range r.cols {
+		types[i] = col.Type
+	}
+	return types
+}
+
+// Next advances to the next row and returns false once there are no rows
+// left or an error occurred.
+func (r *Rows) Next() bool {
+	if r.done {
+		return false
+	}
+
+	t, payload, err := wire.ReadFrame(r.c.r)
+	if err != nil {
+		r.finish(r.c.fail(err))
+		return false
+	}
+
+	switch t {
+	case wire.MsgRow:
+		vals, err := wire.DecodeRow(payload)
+		if err != nil {
+			r.finish(r.c.fail(err))
+			return false
+		}
+		r.vals = vals
+		return true
+	case wire.MsgComplete:
+		r.msg = string(payload)
+		r.finish(r.c.waitReady())
+	case wire.MsgError:
+		if err := r.c.waitReady(); err != nil {
+			r.finish(err)
+		} else {
+			r.finish(&Error{Message: string(payload)})
+		}
+	default:
+		r.finish(r.c.fail(fmt.Errorf("unexpected message type %q", byte(t))))
+	}
+
+	return false
+}
+
+// Values returns the values of the current row. Each value is nil, int64,
+// float64, storage.Decimal, storage.Date, storage.Time, storage.Timestamp,
+// storage.Interval, string or bool.
+func (r *Rows) Values() []interface{} {
+	return r.vals
+}
+
+// Err returns the error, if any, that ended the iteration.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Message returns the statement's status message. It is set once Next has
+// returned false.
+func (r *Rows) Message() string {
+	return r.msg
+}
+
+// Close discards the rows that haven't been read yet.
+func (r *Rows) Close() error {
+	for r.Next() {
+	}
+	if _, ok := r.err.(*Error); ok {
+		return nil
+	}
+	return r.err
+}
+
+func (r *Rows) finish(err error) {
+	r.done = true
+	r.vals = nil
+	r.err = err
+	if r.c.rows == r {
+		r.c.rows = nil
+	}
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mk6i/mkdb/server"
+	"github.com/mk6i/mkdb/storage"
+	"github.com/mk6i/mkdb/wire"
+)
+
+func startServer(t *testing.T, network, address string) (*server.Server, net.Addr) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New()
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+	return srv, l.Addr()
+}
+
+func dial(t *testing.T, addr net.Addr) *Conn {
+	c, err := Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func exec(t *testing.T, c *Conn, queries ...string) {
+	for _, q := range queries {
+		if _, err := c.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+}
+
+func query(t *testing.T, c *Conn, q string) ([]string, [][]interface{}) {
+	rows, err := c.Query(q)
+	if err != nil {
+		t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+	}
+	var vals [][]interface{}
+	for rows.Next() {
+		vals = append(vals, rows.Values())
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("error reading rows:\n %s\nError: %s", q, err.Error())
+	}
+	return rows.Columns(), vals
+}
+
+func TestQuery(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	_, addr := startServer(t, "tcp", "localhost:0")
+	c := dial(t, addr)
+
+	exec(t, c,
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (person_id int, first_name varchar(255), is_admin boolean)`,
+	)
+
+	msg, err := c.Exec(`INSERT INTO people VALUES (1, 'John', true), (2, 'Jane', false)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "inserted 2 record(s) into people" {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	rows, err := c.Query(`SELECT person_id, first_name, is_admin FROM people ORDER BY person_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"person_id", "first_name", "is_admin"}; !reflect.DeepEqual(expected, rows.Columns()) {
+		t.Errorf("expected columns %v, got %v", expected, rows.Columns())
+	}
+	if expected := []byte{wire.TypeInt, wire.TypeString, wire.TypeBool}; !reflect.DeepEqual(expected, rows.ColumnTypes()) {
+		t.Errorf("expected column types %v, got %v", expected, rows.ColumnTypes())
+	}
+	var vals [][]interface{}
+	for rows.Next() {
+		vals = append(vals, rows.Values())
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{{int64(1), "John", true}, {int64(2), "Jane", false}}
+	if !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expected rows %v, got %v", expected, vals)
+	}
+	if rows.Message() != "2 result(s) returned" {
+		t.Errorf("unexpected message %q", rows.Message())
+	}
+
+	// column types are sent even if there are no rows
+	rows, err = c.Query(`SELECT is_admin, first_name FROM people WHERE person_id = 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Errorf("unexpected row %v", rows.Values())
+	}
+	if expected := []byte{wire.TypeBool, wire.TypeString}; !reflect.DeepEqual(expected, rows.ColumnTypes()) {
+		t.Errorf("expected column types %v, got %v", expected, rows.ColumnTypes())
+	}
+
+	// an abandoned result set is drained by the next query
+	if _, err := c.Query(`SELECT person_id FROM people`); err != nil {
+		t.Fatal(err)
+	}
+	if _, vals := query(t, c, `SELECT first_name FROM people WHERE person_id = 2`); !reflect.DeepEqual([][]interface{}{{"Jane"}}, vals) {
+		t.Errorf("unexpected rows %v", vals)
+	}
+
+	// errors are reported without breaking the connection
+	var srvErr *Error
+	if _, err := c.Exec(`SELECT * FROM cars`); !errors.As(err, &srvErr) || srvErr.Message != storage.ErrTableNotExist.Error() {
+		t.Errorf("expected server error %q, got %v", storage.ErrTableNotExist, err)
+	}
+	if _, err := c.Exec(`SELEC`); !errors.As(err, &srvErr) {
+		t.Errorf("expected server error, got %v", err)
+	}
+	if _, vals := query(t, c, `SELECT person_id FROM people WHERE person_id = 1`); !reflect.DeepEqual([][]interface{}{{int64(1)}}, vals) {
+		t.Errorf("unexpected rows %v", vals)
+	}
+}
+
+func TestSharedDatabase(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	_, addr := startServer(t, "unix", filepath.Join(t.TempDir(), "mkdb.sock"))
+	c1 := dial(t, addr)
+	c2 := dial(t, addr)
+
+	exec(t, c1,
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (person_id int, first_name varchar(255))`,
+		`INSERT INTO people VALUES (1, 'John')`,
+	)
+	exec(t, c2, `USE testdb`)
+
+	if _, vals := query(t, c2, `SELECT first_name FROM people`); !reflect.DeepEqual([][]interface{}{{"John"}}, vals) {
+		t.Errorf("unexpected rows %v", vals)
+	}
+
+	exec(t, c1,
+		`BEGIN`,
+		`INSERT INTO people VALUES (2, 'Jane')`,
+	)
+
+	// the second session waits for the first one's transaction to end
+	done := make(chan error)
+	go func() {
+		_, err := c2.Exec(`INSERT INTO people VALUES (3, 'Jim')`)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected insert to wait for the open transaction, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	exec(t, c1, `ROLLBACK`)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	_, vals := query(t, c1, `SELECT person_id FROM people ORDER BY person_id`)
+	if expected := [][]interface{}{{int64(1)}, {int64(3)}}; !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expected rows %v, got %v", expected, vals)
+	}
+
+	// closing a connection rolls back its open transaction
+	exec(t, c2,
+		`BEGIN`,
+		`INSERT INTO people VALUES (4, 'Joe')`,
+	)
+	if err := c2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, vals = query(t, c1, `SELECT person_id FROM people ORDER BY person_id`)
+	if expected := [][]interface{}{{int64(1)}, {int64(3)}}; !reflect.DeepEqual(expected, vals) {
+		t.Errorf("expected rows %v, got %v", expected, vals)
+	}
+}
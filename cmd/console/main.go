@@ -68,9 +68,12 @@ func runTerminal(sess *engine.Session) error {
 		}
 
 		for _, query := range lines {
-			if err := sess.ExecQuery(query); err != nil {
+			res, err := sess.Exec(query)
+			if err != nil {
 				fmt.Printf("error: %s\n\r", err.Error())
+				continue
 			}
+			printResult(res)
 		}
 	}
 
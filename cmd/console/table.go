@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mk6i/mkdb/engine"
+)
+
+// printResult prints the rows returned by a query as a table, followed by
+// the statement's status message.
+func printResult(res *engine.Result) {
+	if res.Columns != nil {
+		printTable(res)
+		fmt.Print("\n\r")
+	}
+	fmt.Printf("%s\n\r", res.Message)
+}
+
+func printTable(res *engine.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+
+	fmt.Print("\n\r\n\r")
+
+	for _, col := range res.Columns {
+		fmt.Fprintf(w, "| [%s]\t", col.Name)
+	}
+
+	fmt.Fprint(w, "|\n\r")
+
+	for range res.Columns {
+		fmt.Fprint(w, "| --------------------\t")
+	}
+
+	fmt.Fprint(w, "|\n\r")
+
+	for _, row := range res.Rows {
+		for _, elem := range row {
+			if elem == nil {
+				elem = "NULL"
+			}
+			fmt.Fprintf(w, "| %v\t", elem)
+		}
+		fmt.Fprint(w, "|\n\r")
+	}
+
+	w.Flush()
+}
@@ -293,7 +293,43 @@ func csvToSql(cfg importCfg, csvRow []string) ([]interface{}, error) {
 			default:
 				return sqlRow, fmt.Errorf("unable to parse bool value `%s`", csvRow[csvIdx])
 			}
-		case storage.TypeVarchar:
+		case storage.TypeReal, storage.TypeDouble:
+			val, err := strconv.ParseFloat(csvRow[csvIdx], 64)
+			if err != nil {
+				return sqlRow, err
+			}
+			sqlRow[i] = val
+		case storage.TypeDecimal:
+			val, err := storage.ParseDecimal(csvRow[csvIdx])
+			if err != nil {
+				return sqlRow, err
+			}
+			sqlRow[i] = val
+		case storage.TypeDate:
+			val, err := storage.ParseDate(csvRow[csvIdx])
+			if err != nil {
+				return sqlRow, err
+			}
+			sqlRow[i] = val
+		case storage.TypeTime:
+			val, err := storage.ParseTime(csvRow[csvIdx])
+			if err != nil {
+				return sqlRow, err
+			}
+			sqlRow[i] = val
+		case storage.TypeTimestamp:
+			val, err := storage.ParseTimestamp(csvRow[csvIdx])
+			if err != nil {
+				return sqlRow, err
+			}
+			sqlRow[i] = val
+		case storage.TypeInterval:
+			val, err := storage.ParseInterval(csvRow[csvIdx])
+			if err != nil {
+				return sqlRow, err
+			}
+			sqlRow[i] = val
+		case storage.TypeVarchar, storage.TypeText, storage.TypeBlob:
 			sqlRow[i] = csvRow[csvIdx]
 		}
 	}
@@ -12,24 +12,30 @@ import (
 
 type mockRelationManager struct {
 	createTable   func(r *storage.Relation, tableName string) error
-	markDeleted   func(tableName string, rowID uint32) (storage.WALBatch, error)
+	markDeleted   func(tableName string, key []byte) (storage.WALBatch, error)
 	fetch         func(tableName string) ([]*storage.Row, []*storage.Field, error)
-	update        func(tableName string, rowID uint32, cols []string, updateSrc []interface{}) (storage.WALBatch, error)
+	update        func(tableName string, key []byte, cols []string, updateSrc []interface{}) (storage.WALBatch, error)
 	insert        func(tableName string, cols []string, vals []interface{}) (storage.WALBatch, error)
 	flushWALBatch func(batch storage.WALBatch) error
+	createIndex   func(idx *storage.Index) error
+	dropIndex     func(indexName string) error
+	indexes       func(tableName string) ([]*storage.Index, error)
+	fetchByIndex  func(idx *storage.Index, vals []interface{}) ([]*storage.Row, []*storage.Field, error)
+	tableStats    func(tableName string) (storage.TableStats, error)
 }
 
 func (m *mockRelationManager) CreateTable(r *storage.Relation, tableName string) error {
 	return m.createTable(r, tableName)
 }
-func (m *mockRelationManager) MarkDeleted(tableName string, rowID uint32) (storage.WALBatch, error) {
-	return m.markDeleted(tableName, rowID)
+func (m *mockRelationManager) MarkDeleted(tableName string, key []byte) (storage.WALBatch, error) {
+	return m.markDeleted(tableName, key)
 }
-func (m *mockRelationManager) Fetch(tableName string) ([]*storage.Row, []*storage.Field, error) {
-	return m.fetch(tableName)
+func (m *mockRelationManager) Scan(tableName string) (storage.RowIterator, []*storage.Field, error) {
+	rows, fields, err := m.fetch(tableName)
+	return &rowSlice{rows: rows}, fields, err
 }
-func (m *mockRelationManager) Update(tableName string, rowID uint32, cols []string, updateSrc []interface{}) (storage.WALBatch, error) {
-	return m.update(tableName, rowID, cols, updateSrc)
+func (m *mockRelationManager) Update(tableName string, key []byte, cols []string, updateSrc []interface{}) (storage.WALBatch, error) {
+	return m.update(tableName, key, cols, updateSrc)
 }
 func (m *mockRelationManager) Insert(tableName string, cols []string, vals []interface{}) (storage.WALBatch, error) {
 	return m.insert(tableName, cols, vals)
@@ -37,10 +43,113 @@ func (m *mockRelationManager) Insert(tableName string, cols []string, vals []int
 func (m *mockRelationManager) FlushWALBatch(batch storage.WALBatch) error {
 	return m.flushWALBatch(batch)
 }
-func (m *mockRelationManager) StartTxn() {
+func (m *mockRelationManager) CreateIndex(idx *storage.Index) error {
+	return m.createIndex(idx)
+}
+func (m *mockRelationManager) DropIndex(indexName string) error {
+	return m.dropIndex(indexName)
+}
+func (m *mockRelationManager) Indexes(tableName string) ([]*storage.Index, error) {
+	if m.indexes == nil {
+		return nil, nil
+	}
+	return m.indexes(tableName)
+}
+func (m *mockRelationManager) ScanByIndex(idx *storage.Index, vals []interface{}) (storage.RowIterator, []*storage.Field, error) {
+	rows, fields, err := m.fetchByIndex(idx, vals)
+	return &rowSlice{rows: rows}, fields, err
+}
+func (m *mockRelationManager) ScanByIndexRange(idx *storage.Index, vals []interface{}, rng storage.KeyRange) (storage.RowIterator, []*storage.Field, error) {
+	return m.ScanByIndex(idx, vals)
+}
+
+func (m *mockRelationManager) SeekRowID(tableName string, rowID uint32) (storage.RowIterator, []*storage.Field, error) {
+	rows, fields, err := m.fetch(tableName)
+	it := &rowSlice{}
+	for _, row := range rows {
+		if row.RowID == rowID {
+			it.rows = append(it.rows, row)
+		}
+	}
+	return it, fields, err
+}
+func (m *mockRelationManager) TableStats(tableName string) (storage.TableStats, error) {
+	if m.tableStats != nil {
+		return m.tableStats(tableName)
+	}
+	if m.fetch == nil {
+		return storage.TableStats{}, nil
+	}
+	rows, _, err := m.fetch(tableName)
+	return storage.TableStats{RowCount: int64(len(rows))}, err
+}
+
+// rowSlice iterates over rows returned by a mock fetch.
+type rowSlice struct {
+	rows []*storage.Row
+}
+
+func (r *rowSlice) Next() (*storage.Row, error) {
+	if len(r.rows) == 0 {
+		return nil, nil
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func (m *mockRelationManager) Analyze(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) Schema(tableName string) (*storage.Relation, error) {
+	return &storage.Relation{}, nil
+}
+
+func (m *mockRelationManager) ReferencingKeys(tableName string) ([]*storage.ForeignKey, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) AddColumn(tableName string, fd storage.FieldDef) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) DropColumn(tableName string, col string) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) RenameColumn(tableName string, oldName string, newName string) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) RenameTable(oldName string, newName string) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) UpdateCheck(check *storage.Check) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) DropTable(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) TruncateTable(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) Vacuum(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) StartTxn() error {
+	return nil
 }
 func (m *mockRelationManager) EndTxn() {
 }
+func (m *mockRelationManager) AbortStmt() error {
+	return nil
+}
 
 func TestGetDataTypes(t *testing.T) {
 	rm := &mockRelationManager{
@@ -180,3 +289,33 @@ func TestCSVImport(t *testing.T) {
 		t.Fatalf("total csv errors does not match expected count. expected: %d actual: %d", len(badRows), totalErr)
 	}
 }
+
+func TestCSVToSQLTemporal(t *testing.T) {
+	cfg := importCfg{
+		srcCols: []int{0, 1, 2, 3},
+		colTypes: []storage.DataType{
+			storage.TypeDate,
+			storage.TypeTime,
+			storage.TypeTimestamp,
+			storage.TypeInterval,
+		},
+	}
+
+	row, err := csvToSql(cfg, []string{"2026-01-02", "13:45:30", "2026-01-02T13:45:30Z", "1 day 02:00:00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{
+		storage.Date(20455),
+		storage.Time(49530000000),
+		storage.Timestamp(1767361530000000),
+		storage.Interval{Days: 1, Micros: 7200000000},
+	}
+	if !reflect.DeepEqual(expected, row) {
+		t.Errorf("expected %v, got %v", expected, row)
+	}
+
+	if _, err := csvToSql(cfg, []string{"2026-13-01", "", "", ""}); !errors.Is(err, storage.ErrInvalidDatetime) {
+		t.Errorf("expected ErrInvalidDatetime error, got %v", err)
+	}
+}
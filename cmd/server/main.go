@@ -0,0 +1,130 @@
+/*
+Server serves mkdb databases to clients over the network.
+
+Each connection gets its own session. Clients speak the framed protocol
+described in package wire; package client implements it for Go programs.
+PostgreSQL clients are served on a separate address.
+
+Usage:
+
+	server [arguments]
+
+The arguments are:
+
+	-addr (optional)
+		The address to listen on. For tcp this is a host:port pair, for unix
+		the path of the socket file. By default listen on localhost:7070.
+
+	-autovacuum (optional)
+		How often to vacuum the tables of the open databases that have many
+		deleted rows, such as 1m. By default tables are only vacuumed by the
+		VACUUM statement.
+
+	-network (optional)
+		The network to listen on, either tcp or unix. By default use tcp.
+
+	-pg-addr (optional)
+		If set, also listen on this TCP address for clients that speak the
+		PostgreSQL wire protocol, such as psql.
+
+	-verbose (optional)
+		If set, log storage engine diagnostics to stderr.
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/mk6i/mkdb/server"
+	"github.com/mk6i/mkdb/storage"
+)
+
+var (
+	cfgAddr    = flag.String("addr", "localhost:7070", "Address to listen on")
+	cfgNetwork = flag.String("network", "tcp", "Network to listen on (tcp or unix)")
+	cfgPGAddr  = flag.String("pg-addr", "", "TCP address to listen on for PostgreSQL clients")
+	cfgVerbose = flag.Bool("verbose", false, "Log storage engine diagnostics to stderr")
+	cfgVacuum  = flag.Duration("autovacuum", 0, "How often to vacuum tables with many deleted rows (0 disables)")
+)
+
+func main() {
+	flag.Parse()
+
+	if *cfgNetwork != "tcp" && *cfgNetwork != "unix" {
+		fmt.Printf("unsupported network %s\n", *cfgNetwork)
+		os.Exit(1)
+	}
+
+	if *cfgVerbose {
+		storage.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	}
+
+	if err := storage.InitStorage(); err != nil {
+		fmt.Printf("storage init error: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	l, err := net.Listen(*cfgNetwork, *cfgAddr)
+	if err != nil {
+		fmt.Printf("error listening on %s: %s\n", *cfgAddr, err.Error())
+		os.Exit(1)
+	}
+
+	var pgl net.Listener
+	if *cfgPGAddr != "" {
+		pgl, err = net.Listen("tcp", *cfgPGAddr)
+		if err != nil {
+			fmt.Printf("error listening on %s: %s\n", *cfgPGAddr, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	srv := server.New()
+	if *cfgVacuum > 0 {
+		srv.AutoVacuum(*cfgVacuum)
+	}
+
+	shutdownHandler(func() {
+		if err := srv.Close(); err != nil {
+			fmt.Printf("error shutting down: %s\n", err.Error())
+		}
+	})
+
+	fmt.Printf("listening on %s %s\n", *cfgNetwork, l.Addr())
+
+	if pgl != nil {
+		fmt.Printf("listening for postgres clients on %s\n", pgl.Addr())
+		go func() {
+			if err := srv.ServePG(pgl); err != nil && !errors.Is(err, server.ErrServerClosed) {
+				fmt.Printf("error: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if err := srv.Serve(l); err != nil && !errors.Is(err, server.ErrServerClosed) {
+		fmt.Printf("error: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func shutdownHandler(fn func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch,
+		syscall.SIGHUP,
+		syscall.SIGINT,
+		syscall.SIGTERM,
+		syscall.SIGQUIT)
+	go func() {
+		<-ch
+		fn()
+		os.Exit(0)
+	}()
+}
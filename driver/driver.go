@@ -0,0 +1,364 @@
+// Package driver registers mkdb as a database/sql driver named "mkdb".
+//
+// The data source name is the path of the data directory, optionally followed
+// by the database to select:
+//
+//	db, err := sql.Open("mkdb", "path/to/data?db=testdb")
+//
+// The data directory is shared by the whole process, so every data source
+// name opened in a process must use the same path. Statements accept `?` and
+// `$n` parameters, which are bound to int64, float64, string, []byte, bool
+// and time.Time arguments. DATE and TIMESTAMP values are scanned as
+// time.Time in UTC, TIME and INTERVAL values as text.
+//
+// Connections to the same database take turns running statements, and a
+// connection with an open transaction keeps its turn until the transaction
+// ends. A goroutine that uses the *sql.DB while it holds a *sql.Tx on the
+// same database therefore blocks until the transaction ends.
+package driver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mk6i/mkdb/engine"
+	"github.com/mk6i/mkdb/storage"
+)
+
+var (
+	ErrDataDirInUse       = errors.New("a different data directory is already in use")
+	ErrNamedParams        = errors.New("named parameters are not supported")
+	ErrLastInsertID       = errors.New("LastInsertId is not supported")
+	ErrTxOptsNotSupported = errors.New("transaction isolation levels and read-only transactions are not supported")
+)
+
+func init() {
+	sql.Register("mkdb", &Driver{})
+}
+
+var (
+	dataDirMtx sync.Mutex
+	dataDir    string
+	pool       *engine.DBPool
+)
+
+// openDataDir recovers the databases in data directory path the first time
+// it's called and returns the pool that connections share their databases
+// through.
+func openDataDir(path string) (*engine.DBPool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dataDirMtx.Lock()
+	defer dataDirMtx.Unlock()
+
+	if pool != nil {
+		if abs != dataDir {
+			return nil, fmt.Errorf("%w: %s", ErrDataDirInUse, dataDir)
+		}
+		return pool, nil
+	}
+
+	storage.SetDataDir(abs)
+	if err := storage.InitStorage(); err != nil {
+		return nil, fmt.Errorf("storage init error: %w", err)
+	}
+	dataDir = abs
+	pool = engine.NewDBPool()
+	return pool, nil
+}
+
+type Driver struct{}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	if path == "" {
+		return nil, errors.New("data source name is missing the data directory")
+	}
+
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data source name: %w", err)
+	}
+	for name := range params {
+		if name != "db" {
+			return nil, fmt.Errorf("unknown data source name parameter %s", name)
+		}
+	}
+
+	p, err := openDataDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connector{
+		driver: d,
+		pool:   p,
+		dbName: params.Get("db"),
+	}, nil
+}
+
+type connector struct {
+	driver *Driver
+	pool   *engine.DBPool
+	dbName string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	sess := &engine.Session{Pool: c.pool}
+	if c.dbName != "" {
+		if err := sess.Use(c.dbName); err != nil {
+			return nil, err
+		}
+	}
+	return &conn{sess: sess}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn is a connection backed by its own session.
+type conn struct {
+	sess *engine.Session
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{conn: c, query: query}, nil
+}
+
+func (c *conn) Close() error {
+	return c.sess.Close()
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) || opts.ReadOnly {
+		return nil, ErrTxOptsNotSupported
+	}
+	if _, err := c.exec(ctx, "BEGIN", nil); err != nil {
+		return nil, err
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.exec(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return result{rowsAffected: int64(res.RowsAffected)}, nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.exec(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{res: res}, nil
+}
+
+func (c *conn) exec(ctx context.Context, query string, args []driver.NamedValue) (*engine.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("%w: %s", ErrNamedParams, arg.Name)
+		}
+		vals[i] = arg.Value
+	}
+	return c.sess.Exec(query, vals...)
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	res, err := t.conn.exec(context.Background(), "COMMIT", nil)
+	if err != nil {
+		return err
+	}
+	if res.Tag == "ROLLBACK" {
+		// a statement failed and aborted the transaction
+		return engine.ErrTxnAborted
+	}
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	_, err := t.conn.exec(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+// stmt is a prepared statement. Statements are parsed each time they run,
+// so preparing one only remembers the query.
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+// NumInput returns -1 since the parameters aren't counted until the
+// statement runs.
+func (s *stmt) NumInput() int {
+	return -1
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type result struct {
+	rowsAffected int64
+}
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, ErrLastInsertID
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return r.rowsAffected, nil
+}
+
+// rows iterates over the rows of a statement's result. Statements that don't
+// return rows have no columns.
+type rows struct {
+	res *engine.Result
+	pos int
+}
+
+func (r *rows) Columns() []string {
+	cols := make([]string, len(r.res.Columns))
+	for i, col := range r.res.Columns {
+		cols[i] = col.Name
+	}
+	return cols
+}
+
+func (r *rows) Close() error {
+	r.pos = len(r.res.Rows)
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.res.Rows) {
+		return io.EOF
+	}
+	for i, val := range r.res.Rows[r.pos] {
+		dest[i] = val
+		switch val := val.(type) {
+		case string:
+			if r.res.Columns[i].Type == storage.TypeBlob {
+				dest[i] = []byte(val)
+			}
+		case storage.Decimal:
+			// decimals are returned as text so that they stay exact
+			dest[i] = val.String()
+		case storage.Date:
+			dest[i] = val.Time()
+		case storage.Timestamp:
+			dest[i] = val.Time()
+		case storage.Time, storage.Interval:
+			dest[i] = fmt.Sprint(val)
+		}
+	}
+	r.pos++
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	switch r.res.Columns[index].Type {
+	case storage.TypeInt:
+		return "INT"
+	case storage.TypeBigInt:
+		return "BIGINT"
+	case storage.TypeBoolean:
+		return "BOOLEAN"
+	case storage.TypeText:
+		return "TEXT"
+	case storage.TypeBlob:
+		return "BLOB"
+	case storage.TypeReal:
+		return "REAL"
+	case storage.TypeDouble:
+		return "DOUBLE PRECISION"
+	case storage.TypeDecimal:
+		return "DECIMAL"
+	case storage.TypeDate:
+		return "DATE"
+	case storage.TypeTime:
+		return "TIME"
+	case storage.TypeTimestamp:
+		return "TIMESTAMP"
+	case storage.TypeInterval:
+		return "INTERVAL"
+	default:
+		return "VARCHAR"
+	}
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.res.Columns[index].Type {
+	case storage.TypeInt, storage.TypeBigInt:
+		return reflect.TypeOf(int64(0))
+	case storage.TypeBoolean:
+		return reflect.TypeOf(false)
+	case storage.TypeReal, storage.TypeDouble:
+		return reflect.TypeOf(float64(0))
+	case storage.TypeBlob:
+		return reflect.TypeOf([]byte(nil))
+	case storage.TypeDate, storage.TypeTimestamp:
+		return reflect.TypeOf(time.Time{})
+	default:
+		return reflect.TypeOf("")
+	}
+}
@@ -0,0 +1,269 @@
+package driver
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	mkdbsql "github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+func TestDriver(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	setup, err := sql.Open("mkdb", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setup.Close()
+
+	if _, err := setup.Exec(`CREATE DATABASE testdb`); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("mkdb", "data?db=testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE people (person_id int, first_name varchar(255), is_admin boolean)`); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := db.Exec(`INSERT INTO people VALUES (?, ?, ?), (?, ?, ?)`, 1, "O'Brien", true, 2, "Jane", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 2 {
+		t.Errorf("expected 2 rows affected, got %d %v", n, err)
+	}
+
+	res, err = db.Exec(`UPDATE people SET first_name = $2 WHERE person_id = $1`, 2, "Janet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		t.Errorf("expected 1 row affected, got %d %v", n, err)
+	}
+
+	rows, err := db.Query(`SELECT person_id, first_name, is_admin FROM people ORDER BY person_id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"person_id", "first_name", "is_admin"}; !reflect.DeepEqual(expected, cols) {
+		t.Errorf("expected columns %v, got %v", expected, cols)
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var typeNames []string
+	for _, typ := range types {
+		typeNames = append(typeNames, typ.DatabaseTypeName())
+	}
+	if expected := []string{"INT", "VARCHAR", "BOOLEAN"}; !reflect.DeepEqual(expected, typeNames) {
+		t.Errorf("expected column types %v, got %v", expected, typeNames)
+	}
+
+	type person struct {
+		id      int64
+		name    string
+		isAdmin bool
+	}
+	var people []person
+	for rows.Next() {
+		var p person
+		if err := rows.Scan(&p.id, &p.name, &p.isAdmin); err != nil {
+			t.Fatal(err)
+		}
+		people = append(people, p)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []person{{1, "O'Brien", true}, {2, "Janet", false}}; !reflect.DeepEqual(expected, people) {
+		t.Errorf("expected rows %v, got %v", expected, people)
+	}
+
+	var name string
+	if err := db.QueryRow(`SELECT first_name FROM people WHERE is_admin = ?`, true).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+	if name != "O'Brien" {
+		t.Errorf("expected O'Brien, got %s", name)
+	}
+
+	stmt, err := db.Prepare(`SELECT count(*) FROM people WHERE person_id = ?`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for id, expected := range map[int]int64{1: 1, 3: 0} {
+		var count int64
+		if err := stmt.QueryRow(id).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if count != expected {
+			t.Errorf("expected count %d for person %d, got %d", expected, id, count)
+		}
+	}
+
+	// blobs are bound and scanned as byte slices
+	if _, err := db.Exec(`CREATE TABLE files (name text, data blob)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO files VALUES (?, ?)`, "a.bin", []byte{0, 1, 255}); err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	if err := db.QueryRow(`SELECT data FROM files WHERE name = ?`, "a.bin").Scan(&data); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0, 1, 255}; !reflect.DeepEqual(expected, data) {
+		t.Errorf("expected data %v, got %v", expected, data)
+	}
+
+	// floats are bound as floating-point numbers and decimals scanned as
+	// text
+	if _, err := db.Exec(`CREATE TABLE prices (amount decimal(6, 2), weight double precision)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO prices VALUES (?, ?)`, 9.99, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	var amount string
+	var weight float64
+	if err := db.QueryRow(`SELECT amount, weight FROM prices WHERE weight > ?`, 0.25).Scan(&amount, &weight); err != nil {
+		t.Fatal(err)
+	}
+	if amount != "9.99" || weight != 0.5 {
+		t.Errorf("expected 9.99 and 0.5, got %s and %v", amount, weight)
+	}
+
+	// times are bound as text and dates and timestamps scanned as times
+	if _, err := db.Exec(`CREATE TABLE events (day date, at timestamp, length interval)`); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	if _, err := db.Exec(`INSERT INTO events VALUES (?, ?, INTERVAL '90 minutes')`, at, at); err != nil {
+		t.Fatal(err)
+	}
+	var day, gotAt time.Time
+	var length string
+	if err := db.QueryRow(`SELECT day, at, length FROM events WHERE at >= ?`, at.Add(-time.Hour)).Scan(&day, &gotAt, &length); err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC); !day.Equal(expected) {
+		t.Errorf("expected day %v, got %v", expected, day)
+	}
+	if !gotAt.Equal(at) {
+		t.Errorf("expected at %v, got %v", at, gotAt)
+	}
+	if length != "01:30:00" {
+		t.Errorf("expected length 01:30:00, got %s", length)
+	}
+
+	if _, err := db.Exec(`DELETE FROM people WHERE person_id = ?`); !errors.Is(err, mkdbsql.ErrParamCount) {
+		t.Errorf("expected ErrParamCount error, got %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM people WHERE person_id = @id`, sql.Named("id", 1)); !errors.Is(err, ErrNamedParams) {
+		t.Errorf("expected ErrNamedParams error, got %v", err)
+	}
+	if _, err := db.Exec(`SELECT * FROM cars`); !errors.Is(err, storage.ErrTableNotExist) {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+}
+
+func TestDriverTx(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	setup, err := sql.Open("mkdb", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setup.Close()
+
+	if _, err := setup.Exec(`CREATE DATABASE testdb`); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sql.Open("mkdb", "data?db=testdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(`CREATE TABLE people (person_id int)`); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO people VALUES (?)`, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO people VALUES (?)`, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []int64
+	rows, err := db.Query(`SELECT person_id FROM people`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if !reflect.DeepEqual([]int64{2}, ids) {
+		t.Errorf("expected ids [2], got %v", ids)
+	}
+}
+
+func TestDriverDSN(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	db, err := sql.Open("mkdb", "data?db=nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Ping(); !errors.Is(err, storage.ErrDBNotExist) {
+		t.Errorf("expected ErrDBNotExist error, got %v", err)
+	}
+
+	if _, err := sql.Open("mkdb", "data?database=testdb"); err == nil {
+		t.Error("expected error for unknown parameter")
+	}
+
+	if _, err := sql.Open("mkdb", "other-data"); !errors.Is(err, ErrDataDirInUse) {
+		t.Errorf("expected ErrDataDirInUse error, got %v", err)
+	}
+}
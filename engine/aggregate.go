@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// setFunction is a set function of a select list, such as count(*) or
+// sum(DISTINCT price).
+type setFunction struct {
+	// name is the lower-case name of the function
+	name string
+	// arg is the aggregated expression, which is nil for count(*)
+	arg      interface{}
+	distinct bool
+}
+
+// asSetFunction returns the set function that expr is, or false if expr is
+// not a set function.
+func asSetFunction(expr interface{}) (setFunction, bool) {
+	switch v := expr.(type) {
+	case sql.Count:
+		return setFunction{name: "count", arg: v.ValueExpression, distinct: v.Distinct}, true
+	case sql.Average:
+		return setFunction{name: "avg", arg: v.ValueExpression, distinct: v.Distinct}, true
+	case sql.Sum:
+		return setFunction{name: "sum", arg: v.ValueExpression, distinct: v.Distinct}, true
+	case sql.Min:
+		return setFunction{name: "min", arg: v.ValueExpression, distinct: v.Distinct}, true
+	case sql.Max:
+		return setFunction{name: "max", arg: v.ValueExpression, distinct: v.Distinct}, true
+	}
+	return setFunction{}, false
+}
+
+// String renders the function call, which names its result column.
+func (f setFunction) String() string {
+	if f.arg == nil {
+		return f.name + "(*)"
+	}
+	if f.distinct {
+		return fmt.Sprintf("%s(DISTINCT %s)", f.name, formatExpr(f.arg))
+	}
+	return fmt.Sprintf("%s(%s)", f.name, formatExpr(f.arg))
+}
+
+// dataType returns the type of the function's result. Counts are big
+// integers and the minimum or maximum has the type of the argument. The sum
+// of integers is a big integer, and both the sum and average of
+// floating-point numbers are floating-point. The average of other numbers
+// is exact.
+func (f setFunction) dataType(qfields storage.Fields) storage.DataType {
+	if f.name == "count" {
+		return storage.TypeBigInt
+	}
+	dt := expressionType(f.arg, qfields)
+	switch f.name {
+	case "avg":
+		if dt == storage.TypeReal || dt == storage.TypeDouble {
+			return storage.TypeDouble
+		}
+		return storage.TypeDecimal
+	case "sum":
+		switch dt {
+		case storage.TypeInt, storage.TypeBigInt:
+			return storage.TypeBigInt
+		case storage.TypeReal, storage.TypeDouble:
+			return storage.TypeDouble
+		}
+	}
+	return dt
+}
+
+// aggState accumulates the values of a set function for a group.
+type aggState struct {
+	fn setFunction
+	// row is the de-duped row of the group that holds the result in column
+	// colIdx
+	row    *storage.Row
+	colIdx int
+	count  int64
+	// seen holds the keys of the values added so far if the function only
+	// aggregates distinct values
+	seen map[string]bool
+	// val is the sum, minimum or maximum so far
+	val interface{}
+	avg *avgState
+}
+
+func newAggState(fn setFunction, row *storage.Row, colIdx int) *aggState {
+	s := &aggState{fn: fn, row: row, colIdx: colIdx}
+	if fn.distinct {
+		s.seen = map[string]bool{}
+	}
+	if fn.name == "avg" {
+		s.avg = &avgState{sum: new(big.Rat)}
+	}
+	return s
+}
+
+// add adds val, the value of the function's argument for a row of the
+// group, to the aggregate. Null values are left out, except by count(*),
+// which counts rows.
+func (s *aggState) add(val interface{}) error {
+	if s.fn.arg == nil {
+		s.count++
+		return nil
+	}
+	if val == nil {
+		return nil
+	}
+	if s.seen != nil {
+		key := hashKey([]interface{}{val})
+		if s.seen[key] {
+			return nil
+		}
+		s.seen[key] = true
+	}
+
+	switch s.fn.name {
+	case "count":
+		s.count++
+	case "avg":
+		if !isNumeric(val) {
+			return fmt.Errorf("%w: %s", ErrNonNumericArg, s.fn)
+		}
+		s.avg.add(val)
+	case "sum":
+		if !isNumeric(val) {
+			return fmt.Errorf("%w: %s", ErrNonNumericArg, s.fn)
+		}
+		if s.val == nil {
+			s.val = val
+			break
+		}
+		sum, err := numericArithmetic(s.val, true, val)
+		if err != nil {
+			return err
+		}
+		s.val = sum
+	case "min":
+		if s.val == nil || compareVals(val, s.val) < 0 {
+			s.val = val
+		}
+	case "max":
+		if s.val == nil || compareVals(val, s.val) > 0 {
+			s.val = val
+		}
+	}
+	return nil
+}
+
+// result returns the value of the aggregate. The count of no values is 0,
+// the other functions of no values are null.
+func (s *aggState) result() (interface{}, error) {
+	switch s.fn.name {
+	case "count":
+		return s.count, nil
+	case "avg":
+		if s.avg.count == 0 {
+			return nil, nil
+		}
+		return s.avg.avg()
+	}
+	return s.val, nil
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"fmt"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+func EvaluateAlterTable(q sql.AlterTable, rm RelationManager) (err error) {
+	if err := rm.StartTxn(); err != nil {
+		return err
+	}
+	defer rm.EndTxn()
+	defer abortOnError(rm, &err)
+
+	schema, err := rm.Schema(q.TableName)
+	if err != nil {
+		return err
+	}
+
+	var batch storage.WALBatch
+
+	switch q.Action {
+	case sql.ADD:
+		cd := q.ColumnDefinition
+		if cd.Unique || cd.PrimaryKey || cd.Check != nil || cd.References != nil {
+			return fmt.Errorf("%w: only NOT NULL and DEFAULT can be declared on an added column", ErrTmpUnsupportedSyntax)
+		}
+		var fd storage.FieldDef
+		if fd, err = fieldDef(cd); err != nil {
+			return err
+		}
+		batch, err = rm.AddColumn(q.TableName, fd)
+	case sql.DROP:
+		for _, check := range schema.Checks {
+			cond, err := parseCondition(check.Expr)
+			if err != nil {
+				return err
+			}
+			for _, ref := range columnRefs(cond) {
+				if ref.ColumnName == q.Column {
+					return fmt.Errorf("%w: %s is used by check constraint %s", storage.ErrColumnInUse, q.Column, check.Name)
+				}
+			}
+		}
+		batch, err = rm.DropColumn(q.TableName, q.Column)
+	case sql.RENAME:
+		tableName := q.TableName
+		rename := func(ref sql.ColumnReference) sql.ColumnReference {
+			if ref.ColumnName == q.Column && (ref.Qualifier == "" || ref.Qualifier == q.TableName) {
+				ref.ColumnName = q.NewName
+			}
+			return ref
+		}
+		if q.Column == "" {
+			tableName = q.NewName
+			rename = func(ref sql.ColumnReference) sql.ColumnReference {
+				if ref.Qualifier == q.TableName {
+					ref.Qualifier = q.NewName
+				}
+				return ref
+			}
+			batch, err = rm.RenameTable(q.TableName, q.NewName)
+		} else {
+			batch, err = rm.RenameColumn(q.TableName, q.Column, q.NewName)
+		}
+		if err != nil {
+			return err
+		}
+		// the check constraints refer to columns by name, so they're
+		// rewritten to follow the rename
+		for _, check := range schema.Checks {
+			cond, err := parseCondition(check.Expr)
+			if err != nil {
+				return err
+			}
+			renamed := false
+			cond = renameColumnRefs(cond, func(ref sql.ColumnReference) sql.ColumnReference {
+				newRef := rename(ref)
+				renamed = renamed || newRef != ref
+				return newRef
+			})
+			if !renamed {
+				continue
+			}
+			logs, err := rm.UpdateCheck(&storage.Check{Name: check.Name, TableName: tableName, Expr: formatExpr(cond)})
+			batch = append(batch, logs...)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: alter table action %s", ErrTmpUnsupportedSyntax, sql.Tokens[q.Action])
+	}
+	if err != nil {
+		return err
+	}
+
+	return rm.FlushWALBatch(batch)
+}
+
+// renameColumnRefs returns a copy of expr with each column reference
+// replaced by what f returns for it.
+func renameColumnRefs(expr interface{}, f func(ref sql.ColumnReference) sql.ColumnReference) interface{} {
+	switch v := expr.(type) {
+	case sql.SearchCondition:
+		v.LHS = renameColumnRefs(v.LHS, f)
+		v.RHS = renameColumnRefs(v.RHS, f)
+		return v
+	case sql.BooleanTerm:
+		v.LHS = renameColumnRefs(v.LHS, f)
+		v.RHS = renameColumnRefs(v.RHS, f)
+		return v
+	case sql.Predicate:
+		v.LHS = renameColumnRefs(v.LHS, f)
+		v.RHS = renameColumnRefs(v.RHS, f)
+		return v
+	case sql.ArithmeticExpression:
+		v.LHS = renameColumnRefs(v.LHS, f)
+		v.RHS = renameColumnRefs(v.RHS, f)
+		return v
+	case sql.Extract:
+		v.Source = renameColumnRefs(v.Source, f)
+		return v
+	case sql.FunctionCall:
+		args := make([]sql.ValueExpression, len(v.Args))
+		for i, arg := range v.Args {
+			args[i] = renameColumnRefs(arg, f)
+		}
+		v.Args = args
+		return v
+	case sql.ColumnReference:
+		return f(v)
+	}
+	return expr
+}
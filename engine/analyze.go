@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+func EvaluateAnalyze(q sql.AnalyzeStatement, rm RelationManager) error {
+	return rm.Analyze(q.TableName)
+}
+
+// EvaluateShowStats returns the column statistics computed by ANALYZE, for
+// table q.TableName or all tables if it's empty.
+func EvaluateShowStats(q sql.ShowStats, rm RelationManager) ([]*storage.Row, []*storage.Field, error) {
+	if err := rm.StartTxn(); err != nil {
+		return nil, nil, err
+	}
+	defer rm.EndTxn()
+
+	fields := []*storage.Field{
+		{Column: "table_name", DataType: storage.TypeVarchar},
+		{Column: "column_name", DataType: storage.TypeVarchar},
+		{Column: "row_count", DataType: storage.TypeBigInt},
+		{Column: "distinct_count", DataType: storage.TypeBigInt},
+		{Column: "null_frac", DataType: storage.TypeVarchar},
+		{Column: "histogram", DataType: storage.TypeVarchar},
+	}
+
+	it, statsFields, err := rm.Scan(storage.StatsTableName)
+	if err == storage.ErrTableNotExist {
+		// no table has been analyzed yet
+		return nil, fields, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pos := make(map[interface{}]int)
+	for i, fd := range statsFields {
+		pos[fd.Column] = i
+	}
+
+	var rows []*storage.Row
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == nil {
+			break
+		}
+		tableName := row.Vals[pos["table_name"]]
+		if q.TableName != "" && tableName != q.TableName {
+			continue
+		}
+		rowCount := row.Vals[pos["row_count"]].(int64)
+		nullFrac := 0.0
+		if rowCount > 0 {
+			nullFrac = float64(row.Vals[pos["null_count"]].(int64)) / float64(rowCount)
+		}
+		rows = append(rows, &storage.Row{Vals: []interface{}{
+			tableName,
+			row.Vals[pos["column_name"]],
+			rowCount,
+			row.Vals[pos["distinct_count"]],
+			fmt.Sprintf("%.4f", nullFrac),
+			row.Vals[pos["histogram"]],
+		}})
+	}
+
+	// the columns of a table are stored in order, but tables are stored in
+	// the order they were analyzed
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Vals[0].(string) < rows[j].Vals[0].(string)
+	})
+
+	return rows, fields, nil
+}
@@ -0,0 +1,460 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+var (
+	ErrCheckViolation      = errors.New("row violates check constraint")
+	ErrForeignKeyViolation = errors.New("row violates foreign key constraint")
+)
+
+// tableConstraints holds the constraints of a table that the engine
+// enforces. The storage layer enforces NOT NULL and UNIQUE constraints.
+type tableConstraints struct {
+	schema *storage.Relation
+	fields storage.Fields
+	// conds holds the parsed search conditions of schema.Checks
+	conds []interface{}
+	// refs holds the foreign keys of the tables that refer to this table
+	refs []*storage.ForeignKey
+}
+
+// rowWriter writes the rows changed by a statement. It enforces the CHECK
+// and FOREIGN KEY constraints of the changed rows and carries out the
+// referential actions that the changes trigger. The WAL entries of the
+// triggered changes are collected in the same batch as the statement's own,
+// so that they're recovered together.
+type rowWriter struct {
+	rm    RelationManager
+	batch storage.WALBatch
+	// now is the value of the NOW() calls of CHECK constraints
+	now    nowValue
+	tables map[string]*tableConstraints
+	// deleted holds the keys of the rows deleted by the statement, by table
+	deleted map[string]map[string]bool
+}
+
+func newRowWriter(rm RelationManager, now nowValue) *rowWriter {
+	return &rowWriter{
+		rm:      rm,
+		now:     now,
+		tables:  make(map[string]*tableConstraints),
+		deleted: make(map[string]map[string]bool),
+	}
+}
+
+// constraints returns the constraints of table tableName.
+func (w *rowWriter) constraints(tableName string) (*tableConstraints, error) {
+	if tc, ok := w.tables[tableName]; ok {
+		return tc, nil
+	}
+
+	schema, err := w.rm.Schema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := w.rm.ReferencingKeys(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &tableConstraints{
+		schema: schema,
+		fields: relationFields(tableName, schema),
+		refs:   refs,
+	}
+	for _, check := range schema.Checks {
+		cond, err := parseCondition(check.Expr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse check constraint %s: %w", check.Name, err)
+		}
+		tc.conds = append(tc.conds, bindNowInExpr(cond, w.now))
+	}
+
+	w.tables[tableName] = tc
+	return tc, nil
+}
+
+// insert inserts a row with values vals for columns cols into table
+// tableName.
+func (w *rowWriter) insert(tableName string, cols []string, vals []interface{}) error {
+	tc, err := w.constraints(tableName)
+	if err != nil {
+		return err
+	}
+	row := tc.insertVals(cols, vals)
+	if err := tc.schema.ConvertVals(row); err != nil {
+		return err
+	}
+	if err := w.checkRow(tc, row, nil); err != nil {
+		return err
+	}
+
+	entries, err := w.rm.Insert(tableName, cols, vals)
+	w.batch = append(w.batch, entries...)
+	return err
+}
+
+// update sets columns cols of row, a row of table tableName with columns
+// fields, to vals.
+func (w *rowWriter) update(tableName string, row *storage.Row, fields storage.Fields, cols []string, vals []interface{}) error {
+	tc, err := w.constraints(tableName)
+	if err != nil {
+		return err
+	}
+
+	old := make(map[string]interface{})
+	for i, field := range fields {
+		old[field.Column.(string)] = row.Vals[i]
+	}
+	updated := make(map[string]interface{})
+	for col, val := range old {
+		updated[col] = val
+	}
+	for i, col := range cols {
+		updated[col] = vals[i]
+	}
+	if err := tc.schema.ConvertVals(updated); err != nil {
+		return err
+	}
+
+	if err := w.checkRow(tc, updated, old); err != nil {
+		return err
+	}
+
+	// find the foreign keys whose referenced values change
+	var changed []*storage.ForeignKey
+	for _, fk := range tc.refs {
+		oldKey := columnVals(old, fk.RefColumns)
+		if hasNull(oldKey) || reflect.DeepEqual(oldKey, columnVals(updated, fk.RefColumns)) {
+			continue
+		}
+		if err := w.restrict(fk, fk.OnUpdate, oldKey); err != nil {
+			return err
+		}
+		changed = append(changed, fk)
+	}
+
+	entries, err := w.rm.Update(tableName, row.Key, cols, vals)
+	w.batch = append(w.batch, entries...)
+	if err != nil {
+		return err
+	}
+
+	for _, fk := range changed {
+		if err := w.cascade(fk, fk.OnUpdate, columnVals(old, fk.RefColumns), columnVals(updated, fk.RefColumns)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pendingDelete is a row that a DELETE statement deletes, either directly
+// or through a cascading delete.
+type pendingDelete struct {
+	tableName string
+	row       *storage.Row
+	vals      map[string]interface{}
+	refs      []*storage.ForeignKey
+}
+
+// delete deletes rows, rows of table tableName with columns fields, along
+// with the rows that cascading deletes remove. Nothing is deleted if a
+// foreign key restricts the deletion of any of the rows.
+func (w *rowWriter) delete(tableName string, rows []*storage.Row, fields storage.Fields) error {
+	var pending []*pendingDelete
+	for _, row := range rows {
+		if err := w.planDelete(tableName, row, fields, &pending); err != nil {
+			return err
+		}
+	}
+
+	// the restricting rows are looked for once all the rows that are
+	// deleted are known, since they may be among them
+	for _, d := range pending {
+		for _, fk := range d.refs {
+			if err := w.restrict(fk, fk.OnDelete, columnVals(d.vals, fk.RefColumns)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, d := range pending {
+		entries, err := w.rm.MarkDeleted(d.tableName, d.row.Key)
+		w.batch = append(w.batch, entries...)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, d := range pending {
+		for _, fk := range d.refs {
+			if fk.OnDelete != storage.SetNull {
+				continue
+			}
+			if err := w.cascade(fk, fk.OnDelete, columnVals(d.vals, fk.RefColumns), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// planDelete adds row, a row of table tableName with columns fields, and the
+// rows that cascading deletes remove along with it to pending.
+func (w *rowWriter) planDelete(tableName string, row *storage.Row, fields storage.Fields, pending *[]*pendingDelete) error {
+	if w.deleted[tableName][string(row.Key)] {
+		return nil
+	}
+	if w.deleted[tableName] == nil {
+		w.deleted[tableName] = make(map[string]bool)
+	}
+	w.deleted[tableName][string(row.Key)] = true
+
+	tc, err := w.constraints(tableName)
+	if err != nil {
+		return err
+	}
+
+	d := &pendingDelete{
+		tableName: tableName,
+		row:       row,
+		vals:      make(map[string]interface{}),
+	}
+	for i, field := range fields {
+		d.vals[field.Column.(string)] = row.Vals[i]
+	}
+	*pending = append(*pending, d)
+
+	for _, fk := range tc.refs {
+		key := columnVals(d.vals, fk.RefColumns)
+		if hasNull(key) {
+			continue
+		}
+		d.refs = append(d.refs, fk)
+		if fk.OnDelete != storage.Cascade {
+			continue
+		}
+		rows, fields, err := w.matchingRows(fk.TableName, fk.Columns, key)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := w.planDelete(fk.TableName, row, fields, pending); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// restrict returns ErrForeignKeyViolation if action rejects changing the
+// parent key oldKey of foreign key fk while rows still refer to it.
+func (w *rowWriter) restrict(fk *storage.ForeignKey, action storage.ReferentialAction, oldKey []interface{}) error {
+	if action != storage.NoAction && action != storage.Restrict {
+		return nil
+	}
+	rows, _, err := w.matchingRows(fk.TableName, fk.Columns, oldKey)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return fmt.Errorf("%w: %s is still referenced from %s", ErrForeignKeyViolation, fk.RefTable, fk.TableName)
+	}
+	return nil
+}
+
+// cascade applies action to the rows that refer to the parent key oldKey of
+// foreign key fk, once the parent key changed to newKey. Deleted parent keys
+// have a nil newKey.
+func (w *rowWriter) cascade(fk *storage.ForeignKey, action storage.ReferentialAction, oldKey []interface{}, newKey []interface{}) error {
+	if action != storage.Cascade && action != storage.SetNull {
+		return nil
+	}
+
+	rows, fields, err := w.matchingRows(fk.TableName, fk.Columns, oldKey)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		switch {
+		case action == storage.SetNull:
+			err = w.update(fk.TableName, row, fields, fk.Columns, make([]interface{}, len(fk.Columns)))
+		case newKey == nil:
+			err = w.delete(fk.TableName, []*storage.Row{row}, fields)
+		default:
+			err = w.update(fk.TableName, row, fields, fk.Columns, newKey)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkRow returns an error if vals, which maps column names to the values
+// of a row, violates a CHECK or FOREIGN KEY constraint of tc. CHECK
+// constraints that evaluate to UNKNOWN are satisfied. If the row is updated,
+// old holds its values before the update and only the foreign keys whose
+// values change are checked.
+func (w *rowWriter) checkRow(tc *tableConstraints, vals map[string]interface{}, old map[string]interface{}) error {
+	if len(tc.conds) > 0 {
+		row := &storage.Row{}
+		for _, fd := range tc.schema.Fields {
+			row.Vals = append(row.Vals, vals[fd.Name])
+		}
+		for i, cond := range tc.conds {
+			ok, err := evaluate(cond, tc.fields, row)
+			if err != nil {
+				return err
+			}
+			if ok == false {
+				return fmt.Errorf("%w: %s", ErrCheckViolation, tc.schema.Checks[i].Name)
+			}
+		}
+	}
+
+	for _, fk := range tc.schema.ForeignKeys {
+		key := columnVals(vals, fk.Columns)
+		// keys with a null value don't refer to anything
+		if hasNull(key) {
+			continue
+		}
+		if old != nil && reflect.DeepEqual(key, columnVals(old, fk.Columns)) {
+			continue
+		}
+		rows, _, err := w.matchingRows(fk.RefTable, fk.RefColumns, key)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return fmt.Errorf("%w: %s has no row with %s = %s", ErrForeignKeyViolation,
+				fk.RefTable, strings.Join(fk.RefColumns, ", "), formatVals(key))
+		}
+	}
+
+	return nil
+}
+
+// matchingRows returns the rows of table tableName whose columns cols equal
+// vals. The rows are read through an index on cols if there is one.
+func (w *rowWriter) matchingRows(tableName string, cols []string, vals []interface{}) ([]*storage.Row, storage.Fields, error) {
+	indexes, err := w.rm.Indexes(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var it storage.RowIterator
+	var fields storage.Fields
+	for _, idx := range indexes {
+		if len(idx.Columns) >= len(cols) && reflect.DeepEqual(idx.Columns[:len(cols)], cols) {
+			it, fields, err = w.rm.ScanByIndex(idx, vals)
+			break
+		}
+	}
+	if it == nil && err == nil {
+		it, fields, err = w.rm.Scan(tableName)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	colIdx := make([]int, len(cols))
+	for i, col := range cols {
+		if colIdx[i], err = fields.LookupFieldIdx(col); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var rows []*storage.Row
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == nil {
+			return rows, fields, nil
+		}
+		match := true
+		for i, idx := range colIdx {
+			if row.Vals[idx] != vals[i] {
+				match = false
+				break
+			}
+		}
+		if match && !w.deleted[tableName][string(row.Key)] {
+			rows = append(rows, row)
+		}
+	}
+}
+
+// insertVals returns the values of the row that an INSERT of vals into
+// columns cols creates, including the defaults of the columns it leaves out.
+func (tc *tableConstraints) insertVals(cols []string, vals []interface{}) map[string]interface{} {
+	ret := make(map[string]interface{})
+	for _, fd := range tc.schema.Fields {
+		ret[fd.Name] = fd.Default
+	}
+	if len(cols) == 0 {
+		for i, fd := range tc.schema.Fields {
+			if i < len(vals) {
+				ret[fd.Name] = vals[i]
+			}
+		}
+		return ret
+	}
+	for i, col := range cols {
+		if i < len(vals) {
+			ret[col] = vals[i]
+		}
+	}
+	return ret
+}
+
+// columnVals returns the values of columns cols found in vals.
+func columnVals(vals map[string]interface{}, cols []string) []interface{} {
+	ret := make([]interface{}, len(cols))
+	for i, col := range cols {
+		ret[i] = vals[col]
+	}
+	return ret
+}
+
+func formatVals(vals []interface{}) string {
+	var strs []string
+	for _, val := range vals {
+		strs = append(strs, formatExpr(val))
+	}
+	return strings.Join(strs, ", ")
+}
+
+// parseCondition parses a search condition, such as the expression of a
+// CHECK constraint.
+func parseCondition(expr string) (interface{}, error) {
+	ts := sql.NewTokenScanner(strings.NewReader(expr))
+	tl := sql.TokenList{}
+
+	for ts.Next() {
+		tl.Add(ts.Cur())
+	}
+
+	p := sql.Parser{TokenList: tl}
+
+	cond, err := p.OrCondition()
+	if err != nil {
+		return nil, err
+	}
+	return convertLiteralsInExpr(cond)
+}
@@ -1,36 +1,234 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/mk6i/mkdb/sql"
 	"github.com/mk6i/mkdb/storage"
 )
 
+var ErrMultiplePrimaryKeys = errors.New("table can only have one primary key")
+
 func EvaluateCreateDatabase(q sql.CreateDatabase) error {
 	return storage.CreateDB(q.Name)
 }
 
+func EvaluateDropDatabase(q sql.DropDatabase) error {
+	err := storage.DropDB(q.Name)
+	if q.IfExists && errors.Is(err, storage.ErrDBNotExist) {
+		return nil
+	}
+	return err
+}
+
+func EvaluateDropTable(q sql.DropTable, rm RelationManager) error {
+	return rm.DropTable(q.Name)
+}
+
+func EvaluateTruncateTable(q sql.TruncateTable, rm RelationManager) error {
+	return rm.TruncateTable(q.Name)
+}
+
+func EvaluateVacuum(q sql.VacuumStatement, rm RelationManager) error {
+	return rm.Vacuum(q.TableName)
+}
+
 func EvaluateCreateTable(q sql.CreateTable, rm RelationManager) error {
 	r := &storage.Relation{}
 
+	var primaryKey []string
+	var uniques [][]string
+	var checks []interface{}
+
 	for _, elem := range q.Elements {
-		fd := storage.FieldDef{
-			Name: elem.ColumnDefinition.Name,
-		}
-		switch t := elem.ColumnDefinition.DataType.(type) {
-		case sql.NumericType:
-			fd.DataType = storage.TypeInt
-		case sql.BigIntType:
-			fd.DataType = storage.TypeBigInt
-		case sql.CharacterStringType:
-			fd.DataType = storage.TypeVarchar
-			fd.Len = t.Len
-		case sql.BooleanType:
-			fd.DataType = storage.TypeBoolean
-		default:
-			panic("unsupported column definition type")
+		cd := elem.ColumnDefinition
+		fd, err := fieldDef(cd)
+		if err != nil {
+			return err
 		}
 		r.Fields = append(r.Fields, fd)
+
+		if cd.PrimaryKey {
+			if primaryKey != nil {
+				return ErrMultiplePrimaryKeys
+			}
+			primaryKey = []string{cd.Name}
+		}
+		if cd.Unique {
+			uniques = append(uniques, []string{cd.Name})
+		}
+		if cd.Check != nil {
+			checks = append(checks, cd.Check)
+		}
+		if cd.References != nil {
+			r.ForeignKeys = append(r.ForeignKeys, newForeignKey(q.Name, []string{cd.Name}, cd.References))
+		}
+	}
+
+	for _, tc := range q.Constraints {
+		switch tc.Type {
+		case sql.PRIMARY:
+			if primaryKey != nil {
+				return ErrMultiplePrimaryKeys
+			}
+			primaryKey = tc.Columns
+		case sql.UNIQUE:
+			uniques = append(uniques, tc.Columns)
+		case sql.CHECK:
+			checks = append(checks, tc.Check)
+		case sql.FOREIGN:
+			r.ForeignKeys = append(r.ForeignKeys, newForeignKey(q.Name, tc.Columns, tc.References))
+		}
+	}
+
+	if primaryKey != nil {
+		// primary key columns can't be null
+		for _, col := range primaryKey {
+			i, err := fieldDefIdx(r.Fields, col)
+			if err != nil {
+				return err
+			}
+			r.Fields[i].NotNull = true
+		}
+		// the table's rows are stored in primary key order
+		r.PrimaryKey = primaryKey
+		r.Indexes = append(r.Indexes, &storage.Index{
+			Name:      q.Name + "_pkey",
+			TableName: q.Name,
+			Columns:   primaryKey,
+			Unique:    true,
+			Primary:   true,
+		})
+	}
+
+	names := make(map[string]bool)
+	for _, cols := range uniques {
+		name := fmt.Sprintf("%s_%s_key", q.Name, strings.Join(cols, "_"))
+		if names[name] {
+			continue
+		}
+		names[name] = true
+		r.Indexes = append(r.Indexes, &storage.Index{
+			Name:      name,
+			TableName: q.Name,
+			Columns:   cols,
+			Unique:    true,
+		})
+	}
+
+	fields := relationFields(q.Name, r)
+	for i, cond := range checks {
+		// evaluating the condition on a row of nulls reports references to
+		// columns that don't exist
+		if _, err := evaluate(cond, fields, &storage.Row{Vals: make([]interface{}, len(fields))}); err != nil {
+			return err
+		}
+		r.Checks = append(r.Checks, &storage.Check{
+			Name:      fmt.Sprintf("%s_check%d", q.Name, i+1),
+			TableName: q.Name,
+			Expr:      formatExpr(cond),
+		})
 	}
 
 	return rm.CreateTable(r, q.Name)
 }
+
+// fieldDef returns the field that column definition cd describes, without
+// the constraints that aren't stored with the field. A DECIMAL without a
+// precision gets the largest one.
+func fieldDef(cd sql.ColumnDefinition) (storage.FieldDef, error) {
+	fd := storage.FieldDef{
+		Name:    cd.Name,
+		NotNull: cd.NotNull,
+		Default: cd.Default,
+	}
+	switch t := cd.DataType.(type) {
+	case sql.NumericType:
+		fd.DataType = storage.TypeInt
+	case sql.BigIntType:
+		fd.DataType = storage.TypeBigInt
+	case sql.CharacterStringType:
+		fd.DataType = storage.TypeVarchar
+		fd.Len = t.Len
+	case sql.TextType:
+		fd.DataType = storage.TypeText
+	case sql.BlobType:
+		fd.DataType = storage.TypeBlob
+	case sql.BooleanType:
+		fd.DataType = storage.TypeBoolean
+	case sql.RealType:
+		fd.DataType = storage.TypeReal
+	case sql.DoubleType:
+		fd.DataType = storage.TypeDouble
+	case sql.DecimalType:
+		if t.Precision > storage.MaxDecimalPrecision {
+			return fd, fmt.Errorf("%w: DECIMAL precision must be between 1 and %d", sql.ErrInvalidNumericType, storage.MaxDecimalPrecision)
+		}
+		fd.DataType = storage.TypeDecimal
+		fd.Len = t.Precision
+		if fd.Len == 0 {
+			fd.Len = storage.MaxDecimalPrecision
+		}
+		fd.Scale = t.Scale
+	case sql.DateType:
+		fd.DataType = storage.TypeDate
+	case sql.TimeType:
+		fd.DataType = storage.TypeTime
+	case sql.TimestampType:
+		fd.DataType = storage.TypeTimestamp
+	case sql.IntervalType:
+		fd.DataType = storage.TypeInterval
+	default:
+		panic("unsupported column definition type")
+	}
+	return fd, nil
+}
+
+// newForeignKey creates the foreign key of table tableName on columns cols
+// that ref describes.
+func newForeignKey(tableName string, cols []string, ref *sql.References) *storage.ForeignKey {
+	return &storage.ForeignKey{
+		Name:       fmt.Sprintf("%s_%s_fkey", tableName, strings.Join(cols, "_")),
+		TableName:  tableName,
+		Columns:    cols,
+		RefTable:   ref.TableName,
+		RefColumns: ref.Columns,
+		OnDelete:   referentialAction(ref.OnDelete),
+		OnUpdate:   referentialAction(ref.OnUpdate),
+	}
+}
+
+func referentialAction(action sql.TokenType) storage.ReferentialAction {
+	switch action {
+	case sql.RESTRICT:
+		return storage.Restrict
+	case sql.CASCADE:
+		return storage.Cascade
+	case sql.NULL:
+		return storage.SetNull
+	default:
+		return storage.NoAction
+	}
+}
+
+// fieldDefIdx returns the position of the field named name in fds.
+func fieldDefIdx(fds []storage.FieldDef, name string) (int, error) {
+	for i, fd := range fds {
+		if fd.Name == name {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", storage.ErrFieldNotFound, name)
+}
+
+// relationFields returns the fields of the rows of table tableName, whose
+// schema is r.
+func relationFields(tableName string, r *storage.Relation) storage.Fields {
+	var fields storage.Fields
+	for _, fd := range r.Fields {
+		fields = append(fields, &storage.Field{TableID: tableName, Column: fd.Name, DataType: fd.DataType})
+	}
+	return fields
+}
@@ -2,15 +2,20 @@ package engine
 
 import (
 	"github.com/mk6i/mkdb/sql"
-	"github.com/mk6i/mkdb/storage"
 )
 
-func EvaluateDelete(q sql.DeleteStatementSearched, rm RelationManager) (int, error) {
-	rm.StartTxn()
+func EvaluateDelete(q sql.DeleteStatementSearched, rm RelationManager) (count int, err error) {
+	if err := rm.StartTxn(); err != nil {
+		return 0, err
+	}
 	defer rm.EndTxn()
+	defer abortOnError(rm, &err)
+
+	now := statementTime()
+	q.WhereClause = bindNowInExpr(q.WhereClause, now)
 
 	table := q.TableName
-	rows, fields, err := rm.Fetch(table)
+	rows, fields, err := fetchTable(rm, table, table, q.WhereClause)
 	if err != nil {
 		return 0, err
 	}
@@ -22,21 +27,19 @@ func EvaluateDelete(q sql.DeleteStatementSearched, rm RelationManager) (int, err
 		}
 	}
 
-	count := 0
-	var batch storage.WALBatch
-
-	for _, row := range rows {
-		walEntries, err := rm.MarkDeleted(q.TableName, row.RowID)
-		if err != nil {
-			return 0, err
-		}
-		batch = append(batch, walEntries...)
-		count++
+	// the rows are deleted together, so that a foreign key that restricts
+	// the deletion of any of them stops the statement before anything is
+	// deleted
+	w := newRowWriter(rm, now)
+	if err := w.delete(q.TableName, rows, fields); err != nil {
+		return 0, err
 	}
 
-	if err := rm.FlushWALBatch(batch); err != nil {
-		return count, err
+	// referential actions are carried out in the same batch, so that the
+	// rows they change are recovered along with the deleted rows
+	if err := rm.FlushWALBatch(w.batch); err != nil {
+		return 0, err
 	}
 
-	return count, nil
+	return len(rows), nil
 }
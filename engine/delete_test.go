@@ -16,7 +16,7 @@ func TestDelete(t *testing.T) {
 		query         sql.DeleteStatementSearched
 		givenFields   map[string]storage.Fields
 		givenRows     map[string][]*storage.Row
-		expectDeleted []int32
+		expectDeleted [][]byte
 		expectErr     error
 	}{
 		{
@@ -42,30 +42,30 @@ func TestDelete(t *testing.T) {
 			},
 			givenRows: map[string][]*storage.Row{
 				"tbl1": {
-					{RowID: 1, Vals: []interface{}{"a"}},
-					{RowID: 2, Vals: []interface{}{"b"}},
-					{RowID: 3, Vals: []interface{}{"c"}},
-					{RowID: 4, Vals: []interface{}{"d"}},
-					{RowID: 5, Vals: []interface{}{"c"}},
-					{RowID: 6, Vals: []interface{}{"f"}},
-					{RowID: 7, Vals: []interface{}{"c"}},
-					{RowID: 8, Vals: []interface{}{"h"}},
+					{RowID: 1, Key: []byte{1}, Vals: []interface{}{"a"}},
+					{RowID: 2, Key: []byte{2}, Vals: []interface{}{"b"}},
+					{RowID: 3, Key: []byte{3}, Vals: []interface{}{"c"}},
+					{RowID: 4, Key: []byte{4}, Vals: []interface{}{"d"}},
+					{RowID: 5, Key: []byte{5}, Vals: []interface{}{"c"}},
+					{RowID: 6, Key: []byte{6}, Vals: []interface{}{"f"}},
+					{RowID: 7, Key: []byte{7}, Vals: []interface{}{"c"}},
+					{RowID: 8, Key: []byte{8}, Vals: []interface{}{"h"}},
 				},
 			},
-			expectDeleted: []int32{3, 5, 7},
+			expectDeleted: [][]byte{{3}, {5}, {7}},
 		},
 	}
 
 	for _, test := range tc {
 		t.Run(test.name, func(t *testing.T) {
-			var actualDeleted []int32
+			var actualDeleted [][]byte
 
 			rm := &mockRelationManager{
 				fetch: func(tableName string) ([]*storage.Row, []*storage.Field, error) {
 					return test.givenRows[tableName], test.givenFields[tableName], nil
 				},
-				markDeleted: func(tableName string, rowID uint32) (storage.WALBatch, error) {
-					actualDeleted = append(actualDeleted, int32(rowID))
+				markDeleted: func(tableName string, key []byte) (storage.WALBatch, error) {
+					actualDeleted = append(actualDeleted, key)
 					return nil, nil
 				},
 				flushWALBatch: func(batch storage.WALBatch) error {
@@ -82,7 +82,7 @@ func TestDelete(t *testing.T) {
 				t.Fatalf("deleted count does not match. expected: %d actual: %d", len(test.expectDeleted), count)
 			}
 			if !reflect.DeepEqual(test.expectDeleted, actualDeleted) {
-				t.Fatalf("deleted row ID list does not match. expected: %v actual: %v", test.expectDeleted, actualDeleted)
+				t.Fatalf("deleted row key list does not match. expected: %v actual: %v", test.expectDeleted, actualDeleted)
 			}
 		})
 	}
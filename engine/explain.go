@@ -0,0 +1,279 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// explainer is implemented by operators that describe themselves in EXPLAIN
+// output. explain returns the description and the inputs of the operator.
+type explainer interface {
+	explain() (string, []Operator)
+}
+
+// EvaluateExplain returns the plan chosen for a query, one operator per row.
+// Inputs are listed under the operator that reads them.
+func EvaluateExplain(q sql.ExplainStatement, rm RelationManager) ([]*storage.Row, []*storage.Field, error) {
+	if err := rm.StartTxn(); err != nil {
+		return nil, nil, err
+	}
+	defer rm.EndTxn()
+
+	plan, err := planSelect(q.Select, rm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []*storage.Row
+	explainTree(plan, 0, &rows)
+
+	return rows, []*storage.Field{{Column: "QUERY PLAN", DataType: storage.TypeVarchar}}, nil
+}
+
+func explainTree(op Operator, depth int, rows *[]*storage.Row) {
+	e, ok := op.(explainer)
+	if !ok {
+		*rows = append(*rows, &storage.Row{Vals: []interface{}{indent(depth) + fmt.Sprintf("%T", op)}})
+		return
+	}
+
+	desc, inputs := e.explain()
+	if desc == "" {
+		// the operator doesn't matter to the plan, show its inputs in its
+		// place
+		for _, input := range inputs {
+			explainTree(input, depth, rows)
+		}
+		return
+	}
+
+	*rows = append(*rows, &storage.Row{Vals: []interface{}{indent(depth) + desc}})
+	for _, input := range inputs {
+		explainTree(input, depth+1, rows)
+	}
+}
+
+func indent(depth int) string {
+	if depth == 0 {
+		return ""
+	}
+	return strings.Repeat("   ", depth-1) + "-> "
+}
+
+func estimate(rows float64) string {
+	return fmt.Sprintf("(rows=%d)", int64(math.Round(rows)))
+}
+
+func (op *scanOp) explain() (string, []Operator) {
+	table := op.tableName
+	if op.tableID != op.tableName {
+		table += " " + op.tableID
+	}
+
+	switch op.path.kind {
+	case rowIDSeek:
+		return fmt.Sprintf("Row ID Seek on %s (%s = %d) %s", table, storage.RowIDColumn, op.path.rowID, estimate(op.path.rows)), nil
+	case indexScan, indexRangeScan:
+		var keys []string
+		for i, val := range op.path.vals {
+			if val == nil {
+				keys = append(keys, fmt.Sprintf("%s IS NULL", op.path.index.Columns[i]))
+				continue
+			}
+			keys = append(keys, fmt.Sprintf("%s = %s", op.path.index.Columns[i], formatExpr(val)))
+		}
+		desc := "Index Scan"
+		if op.path.kind == indexRangeScan {
+			desc = "Index Range Scan"
+			keys = append(keys, formatKeyRange(op.path.index.Columns[len(op.path.vals)], op.path.rng))
+		}
+		return fmt.Sprintf("%s using %s on %s (%s) %s", desc, op.path.index.Name, table, strings.Join(keys, ", "), estimate(op.path.rows)), nil
+	default:
+		return fmt.Sprintf("Seq Scan on %s %s", table, estimate(op.path.rows)), nil
+	}
+}
+
+// formatKeyRange formats the bounds that rng puts on column col.
+func formatKeyRange(col string, rng storage.KeyRange) string {
+	var bounds []string
+	if rng.Lower != nil {
+		op := ">"
+		if rng.LowerInclusive {
+			op = ">="
+		}
+		bounds = append(bounds, fmt.Sprintf("%s %s %s", col, op, formatExpr(rng.Lower)))
+	}
+	if rng.Upper != nil {
+		op := "<"
+		if rng.UpperInclusive {
+			op = "<="
+		}
+		bounds = append(bounds, fmt.Sprintf("%s %s %s", col, op, formatExpr(rng.Upper)))
+	}
+	return strings.Join(bounds, " AND ")
+}
+
+func (op *singleRowOp) explain() (string, []Operator) {
+	return "Result", nil
+}
+
+func (op *filterOp) explain() (string, []Operator) {
+	return fmt.Sprintf("Filter: %s %s", formatConds(op.conds), estimate(op.rows)), []Operator{op.child}
+}
+
+func (op *nestedLoopJoinOp) explain() (string, []Operator) {
+	desc := fmt.Sprintf("Nested Loop %s Join", joinTypeName(op.joinType))
+	if len(op.conds) > 0 {
+		desc += " on " + formatConds(op.conds)
+	}
+
+	return desc + " " + estimate(op.rows), []Operator{op.lhs, op.rhs}
+}
+
+func (op *hashJoinOp) explain() (string, []Operator) {
+	desc := fmt.Sprintf("Hash %s Join on %s %s", joinTypeName(op.joinType), formatJoinKeys(op.lKeys, op.rKeys, op.conds), estimate(op.rows))
+	if op.buildLeft {
+		return desc, []Operator{&hashBuild{op.lhs}, op.rhs}
+	}
+	return desc, []Operator{op.lhs, &hashBuild{op.rhs}}
+}
+
+func (op *hashBuild) explain() (string, []Operator) {
+	return "Hash", []Operator{op.Operator}
+}
+
+func (op *mergeJoinOp) explain() (string, []Operator) {
+	desc := fmt.Sprintf("Merge %s Join on %s %s", joinTypeName(op.joinType), formatJoinKeys(op.lKeys, op.rKeys, op.conds), estimate(op.rows))
+	return desc, []Operator{op.lhs, op.rhs}
+}
+
+func joinTypeName(joinType sql.JoinType) string {
+	switch joinType {
+	case sql.LEFT_JOIN:
+		return "Left"
+	case sql.RIGHT_JOIN:
+		return "Right"
+	default:
+		return "Inner"
+	}
+}
+
+// formatJoinKeys renders the equated join keys and the rest of the join
+// predicates as SQL.
+func formatJoinKeys(lKeys []sql.ColumnReference, rKeys []sql.ColumnReference, conds []interface{}) string {
+	var strs []string
+	for i := range lKeys {
+		strs = append(strs, fmt.Sprintf("%s = %s", lKeys[i].String(), rKeys[i].String()))
+	}
+	if len(conds) > 0 {
+		strs = append(strs, formatConds(conds))
+	}
+	return strings.Join(strs, " AND ")
+}
+
+func (op *reorderOp) explain() (string, []Operator) {
+	return "", []Operator{op.child}
+}
+
+func (op *projectOp) explain() (string, []Operator) {
+	var cols []string
+	for _, col := range op.selectList {
+		str := formatExpr(col.ValueExpressionPrimary)
+		if col.AsClause != "" {
+			str += " AS " + col.AsClause
+		}
+		cols = append(cols, str)
+	}
+	return "Project: " + strings.Join(cols, ", "), []Operator{op.child}
+}
+
+func (op *aggregateOp) explain() (string, []Operator) {
+	if len(op.groupBy) == 0 {
+		return "Aggregate", []Operator{op.child}
+	}
+	var cols []string
+	for _, col := range op.groupBy {
+		cols = append(cols, col.String())
+	}
+	return "Aggregate: group by " + strings.Join(cols, ", "), []Operator{op.child}
+}
+
+func (op *sortOp) explain() (string, []Operator) {
+	var keys []string
+	for _, ss := range op.ssl {
+		key := ss.SortKey.String()
+		if ss.OrderingSpecification.Type == sql.DESC {
+			key += " DESC"
+		}
+		keys = append(keys, key)
+	}
+	return "Sort: " + strings.Join(keys, ", "), []Operator{op.child}
+}
+
+func (op *limitOp) explain() (string, []Operator) {
+	var parts []string
+	if op.limit >= 0 {
+		parts = append(parts, fmt.Sprintf("limit %d", op.limit))
+	}
+	if op.offset > 0 {
+		parts = append(parts, fmt.Sprintf("offset %d", op.offset))
+	}
+	return "Limit: " + strings.Join(parts, " "), []Operator{op.child}
+}
+
+func formatConds(conds []interface{}) string {
+	var strs []string
+	for _, cond := range conds {
+		strs = append(strs, formatExpr(cond))
+	}
+	return strings.Join(strs, " AND ")
+}
+
+// formatExpr renders an expression as SQL.
+func formatExpr(expr interface{}) string {
+	switch v := expr.(type) {
+	case sql.SearchCondition:
+		return fmt.Sprintf("(%s OR %s)", formatExpr(v.LHS), formatExpr(v.RHS))
+	case sql.BooleanTerm:
+		return fmt.Sprintf("%s AND %s", formatExpr(v.LHS), formatExpr(v.RHS))
+	case sql.Predicate:
+		return fmt.Sprintf("%s %s %s", formatExpr(v.LHS), sql.Tokens[v.CompOp], formatExpr(v.RHS))
+	case sql.ColumnReference:
+		return v.String()
+	case sql.Count, sql.Average, sql.Sum, sql.Min, sql.Max:
+		fn, _ := asSetFunction(v)
+		return fn.String()
+	case sql.Asterisk:
+		return "*"
+	case sql.ArithmeticExpression:
+		return fmt.Sprintf("%s %s %s", formatExpr(v.LHS), sql.Tokens[v.Op], formatExpr(v.RHS))
+	case sql.Extract:
+		return fmt.Sprintf("EXTRACT(%s FROM %s)", v.Field, formatExpr(v.Source))
+	case sql.FunctionCall:
+		var args []string
+		for _, arg := range v.Args {
+			args = append(args, formatExpr(arg))
+		}
+		return fmt.Sprintf("%s(%s)", v.Name, strings.Join(args, ", "))
+	case nowValue:
+		return "NOW()"
+	case storage.Date:
+		return fmt.Sprintf("DATE '%s'", v)
+	case storage.Time:
+		return fmt.Sprintf("TIME '%s'", v)
+	case storage.Timestamp:
+		return fmt.Sprintf("TIMESTAMP '%s'", v)
+	case storage.Interval:
+		return fmt.Sprintf("INTERVAL '%s'", v)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case nil:
+		return "NULL"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
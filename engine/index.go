@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+func EvaluateCreateIndex(q sql.CreateIndex, rm RelationManager) error {
+	return rm.CreateIndex(&storage.Index{
+		Name:      q.Name,
+		TableName: q.TableName,
+		Columns:   q.Columns,
+		Unique:    q.Unique,
+	})
+}
+
+func EvaluateDropIndex(q sql.DropIndex, rm RelationManager) error {
+	return rm.DropIndex(q.Name)
+}
+
+// fetchTable returns the rows of table tableName. See scanTable for how the
+// where clause is used.
+func fetchTable(rm RelationManager, tableName string, tableID string, where interface{}) ([]*storage.Row, []*storage.Field, error) {
+	it, fields, err := scanTable(rm, tableName, tableID, where)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rows []*storage.Row
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == nil {
+			return rows, fields, nil
+		}
+		rows = append(rows, row)
+	}
+}
+
+// scanTable returns an iterator over the rows of table tableName. The rows
+// are read through the cheapest access path for the where clause, so they are
+// a superset of the rows matching it and the caller still has to filter them.
+func scanTable(rm RelationManager, tableName string, tableID string, where interface{}) (storage.RowIterator, []*storage.Field, error) {
+	wc, ok := where.(sql.WhereClause)
+	if !ok {
+		return rm.Scan(tableName)
+	}
+
+	stats, err := rm.TableStats(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path, err := chooseAccessPath(rm, tableName, tableID, splitConjuncts(wc.SearchCondition), stats)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return path.open(rm, tableName)
+}
+
+// equalityPredicates collects the `column = constant` and `column IS NULL`
+// predicates that are ANDed together at the top level of a search condition.
+// A column that IS NULL is collected with a nil value. Predicates that are
+// part of an OR can't be used to narrow down a table scan and are ignored.
+func equalityPredicates(q interface{}, tableID string, vals map[string]interface{}) {
+	switch v := q.(type) {
+	case sql.BooleanTerm:
+		equalityPredicates(v.LHS, tableID, vals)
+		equalityPredicates(v.RHS, tableID, vals)
+	case sql.Predicate:
+		if v.CompOp == sql.IS {
+			if cr, ok := v.LHS.(sql.ColumnReference); ok && (cr.Qualifier == "" || cr.Qualifier == tableID) {
+				vals[cr.ColumnName] = nil
+			}
+			return
+		}
+		if v.CompOp != sql.EQ {
+			return
+		}
+		col, val := v.LHS, v.RHS
+		if _, ok := col.(sql.ColumnReference); !ok {
+			col, val = val, col
+		}
+		cr, ok := col.(sql.ColumnReference)
+		if !ok {
+			return
+		}
+		if cr.Qualifier != "" && cr.Qualifier != tableID {
+			return
+		}
+		switch val.(type) {
+		case int64, string, bool, storage.Date, storage.Time, storage.Timestamp, storage.Interval:
+			vals[cr.ColumnName] = val
+		}
+	}
+}
+
+// rangePredicates finds the comparisons of the columns of table tableID with
+// constants in q and adds the bounds they put on the columns to ranges. The
+// tightest bound is kept if a column is compared more than once.
+func rangePredicates(q interface{}, tableID string, ranges map[string]storage.KeyRange) {
+	switch v := q.(type) {
+	case sql.BooleanTerm:
+		rangePredicates(v.LHS, tableID, ranges)
+		rangePredicates(v.RHS, tableID, ranges)
+	case sql.Predicate:
+		col, val, op := v.LHS, v.RHS, v.CompOp
+		if _, ok := col.(sql.ColumnReference); !ok {
+			// put the column on the left, e.g. 5 < col becomes col > 5
+			col, val, op = val, col, flipCompOp(op)
+		}
+		cr, ok := col.(sql.ColumnReference)
+		if !ok {
+			return
+		}
+		if cr.Qualifier != "" && cr.Qualifier != tableID {
+			return
+		}
+		switch val.(type) {
+		case int64, string, storage.Date, storage.Time, storage.Timestamp, storage.Interval:
+		default:
+			return
+		}
+		rng := ranges[cr.ColumnName]
+		switch op {
+		case sql.GT, sql.GTE:
+			if rng.Lower != nil {
+				if cmp := compareVals(val, rng.Lower); cmp < 0 || (cmp == 0 && op == sql.GTE) {
+					return
+				}
+			}
+			rng.Lower, rng.LowerInclusive = val, op == sql.GTE
+		case sql.LT, sql.LTE:
+			if rng.Upper != nil {
+				if cmp := compareVals(val, rng.Upper); cmp > 0 || (cmp == 0 && op == sql.LTE) {
+					return
+				}
+			}
+			rng.Upper, rng.UpperInclusive = val, op == sql.LTE
+		default:
+			return
+		}
+		ranges[cr.ColumnName] = rng
+	}
+}
@@ -5,28 +5,38 @@ import (
 	"github.com/mk6i/mkdb/storage"
 )
 
-func EvaluateInsert(q sql.InsertStatement, rm RelationManager) (int, error) {
-	rm.StartTxn()
+func EvaluateInsert(q sql.InsertStatement, rm RelationManager) (count int, err error) {
+	if err := rm.StartTxn(); err != nil {
+		return 0, err
+	}
 	defer rm.EndTxn()
+	defer abortOnError(rm, &err)
 
 	tbl := q.TableName
 	cols := q.InsertColumnsAndSource.InsertColumnList.ColumnNames
 	vals := q.InsertColumnsAndSource.QueryExpression.(sql.TableValueConstructor).TableValueConstructorList
 
-	var batch storage.WALBatch
+	now := statementTime()
+	w := newRowWriter(rm, now)
 
-	count := 0
 	for _, tvc := range vals {
-		walEntries, err := rm.Insert(tbl, cols, tvc.RowValueConstructorList)
-		if err != nil {
+		// the values are expressions without column references
+		row := make([]interface{}, len(tvc.RowValueConstructorList))
+		for i, expr := range tvc.RowValueConstructorList {
+			val, err := evaluate(bindNowInExpr(expr, now), storage.Fields{}, &storage.Row{})
+			if err != nil {
+				return 0, err
+			}
+			row[i] = val
+		}
+		if err := w.insert(tbl, cols, row); err != nil {
 			return 0, err
 		}
 		count++
-		batch = append(batch, walEntries...)
 	}
 
-	if err := rm.FlushWALBatch(batch); err != nil {
-		return count, err
+	if err := rm.FlushWALBatch(w.batch); err != nil {
+		return 0, err
 	}
 
 	return count, nil
@@ -0,0 +1,794 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// hashJoinMemLimit is the number of bytes of build rows a hash join holds in
+// memory. Once the build input grows past it, both inputs are partitioned
+// to temporary files by join key and joined one partition at a time.
+var hashJoinMemLimit int64 = 16 << 20
+
+// Bounds on the number of partitions a spilled hash join is split into.
+const (
+	minHashJoinPartitions = 4
+	maxHashJoinPartitions = 128
+)
+
+// joinMatch reports whether all of the join predicates conds are true for
+// row. Rows for which a predicate is UNKNOWN don't match.
+func joinMatch(conds []interface{}, fields storage.Fields, row *storage.Row) (bool, error) {
+	match := true
+	for _, cond := range conds {
+		result, err := evaluate(cond, fields, row)
+		if err != nil {
+			return false, err
+		}
+		val, ok := result.(bool)
+		if !ok && result != nil {
+			return false, ErrNonBoolJoinCond
+		}
+		match = match && val
+	}
+	return match, nil
+}
+
+// joinFields returns the columns of a join of lhs and rhs.
+func joinFields(lhs Operator, rhs Operator) storage.Fields {
+	fields := storage.Fields{}
+	fields = append(fields, lhs.Fields()...)
+	fields = append(fields, rhs.Fields()...)
+	return fields
+}
+
+// keyVals evaluates the join keys of a row read from an input with columns
+// fields.
+func keyVals(keys []sql.ColumnReference, fields storage.Fields, row *storage.Row) ([]interface{}, error) {
+	vals := make([]interface{}, len(keys))
+	for i, key := range keys {
+		val, err := evalPrimary(key, fields, row)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// joinKey returns the hash key of join key values, or an empty string if any
+// of them is null. A null key isn't equal to any key, not even another null
+// one, so rows with a null key never match.
+func joinKey(vals []interface{}) string {
+	if hasNull(vals) {
+		return ""
+	}
+	return hashKey(vals)
+}
+
+func hasNull(vals []interface{}) bool {
+	for _, val := range vals {
+		if val == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hashKey encodes join key values so that two lists of values have the same
+// encoding only if they're equal.
+func hashKey(vals []interface{}) string {
+	var sb strings.Builder
+	for _, val := range vals {
+		var str string
+		switch v := val.(type) {
+		case int64, float64, storage.Decimal:
+			// numbers that compare as equal share a key
+			sb.WriteByte('n')
+			str = numericKey(v)
+		case storage.Date, storage.Time, storage.Timestamp, storage.Interval:
+			// dates and timestamps that compare as equal share a key
+			sb.WriteByte('t')
+			str = temporalKey(v)
+		case string:
+			sb.WriteByte('s')
+			str = v
+		case bool:
+			sb.WriteByte('b')
+			str = strconv.FormatBool(v)
+		default:
+			sb.WriteByte('o')
+			str = fmt.Sprintf("%T:%v", v, v)
+		}
+		// prefix each value with its length so that it ends unambiguously
+		sb.WriteString(strconv.Itoa(len(str)))
+		sb.WriteByte(':')
+		sb.WriteString(str)
+	}
+	return sb.String()
+}
+
+// compareKeys compares two lists of join key values in the order that
+// indexes sort them in. Values of different types are ordered by type, so
+// that they compare as unequal.
+func compareKeys(lhs []interface{}, rhs []interface{}) int {
+	for i := range lhs {
+		if c := compareVals(lhs[i], rhs[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareVals(lhs interface{}, rhs interface{}) int {
+	if lRank, rRank := typeRank(lhs), typeRank(rhs); lRank != rRank {
+		if lRank < rRank {
+			return -1
+		}
+		return 1
+	}
+	switch lhs := lhs.(type) {
+	case int64, float64, storage.Decimal:
+		return compareNumeric(lhs, rhs)
+	case storage.Date, storage.Time, storage.Timestamp, storage.Interval:
+		return compareTemporal(lhs, rhs)
+	case string:
+		rhs := rhs.(string)
+		switch {
+		case lhs < rhs:
+			return -1
+		case lhs > rhs:
+			return 1
+		}
+	case bool:
+		rhs := rhs.(bool)
+		switch {
+		case !lhs && rhs:
+			return -1
+		case lhs && !rhs:
+			return 1
+		}
+	}
+	return 0
+}
+
+func typeRank(val interface{}) int {
+	switch val.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int64, float64, storage.Decimal:
+		// numbers of all types compare with each other
+		return 2
+	case string:
+		return 3
+	case storage.Date, storage.Timestamp:
+		// dates and timestamps compare with each other
+		return 4
+	case storage.Time:
+		return 5
+	case storage.Interval:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// rowSize estimates the number of bytes of memory a row takes up.
+func rowSize(row *storage.Row) int64 {
+	size := int64(48)
+	for _, val := range row.Vals {
+		size += 16
+		if str, ok := val.(string); ok {
+			size += int64(len(str))
+		}
+	}
+	return size
+}
+
+// joinEntry is a row held by a join, along with whether it has been joined
+// to a row of the other input.
+type joinEntry struct {
+	row     *storage.Row
+	key     string
+	matched bool
+}
+
+// hashJoinOp joins two inputs on equality of their join keys. The rows of the
+// build input are loaded into a hash table, which the rows of the probe
+// input look up their matches in. Left and right joins pad the rows of the
+// preserved input that don't have a match, whichever side of the hash table
+// the preserved input is on.
+type hashJoinOp struct {
+	lhs      Operator
+	rhs      Operator
+	joinType sql.JoinType
+	lKeys    []sql.ColumnReference
+	rKeys    []sql.ColumnReference
+	// conds holds the join predicates checked after the keys match
+	conds []interface{}
+	// buildLeft is set if the hash table is built from the left input
+	buildLeft bool
+	// rows is the estimated number of rows returned
+	rows float64
+	// buildRows is the estimated number of rows in the build input
+	buildRows float64
+
+	fields       storage.Fields
+	build        Operator
+	probe        Operator
+	buildKeys    []sql.ColumnReference
+	probeKeys    []sql.ColumnReference
+	padBuild     bool
+	padProbe     bool
+	buildPadding *storage.Row
+	probePadding *storage.Row
+
+	table   map[string][]*joinEntry
+	entries []*joinEntry
+
+	// the partitions of the inputs once the build input is spilled to disk
+	buildParts []*spillFile
+	probeParts []*spillFile
+	part       int
+
+	probeRow     *storage.Row
+	bucket       []*joinEntry
+	bucketPos    int
+	probeMatched bool
+	draining     bool
+	unmatchedPos int
+}
+
+func (op *hashJoinOp) Open() error {
+	if err := op.lhs.Open(); err != nil {
+		return err
+	}
+	if err := op.rhs.Open(); err != nil {
+		return err
+	}
+
+	op.fields = joinFields(op.lhs, op.rhs)
+
+	op.build, op.probe = op.rhs, op.lhs
+	op.buildKeys, op.probeKeys = op.rKeys, op.lKeys
+	if op.buildLeft {
+		op.build, op.probe = op.lhs, op.rhs
+		op.buildKeys, op.probeKeys = op.lKeys, op.rKeys
+	}
+
+	preserveLeft := op.joinType == sql.LEFT_JOIN
+	preserveRight := op.joinType == sql.RIGHT_JOIN
+	op.padBuild = (op.buildLeft && preserveLeft) || (!op.buildLeft && preserveRight)
+	op.padProbe = (op.buildLeft && preserveRight) || (!op.buildLeft && preserveLeft)
+
+	op.buildPadding = &storage.Row{Vals: make([]interface{}, len(op.build.Fields()))}
+	op.probePadding = &storage.Row{Vals: make([]interface{}, len(op.probe.Fields()))}
+
+	op.probeRow = nil
+	op.draining = false
+	op.part = 0
+
+	return op.buildTable()
+}
+
+// buildTable reads the build input into the hash table. If it doesn't fit
+// in memory, both inputs are partitioned to disk instead and the first
+// partition is loaded.
+func (op *hashJoinOp) buildTable() error {
+	op.table = map[string][]*joinEntry{}
+	op.entries = nil
+
+	var mem int64
+	for {
+		row, err := op.build.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		vals, err := keyVals(op.buildKeys, op.build.Fields(), row)
+		if err != nil {
+			return err
+		}
+		entry := &joinEntry{row: row, key: joinKey(vals)}
+
+		if op.buildParts != nil {
+			if err := op.buildParts[op.partition(entry.key)].write(row); err != nil {
+				return err
+			}
+			continue
+		}
+
+		op.addEntry(entry)
+		mem += rowSize(row)
+		if mem > hashJoinMemLimit {
+			if err := op.spill(mem); err != nil {
+				return err
+			}
+		}
+	}
+
+	if op.buildParts == nil {
+		return nil
+	}
+
+	for {
+		row, err := op.probe.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		vals, err := keyVals(op.probeKeys, op.probe.Fields(), row)
+		if err != nil {
+			return err
+		}
+		if err := op.probeParts[op.partition(joinKey(vals))].write(row); err != nil {
+			return err
+		}
+	}
+
+	return op.loadPartition(0)
+}
+
+// addEntry adds a build row to the hash table. A row with a null key is only
+// kept for padding, since no probe row can match it.
+func (op *hashJoinOp) addEntry(entry *joinEntry) {
+	if entry.key != "" {
+		op.table[entry.key] = append(op.table[entry.key], entry)
+	}
+	op.entries = append(op.entries, entry)
+}
+
+// spill moves the build rows read so far into partition files, which the
+// rest of the build rows are then written to. The number of partitions is
+// chosen so that each is expected to fit in memory.
+func (op *hashJoinOp) spill(mem int64) error {
+	avgSize := float64(mem) / float64(len(op.entries))
+	total := avgSize * math.Max(op.buildRows, 2*float64(len(op.entries)))
+	n := int(math.Ceil(total/float64(hashJoinMemLimit))) + 1
+	if n < minHashJoinPartitions {
+		n = minHashJoinPartitions
+	}
+	if n > maxHashJoinPartitions {
+		n = maxHashJoinPartitions
+	}
+
+	for i := 0; i < n; i++ {
+		buildPart, err := newSpillFile()
+		if err != nil {
+			return err
+		}
+		op.buildParts = append(op.buildParts, buildPart)
+		probePart, err := newSpillFile()
+		if err != nil {
+			return err
+		}
+		op.probeParts = append(op.probeParts, probePart)
+	}
+
+	for _, entry := range op.entries {
+		if err := op.buildParts[op.partition(entry.key)].write(entry.row); err != nil {
+			return err
+		}
+	}
+	op.table = map[string][]*joinEntry{}
+	op.entries = nil
+
+	return nil
+}
+
+func (op *hashJoinOp) partition(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(op.buildParts)))
+}
+
+// loadPartition loads the build rows of partition i into the hash table and
+// starts reading its probe rows. Partitions are loaded whole, even if the
+// join keys are too skewed for them to fit in memory.
+func (op *hashJoinOp) loadPartition(i int) error {
+	if i > 0 {
+		// the previous partition is done with
+		if err := op.buildParts[i-1].close(); err != nil {
+			return err
+		}
+		if err := op.probeParts[i-1].close(); err != nil {
+			return err
+		}
+	}
+
+	op.part = i
+	op.table = map[string][]*joinEntry{}
+	op.entries = nil
+
+	if err := op.buildParts[i].rewind(); err != nil {
+		return err
+	}
+	for {
+		row, err := op.buildParts[i].read()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		vals, err := keyVals(op.buildKeys, op.build.Fields(), row)
+		if err != nil {
+			return err
+		}
+		op.addEntry(&joinEntry{row: row, key: joinKey(vals)})
+	}
+
+	return op.probeParts[i].rewind()
+}
+
+func (op *hashJoinOp) nextProbe() (*storage.Row, error) {
+	if op.probeParts != nil {
+		return op.probeParts[op.part].read()
+	}
+	return op.probe.Next()
+}
+
+func (op *hashJoinOp) Next() (*storage.Row, error) {
+	for {
+		if op.probeRow != nil {
+			for op.bucketPos < len(op.bucket) {
+				entry := op.bucket[op.bucketPos]
+				op.bucketPos++
+				row := op.merge(entry.row, op.probeRow)
+				ok, err := joinMatch(op.conds, op.fields, row)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					entry.matched = true
+					op.probeMatched = true
+					return row, nil
+				}
+			}
+			probeRow := op.probeRow
+			op.probeRow = nil
+			if op.padProbe && !op.probeMatched {
+				return op.merge(op.buildPadding, probeRow), nil
+			}
+			continue
+		}
+
+		if !op.draining {
+			row, err := op.nextProbe()
+			if err != nil {
+				return nil, err
+			}
+			if row != nil {
+				vals, err := keyVals(op.probeKeys, op.probe.Fields(), row)
+				if err != nil {
+					return nil, err
+				}
+				op.probeRow = row
+				op.bucket = op.table[joinKey(vals)]
+				op.bucketPos = 0
+				op.probeMatched = false
+				continue
+			}
+			op.draining = true
+			op.unmatchedPos = 0
+		}
+
+		// the probe rows are exhausted, pad the build rows that weren't
+		// matched
+		if op.padBuild {
+			for op.unmatchedPos < len(op.entries) {
+				entry := op.entries[op.unmatchedPos]
+				op.unmatchedPos++
+				if !entry.matched {
+					return op.merge(entry.row, op.probePadding), nil
+				}
+			}
+		}
+
+		if op.part+1 >= len(op.buildParts) {
+			return nil, nil
+		}
+		if err := op.loadPartition(op.part + 1); err != nil {
+			return nil, err
+		}
+		op.draining = false
+	}
+}
+
+// merge combines a build and probe row, keeping the columns of the left input
+// first.
+func (op *hashJoinOp) merge(buildRow *storage.Row, probeRow *storage.Row) *storage.Row {
+	if op.buildLeft {
+		return buildRow.Merge(probeRow)
+	}
+	return probeRow.Merge(buildRow)
+}
+
+func (op *hashJoinOp) Close() error {
+	op.table = nil
+	op.entries = nil
+	op.bucket = nil
+	op.probeRow = nil
+
+	var firstErr error
+	for _, part := range append(op.buildParts, op.probeParts...) {
+		if err := part.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	op.buildParts = nil
+	op.probeParts = nil
+
+	for _, input := range []Operator{op.lhs, op.rhs} {
+		if err := input.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (op *hashJoinOp) Fields() storage.Fields {
+	return op.fields
+}
+
+// hashBuild stands for the hash table of a hash join in EXPLAIN output.
+type hashBuild struct {
+	Operator
+}
+
+// mergeJoinOp joins two inputs that are both sorted by their join keys by
+// reading them side by side. The right rows that share a key are held in
+// memory while the left rows with the same key are joined to them.
+type mergeJoinOp struct {
+	lhs      Operator
+	rhs      Operator
+	joinType sql.JoinType
+	lKeys    []sql.ColumnReference
+	rKeys    []sql.ColumnReference
+	// conds holds the join predicates checked after the keys match
+	conds []interface{}
+	// rows is the estimated number of rows returned
+	rows float64
+
+	fields   storage.Fields
+	lPadding *storage.Row
+	rPadding *storage.Row
+
+	group    []*joinEntry
+	groupKey []interface{}
+	rNext    *storage.Row
+	rNextKey []interface{}
+	lDone    bool
+	out      []*storage.Row
+}
+
+func (op *mergeJoinOp) Open() error {
+	if err := op.lhs.Open(); err != nil {
+		return err
+	}
+	if err := op.rhs.Open(); err != nil {
+		return err
+	}
+
+	op.fields = joinFields(op.lhs, op.rhs)
+	op.lPadding = &storage.Row{Vals: make([]interface{}, len(op.lhs.Fields()))}
+	op.rPadding = &storage.Row{Vals: make([]interface{}, len(op.rhs.Fields()))}
+
+	op.group = nil
+	op.groupKey = nil
+	op.lDone = false
+	op.out = nil
+
+	return op.advanceRight()
+}
+
+// advanceRight reads the next right row ahead of the current group.
+func (op *mergeJoinOp) advanceRight() error {
+	row, err := op.rhs.Next()
+	if err != nil {
+		return err
+	}
+	op.rNext = row
+	op.rNextKey = nil
+	if row != nil {
+		op.rNextKey, err = keyVals(op.rKeys, op.rhs.Fields(), row)
+	}
+	return err
+}
+
+// nextGroup replaces the current group with the right rows that share the
+// key of the next right row.
+func (op *mergeJoinOp) nextGroup() error {
+	op.group = nil
+	op.groupKey = op.rNextKey
+	for op.rNext != nil && compareKeys(op.rNextKey, op.groupKey) == 0 {
+		op.group = append(op.group, &joinEntry{row: op.rNext})
+		if err := op.advanceRight(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardGroup drops the current group, padding its unmatched rows for a
+// right join.
+func (op *mergeJoinOp) discardGroup() {
+	if op.joinType == sql.RIGHT_JOIN {
+		for _, entry := range op.group {
+			if !entry.matched {
+				op.out = append(op.out, op.lPadding.Merge(entry.row))
+			}
+		}
+	}
+	op.group = nil
+}
+
+func (op *mergeJoinOp) Next() (*storage.Row, error) {
+	for {
+		if len(op.out) > 0 {
+			row := op.out[0]
+			op.out = op.out[1:]
+			return row, nil
+		}
+
+		if op.lDone {
+			if op.joinType != sql.RIGHT_JOIN {
+				return nil, nil
+			}
+			if op.group != nil {
+				op.discardGroup()
+				continue
+			}
+			if op.rNext == nil {
+				return nil, nil
+			}
+			row := op.rNext
+			if err := op.advanceRight(); err != nil {
+				return nil, err
+			}
+			return op.lPadding.Merge(row), nil
+		}
+
+		lRow, err := op.lhs.Next()
+		if err != nil {
+			return nil, err
+		}
+		if lRow == nil {
+			op.lDone = true
+			continue
+		}
+		lKey, err := keyVals(op.lKeys, op.lhs.Fields(), lRow)
+		if err != nil {
+			return nil, err
+		}
+
+		// skip the groups of right rows that sort before the left row
+		for op.group == nil || compareKeys(op.groupKey, lKey) < 0 {
+			op.discardGroup()
+			if op.rNext == nil {
+				break
+			}
+			if err := op.nextGroup(); err != nil {
+				return nil, err
+			}
+		}
+
+		matched := false
+		if op.group != nil && !hasNull(lKey) && compareKeys(op.groupKey, lKey) == 0 {
+			for _, entry := range op.group {
+				row := lRow.Merge(entry.row)
+				ok, err := joinMatch(op.conds, op.fields, row)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					entry.matched = true
+					matched = true
+					op.out = append(op.out, row)
+				}
+			}
+		}
+		if !matched && op.joinType == sql.LEFT_JOIN {
+			op.out = append(op.out, lRow.Merge(op.rPadding))
+		}
+	}
+}
+
+func (op *mergeJoinOp) Close() error {
+	op.group = nil
+	op.rNext = nil
+	op.out = nil
+	lErr := op.lhs.Close()
+	if err := op.rhs.Close(); err != nil {
+		return err
+	}
+	return lErr
+}
+
+func (op *mergeJoinOp) Fields() storage.Fields {
+	return op.fields
+}
+
+// spillFile is a temporary file that rows are written to and read back from.
+// The file is removed once it's closed.
+type spillFile struct {
+	f   *os.File
+	w   *bufio.Writer
+	enc *gob.Encoder
+	dec *gob.Decoder
+}
+
+func init() {
+	// rows hold their values as interface{}, so gob has to be told about
+	// the value types that aren't built into it
+	gob.Register(storage.Decimal{})
+	gob.Register(storage.Date(0))
+	gob.Register(storage.Time(0))
+	gob.Register(storage.Timestamp(0))
+	gob.Register(storage.Interval{})
+}
+
+func newSpillFile() (*spillFile, error) {
+	f, err := os.CreateTemp("", "mkdb-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create spill file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	return &spillFile{f: f, w: w, enc: gob.NewEncoder(w)}, nil
+}
+
+func (s *spillFile) write(row *storage.Row) error {
+	return s.enc.Encode(row)
+}
+
+// rewind prepares the rows written so far to be read from the beginning.
+func (s *spillFile) rewind() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.dec = gob.NewDecoder(bufio.NewReader(s.f))
+	return nil
+}
+
+// read returns the next row, or nil once all the rows have been read.
+func (s *spillFile) read() (*storage.Row, error) {
+	row := &storage.Row{}
+	if err := s.dec.Decode(row); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return row, nil
+}
+
+func (s *spillFile) close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	if rmErr := os.Remove(s.f.Name()); err == nil {
+		err = rmErr
+	}
+	s.f = nil
+	return err
+}
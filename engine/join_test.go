@@ -0,0 +1,364 @@
+package engine
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// joinInputs returns a left and right input that are both sorted by id, with
+// duplicate ids, ids that only one side has and null ids, which sort first
+// and don't match each other.
+func joinInputs() (*countingOp, *countingOp) {
+	lhs := &countingOp{
+		fields: storage.Fields{{TableID: "l", Column: "id"}, {TableID: "l", Column: "name"}},
+	}
+	for i, id := range []interface{}{nil, nil, int64(1), int64(2), int64(2), int64(3), int64(5), int64(5), int64(6), int64(9)} {
+		lhs.rows = append(lhs.rows, &storage.Row{Vals: []interface{}{id, fmt.Sprintf("l%d", i)}})
+	}
+	rhs := &countingOp{
+		fields: storage.Fields{{TableID: "r", Column: "id"}, {TableID: "r", Column: "name"}},
+	}
+	for i, id := range []interface{}{nil, int64(0), int64(2), int64(2), int64(4), int64(5), int64(6), int64(6), int64(9), int64(10)} {
+		rhs.rows = append(rhs.rows, &storage.Row{Vals: []interface{}{id, fmt.Sprintf("r%d", i)}})
+	}
+	return lhs, rhs
+}
+
+func joinConds() []interface{} {
+	return []interface{}{
+		sql.Predicate{
+			ComparisonPredicate: sql.ComparisonPredicate{
+				LHS:    sql.ColumnReference{Qualifier: "l", ColumnName: "id"},
+				CompOp: sql.EQ,
+				RHS:    sql.ColumnReference{Qualifier: "r", ColumnName: "id"},
+			},
+		},
+		// filters out some of the rows that have matching keys
+		sql.Predicate{
+			ComparisonPredicate: sql.ComparisonPredicate{
+				LHS:    sql.ColumnReference{Qualifier: "r", ColumnName: "name"},
+				CompOp: sql.NEQ,
+				RHS:    "r6",
+			},
+		},
+	}
+}
+
+// sortedStrings returns the rows as sorted strings, for comparing the rows
+// of joins that return them in different orders.
+func sortedStrings(rows []*storage.Row) []string {
+	var ans []string
+	for _, row := range rows {
+		ans = append(ans, row.String())
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func TestHashAndMergeJoin(t *testing.T) {
+
+	keys := func() ([]sql.ColumnReference, []sql.ColumnReference) {
+		return []sql.ColumnReference{{Qualifier: "l", ColumnName: "id"}},
+			[]sql.ColumnReference{{Qualifier: "r", ColumnName: "id"}}
+	}
+
+	tbl := []struct {
+		name string
+		op   func(lhs Operator, rhs Operator, joinType sql.JoinType) Operator
+	}{
+		{
+			name: "hash join building on the right",
+			op: func(lhs Operator, rhs Operator, joinType sql.JoinType) Operator {
+				lKeys, rKeys := keys()
+				return &hashJoinOp{lhs: lhs, rhs: rhs, joinType: joinType, lKeys: lKeys, rKeys: rKeys, conds: joinConds()[1:]}
+			},
+		},
+		{
+			name: "hash join building on the left",
+			op: func(lhs Operator, rhs Operator, joinType sql.JoinType) Operator {
+				lKeys, rKeys := keys()
+				return &hashJoinOp{lhs: lhs, rhs: rhs, joinType: joinType, lKeys: lKeys, rKeys: rKeys, conds: joinConds()[1:], buildLeft: true}
+			},
+		},
+		{
+			name: "merge join",
+			op: func(lhs Operator, rhs Operator, joinType sql.JoinType) Operator {
+				lKeys, rKeys := keys()
+				return &mergeJoinOp{lhs: lhs, rhs: rhs, joinType: joinType, lKeys: lKeys, rKeys: rKeys, conds: joinConds()[1:]}
+			},
+		},
+	}
+
+	joinTypes := map[string]sql.JoinType{
+		"inner": sql.INNER_JOIN,
+		"left":  sql.LEFT_JOIN,
+		"right": sql.RIGHT_JOIN,
+	}
+
+	for _, test := range tbl {
+		for name, joinType := range joinTypes {
+			t.Run(test.name+"/"+name, func(t *testing.T) {
+				lhs, rhs := joinInputs()
+				expect, err := drain(&nestedLoopJoinOp{lhs: lhs, rhs: rhs, joinType: joinType, conds: joinConds()})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				lhs, rhs = joinInputs()
+				op := test.op(lhs, rhs, joinType)
+				actual, err := drain(op)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(sortedStrings(expect), sortedStrings(actual)) {
+					t.Fatalf("rows do not match. expected: %s actual: %s", expect, actual)
+				}
+				if !reflect.DeepEqual(joinFields(lhs, rhs), op.Fields()) {
+					t.Errorf("fields do not match. expected: %s actual: %s", joinFields(lhs, rhs), op.Fields())
+				}
+				if lhs.opens != 1 || rhs.opens != 1 {
+					t.Errorf("expected each input to be read once, got %d and %d", lhs.opens, rhs.opens)
+				}
+			})
+		}
+	}
+}
+
+func TestHashJoinSpillsToDisk(t *testing.T) {
+
+	defer func(limit int64) {
+		hashJoinMemLimit = limit
+	}(hashJoinMemLimit)
+	hashJoinMemLimit = 1024
+
+	lhs := &countingOp{fields: storage.Fields{{TableID: "l", Column: "id"}}}
+	rhs := &countingOp{fields: storage.Fields{{TableID: "r", Column: "id"}, {TableID: "r", Column: "name"}}}
+	for i := 0; i < 2000; i++ {
+		lhs.rows = append(lhs.rows, &storage.Row{Vals: []interface{}{int64(i)}})
+		if i%2 == 0 {
+			rhs.rows = append(rhs.rows, &storage.Row{Vals: []interface{}{int64(i), fmt.Sprintf("name%d", i)}})
+		}
+	}
+
+	op := &hashJoinOp{
+		lhs:       lhs,
+		rhs:       rhs,
+		joinType:  sql.LEFT_JOIN,
+		lKeys:     []sql.ColumnReference{{Qualifier: "l", ColumnName: "id"}},
+		rKeys:     []sql.ColumnReference{{Qualifier: "r", ColumnName: "id"}},
+		rows:      2000,
+		buildRows: 1000,
+	}
+
+	if err := op.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if len(op.buildParts) == 0 {
+		t.Fatal("expected the build input to be spilled to disk")
+	}
+
+	var rows []*storage.Row
+	for {
+		row, err := op.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	if err := op.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var expect []*storage.Row
+	for i := 0; i < 2000; i++ {
+		if i%2 == 0 {
+			expect = append(expect, &storage.Row{Vals: []interface{}{int64(i), int64(i), fmt.Sprintf("name%d", i)}})
+		} else {
+			expect = append(expect, &storage.Row{Vals: []interface{}{int64(i), nil, nil}})
+		}
+	}
+	if !reflect.DeepEqual(sortedStrings(expect), sortedStrings(rows)) {
+		t.Fatalf("rows do not match. expected %d rows, got %d", len(expect), len(rows))
+	}
+}
+
+func TestSpillFileValueTypes(t *testing.T) {
+
+	rows := []*storage.Row{
+		{Vals: []interface{}{
+			int64(1), "one", true, 1.5, storage.NewDecimal(1250, 2), []byte{1, 2},
+			storage.Date(20454), storage.Time(36000000000), storage.Timestamp(1767261600000000),
+			storage.Interval{Months: 1, Days: 2, Micros: 3},
+		}},
+		{Vals: []interface{}{
+			int64(-2), "", false, -0.25, storage.NewDecimal(-3, 0), []byte{0},
+			storage.Date(-1), storage.Time(0), storage.Timestamp(-1), storage.Interval{},
+		}},
+		{Vals: []interface{}{nil, nil, nil, nil, nil, nil, nil, nil, nil, nil}},
+	}
+
+	s, err := newSpillFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	for _, row := range rows {
+		if err := s.write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.rewind(); err != nil {
+		t.Fatal(err)
+	}
+
+	var actual []*storage.Row
+	for {
+		row, err := s.read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		actual = append(actual, row)
+	}
+	if !reflect.DeepEqual(rows, actual) {
+		t.Fatalf("rows do not match. expected: %v actual: %v", rows, actual)
+	}
+}
+
+func TestPlanJoinMethod(t *testing.T) {
+
+	givenFields := map[string]storage.Fields{
+		"tbl1": {
+			&storage.Field{Column: "id"},
+			&storage.Field{Column: "grp"},
+		},
+		"tbl2": {
+			&storage.Field{Column: "id"},
+			&storage.Field{Column: "grp"},
+		},
+	}
+	givenRows := map[string][]*storage.Row{
+		"tbl1": {
+			{Vals: []interface{}{int64(1), int64(1)}},
+			{Vals: []interface{}{int64(2), int64(1)}},
+			{Vals: []interface{}{int64(3), int64(1)}},
+		},
+		"tbl2": {
+			{Vals: []interface{}{int64(2), int64(1)}},
+			{Vals: []interface{}{int64(3), int64(1)}},
+			{Vals: []interface{}{int64(4), int64(1)}},
+		},
+	}
+
+	newRM := func(rowCount int64) *mockRelationManager {
+		return &mockRelationManager{
+			fetch: func(tableName string) ([]*storage.Row, []*storage.Field, error) {
+				var fields []*storage.Field
+				for _, fd := range givenFields[tableName] {
+					cp := *fd
+					fields = append(fields, &cp)
+				}
+				return givenRows[tableName], fields, nil
+			},
+			fetchByIndex: func(idx *storage.Index, vals []interface{}) ([]*storage.Row, []*storage.Field, error) {
+				var fields []*storage.Field
+				for _, fd := range givenFields[idx.TableName] {
+					cp := *fd
+					fields = append(fields, &cp)
+				}
+				return givenRows[idx.TableName], fields, nil
+			},
+			indexes: func(tableName string) ([]*storage.Index, error) {
+				return []*storage.Index{
+					{Name: tableName + "_idx", TableName: tableName, Columns: []string{"grp", "id"}},
+				}, nil
+			},
+			tableStats: func(tableName string) (storage.TableStats, error) {
+				return storage.TableStats{RowCount: rowCount}, nil
+			},
+		}
+	}
+
+	tbl := []struct {
+		name     string
+		query    string
+		rowCount int64
+		expect   string
+		rows     int
+	}{
+		{
+			name:     "small tables",
+			query:    `SELECT * FROM tbl1 JOIN tbl2 ON tbl1.id = tbl2.id`,
+			rowCount: 3,
+			expect:   "-> Nested Loop Inner Join on tbl1.id = tbl2.id (rows=3)",
+			rows:     2,
+		},
+		{
+			name:     "large tables",
+			query:    `SELECT * FROM tbl1 JOIN tbl2 ON tbl1.id = tbl2.id`,
+			rowCount: 1000,
+			expect:   "-> Hash Inner Join on tbl1.id = tbl2.id (rows=1000)",
+			rows:     2,
+		},
+		{
+			name:     "large tables joined without equality",
+			query:    `SELECT * FROM tbl1 JOIN tbl2 ON tbl1.id < tbl2.id`,
+			rowCount: 1000,
+			expect:   "-> Nested Loop Inner Join on tbl1.id < tbl2.id (rows=333333)",
+			rows:     6,
+		},
+		{
+			name:     "large tables joined with outer join",
+			query:    `SELECT * FROM tbl1 LEFT JOIN tbl2 ON tbl1.id = tbl2.id AND tbl1.grp > tbl2.grp`,
+			rowCount: 1000,
+			expect:   "-> Hash Left Join on tbl1.id = tbl2.id AND tbl1.grp > tbl2.grp (rows=1000)",
+			rows:     3,
+		},
+		{
+			name:     "inputs sorted by index",
+			query:    `SELECT * FROM tbl1 JOIN tbl2 ON tbl1.id = tbl2.id WHERE tbl1.grp = 1 AND tbl2.grp = 1`,
+			rowCount: 1000,
+			expect:   "-> Merge Inner Join on tbl1.id = tbl2.id (rows=100)",
+			rows:     2,
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			rm := newRM(test.rowCount)
+
+			q, err := parseSQL(test.query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plan, _, err := EvaluateExplain(sql.ExplainStatement{Select: q.(sql.Select)}, rm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := plan[1].Vals[0]; actual != test.expect {
+				t.Fatalf("unexpected join. expected: %q actual: %q", test.expect, actual)
+			}
+
+			rows, _, err := EvaluateSelect(q.(sql.Select), rm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != test.rows {
+				t.Errorf("expected %d rows, got %s", test.rows, rows)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package engine
+
+import (
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// convertLiterals returns a copy of statement stmt in which the decimal and
+// typed literals that the parser keeps as text are replaced by their values.
+func convertLiterals(stmt interface{}) (interface{}, error) {
+	var err error
+	switch q := stmt.(type) {
+	case sql.Select:
+		return convertLiteralsInSelect(q)
+	case sql.ExplainStatement:
+		q.Select, err = convertLiteralsInSelect(q.Select)
+		return q, err
+	case sql.InsertStatement:
+		tvc, ok := q.QueryExpression.(sql.TableValueConstructor)
+		if !ok {
+			return q, nil
+		}
+		rows := make([]sql.RowValueConstructor, len(tvc.TableValueConstructorList))
+		for i, rvc := range tvc.TableValueConstructorList {
+			vals := make([]interface{}, len(rvc.RowValueConstructorList))
+			for j, val := range rvc.RowValueConstructorList {
+				if vals[j], err = convertLiteralsInExpr(val); err != nil {
+					return nil, err
+				}
+			}
+			rows[i] = sql.RowValueConstructor{RowValueConstructorList: vals}
+		}
+		tvc.TableValueConstructorList = rows
+		q.QueryExpression = tvc
+		return q, nil
+	case sql.UpdateStatementSearched:
+		set := make([]sql.SetClause, len(q.Set))
+		for i, sc := range q.Set {
+			if sc.UpdateSource, err = convertLiteralsInExpr(sc.UpdateSource); err != nil {
+				return nil, err
+			}
+			set[i] = sc
+		}
+		q.Set = set
+		q.Where, err = convertLiteralsInExpr(q.Where)
+		return q, err
+	case sql.DeleteStatementSearched:
+		q.WhereClause, err = convertLiteralsInExpr(q.WhereClause)
+		return q, err
+	case sql.CreateTable:
+		elems := make([]sql.TableElement, len(q.Elements))
+		for i, elem := range q.Elements {
+			if elem.ColumnDefinition, err = convertLiteralsInColumn(elem.ColumnDefinition); err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		q.Elements = elems
+		constraints := make([]sql.TableConstraint, len(q.Constraints))
+		for i, tc := range q.Constraints {
+			if tc.Check, err = convertLiteralsInExpr(tc.Check); err != nil {
+				return nil, err
+			}
+			constraints[i] = tc
+		}
+		q.Constraints = constraints
+		return q, nil
+	case sql.AlterTable:
+		q.ColumnDefinition, err = convertLiteralsInColumn(q.ColumnDefinition)
+		return q, err
+	}
+	return stmt, nil
+}
+
+// convertLiteralsInSelect returns a copy of query q in which the literals
+// are replaced by their values.
+func convertLiteralsInSelect(q sql.Select) (sql.Select, error) {
+	var err error
+	selectList := make(sql.SelectList, len(q.SelectList))
+	for i, dc := range q.SelectList {
+		if dc.ValueExpressionPrimary, err = convertLiteralsInExpr(dc.ValueExpressionPrimary); err != nil {
+			return q, err
+		}
+		selectList[i] = dc
+	}
+	q.SelectList = selectList
+	from := make(sql.FromClause, len(q.FromClause))
+	for i, tf := range q.FromClause {
+		if from[i], err = convertLiteralsInTableRef(tf); err != nil {
+			return q, err
+		}
+	}
+	q.FromClause = from
+	q.WhereClause, err = convertLiteralsInExpr(q.WhereClause)
+	return q, err
+}
+
+// convertLiteralsInTableRef returns a copy of table reference tf in which
+// the literals of the join conditions are replaced by their values.
+func convertLiteralsInTableRef(tf sql.TableReference) (sql.TableReference, error) {
+	join, ok := tf.(sql.QualifiedJoin)
+	if !ok {
+		return tf, nil
+	}
+	var err error
+	if join.LHS, err = convertLiteralsInTableRef(join.LHS); err != nil {
+		return nil, err
+	}
+	if join.RHS, err = convertLiteralsInTableRef(join.RHS); err != nil {
+		return nil, err
+	}
+	join.JoinCondition, err = convertLiteralsInExpr(join.JoinCondition)
+	return join, err
+}
+
+// convertLiteralsInColumn returns a copy of column definition cd in which
+// the literals of the default and check constraint are replaced by their
+// values.
+func convertLiteralsInColumn(cd sql.ColumnDefinition) (sql.ColumnDefinition, error) {
+	var err error
+	if cd.Default, err = convertLiteralsInExpr(cd.Default); err != nil {
+		return cd, err
+	}
+	cd.Check, err = convertLiteralsInExpr(cd.Check)
+	return cd, err
+}
+
+// convertLiteralsInExpr returns a copy of expr in which the literals are
+// replaced by their values.
+func convertLiteralsInExpr(expr interface{}) (interface{}, error) {
+	var err error
+	switch v := expr.(type) {
+	case sql.DecimalLiteral:
+		return storage.ParseDecimal(string(v))
+	case sql.TypedLiteral:
+		return typedLiteralVal(v)
+	case sql.WhereClause:
+		v.SearchCondition, err = convertLiteralsInExpr(v.SearchCondition)
+		return v, err
+	case sql.SearchCondition:
+		if v.LHS, err = convertLiteralsInExpr(v.LHS); err != nil {
+			return nil, err
+		}
+		v.RHS, err = convertLiteralsInExpr(v.RHS)
+		return v, err
+	case sql.BooleanTerm:
+		if v.LHS, err = convertLiteralsInExpr(v.LHS); err != nil {
+			return nil, err
+		}
+		v.RHS, err = convertLiteralsInExpr(v.RHS)
+		return v, err
+	case sql.Predicate:
+		if v.LHS, err = convertLiteralsInExpr(v.LHS); err != nil {
+			return nil, err
+		}
+		v.RHS, err = convertLiteralsInExpr(v.RHS)
+		return v, err
+	case sql.ArithmeticExpression:
+		if v.LHS, err = convertLiteralsInExpr(v.LHS); err != nil {
+			return nil, err
+		}
+		v.RHS, err = convertLiteralsInExpr(v.RHS)
+		return v, err
+	case sql.Extract:
+		v.Source, err = convertLiteralsInExpr(v.Source)
+		return v, err
+	case sql.Count:
+		v.ValueExpression, err = convertLiteralsInExpr(v.ValueExpression)
+		return v, err
+	case sql.Average:
+		v.ValueExpression, err = convertLiteralsInExpr(v.ValueExpression)
+		return v, err
+	case sql.Sum:
+		v.ValueExpression, err = convertLiteralsInExpr(v.ValueExpression)
+		return v, err
+	case sql.Min:
+		v.ValueExpression, err = convertLiteralsInExpr(v.ValueExpression)
+		return v, err
+	case sql.Max:
+		v.ValueExpression, err = convertLiteralsInExpr(v.ValueExpression)
+		return v, err
+	case sql.FunctionCall:
+		args := make([]sql.ValueExpression, len(v.Args))
+		for i, arg := range v.Args {
+			if args[i], err = convertLiteralsInExpr(arg); err != nil {
+				return nil, err
+			}
+		}
+		v.Args = args
+		return v, nil
+	}
+	return expr, nil
+}
+
+// typedLiteralVal returns the value of the type that literal lit names.
+func typedLiteralVal(lit sql.TypedLiteral) (interface{}, error) {
+	switch lit.Type {
+	case sql.T_DATE:
+		return storage.ParseDate(lit.Text)
+	case sql.T_TIME:
+		return storage.ParseTime(lit.Text)
+	case sql.T_TIMESTAMP:
+		return storage.ParseTimestamp(lit.Text)
+	}
+	iv, err := storage.ParseInterval(lit.Text)
+	if err != nil {
+		return nil, err
+	}
+	if lit.Negative {
+		iv = iv.Neg()
+	}
+	return iv, nil
+}
+
+// bindArgs returns a copy of args in which the decimals are replaced by the
+// text that the parser reads them as.
+func bindArgs(args []interface{}) []interface{} {
+	ret := make([]interface{}, len(args))
+	for i, arg := range args {
+		if d, ok := arg.(storage.Decimal); ok {
+			arg = sql.DecimalLiteral(d.String())
+		}
+		ret[i] = arg
+	}
+	return ret
+}
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/mk6i/mkdb/storage"
+)
+
+// isNumeric reports whether val is an integer, floating-point or decimal
+// number.
+func isNumeric(val interface{}) bool {
+	switch val.(type) {
+	case int64, float64, storage.Decimal:
+		return true
+	}
+	return false
+}
+
+// compareNumeric compares numbers lhs and rhs, returning -1, 0 or 1. Integers
+// and decimals are compared exactly. If either number is floating-point, the
+// other one is converted to floating-point first.
+func compareNumeric(lhs interface{}, rhs interface{}) int {
+	if l, ok := lhs.(int64); ok {
+		if r, ok := rhs.(int64); ok {
+			switch {
+			case l < r:
+				return -1
+			case l > r:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	_, lFloat := lhs.(float64)
+	_, rFloat := rhs.(float64)
+	if lFloat || rFloat {
+		l, r := toFloat(lhs), toFloat(rhs)
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		}
+		return 0
+	}
+	return toDecimal(lhs).Cmp(toDecimal(rhs))
+}
+
+// toFloat converts number val to floating-point.
+func toFloat(val interface{}) float64 {
+	switch v := val.(type) {
+	case int64:
+		return float64(v)
+	case storage.Decimal:
+		return v.Float64()
+	}
+	return val.(float64)
+}
+
+// toDecimal converts integer or decimal val to a decimal.
+func toDecimal(val interface{}) storage.Decimal {
+	if v, ok := val.(int64); ok {
+		return storage.NewDecimal(v, 0)
+	}
+	return val.(storage.Decimal)
+}
+
+// numericKey returns the same string for numbers that compare as equal,
+// whatever their types, e.g. 2, 2.0 and 2.00.
+func numericKey(val interface{}) string {
+	switch v := val.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if v == 0 {
+			// -0 equals 0
+			v = 0
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	str := val.(storage.Decimal).String()
+	if strings.Contains(str, ".") {
+		str = strings.TrimRight(strings.TrimRight(str, "0"), ".")
+	}
+	if str == "-0" {
+		str = "0"
+	}
+	return str
+}
+
+// avgMinScale is the smallest number of digits after the decimal point of an
+// exact average.
+const avgMinScale = 6
+
+// avgState accumulates the values that AVG averages for a group.
+type avgState struct {
+	count int64
+	// sum is the exact sum of the integers and decimals, fsum the sum of the
+	// floating-point numbers
+	sum   *big.Rat
+	fsum  float64
+	float bool
+	scale int
+}
+
+// add adds number val to the average.
+func (s *avgState) add(val interface{}) {
+	s.count++
+	switch v := val.(type) {
+	case int64:
+		s.sum.Add(s.sum, new(big.Rat).SetInt64(v))
+	case storage.Decimal:
+		s.sum.Add(s.sum, v.Rat())
+		if v.Scale() > s.scale {
+			s.scale = v.Scale()
+		}
+	case float64:
+		s.fsum += v
+		s.float = true
+	}
+}
+
+// avg returns the average of the values added. It's floating-point if the
+// values are, otherwise it's a decimal with at least avgMinScale digits
+// after the decimal point, or fewer if a large average doesn't fit.
+func (s *avgState) avg() (interface{}, error) {
+	if s.float {
+		f, _ := s.sum.Float64()
+		return (s.fsum + f) / float64(s.count), nil
+	}
+	avg := new(big.Rat).Quo(s.sum, new(big.Rat).SetInt64(s.count))
+	scale := s.scale
+	if scale < avgMinScale {
+		scale = avgMinScale
+	}
+	for ; scale >= 0; scale-- {
+		d, err := storage.DecimalFromRat(avg, scale)
+		if !errors.Is(err, storage.ErrNumericOutOfRange) {
+			return d, err
+		}
+	}
+	return nil, storage.ErrNumericOutOfRange
+}
+
+// numericArithmetic adds (plus) or subtracts numbers lhs and rhs. The sum
+// of integers is an integer, unless it overflows, and the sum of decimals
+// is exact. If either number is floating-point, so is the sum.
+func numericArithmetic(lhs interface{}, plus bool, rhs interface{}) (interface{}, error) {
+	if l, ok := lhs.(int64); ok {
+		if r, ok := rhs.(int64); ok {
+			if !plus {
+				if r == math.MinInt64 {
+					return nil, storage.ErrIntOutOfRange
+				}
+				r = -r
+			}
+			sum := l + r
+			if (sum > l) != (r > 0) {
+				return nil, storage.ErrIntOutOfRange
+			}
+			return sum, nil
+		}
+	}
+
+	_, lFloat := lhs.(float64)
+	_, rFloat := rhs.(float64)
+	if lFloat || rFloat {
+		if plus {
+			return toFloat(lhs) + toFloat(rhs), nil
+		}
+		return toFloat(lhs) - toFloat(rhs), nil
+	}
+
+	l, r := toDecimal(lhs), toDecimal(rhs)
+	scale := l.Scale()
+	if r.Scale() > scale {
+		scale = r.Scale()
+	}
+	if plus {
+		return storage.DecimalFromRat(new(big.Rat).Add(l.Rat(), r.Rat()), scale)
+	}
+	return storage.DecimalFromRat(new(big.Rat).Sub(l.Rat(), r.Rat()), scale)
+}
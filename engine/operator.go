@@ -0,0 +1,777 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// Operator is a node in a query plan. Rows are pulled through the plan one at
+// a time, so operators only hold on to the rows they need to see all at once,
+// such as the groups of an aggregation or the input of a sort.
+type Operator interface {
+	// Open prepares the operator to return rows from the beginning. An
+	// operator can be opened again after it's closed to rescan its input.
+	Open() error
+	// Next returns the next row, or nil once there are no more rows.
+	Next() (*storage.Row, error)
+	// Close releases the rows held by the operator and its inputs.
+	Close() error
+	// Fields returns the columns of the returned rows. It's only valid after
+	// Open is called.
+	Fields() storage.Fields
+}
+
+// drain opens op and reads all of its rows.
+func drain(op Operator) ([]*storage.Row, error) {
+	if err := op.Open(); err != nil {
+		op.Close()
+		return nil, err
+	}
+
+	var rows []*storage.Row
+	for {
+		row, err := op.Next()
+		if err != nil {
+			op.Close()
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, op.Close()
+}
+
+// scanOp reads the rows of a table through an access path.
+type scanOp struct {
+	rm        RelationManager
+	tableName string
+	tableID   string
+	path      accessPath
+
+	it     storage.RowIterator
+	fields storage.Fields
+}
+
+func (op *scanOp) Open() error {
+	it, fields, err := op.path.open(op.rm, op.tableName)
+	if err != nil {
+		return err
+	}
+	for _, fd := range fields {
+		fd.TableID = op.tableID
+	}
+	op.it = it
+	op.fields = fields
+	return nil
+}
+
+func (op *scanOp) Next() (*storage.Row, error) {
+	return op.it.Next()
+}
+
+func (op *scanOp) Close() error {
+	op.it = nil
+	return nil
+}
+
+func (op *scanOp) Fields() storage.Fields {
+	return op.fields
+}
+
+// singleRowOp returns one empty row. It stands in for the FROM clause of a
+// query that doesn't select from a table.
+type singleRowOp struct {
+	done bool
+}
+
+func (op *singleRowOp) Open() error {
+	op.done = false
+	return nil
+}
+
+func (op *singleRowOp) Next() (*storage.Row, error) {
+	if op.done {
+		return nil, nil
+	}
+	op.done = true
+	return &storage.Row{}, nil
+}
+
+func (op *singleRowOp) Close() error {
+	return nil
+}
+
+func (op *singleRowOp) Fields() storage.Fields {
+	return storage.Fields{}
+}
+
+// filterOp returns the rows for which all of a list of predicates are true.
+type filterOp struct {
+	child Operator
+	conds []interface{}
+	// rows is the estimated number of rows returned
+	rows float64
+}
+
+func (op *filterOp) Open() error {
+	return op.child.Open()
+}
+
+func (op *filterOp) Next() (*storage.Row, error) {
+	for {
+		row, err := op.child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		ok, err := matchAll(op.conds, op.child.Fields(), row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return row, nil
+		}
+	}
+}
+
+// matchAll reports whether all of conds are true for row. As with AND, every
+// predicate has to evaluate to a boolean or UNKNOWN when there's more than
+// one. Rows for which a predicate is UNKNOWN don't match.
+func matchAll(conds []interface{}, qfields storage.Fields, row *storage.Row) (bool, error) {
+	match := true
+	for _, cond := range conds {
+		result, err := evaluate(cond, qfields, row)
+		if err != nil {
+			return false, err
+		}
+		val, ok := result.(bool)
+		if !ok && result != nil && len(conds) > 1 {
+			return false, newErrIncompatTypeCompare(cond, result)
+		}
+		match = match && val
+	}
+	return match, nil
+}
+
+func (op *filterOp) Close() error {
+	return op.child.Close()
+}
+
+func (op *filterOp) Fields() storage.Fields {
+	return op.child.Fields()
+}
+
+// nestedLoopJoinOp joins two inputs by rescanning the inner input for each
+// row of the outer input. The left input is the outer one, except for right
+// joins.
+type nestedLoopJoinOp struct {
+	lhs      Operator
+	rhs      Operator
+	joinType sql.JoinType
+	conds    []interface{}
+	// rows is the estimated number of rows returned
+	rows float64
+
+	outer      Operator
+	inner      Operator
+	fields     storage.Fields
+	padding    *storage.Row
+	outerRow   *storage.Row
+	matched    bool
+	innerFresh bool
+}
+
+func (op *nestedLoopJoinOp) Open() error {
+	if err := op.lhs.Open(); err != nil {
+		return err
+	}
+	if err := op.rhs.Open(); err != nil {
+		return err
+	}
+
+	op.outer, op.inner = op.lhs, op.rhs
+	if op.joinType == sql.RIGHT_JOIN {
+		op.outer, op.inner = op.rhs, op.lhs
+	}
+
+	op.fields = storage.Fields{}
+	op.fields = append(op.fields, op.lhs.Fields()...)
+	op.fields = append(op.fields, op.rhs.Fields()...)
+
+	op.padding = &storage.Row{Vals: make([]interface{}, len(op.inner.Fields()))}
+	op.outerRow = nil
+	op.innerFresh = true
+
+	return nil
+}
+
+func (op *nestedLoopJoinOp) Next() (*storage.Row, error) {
+	for {
+		if op.outerRow == nil {
+			row, err := op.outer.Next()
+			if err != nil || row == nil {
+				return nil, err
+			}
+			// the inner input is already at its beginning for the first
+			// outer row
+			if !op.innerFresh {
+				if err := op.inner.Close(); err != nil {
+					return nil, err
+				}
+				if err := op.inner.Open(); err != nil {
+					return nil, err
+				}
+			}
+			op.innerFresh = false
+			op.outerRow = row
+			op.matched = false
+		}
+
+		innerRow, err := op.inner.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if innerRow == nil {
+			outerRow := op.outerRow
+			op.outerRow = nil
+			if !op.matched && (op.joinType == sql.LEFT_JOIN || op.joinType == sql.RIGHT_JOIN) {
+				return op.merge(outerRow, op.padding), nil
+			}
+			continue
+		}
+
+		tmpRow := op.merge(op.outerRow, innerRow)
+		doJoin := true
+		for _, cond := range op.conds {
+			result, err := evaluate(cond, op.fields, tmpRow)
+			if err != nil {
+				return nil, err
+			}
+			val, ok := result.(bool)
+			if !ok && result != nil {
+				return nil, ErrNonBoolJoinCond
+			}
+			doJoin = doJoin && val
+		}
+		if doJoin {
+			op.matched = true
+			return tmpRow, nil
+		}
+	}
+}
+
+// merge combines an outer and inner row, keeping the columns of the left
+// input first.
+func (op *nestedLoopJoinOp) merge(outerRow *storage.Row, innerRow *storage.Row) *storage.Row {
+	if op.joinType == sql.RIGHT_JOIN {
+		return innerRow.Merge(outerRow)
+	}
+	return outerRow.Merge(innerRow)
+}
+
+func (op *nestedLoopJoinOp) Close() error {
+	op.outerRow = nil
+	lErr := op.lhs.Close()
+	if err := op.rhs.Close(); err != nil {
+		return err
+	}
+	return lErr
+}
+
+func (op *nestedLoopJoinOp) Fields() storage.Fields {
+	return op.fields
+}
+
+// reorderOp rearranges the columns of a join whose inputs were reordered by
+// the planner, so that they appear in the order the inputs were written in.
+type reorderOp struct {
+	child Operator
+	// inputs holds the joined inputs in written order
+	inputs []Operator
+	// order holds the positions in inputs of the inputs in join order
+	order []int
+
+	perm   []int
+	fields storage.Fields
+}
+
+func (op *reorderOp) Open() error {
+	if err := op.child.Open(); err != nil {
+		return err
+	}
+
+	// find where each input's columns start in the joined row
+	starts := make([]int, len(op.inputs))
+	pos := 0
+	for _, idx := range op.order {
+		starts[idx] = pos
+		pos += len(op.inputs[idx].Fields())
+	}
+
+	childFields := op.child.Fields()
+	op.perm = op.perm[:0]
+	op.fields = nil
+	for i, input := range op.inputs {
+		for j := range input.Fields() {
+			op.perm = append(op.perm, starts[i]+j)
+			op.fields = append(op.fields, childFields[starts[i]+j])
+		}
+	}
+
+	return nil
+}
+
+func (op *reorderOp) Next() (*storage.Row, error) {
+	row, err := op.child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(op.perm))
+	for i, idx := range op.perm {
+		vals[i] = row.Vals[idx]
+	}
+	return &storage.Row{RowID: row.RowID, Key: row.Key, Vals: vals}, nil
+}
+
+func (op *reorderOp) Close() error {
+	return op.child.Close()
+}
+
+func (op *reorderOp) Fields() storage.Fields {
+	return op.fields
+}
+
+// projectOp rearranges the columns of its input according to the select list.
+// Set functions are given the values of their arguments, which aggregateOp
+// aggregates.
+type projectOp struct {
+	child      Operator
+	selectList sql.SelectList
+
+	// maps SELECT column references to input column positions, or to
+	// rowIDIdx for the rowid pseudo-column
+	lookup map[sql.ColumnReference]int
+	fields storage.Fields
+}
+
+// rowIDIdx stands for the position of the rowid pseudo-column, which isn't
+// stored with the other columns.
+const rowIDIdx = -1
+
+func (op *projectOp) Open() error {
+	if err := op.child.Open(); err != nil {
+		return err
+	}
+
+	qfields := op.child.Fields()
+
+	if _, isSelectStar := op.selectList[0].ValueExpressionPrimary.(sql.Asterisk); isSelectStar {
+		// select *, nothing to do here
+		op.lookup = nil
+		op.fields = qfields
+		return nil
+	}
+
+	op.lookup = map[sql.ColumnReference]int{}
+
+	// build column reference lookup
+	for _, elem := range op.selectList {
+		switch elem := elem.ValueExpressionPrimary.(type) {
+		case sql.ColumnReference:
+			idx, err := findColumnInFieldList(elem, qfields)
+			if err != nil {
+				if !isRowIDColumn(elem, qfields) {
+					return err
+				}
+				idx = rowIDIdx
+			}
+			op.lookup[elem] = idx
+		default:
+			fn, ok := asSetFunction(elem)
+			if !ok {
+				break
+			}
+			// check the columns of the argument up front, since an empty
+			// input never evaluates it
+			for _, col := range columnRefs(fn.arg) {
+				if _, err := findColumnInFieldList(col, qfields); err != nil && !isRowIDColumn(col, qfields) {
+					return err
+				}
+			}
+		}
+	}
+
+	op.fields = nil
+
+	// build the query result header row
+	for _, selectCol := range op.selectList {
+		var field *storage.Field
+
+		switch elem := selectCol.ValueExpressionPrimary.(type) {
+		case sql.ColumnReference:
+			if op.lookup[elem] == rowIDIdx {
+				field = &storage.Field{
+					TableID:  qfields[0].TableID,
+					Column:   storage.RowIDColumn,
+					DataType: storage.TypeBigInt,
+				}
+				break
+			}
+			// copy the field so that an alias doesn't rename the input
+			// column, which operators below still look up by name
+			fd := *qfields[op.lookup[elem]]
+			field = &fd
+		default:
+			if fn, ok := asSetFunction(elem); ok {
+				field = &storage.Field{Column: fn.String(), DataType: fn.dataType(qfields)}
+				break
+			}
+			field = &storage.Field{Column: "?", DataType: expressionType(elem, qfields)}
+		}
+
+		// replace field name with alias
+		if selectCol.AsClause != "" {
+			field.Column = selectCol.AsClause
+		}
+
+		op.fields = append(op.fields, field)
+	}
+
+	return nil
+}
+
+func (op *projectOp) Next() (*storage.Row, error) {
+	row, err := op.child.Next()
+	if err != nil || row == nil || op.lookup == nil {
+		return row, err
+	}
+
+	// rearrange result set columns according to order imposed by selectList
+	newVals := make([]interface{}, 0, len(op.selectList))
+	for _, elem := range op.selectList {
+		switch elem := elem.ValueExpressionPrimary.(type) {
+		case sql.ColumnReference:
+			if idx := op.lookup[elem]; idx == rowIDIdx {
+				newVals = append(newVals, rowIDVal(row))
+			} else {
+				newVals = append(newVals, row.Vals[idx])
+			}
+		default:
+			if fn, ok := asSetFunction(elem); ok {
+				// pass the value of the argument on to the aggregation step.
+				// count(*) doesn't have one.
+				var val interface{}
+				if fn.arg != nil {
+					if val, err = evalPrimary(fn.arg, op.child.Fields(), row); err != nil {
+						return nil, err
+					}
+				}
+				newVals = append(newVals, val)
+				break
+			}
+			result, err := evaluate(elem, op.child.Fields(), row)
+			if err != nil {
+				return nil, err
+			}
+			newVals = append(newVals, result)
+		}
+	}
+
+	return &storage.Row{RowID: row.RowID, Key: row.Key, Vals: newVals}, nil
+}
+
+func (op *projectOp) Close() error {
+	return op.child.Close()
+}
+
+func (op *projectOp) Fields() storage.Fields {
+	return op.fields
+}
+
+// aggregateOp groups the projected rows of its input by the GROUP BY columns
+// and computes the aggregate functions of the select list for each group.
+// Only one row per group is kept in memory.
+type aggregateOp struct {
+	child      Operator
+	selectList sql.SelectList
+	groupBy    []sql.ColumnReference
+
+	rows   []*storage.Row
+	pos    int
+	loaded bool
+}
+
+func (op *aggregateOp) Open() error {
+	op.rows, op.pos, op.loaded = nil, 0, false
+	return op.child.Open()
+}
+
+func (op *aggregateOp) Next() (*storage.Row, error) {
+	if !op.loaded {
+		if err := op.aggregate(); err != nil {
+			return nil, err
+		}
+		op.loaded = true
+	}
+	if op.pos >= len(op.rows) {
+		return nil, nil
+	}
+	row := op.rows[op.pos]
+	op.rows[op.pos] = nil
+	op.pos++
+	return row, nil
+}
+
+func (op *aggregateOp) aggregate() error {
+	// map columns to indexes on the select list
+	colToIdx := map[sql.ColumnReference]int{}
+	for idx, col := range op.selectList {
+		switch col := col.ValueExpressionPrimary.(type) {
+		case sql.ColumnReference:
+			colToIdx[col] = idx
+		}
+	}
+
+	// generate keys for GROUP BY values. nulls are grouped together.
+	groupKey := func(row *storage.Row) string {
+		vals := make([]interface{}, len(op.groupBy))
+		for i, groupByCol := range op.groupBy {
+			vals[i] = row.Vals[colToIdx[groupByCol]]
+		}
+		return hashKey(vals)
+	}
+
+	// map group key to the row that contains the aggregated value
+	groupKeyToRow := map[string]*storage.Row{}
+
+	// this auxiliary data structure stores the running state of each set
+	// function per group
+	aggs := map[string][]*aggState{}
+
+	// calculate the aggregate values. de-dupe the rows by group key
+	for {
+		row, err := op.child.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+
+		key := groupKey(row)
+		groupRow, ok := groupKeyToRow[key]
+		if !ok {
+			// this is the first time we encounter this unique group key. the
+			// aggregate value for all subsequent rows that have this particular
+			// group key is maintained in this row, and the rest of the rows
+			// are discarded.
+			groupKeyToRow[key] = row
+			op.rows = append(op.rows, row)
+			groupRow = row
+			for colIdx, selectCol := range op.selectList {
+				if fn, ok := asSetFunction(selectCol.ValueExpressionPrimary); ok {
+					aggs[key] = append(aggs[key], newAggState(fn, groupRow, colIdx))
+				}
+			}
+		}
+
+		for _, state := range aggs[key] {
+			if err := state.add(row.Vals[state.colIdx]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// store the results in the de-duped rows
+	for _, states := range aggs {
+		for _, state := range states {
+			val, err := state.result()
+			if err != nil {
+				return err
+			}
+			state.row.Vals[state.colIdx] = val
+		}
+	}
+
+	// if implicit group by with no results, return a single row that contains
+	// 0-value results
+	if len(op.groupBy) == 0 && len(op.rows) == 0 {
+		row, err := emptyAggregateRow(op.selectList)
+		if err != nil {
+			return err
+		}
+		op.rows = append(op.rows, row)
+	}
+
+	return nil
+}
+
+func (op *aggregateOp) Close() error {
+	op.rows = nil
+	return op.child.Close()
+}
+
+func (op *aggregateOp) Fields() storage.Fields {
+	return op.child.Fields()
+}
+
+// sortOp sorts the rows of its input by the ORDER BY columns.
+type sortOp struct {
+	child Operator
+	ssl   []sql.SortSpecification
+
+	sortIdxs []int
+	rows     []*storage.Row
+	pos      int
+	loaded   bool
+}
+
+func (op *sortOp) Open() error {
+	op.rows, op.pos, op.loaded = nil, 0, false
+	if err := op.child.Open(); err != nil {
+		return err
+	}
+
+	op.sortIdxs = nil
+	for _, ss := range op.ssl {
+		idx, err := findColumnInFieldList(ss.SortKey, op.child.Fields())
+		if err != nil {
+			if errors.Is(err, storage.ErrFieldNotFound) {
+				return fmt.Errorf("%w: %s", ErrSortFieldNotFound, err)
+			}
+			return err
+		}
+		op.sortIdxs = append(op.sortIdxs, idx)
+	}
+
+	return nil
+}
+
+func (op *sortOp) Next() (*storage.Row, error) {
+	if !op.loaded {
+		for {
+			row, err := op.child.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				break
+			}
+			op.rows = append(op.rows, row)
+		}
+		sortRows(op.ssl, op.sortIdxs, op.rows)
+		op.loaded = true
+	}
+	if op.pos >= len(op.rows) {
+		return nil, nil
+	}
+	row := op.rows[op.pos]
+	op.rows[op.pos] = nil
+	op.pos++
+	return row, nil
+}
+
+func (op *sortOp) Close() error {
+	op.rows = nil
+	return op.child.Close()
+}
+
+func (op *sortOp) Fields() storage.Fields {
+	return op.child.Fields()
+}
+
+// sortRows sorts rows by the columns at sortIdxs, which correspond to the
+// sort specifications in ssl.
+func sortRows(ssl []sql.SortSpecification, sortIdxs []int, rows []*storage.Row) {
+	sort.Slice(rows, func(i, j int) bool {
+		for sortIdx, fieldIdx := range sortIdxs {
+			lhs := rows[i].Vals[fieldIdx]
+			rhs := rows[j].Vals[fieldIdx]
+
+			if lhs == nil || rhs == nil {
+				if lhs == rhs {
+					continue
+				}
+				return (lhs == nil) == nullsFirst(ssl[sortIdx])
+			}
+
+			cmp := compareVals(lhs, rhs)
+			if cmp == 0 {
+				continue
+			}
+			sortAsc := cmp < 0
+			if ssl[sortIdx].OrderingSpecification.Type == sql.DESC {
+				sortAsc = !sortAsc
+			}
+			return sortAsc
+
+		}
+
+		// i & j are considered equal
+		return false
+	})
+}
+
+// nullsFirst reports whether nulls sort before the other values of a sort
+// key. Unless NULLS FIRST or NULLS LAST says otherwise, nulls sort as if
+// they were larger than any other value.
+func nullsFirst(ss sql.SortSpecification) bool {
+	switch ss.NullOrdering.Type {
+	case sql.FIRST:
+		return true
+	case sql.LAST:
+		return false
+	}
+	return ss.OrderingSpecification.Type == sql.DESC
+}
+
+// limitOp skips the first offset rows of its input and returns at most limit
+// of the rows after them. A negative limit returns all of the rows. Once the
+// limit is reached, no more rows are pulled from the input.
+type limitOp struct {
+	child  Operator
+	offset int
+	limit  int
+
+	skipped  int
+	returned int
+}
+
+func (op *limitOp) Open() error {
+	op.skipped, op.returned = 0, 0
+	return op.child.Open()
+}
+
+func (op *limitOp) Next() (*storage.Row, error) {
+	for op.skipped < op.offset {
+		row, err := op.child.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		op.skipped++
+	}
+	if op.limit >= 0 && op.returned >= op.limit {
+		return nil, nil
+	}
+	row, err := op.child.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	op.returned++
+	return row, nil
+}
+
+func (op *limitOp) Close() error {
+	return op.child.Close()
+}
+
+func (op *limitOp) Fields() storage.Fields {
+	return op.child.Fields()
+}
@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// countingOp returns rows and counts how many of them were pulled.
+type countingOp struct {
+	rows   []*storage.Row
+	fields storage.Fields
+	pos    int
+	pulled int
+	opens  int
+}
+
+func (op *countingOp) Open() error {
+	op.pos = 0
+	op.opens++
+	return nil
+}
+
+func (op *countingOp) Next() (*storage.Row, error) {
+	if op.pos >= len(op.rows) {
+		return nil, nil
+	}
+	op.pos++
+	op.pulled++
+	return op.rows[op.pos-1], nil
+}
+
+func (op *countingOp) Close() error {
+	return nil
+}
+
+func (op *countingOp) Fields() storage.Fields {
+	return op.fields
+}
+
+func TestLimitStopsPullingRows(t *testing.T) {
+	child := &countingOp{fields: storage.Fields{{Column: "col1"}}}
+	for i := 0; i < 1000; i++ {
+		child.rows = append(child.rows, &storage.Row{Vals: []interface{}{int64(i)}})
+	}
+
+	rows, err := drain(&limitOp{child: child, offset: 3, limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []*storage.Row{
+		{Vals: []interface{}{int64(3)}},
+		{Vals: []interface{}{int64(4)}},
+	}
+	if !reflect.DeepEqual(expect, rows) {
+		t.Fatalf("rows do not match. expected: %s actual: %s", expect, rows)
+	}
+	if child.pulled != 5 {
+		t.Errorf("expected 5 rows to be pulled, got %d", child.pulled)
+	}
+}
+
+func TestNestedLoopJoinRescansInner(t *testing.T) {
+	lhs := &countingOp{
+		fields: storage.Fields{{TableID: "l", Column: "id"}},
+		rows: []*storage.Row{
+			{Vals: []interface{}{int64(1)}},
+			{Vals: []interface{}{int64(2)}},
+			{Vals: []interface{}{int64(3)}},
+		},
+	}
+	rhs := &countingOp{
+		fields: storage.Fields{{TableID: "r", Column: "id"}},
+		rows: []*storage.Row{
+			{Vals: []interface{}{int64(3)}},
+			{Vals: []interface{}{int64(1)}},
+		},
+	}
+
+	op := &nestedLoopJoinOp{
+		lhs:      lhs,
+		rhs:      rhs,
+		joinType: sql.LEFT_JOIN,
+		conds: []interface{}{
+			sql.Predicate{
+				ComparisonPredicate: sql.ComparisonPredicate{
+					LHS:    sql.ColumnReference{Qualifier: "l", ColumnName: "id"},
+					CompOp: sql.EQ,
+					RHS:    sql.ColumnReference{Qualifier: "r", ColumnName: "id"},
+				},
+			},
+		},
+	}
+
+	rows, err := drain(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []*storage.Row{
+		{Vals: []interface{}{int64(1), int64(1)}},
+		{Vals: []interface{}{int64(2), nil}},
+		{Vals: []interface{}{int64(3), int64(3)}},
+	}
+	if !reflect.DeepEqual(expect, rows) {
+		t.Fatalf("rows do not match. expected: %s actual: %s", expect, rows)
+	}
+	if rhs.opens != len(lhs.rows) {
+		t.Errorf("expected the inner input to be opened %d times, got %d", len(lhs.rows), rhs.opens)
+	}
+}
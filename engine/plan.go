@@ -0,0 +1,1121 @@
+package engine
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// Selectivities assumed for predicates whose selectivity can't be derived
+// from table statistics.
+const (
+	eqSelectivity    = 0.1
+	neqSelectivity   = 0.9
+	rangeSelectivity = 1.0 / 3
+	otherSelectivity = 0.5
+)
+
+// planSelect builds the operator tree that evaluates q. Rows flow through the
+// joins, the where clause, the select list, the aggregation, the sort and
+// finally the limit and offset.
+func planSelect(q sql.Select, rm RelationManager) (Operator, error) {
+	var plan Operator
+
+	if len(q.TableExpression.FromClause) == 0 {
+		// handle case where no FROM clause is specified
+		plan = &singleRowOp{}
+	} else {
+		tf := q.TableExpression.FromClause[0]
+		p := &planner{rm: rm, tableCount: len(tableIDs(tf)), stats: map[string]storage.TableStats{}}
+
+		var conds []*conjunct
+		if wc, ok := q.TableExpression.WhereClause.(sql.WhereClause); ok {
+			conds = p.conjuncts(wc.SearchCondition)
+		}
+
+		sp, rest, err := p.planFrom(tf, conds)
+		if err != nil {
+			return nil, err
+		}
+
+		// predicates that can't be pushed down to a single table or join
+		// are applied once all the tables are joined
+		plan = sp.op
+		if len(rest) > 0 {
+			rows := math.Max(1, sp.rows*p.selectivity(exprs(rest)))
+			plan = &filterOp{child: plan, conds: exprs(rest), rows: rows}
+		}
+	}
+
+	plan = &projectOp{child: plan, selectList: q.SelectList}
+
+	if q.SelectList.HasAggrFunc() {
+		plan = &aggregateOp{child: plan, selectList: q.SelectList, groupBy: q.GroupByClause}
+	}
+
+	if len(q.SortSpecificationList) > 0 {
+		plan = &sortOp{child: plan, ssl: q.SortSpecificationList}
+	}
+
+	if q.LimitOffsetClause.OffsetActive || q.LimitOffsetClause.LimitActive {
+		op := &limitOp{child: plan, limit: -1}
+		if q.LimitOffsetClause.OffsetActive {
+			op.offset = q.LimitOffsetClause.Offset
+		}
+		if q.LimitOffsetClause.LimitActive {
+			op.limit = q.LimitOffsetClause.Limit
+		}
+		plan = op
+	}
+
+	return plan, nil
+}
+
+// planner chooses how the tables of a FROM clause are read and joined.
+type planner struct {
+	rm         RelationManager
+	tableCount int
+	// stats holds the statistics of the planned tables, keyed by table ID
+	stats map[string]storage.TableStats
+}
+
+// conjunct is one of the predicates ANDed together in a WHERE or ON clause.
+type conjunct struct {
+	expr interface{}
+	// tables holds the IDs of the tables the predicate refers to
+	tables map[string]bool
+	// unbound is set if the predicate refers to a column without a
+	// qualifier in a query that reads more than one table, in which case
+	// the table it belongs to isn't known until the query runs
+	unbound bool
+}
+
+// subplan is a planned part of the FROM clause.
+type subplan struct {
+	op     Operator
+	tables map[string]bool
+	// rows is the estimated number of rows returned
+	rows float64
+	// cost is the estimated number of rows read to return them once
+	cost float64
+	// order holds the columns the rows are known to be sorted by
+	order []sql.ColumnReference
+}
+
+// conjuncts splits a search condition into the predicates that are ANDed
+// together at its top level.
+func (p *planner) conjuncts(cond interface{}) []*conjunct {
+	var conds []*conjunct
+	for _, expr := range splitConjuncts(cond) {
+		c := &conjunct{expr: expr, tables: map[string]bool{}}
+		for _, col := range columnRefs(expr) {
+			if col.Qualifier != "" {
+				c.tables[col.Qualifier] = true
+			} else if p.tableCount > 1 {
+				c.unbound = true
+			}
+		}
+		conds = append(conds, c)
+	}
+	return conds
+}
+
+// planFrom plans table reference tf. Predicates in conds that only refer to
+// tables in tf are pushed down as far as they can go. The predicates that
+// couldn't be applied in tf are returned.
+func (p *planner) planFrom(tf sql.TableReference, conds []*conjunct) (*subplan, []*conjunct, error) {
+	switch v := tf.(type) {
+	case sql.TableName:
+		return p.planTable(v, conds)
+	case sql.QualifiedJoin:
+		switch v.JoinType {
+		case sql.INNER_JOIN:
+			return p.planInnerJoin(v, conds)
+		case sql.LEFT_JOIN, sql.RIGHT_JOIN:
+			return p.planOuterJoin(v, conds)
+		}
+		return nil, nil, fmt.Errorf("%w: join type %d", ErrTmpUnsupportedSyntax, v.JoinType)
+	}
+	return nil, nil, fmt.Errorf("%w: table reference %T", ErrTmpUnsupportedSyntax, tf)
+}
+
+// planTable chooses the access path of a table and filters its rows by the
+// predicates that only refer to it.
+func (p *planner) planTable(tn sql.TableName, conds []*conjunct) (*subplan, []*conjunct, error) {
+	tableID := tn.Name
+	if tn.CorrelationName != nil {
+		tableID = tn.CorrelationName.(string)
+	}
+	tables := map[string]bool{tableID: true}
+
+	local, rest := partition(conds, func(c *conjunct) bool {
+		return !c.unbound && isSubset(c.tables, tables)
+	})
+
+	stats, err := p.rm.TableStats(tn.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.stats[tableID] = stats
+
+	path, err := chooseAccessPath(p.rm, tn.Name, tableID, exprs(local), stats)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sp := &subplan{
+		op:     &scanOp{rm: p.rm, tableName: tn.Name, tableID: tableID, path: path},
+		tables: tables,
+		rows:   path.rows,
+		cost:   path.cost,
+	}
+
+	if path.kind == indexScan || path.kind == indexRangeScan {
+		// index entries that share the looked up values are sorted by the
+		// rest of the indexed columns
+		for _, col := range path.index.Columns[len(path.vals):] {
+			sp.order = append(sp.order, sql.ColumnReference{Qualifier: tableID, ColumnName: col})
+		}
+	}
+
+	if len(local) > 0 {
+		// the predicates used by the access path are still checked, since
+		// the access path may return more rows than match them
+		sp.rows = math.Max(1, path.rows*p.selectivity(path.residual(exprs(local))))
+		sp.op = &filterOp{child: sp.op, conds: exprs(local), rows: sp.rows}
+	}
+
+	return sp, rest, nil
+}
+
+// planInnerJoin plans a chain of inner joins. The joined inputs are reordered
+// if that's estimated to be cheaper than joining them in written order.
+func (p *planner) planInnerJoin(qj sql.QualifiedJoin, conds []*conjunct) (*subplan, []*conjunct, error) {
+	var inputs []sql.TableReference
+	var on []*conjunct
+	p.flattenInnerJoin(qj, &inputs, &on)
+
+	tables := tableIDs(qj)
+
+	// predicates that only refer to tables of this join are applied here
+	// or further down. unbound predicates from the where clause are left
+	// to the caller, since they might refer to tables outside the join.
+	local, rest := partition(conds, func(c *conjunct) bool {
+		return !c.unbound && isSubset(c.tables, tables)
+	})
+	local = append(local, on...)
+
+	var items []*subplan
+	for _, input := range inputs {
+		sp, remaining, err := p.planFrom(input, local)
+		if err != nil {
+			return nil, nil, err
+		}
+		local = remaining
+		items = append(items, sp)
+	}
+
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	written := p.joinCost(items, order, local)
+
+	if greedy := p.greedyJoinOrder(items, local); p.joinCost(items, greedy, local) < written {
+		order = greedy
+	}
+
+	sp := items[order[0]]
+	joined := map[string]bool{}
+	for id := range sp.tables {
+		joined[id] = true
+	}
+
+	for i, idx := range order[1:] {
+		item := items[idx]
+		for id := range item.tables {
+			joined[id] = true
+		}
+
+		last := i == len(order)-2
+		var stepConds []*conjunct
+		stepConds, local = partition(local, func(c *conjunct) bool {
+			return (last && c.unbound) || (!c.unbound && isSubset(c.tables, joined))
+		})
+
+		rows := math.Max(1, sp.rows*item.rows*p.joinSelectivity(stepConds, sp.rows, item.rows))
+		sp = planJoin(sp, item, sql.INNER_JOIN, stepConds, rows)
+	}
+
+	if !isIdentity(order) {
+		// restore the column order of the written join
+		ops := make([]Operator, len(items))
+		for i, item := range items {
+			ops[i] = item.op
+		}
+		sp.op = &reorderOp{child: sp.op, inputs: ops, order: order}
+	}
+
+	return sp, rest, nil
+}
+
+// flattenInnerJoin collects the inputs and ON predicates of a chain of inner
+// joins.
+func (p *planner) flattenInnerJoin(tf sql.TableReference, inputs *[]sql.TableReference, on *[]*conjunct) {
+	qj, ok := tf.(sql.QualifiedJoin)
+	if !ok || qj.JoinType != sql.INNER_JOIN {
+		*inputs = append(*inputs, tf)
+		return
+	}
+	p.flattenInnerJoin(qj.LHS, inputs, on)
+	p.flattenInnerJoin(qj.RHS, inputs, on)
+	*on = append(*on, p.conjuncts(qj.JoinCondition)...)
+}
+
+// planOuterJoin plans a left or right join. Predicates from the where clause
+// can only be pushed down to the preserved side, since filtering the other
+// side before the join would pad rows that should have been filtered out.
+// Conversely, ON predicates can only be pushed down to the padded side.
+func (p *planner) planOuterJoin(qj sql.QualifiedJoin, conds []*conjunct) (*subplan, []*conjunct, error) {
+	preserved, padded := qj.LHS, qj.RHS
+	if qj.JoinType == sql.RIGHT_JOIN {
+		preserved, padded = qj.RHS, qj.LHS
+	}
+
+	preservedTables := tableIDs(preserved)
+	paddedTables := tableIDs(padded)
+
+	down, rest := partition(conds, func(c *conjunct) bool {
+		return !c.unbound && isSubset(c.tables, preservedTables)
+	})
+
+	preservedPlan, remaining, err := p.planFrom(preserved, down)
+	if err != nil {
+		return nil, nil, err
+	}
+	rest = append(rest, remaining...)
+
+	on, joinConds := partition(p.conjuncts(qj.JoinCondition), func(c *conjunct) bool {
+		return !c.unbound && isSubset(c.tables, paddedTables)
+	})
+
+	paddedPlan, remaining, err := p.planFrom(padded, on)
+	if err != nil {
+		return nil, nil, err
+	}
+	joinConds = append(joinConds, remaining...)
+
+	rows := preservedPlan.rows * paddedPlan.rows * p.joinSelectivity(joinConds, preservedPlan.rows, paddedPlan.rows)
+	rows = math.Max(preservedPlan.rows, rows)
+
+	lhs, rhs := preservedPlan, paddedPlan
+	if qj.JoinType == sql.RIGHT_JOIN {
+		lhs, rhs = paddedPlan, preservedPlan
+	}
+
+	return planJoin(lhs, rhs, qj.JoinType, joinConds, rows), rest, nil
+}
+
+// planJoin picks the cheapest way of joining subplans lhs and rhs on conds.
+// A nested loop join can join on any predicate. If conds equate columns of
+// both inputs, a hash join can be used instead, or a merge join if both
+// inputs are already sorted by the equated columns.
+func planJoin(lhs *subplan, rhs *subplan, joinType sql.JoinType, conds []*conjunct, rows float64) *subplan {
+	tables := map[string]bool{}
+	for id := range lhs.tables {
+		tables[id] = true
+	}
+	for id := range rhs.tables {
+		tables[id] = true
+	}
+
+	outer, inner := lhs, rhs
+	if joinType == sql.RIGHT_JOIN {
+		outer, inner = rhs, lhs
+	}
+
+	best := &subplan{
+		op: &nestedLoopJoinOp{
+			lhs:      lhs.op,
+			rhs:      rhs.op,
+			joinType: joinType,
+			conds:    exprs(conds),
+			rows:     rows,
+		},
+		tables: tables,
+		rows:   rows,
+		cost:   outer.cost + outer.rows*inner.cost,
+		order:  outer.order,
+	}
+
+	keys := equiJoinKeys(conds, lhs.tables, rhs.tables)
+	if len(keys) == 0 {
+		return best
+	}
+
+	if mergeKeys := sortedKeys(keys, lhs.order, rhs.order); len(mergeKeys) > 0 {
+		// both inputs are read once, without holding on to them
+		if cost := lhs.cost + rhs.cost; cost < best.cost {
+			lKeys, rKeys, rest := splitJoinKeys(mergeKeys, conds)
+			order := lhs.order
+			if joinType == sql.RIGHT_JOIN {
+				order = rhs.order[:len(mergeKeys)]
+			}
+			best = &subplan{
+				op: &mergeJoinOp{
+					lhs:      lhs.op,
+					rhs:      rhs.op,
+					joinType: joinType,
+					lKeys:    lKeys,
+					rKeys:    rKeys,
+					conds:    exprs(rest),
+					rows:     rows,
+				},
+				tables: tables,
+				rows:   rows,
+				cost:   cost,
+				order:  order,
+			}
+		}
+	}
+
+	// both inputs are read once, and each of their rows is hashed
+	if cost := hashJoinCost(lhs.rows, lhs.cost, rhs.rows, rhs.cost); cost < best.cost {
+		lKeys, rKeys, rest := splitJoinKeys(keys, conds)
+		op := &hashJoinOp{
+			lhs:       lhs.op,
+			rhs:       rhs.op,
+			joinType:  joinType,
+			lKeys:     lKeys,
+			rKeys:     rKeys,
+			conds:     exprs(rest),
+			rows:      rows,
+			buildRows: rhs.rows,
+		}
+		// the hash table is built from the smaller input
+		if lhs.rows < rhs.rows {
+			op.buildLeft = true
+			op.buildRows = lhs.rows
+		}
+		best = &subplan{
+			op:     op,
+			tables: tables,
+			rows:   rows,
+			cost:   cost,
+		}
+	}
+
+	return best
+}
+
+func hashJoinCost(lRows float64, lCost float64, rRows float64, rCost float64) float64 {
+	return lCost + rCost + lRows + rRows
+}
+
+// equiJoinKey is a join predicate that equates a column of the left input
+// with a column of the right input.
+type equiJoinKey struct {
+	cond *conjunct
+	lhs  sql.ColumnReference
+	rhs  sql.ColumnReference
+}
+
+// equiJoinKeys returns the predicates of conds that equate a column of a
+// table in lTables with a column of a table in rTables.
+func equiJoinKeys(conds []*conjunct, lTables map[string]bool, rTables map[string]bool) []equiJoinKey {
+	var keys []equiJoinKey
+	for _, c := range conds {
+		pred, ok := c.expr.(sql.Predicate)
+		if c.unbound || !ok || pred.CompOp != sql.EQ {
+			continue
+		}
+		lCol, lIsCol := pred.LHS.(sql.ColumnReference)
+		rCol, rIsCol := pred.RHS.(sql.ColumnReference)
+		if !lIsCol || !rIsCol {
+			continue
+		}
+		if lTables[lCol.Qualifier] && rTables[rCol.Qualifier] {
+			keys = append(keys, equiJoinKey{cond: c, lhs: lCol, rhs: rCol})
+		} else if lTables[rCol.Qualifier] && rTables[lCol.Qualifier] {
+			keys = append(keys, equiJoinKey{cond: c, lhs: rCol, rhs: lCol})
+		}
+	}
+	return keys
+}
+
+// sortedKeys returns the join keys that both inputs are sorted by, in sort
+// order. The inputs have to be sorted by the same keys from their first sort
+// column on.
+func sortedKeys(keys []equiJoinKey, lOrder []sql.ColumnReference, rOrder []sql.ColumnReference) []equiJoinKey {
+	var ans []equiJoinKey
+	for i := 0; i < len(lOrder) && i < len(rOrder); i++ {
+		found := false
+		for _, key := range keys {
+			if key.lhs == lOrder[i] && key.rhs == rOrder[i] {
+				ans = append(ans, key)
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	return ans
+}
+
+// splitJoinKeys returns the columns that keys equate, along with the
+// predicates of conds that aren't keys.
+func splitJoinKeys(keys []equiJoinKey, conds []*conjunct) ([]sql.ColumnReference, []sql.ColumnReference, []*conjunct) {
+	var lKeys, rKeys []sql.ColumnReference
+	isKey := map[*conjunct]bool{}
+	for _, key := range keys {
+		lKeys = append(lKeys, key.lhs)
+		rKeys = append(rKeys, key.rhs)
+		isKey[key.cond] = true
+	}
+	_, rest := partition(conds, func(c *conjunct) bool {
+		return isKey[c]
+	})
+	return lKeys, rKeys, rest
+}
+
+// joinCost estimates the cost of joining items in the given order. The inner
+// input of each nested loop join is read once for every row of the outer
+// input, which a hash join avoids when the items are joined on equal
+// columns.
+func (p *planner) joinCost(items []*subplan, order []int, conds []*conjunct) float64 {
+	joined := map[string]bool{}
+	applied := map[*conjunct]bool{}
+
+	first := items[order[0]]
+	for id := range first.tables {
+		joined[id] = true
+	}
+	rows, cost := first.rows, first.cost
+
+	for _, idx := range order[1:] {
+		item := items[idx]
+		prev := map[string]bool{}
+		for id := range joined {
+			prev[id] = true
+		}
+		for id := range item.tables {
+			joined[id] = true
+		}
+		var stepConds []*conjunct
+		for _, c := range conds {
+			if !applied[c] && !c.unbound && isSubset(c.tables, joined) {
+				applied[c] = true
+				stepConds = append(stepConds, c)
+			}
+		}
+		stepCost := rows * item.cost
+		if len(equiJoinKeys(stepConds, prev, item.tables)) > 0 {
+			stepCost = math.Min(stepCost, hashJoinCost(rows, 0, item.rows, item.cost))
+		}
+		cost += stepCost
+		rows = math.Max(1, rows*item.rows*p.joinSelectivity(stepConds, rows, item.rows))
+	}
+
+	return cost
+}
+
+// greedyJoinOrder orders items starting with the one that returns the fewest
+// rows, then repeatedly joining the item that keeps the intermediate result
+// smallest. Items connected to the joined ones by a predicate are preferred
+// over cross products.
+func (p *planner) greedyJoinOrder(items []*subplan, conds []*conjunct) []int {
+	used := make([]bool, len(items))
+
+	first := 0
+	for i, item := range items {
+		if item.rows < items[first].rows {
+			first = i
+		}
+	}
+	order := []int{first}
+	used[first] = true
+
+	joined := map[string]bool{}
+	for id := range items[first].tables {
+		joined[id] = true
+	}
+	rows := items[first].rows
+
+	for len(order) < len(items) {
+		best, bestRows, bestConnected := -1, 0.0, false
+		for i, item := range items {
+			if used[i] {
+				continue
+			}
+			candidate := map[string]bool{}
+			for id := range joined {
+				candidate[id] = true
+			}
+			for id := range item.tables {
+				candidate[id] = true
+			}
+			var stepConds []*conjunct
+			connected := false
+			for _, c := range conds {
+				if !c.unbound && isSubset(c.tables, candidate) && !isSubset(c.tables, joined) {
+					stepConds = append(stepConds, c)
+					connected = true
+				}
+			}
+			outRows := rows * item.rows * p.joinSelectivity(stepConds, rows, item.rows)
+			if best == -1 || (connected && !bestConnected) || (connected == bestConnected && outRows < bestRows) {
+				best, bestRows, bestConnected = i, outRows, connected
+			}
+		}
+		order = append(order, best)
+		used[best] = true
+		for id := range items[best].tables {
+			joined[id] = true
+		}
+		rows = math.Max(1, bestRows)
+	}
+
+	return order
+}
+
+// joinSelectivity estimates the fraction of the combinations of lRows and
+// rRows rows that match conds. An equality between columns matches each
+// value of the column with more distinct values at most once. Without
+// statistics, it's assumed to join a key to a foreign key.
+func (p *planner) joinSelectivity(conds []*conjunct, lRows float64, rRows float64) float64 {
+	sel := 1.0
+	for _, c := range conds {
+		if pred, ok := c.expr.(sql.Predicate); ok && pred.CompOp == sql.EQ {
+			lCol, lIsCol := pred.LHS.(sql.ColumnReference)
+			rCol, rIsCol := pred.RHS.(sql.ColumnReference)
+			if lIsCol && rIsCol {
+				lStats, lOK := p.columnStats(lCol)
+				rStats, rOK := p.columnStats(rCol)
+				if lOK && rOK && lStats.DistinctCount > 0 && rStats.DistinctCount > 0 {
+					sel *= 1 / float64(max64(lStats.DistinctCount, rStats.DistinctCount))
+				} else {
+					sel *= 1 / math.Max(1, math.Max(lRows, rRows))
+				}
+				continue
+			}
+		}
+		sel *= p.selectivity([]interface{}{c.expr})
+	}
+	return sel
+}
+
+// selectivity estimates the fraction of rows that match all of conds.
+func (p *planner) selectivity(conds []interface{}) float64 {
+	sel := 1.0
+	for _, cond := range conds {
+		sel *= p.exprSelectivity(cond)
+	}
+	return sel
+}
+
+func (p *planner) exprSelectivity(expr interface{}) float64 {
+	switch v := expr.(type) {
+	case sql.SearchCondition:
+		lhs, rhs := p.exprSelectivity(v.LHS), p.exprSelectivity(v.RHS)
+		return lhs + rhs - lhs*rhs
+	case sql.BooleanTerm:
+		return p.exprSelectivity(v.LHS) * p.exprSelectivity(v.RHS)
+	case sql.Predicate:
+		if sel, ok := p.predicateSelectivity(v); ok {
+			return sel
+		}
+		switch v.CompOp {
+		case sql.EQ, sql.IS:
+			return eqSelectivity
+		case sql.NEQ, sql.IS_NOT:
+			return neqSelectivity
+		default:
+			return rangeSelectivity
+		}
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	return otherSelectivity
+}
+
+// predicateSelectivity estimates the selectivity of a comparison between a
+// column and a constant from the column's statistics. It returns false if
+// the predicate isn't such a comparison or the column hasn't been analyzed.
+func (p *planner) predicateSelectivity(pred sql.Predicate) (float64, bool) {
+	col, isCol := pred.LHS.(sql.ColumnReference)
+	val := pred.RHS
+	op := pred.CompOp
+	if (pred.LHS == nil || pred.RHS == nil) && op != sql.IS && op != sql.IS_NOT {
+		// a comparison with NULL is never true
+		return 0, true
+	}
+	if !isCol {
+		// put the column on the left, e.g. 5 < col becomes col > 5
+		if col, isCol = pred.RHS.(sql.ColumnReference); !isCol {
+			return 0, false
+		}
+		val = pred.LHS
+		op = flipCompOp(op)
+	}
+	if len(columnRefs(val)) > 0 {
+		return 0, false
+	}
+
+	cs, ok := p.columnStats(col)
+	if !ok {
+		return 0, false
+	}
+	tableStats := p.stats[p.tableID(col)]
+	notNull := 1.0
+	if tableStats.AnalyzedRowCount > 0 {
+		notNull = 1 - float64(cs.NullCount)/float64(tableStats.AnalyzedRowCount)
+	}
+
+	eq := notNull / math.Max(1, float64(cs.DistinctCount))
+	switch op {
+	case sql.IS:
+		return 1 - notNull, true
+	case sql.IS_NOT:
+		return notNull, true
+	case sql.EQ:
+		return eq, true
+	case sql.NEQ:
+		return math.Max(0, notNull-eq), true
+	}
+
+	below, ok := histogramFraction(cs.Histogram, val)
+	if !ok {
+		return 0, false
+	}
+	switch op {
+	case sql.LT, sql.LTE:
+		return notNull * below, true
+	default:
+		return notNull * (1 - below), true
+	}
+}
+
+// flipCompOp returns the comparison operator that compares the same values
+// as op once its operands are swapped.
+func flipCompOp(op sql.TokenType) sql.TokenType {
+	switch op {
+	case sql.LT:
+		return sql.GT
+	case sql.LTE:
+		return sql.GTE
+	case sql.GT:
+		return sql.LT
+	case sql.GTE:
+		return sql.LTE
+	}
+	return op
+}
+
+// histogramFraction estimates the fraction of the values described by
+// histogram bounds that are less than val. Values are assumed to be spread
+// evenly within each bucket.
+func histogramFraction(bounds []interface{}, val interface{}) (float64, bool) {
+	if len(bounds) < 2 {
+		return 0, false
+	}
+	below := 0
+	for _, bound := range bounds {
+		if compareVals(bound, val) < 0 {
+			below++
+		}
+	}
+	switch below {
+	case 0:
+		return 0, true
+	case len(bounds):
+		return 1, true
+	}
+	return (float64(below) - 0.5) / float64(len(bounds)-1), true
+}
+
+// tableID returns the ID of the table that column col belongs to, which is
+// only known for unqualified columns if one table is planned.
+func (p *planner) tableID(col sql.ColumnReference) string {
+	if col.Qualifier != "" || p.tableCount != 1 {
+		return col.Qualifier
+	}
+	for id := range p.stats {
+		return id
+	}
+	return ""
+}
+
+// columnStats returns the statistics of column col if its table has been
+// analyzed.
+func (p *planner) columnStats(col sql.ColumnReference) (storage.ColumnStats, bool) {
+	stats, ok := p.stats[p.tableID(col)]
+	if !ok {
+		return storage.ColumnStats{}, false
+	}
+	cs, ok := stats.Columns[col.ColumnName]
+	return cs, ok
+}
+
+// columnEqSelectivity estimates the fraction of the rows of a table with
+// statistics stats where column col equals constant val, or is null if val
+// is nil.
+func columnEqSelectivity(stats storage.TableStats, col string, val interface{}) float64 {
+	cs, ok := stats.Columns[col]
+	if !ok || stats.AnalyzedRowCount == 0 {
+		return eqSelectivity
+	}
+	nullFrac := float64(cs.NullCount) / float64(stats.AnalyzedRowCount)
+	if val == nil {
+		return nullFrac
+	}
+	if cs.DistinctCount == 0 {
+		return 0
+	}
+	return (1 - nullFrac) / float64(cs.DistinctCount)
+}
+
+// columnRangeSelectivity estimates the fraction of the rows of a table with
+// statistics stats where column col lies within rng.
+func columnRangeSelectivity(stats storage.TableStats, col string, rng storage.KeyRange) float64 {
+	sel := 1.0
+	if rng.Lower != nil {
+		sel *= rangeSelectivity
+	}
+	if rng.Upper != nil {
+		sel *= rangeSelectivity
+	}
+	cs, ok := stats.Columns[col]
+	if !ok || stats.AnalyzedRowCount == 0 {
+		return sel
+	}
+
+	lower, upper := 0.0, 1.0
+	if rng.Lower != nil {
+		if lower, ok = histogramFraction(cs.Histogram, rng.Lower); !ok {
+			return sel
+		}
+	}
+	if rng.Upper != nil {
+		if upper, ok = histogramFraction(cs.Histogram, rng.Upper); !ok {
+			return sel
+		}
+	}
+	notNull := 1 - float64(cs.NullCount)/float64(stats.AnalyzedRowCount)
+	return notNull * math.Max(0, upper-lower)
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// accessKind is a way of reading the rows of a table.
+type accessKind int
+
+const (
+	fullScan accessKind = iota
+	rowIDSeek
+	indexScan
+	// indexRangeScan reads the index entries whose leading columns equal
+	// vals and whose next column lies within rng
+	indexRangeScan
+)
+
+// accessPath describes how the rows of a table are read.
+type accessPath struct {
+	kind  accessKind
+	rowID int64
+	index *storage.Index
+	vals  []interface{}
+	rng   storage.KeyRange
+	// rows is the estimated number of rows read
+	rows float64
+	// cost is the estimated number of rows and index entries read
+	cost float64
+}
+
+// chooseAccessPath picks the cheapest way to read the rows of table
+// tableName that match conds. A row can be looked up by its row ID if conds
+// constrain the rowid pseudo-column to a constant. An index can be used if
+// conds constrain its leading columns to constants. The primary key index
+// can also be used if conds bound the column after those to a range of
+// constants.
+func chooseAccessPath(rm RelationManager, tableName string, tableID string, conds []interface{}, stats storage.TableStats) (accessPath, error) {
+	n := math.Max(1, float64(stats.RowCount))
+	best := accessPath{kind: fullScan, rows: n, cost: n}
+
+	eqVals := make(map[string]interface{})
+	ranges := make(map[string]storage.KeyRange)
+	for _, cond := range conds {
+		equalityPredicates(cond, tableID, eqVals)
+		rangePredicates(cond, tableID, ranges)
+	}
+	if len(eqVals) == 0 && len(ranges) == 0 {
+		return best, nil
+	}
+
+	if rowID, ok := eqVals[storage.RowIDColumn].(int64); ok {
+		return accessPath{kind: rowIDSeek, rowID: rowID, rows: 1, cost: 1}, nil
+	}
+
+	indexes, err := rm.Indexes(tableName)
+	if err != nil {
+		return best, err
+	}
+	if len(indexes) == 0 {
+		return best, nil
+	}
+
+	// an index is searched by the encoded value, so it can't be used to
+	// compare a column with a constant of another type, e.g. a DECIMAL
+	// column with an integer
+	schema, err := rm.Schema(tableName)
+	if err != nil {
+		return best, err
+	}
+	for _, fd := range schema.Fields {
+		if val, ok := eqVals[fd.Name]; ok && val != nil && !indexableVal(fd.DataType, val) {
+			delete(eqVals, fd.Name)
+		}
+		if rng, ok := ranges[fd.Name]; ok && (!indexableVal(fd.DataType, rng.Lower) || !indexableVal(fd.DataType, rng.Upper)) {
+			delete(ranges, fd.Name)
+		}
+	}
+
+	for _, idx := range indexes {
+		var vals []interface{}
+		for _, col := range idx.Columns {
+			val, ok := eqVals[col]
+			if !ok {
+				break
+			}
+			vals = append(vals, val)
+		}
+
+		sel := 1.0
+		for i, col := range idx.Columns[:len(vals)] {
+			sel *= columnEqSelectivity(stats, col, vals[i])
+		}
+
+		path := accessPath{kind: indexScan, index: idx, vals: vals}
+		if idx.Primary && len(vals) < len(idx.Columns) {
+			col := idx.Columns[len(vals)]
+			if rng, ok := ranges[col]; ok {
+				path.kind, path.rng = indexRangeScan, rng
+				sel *= columnRangeSelectivity(stats, col, rng)
+			}
+		}
+		if len(vals) == 0 && path.kind != indexRangeScan {
+			continue
+		}
+
+		path.rows = math.Max(1, n*sel)
+		if idx.Unique && len(vals) == len(idx.Columns) && !hasNull(vals) {
+			path.rows = 1
+		}
+		// each matching index entry is followed by a lookup of its row,
+		// except in the primary key index of a table clustered on it
+		path.cost = 1 + 2*path.rows
+		if idx.Primary {
+			path.cost = 1 + path.rows
+		}
+
+		if path.cost < best.cost || (path.cost == best.cost && best.kind != fullScan && len(vals) > len(best.vals)) {
+			best = path
+		}
+	}
+
+	return best, nil
+}
+
+// indexableVal returns true if val is nil or is stored in the index of a
+// column of type dt as is.
+func indexableVal(dt storage.DataType, val interface{}) bool {
+	switch val.(type) {
+	case nil:
+		return true
+	case int64:
+		return dt == storage.TypeInt || dt == storage.TypeBigInt
+	case string:
+		return dt == storage.TypeVarchar || dt == storage.TypeText || dt == storage.TypeBlob
+	case bool:
+		return dt == storage.TypeBoolean
+	case storage.Date:
+		return dt == storage.TypeDate
+	case storage.Time:
+		return dt == storage.TypeTime
+	case storage.Timestamp:
+		return dt == storage.TypeTimestamp
+	case storage.Interval:
+		return dt == storage.TypeInterval
+	}
+	return false
+}
+
+// residual returns the predicates of conds that the access path doesn't
+// narrow the rows down by.
+func (ap accessPath) residual(conds []interface{}) []interface{} {
+	if ap.kind == fullScan {
+		return conds
+	}
+
+	cols := map[string]bool{}
+	switch ap.kind {
+	case rowIDSeek:
+		cols[storage.RowIDColumn] = true
+	case indexScan, indexRangeScan:
+		for _, col := range ap.index.Columns[:len(ap.vals)] {
+			cols[col] = true
+		}
+	}
+
+	var ans []interface{}
+	for _, cond := range conds {
+		if pred, ok := cond.(sql.Predicate); ok {
+			refs := columnRefs(pred)
+			switch pred.CompOp {
+			case sql.EQ, sql.IS:
+				if len(refs) == 1 && cols[refs[0].ColumnName] {
+					continue
+				}
+			case sql.LT, sql.LTE, sql.GT, sql.GTE:
+				if ap.kind == indexRangeScan && len(refs) == 1 && refs[0].ColumnName == ap.index.Columns[len(ap.vals)] {
+					continue
+				}
+			}
+		}
+		ans = append(ans, cond)
+	}
+	return ans
+}
+
+// open returns an iterator over the rows read by the access path.
+func (ap accessPath) open(rm RelationManager, tableName string) (storage.RowIterator, []*storage.Field, error) {
+	switch ap.kind {
+	case rowIDSeek:
+		if ap.rowID < 0 || ap.rowID > math.MaxUint32 {
+			// no row can have this ID, but the table's columns are still
+			// needed
+			_, fields, err := rm.Scan(tableName)
+			return emptyRows{}, fields, err
+		}
+		return rm.SeekRowID(tableName, uint32(ap.rowID))
+	case indexScan:
+		return rm.ScanByIndex(ap.index, ap.vals)
+	case indexRangeScan:
+		return rm.ScanByIndexRange(ap.index, ap.vals, ap.rng)
+	default:
+		return rm.Scan(tableName)
+	}
+}
+
+// emptyRows is an iterator without rows.
+type emptyRows struct{}
+
+func (emptyRows) Next() (*storage.Row, error) {
+	return nil, nil
+}
+
+// splitConjuncts returns the predicates that are ANDed together at the top
+// level of a search condition.
+func splitConjuncts(cond interface{}) []interface{} {
+	if bt, ok := cond.(sql.BooleanTerm); ok {
+		return append([]interface{}{bt.LHS}, splitConjuncts(bt.RHS)...)
+	}
+	return []interface{}{cond}
+}
+
+// columnRefs returns the column references in an expression.
+func columnRefs(expr interface{}) []sql.ColumnReference {
+	switch v := expr.(type) {
+	case sql.SearchCondition:
+		return append(columnRefs(v.LHS), columnRefs(v.RHS)...)
+	case sql.BooleanTerm:
+		return append(columnRefs(v.LHS), columnRefs(v.RHS)...)
+	case sql.Predicate:
+		return append(columnRefs(v.LHS), columnRefs(v.RHS)...)
+	case sql.ArithmeticExpression:
+		return append(columnRefs(v.LHS), columnRefs(v.RHS)...)
+	case sql.Extract:
+		return columnRefs(v.Source)
+	case sql.FunctionCall:
+		var refs []sql.ColumnReference
+		for _, arg := range v.Args {
+			refs = append(refs, columnRefs(arg)...)
+		}
+		return refs
+	case sql.ColumnReference:
+		return []sql.ColumnReference{v}
+	}
+	return nil
+}
+
+// tableIDs returns the IDs of the tables read by table reference tf.
+func tableIDs(tf sql.TableReference) map[string]bool {
+	ids := map[string]bool{}
+	switch v := tf.(type) {
+	case sql.TableName:
+		if v.CorrelationName != nil {
+			ids[v.CorrelationName.(string)] = true
+		} else {
+			ids[v.Name] = true
+		}
+	case sql.QualifiedJoin:
+		for id := range tableIDs(v.LHS) {
+			ids[id] = true
+		}
+		for id := range tableIDs(v.RHS) {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// partition splits conds into the predicates that satisfy f and the ones
+// that don't.
+func partition(conds []*conjunct, f func(c *conjunct) bool) ([]*conjunct, []*conjunct) {
+	var in, out []*conjunct
+	for _, c := range conds {
+		if f(c) {
+			in = append(in, c)
+		} else {
+			out = append(out, c)
+		}
+	}
+	return in, out
+}
+
+func exprs(conds []*conjunct) []interface{} {
+	var ans []interface{}
+	for _, c := range conds {
+		ans = append(ans, c.expr)
+	}
+	return ans
+}
+
+func isSubset(lhs map[string]bool, rhs map[string]bool) bool {
+	for k := range lhs {
+		if !rhs[k] {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentity(order []int) bool {
+	for i, idx := range order {
+		if i != idx {
+			return false
+		}
+	}
+	return true
+}
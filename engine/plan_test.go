@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+func TestPlanReordersJoin(t *testing.T) {
+
+	givenFields := map[string]storage.Fields{
+		"big": {
+			&storage.Field{Column: "id"},
+		},
+		"small": {
+			&storage.Field{Column: "big_id"},
+			&storage.Field{Column: "name"},
+		},
+	}
+	givenRows := map[string][]*storage.Row{
+		"big": {
+			{Vals: []interface{}{int64(1)}},
+			{Vals: []interface{}{int64(2)}},
+			{Vals: []interface{}{int64(3)}},
+		},
+		"small": {
+			{Vals: []interface{}{int64(2), "x"}},
+			{Vals: []interface{}{int64(3), "y"}},
+		},
+	}
+	rowCounts := map[string]int64{
+		"big":   1000,
+		"small": 2,
+	}
+
+	rm := &mockRelationManager{
+		fetch: func(tableName string) ([]*storage.Row, []*storage.Field, error) {
+			// return copies, since scans assign table IDs to the fields
+			var fields []*storage.Field
+			for _, fd := range givenFields[tableName] {
+				cp := *fd
+				fields = append(fields, &cp)
+			}
+			return givenRows[tableName], fields, nil
+		},
+		tableStats: func(tableName string) (storage.TableStats, error) {
+			return storage.TableStats{RowCount: rowCounts[tableName]}, nil
+		},
+	}
+
+	q, err := parseSQL(`SELECT * FROM big JOIN small ON big.id = small.big_id WHERE small.name = 'x'`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _, err := EvaluateExplain(sql.ExplainStatement{Select: q.(sql.Select)}, rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plan []string
+	for _, row := range rows {
+		plan = append(plan, row.Vals[0].(string))
+	}
+
+	// the small table is read first since it's filtered down to fewer rows,
+	// and the where clause is pushed down to it
+	expectPlan := []string{
+		"Project: *",
+		"-> Nested Loop Inner Join on big.id = small.big_id (rows=1)",
+		"   -> Filter: small.name = 'x' (rows=1)",
+		"      -> Seq Scan on small (rows=2)",
+		"   -> Seq Scan on big (rows=1000)",
+	}
+	if !reflect.DeepEqual(expectPlan, plan) {
+		t.Fatalf("plans do not match. expected: %q actual: %q", expectPlan, plan)
+	}
+
+	rows, fields, err := EvaluateSelect(q.(sql.Select), rm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// columns are still returned in the order the tables were written in
+	expectFields := []*storage.Field{
+		{TableID: "big", Column: "id"},
+		{TableID: "small", Column: "big_id"},
+		{TableID: "small", Column: "name"},
+	}
+	if !reflect.DeepEqual(expectFields, fields) {
+		t.Fatalf("fields do not match. expected: %s actual: %s", expectFields, fields)
+	}
+
+	expectRows := []*storage.Row{
+		{Vals: []interface{}{int64(2), int64(2), "x"}},
+	}
+	if !reflect.DeepEqual(expectRows, rows) {
+		t.Fatalf("rows do not match. expected: %s actual: %s", expectRows, rows)
+	}
+}
+
+func TestPlanAccessPath(t *testing.T) {
+
+	idx := &storage.Index{Name: "idx_name", TableName: "tbl1", Columns: []string{"name"}}
+
+	tbl := []struct {
+		name   string
+		where  string
+		rows   int64
+		expect accessKind
+	}{
+		{name: "no predicates", where: "true", rows: 1000, expect: fullScan},
+		{name: "range predicate", where: "name > 'a'", rows: 1000, expect: fullScan},
+		{name: "indexed equality", where: "name = 'a'", rows: 1000, expect: indexScan},
+		{name: "indexed equality on tiny table", where: "name = 'a'", rows: 2, expect: fullScan},
+		{name: "row ID", where: "rowid = 5 AND name = 'a'", rows: 1000, expect: rowIDSeek},
+		{name: "equality in OR", where: "name = 'a' OR name = 'b'", rows: 1000, expect: fullScan},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			rm := &mockRelationManager{
+				indexes: func(tableName string) ([]*storage.Index, error) {
+					return []*storage.Index{idx}, nil
+				},
+			}
+			q, err := parseSQL(`SELECT * FROM tbl1 WHERE `+test.where, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wc := q.(sql.Select).TableExpression.WhereClause.(sql.WhereClause)
+			path, err := chooseAccessPath(rm, "tbl1", "tbl1", splitConjuncts(wc.SearchCondition), storage.TableStats{RowCount: test.rows})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path.kind != test.expect {
+				t.Errorf("expected access path %d, got %d", test.expect, path.kind)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mk6i/mkdb/storage"
+)
+
+// DBPool shares open databases between the sessions of one process. Opening
+// a database file twice would give each session its own page cache and WAL
+// writer, so sessions that belong to a pool look their database up here
+// instead of opening it themselves.
+type DBPool struct {
+	mtx sync.Mutex
+	dbs map[string]*database
+}
+
+func NewDBPool() *DBPool {
+	return &DBPool{
+		dbs: make(map[string]*database),
+	}
+}
+
+// database is a database opened by one or more sessions. The storage layer
+// doesn't isolate concurrent statements from each other, so sessions take
+// turns running statements against it. A session that begins a transaction
+// keeps its turn until the transaction ends.
+type database struct {
+	name string
+	rs   *storage.RelationService
+	turn sync.Mutex
+	refs int
+}
+
+// open returns database dbName, opening it if no other session of the pool
+// is using it. A nil pool opens a private copy of the database.
+func (p *DBPool) open(dbName string) (*database, error) {
+	if p == nil {
+		rs, err := storage.OpenRelation(dbName, true)
+		if err != nil {
+			return nil, err
+		}
+		return &database{name: dbName, rs: rs, refs: 1}, nil
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if db, ok := p.dbs[dbName]; ok {
+		db.refs++
+		return db, nil
+	}
+
+	rs, err := storage.OpenRelation(dbName, true)
+	if err != nil {
+		return nil, err
+	}
+	db := &database{name: dbName, rs: rs, refs: 1}
+	p.dbs[dbName] = db
+	return db, nil
+}
+
+// release gives up a session's reference to db and closes db once no
+// session of the pool uses it.
+func (p *DBPool) release(db *database) error {
+	if p == nil {
+		return db.rs.Close()
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	db.refs--
+	if db.refs > 0 {
+		return nil
+	}
+	delete(p.dbs, db.name)
+	return db.rs.Close()
+}
+
+// StartAutoVacuum vacuums the tables of the pool's open databases that have
+// many deleted rows every interval, and returns a function that stops it.
+// Databases that a session is running a statement or transaction against
+// are skipped until the next interval. Errors are passed to onErr.
+func (p *DBPool) StartAutoVacuum(interval time.Duration, onErr func(dbName string, err error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				p.autoVacuum(onErr)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (p *DBPool) autoVacuum(onErr func(dbName string, err error)) {
+	// hold a reference to each database so that it stays open while it's
+	// vacuumed
+	p.mtx.Lock()
+	dbs := make([]*database, 0, len(p.dbs))
+	for _, db := range p.dbs {
+		db.refs++
+		dbs = append(dbs, db)
+	}
+	p.mtx.Unlock()
+
+	for _, db := range dbs {
+		if db.turn.TryLock() {
+			err := db.rs.AutoVacuum()
+			db.turn.Unlock()
+			if err != nil {
+				onErr(db.name, err)
+			}
+		}
+		if err := p.release(db); err != nil {
+			onErr(db.name, err)
+		}
+	}
+}
@@ -3,11 +3,8 @@ package engine
 import (
 	"errors"
 	"fmt"
-	"math"
-	"os"
-	"sort"
 	"strings"
-	"text/tabwriter"
+	"time"
 
 	"github.com/mk6i/mkdb/sql"
 	"github.com/mk6i/mkdb/storage"
@@ -15,276 +12,151 @@ import (
 
 var (
 	ErrIncompatTypeCompare  = errors.New("incompatible type comparison")
+	ErrInvalidFunctionArg   = errors.New("invalid function argument")
 	ErrNonBoolJoinCond      = errors.New("non-boolean join condition")
+	ErrNonNumericArg        = errors.New("aggregate function requires a numeric argument")
 	ErrSortFieldNotFound    = errors.New("sort field is not in select list")
 	ErrTmpUnsupportedSyntax = errors.New("temporarily unsupported syntax")
+	ErrUndefinedOperator    = errors.New("operator is not defined for the operand types")
+	ErrUnknownFunction      = errors.New("unknown function")
 )
 
 func EvaluateSelect(q sql.Select, rm RelationManager) ([]*storage.Row, []*storage.Field, error) {
-	rm.StartTxn()
-	defer rm.EndTxn()
-
-	// handle case where no FROM clause is specified
-	if len(q.TableExpression.FromClause) == 0 {
-		var fields storage.Fields
-		// create a placeholder row to populate
-		rows := []*storage.Row{{}}
-		fields, err := projectColumns(q.SelectList, fields, rows)
-		return rows, fields, err
+	if err := rm.StartTxn(); err != nil {
+		return nil, nil, err
 	}
+	defer rm.EndTxn()
 
-	rows, fields, err := nestedLoopJoin(rm, q.TableExpression.FromClause[0])
+	q = bindNowInSelect(q, statementTime())
+	plan, err := planSelect(q, rm)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if q.TableExpression.WhereClause != nil {
-		rows, err = filterRows(q.TableExpression.WhereClause.(sql.WhereClause), fields, rows)
-		if err != nil {
-			return nil, nil, err
-		}
-	}
-
-	fields, err = projectColumns(q.SelectList, fields, rows)
+	rows, err := drain(plan)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	rows, err = aggregateRows(q.SelectList, q.GroupByClause, rows)
-	if err != nil {
-		return nil, nil, err
+	return rows, plan.Fields(), nil
+}
+
+// streamSelect runs query q like EvaluateSelect, but writes the result's
+// columns and then each of its rows to w as they're produced instead of
+// collecting them. It returns the columns and the number of rows written.
+func streamSelect(q sql.Select, rm RelationManager, w ResultWriter) ([]Column, int, error) {
+	if err := rm.StartTxn(); err != nil {
+		return nil, 0, err
 	}
+	defer rm.EndTxn()
 
-	err = sortColumns(q.SortSpecificationList, fields, rows)
+	q = bindNowInSelect(q, statementTime())
+	plan, err := planSelect(q, rm)
 	if err != nil {
-		return nil, nil, err
+		return nil, 0, err
 	}
 
-	if q.LimitOffsetClause.OffsetActive {
-		rows = offset(int(q.LimitOffsetClause.Offset), rows)
+	if err := plan.Open(); err != nil {
+		plan.Close()
+		return nil, 0, err
 	}
-
-	if q.LimitOffsetClause.LimitActive {
-		rows = limit(int(q.LimitOffsetClause.Limit), rows)
+	cols := resultColumns(plan.Fields())
+	if err := w.WriteColumns(cols); err != nil {
+		plan.Close()
+		return nil, 0, err
 	}
 
-	return rows, fields, nil
-}
-
-func nestedLoopJoin(rm RelationManager, tf sql.TableReference) ([]*storage.Row, storage.Fields, error) {
-
-	switch v := tf.(type) {
-	case sql.TableName:
-		rows, fields, err := rm.Fetch(v.Name)
+	count := 0
+	for {
+		row, err := plan.Next()
 		if err != nil {
-			return nil, nil, err
-		}
-		tableID := v.Name
-		if v.CorrelationName != nil {
-			tableID = v.CorrelationName.(string)
+			plan.Close()
+			return nil, 0, err
 		}
-		for _, fd := range fields {
-			fd.TableID = tableID
+		if row == nil {
+			break
 		}
-		return rows, fields, err
-	case sql.JoinedTable:
-		switch v := v.(type) {
-		case sql.QualifiedJoin:
-			lRows, lFields, err := nestedLoopJoin(rm, v.LHS)
-			if err != nil {
-				return nil, nil, err
-			}
-			rRows, rFields, err := nestedLoopJoin(rm, v.RHS)
-			if err != nil {
-				return nil, nil, err
-			}
-
-			var tmpRows []*storage.Row
-
-			tmpFields := storage.Fields{}
-			tmpFields = append(tmpFields, lFields...)
-			tmpFields = append(tmpFields, rFields...)
-
-			switch v.JoinType {
-			case sql.INNER_JOIN:
-				for _, lRow := range lRows {
-					for _, rRow := range rRows {
-						tmpRow := lRow.Merge(rRow)
-						result, err := evaluate(v.JoinCondition, tmpFields, tmpRow)
-						if err != nil {
-							return nil, nil, err
-						}
-						if doJoin, ok := result.(bool); !ok {
-							return nil, nil, ErrNonBoolJoinCond
-						} else if doJoin {
-							tmpRows = append(tmpRows, tmpRow)
-						}
-					}
-				}
-			case sql.LEFT_JOIN:
-				rPadded := &storage.Row{Vals: make([]interface{}, len(rFields))}
-				for _, lRow := range lRows {
-					hasMatch := false
-					for _, rRow := range rRows {
-						tmpRow := lRow.Merge(rRow)
-						result, err := evaluate(v.JoinCondition, tmpFields, tmpRow)
-						if err != nil {
-							return nil, nil, err
-						}
-						if doJoin, ok := result.(bool); !ok {
-							return nil, nil, ErrNonBoolJoinCond
-						} else if doJoin {
-							hasMatch = true
-							tmpRows = append(tmpRows, tmpRow)
-						}
-					}
-					if !hasMatch {
-						tmpRows = append(tmpRows, lRow.Merge(rPadded))
-					}
-				}
-			case sql.RIGHT_JOIN:
-				lPadded := &storage.Row{Vals: make([]interface{}, len(lFields))}
-				for _, rRow := range rRows {
-					hasMatch := false
-					for _, lRow := range lRows {
-						tmpRow := lRow.Merge(rRow)
-						result, err := evaluate(v.JoinCondition, tmpFields, tmpRow)
-						if err != nil {
-							return nil, nil, err
-						}
-						if doJoin, ok := result.(bool); !ok {
-							return nil, nil, ErrNonBoolJoinCond
-						} else if doJoin {
-							hasMatch = true
-							tmpRows = append(tmpRows, tmpRow)
-						}
-					}
-					if !hasMatch {
-						tmpRows = append(tmpRows, lPadded.Merge(rRow))
-					}
-				}
-			}
-			return tmpRows, tmpFields, nil
+		if err := w.WriteRow(row.Vals); err != nil {
+			plan.Close()
+			return nil, 0, err
 		}
+		count++
 	}
 
-	return nil, nil, nil
+	return cols, count, plan.Close()
 }
 
-func projectColumns(selectList sql.SelectList, qfields storage.Fields, rows []*storage.Row) (storage.Fields, error) {
-	if _, isSelectStar := selectList[0].ValueExpressionPrimary.(sql.Asterisk); isSelectStar {
-		// select *, nothing to do here
-		return qfields, nil
-	}
-
-	// maps SELECT column references to storage field columns positions
-	lookup := map[sql.ColumnReference]int{}
-
-	// build column reference lookup
-	for _, elem := range selectList {
-		switch elem := elem.ValueExpressionPrimary.(type) {
-		case sql.Average:
-			col := elem.ValueExpression.(sql.ColumnReference)
-			idx, err := findColumnInFieldList(col, qfields)
-			if err != nil {
-				return nil, err
-			}
-			lookup[col] = idx
-		case sql.ColumnReference:
-			idx, err := findColumnInFieldList(elem, qfields)
-			if err != nil {
-				return nil, err
-			}
-			lookup[elem] = idx
-		case sql.Count:
-			col, isColRef := elem.ValueExpression.(sql.ColumnReference)
-			if !isColRef {
-				break
-			}
-			idx, err := findColumnInFieldList(col, qfields)
-			if err != nil {
-				return nil, err
-			}
-			lookup[col] = idx
+// expressionType returns the data type of the value an expression evaluates
+// to. qfields holds the columns that the expression refers to.
+func expressionType(expr interface{}, qfields storage.Fields) storage.DataType {
+	switch v := expr.(type) {
+	case int64:
+		return storage.TypeBigInt
+	case float64:
+		return storage.TypeDouble
+	case storage.Decimal:
+		return storage.TypeDecimal
+	case storage.Date:
+		return storage.TypeDate
+	case storage.Time:
+		return storage.TypeTime
+	case storage.Timestamp, nowValue:
+		return storage.TypeTimestamp
+	case storage.Interval:
+		return storage.TypeInterval
+	case bool, sql.SearchCondition, sql.BooleanTerm, sql.Predicate:
+		return storage.TypeBoolean
+	case sql.ColumnReference:
+		if idx, err := findColumnInFieldList(v, qfields); err == nil {
+			return qfields[idx].DataType
 		}
-	}
-
-	// rearrange result set columns according to order imposed by selectList
-	// todo use copy to make more efficient?
-	for _, row := range rows {
-		var newVals []interface{}
-		for _, elem := range selectList {
-			switch elem := elem.ValueExpressionPrimary.(type) {
-			case sql.Average:
-				// set initial value used for subsequent aggregation step
-				idx := lookup[elem.ValueExpression.(sql.ColumnReference)]
-				newVals = append(newVals, row.Vals[idx].(int64))
-			case sql.Count:
-				// set initial value used for subsequent aggregation step
-				count := int64(0)
-				if colRef, hasColRef := elem.ValueExpression.(sql.ColumnReference); hasColRef {
-					idx := lookup[colRef]
-					if row.Vals[idx] != nil {
-						// starting count is 1 since selected column is non-nil
-						// for this row
-						count = 1
-					}
-				} else {
-					// select count(*) just counts the number of rows
-					// regardless of values, so starting count is 1
-					count = 1
-				}
-				newVals = append(newVals, count)
-			case sql.ColumnReference:
-				idx := lookup[elem]
-				newVals = append(newVals, row.Vals[idx])
-			default:
-				result, err := evaluate(elem, qfields, row)
-				if err != nil {
-					return nil, err
-				}
-				newVals = append(newVals, result)
-			}
+	case sql.ArithmeticExpression:
+		// apply the operator to values of the operand types, which yields a
+		// value of the result type
+		lhs := zeroValue(expressionType(v.LHS, qfields))
+		rhs := zeroValue(expressionType(v.RHS, qfields))
+		if val, err := arithmetic(lhs, v.Op, rhs); err == nil {
+			return expressionType(val, qfields)
 		}
-		row.Vals = newVals
-	}
-
-	var headerRow storage.Fields
-
-	// build the query result header row
-	for _, selectCol := range selectList {
-		var field *storage.Field
-
-		switch elem := selectCol.ValueExpressionPrimary.(type) {
-		case sql.Average:
-			col := elem.ValueExpression.(sql.ColumnReference)
-			field = &storage.Field{
-				Column: fmt.Sprintf("avg(%s)", col.String()),
-			}
-		case sql.Count:
-			col, hasColRef := elem.ValueExpression.(sql.ColumnReference)
-			if hasColRef {
-				field = &storage.Field{
-					Column: fmt.Sprintf("count(%s)", col.String()),
-				}
-			} else {
-				field = &storage.Field{Column: "count(*)"}
-			}
-		case sql.ColumnReference:
-			field = qfields[lookup[elem]]
-		default:
-			field = &storage.Field{Column: "?"}
+	case sql.Extract:
+		if v.Field == "SECOND" || v.Field == "EPOCH" {
+			return storage.TypeDecimal
 		}
-
-		// replace field name with alias
-		if selectCol.AsClause != "" {
-			field.Column = selectCol.AsClause
+		return storage.TypeBigInt
+	case sql.FunctionCall:
+		switch v.Name {
+		case "NOW":
+			return storage.TypeTimestamp
+		case "DATE_TRUNC":
+			if len(v.Args) == 2 {
+				return expressionType(v.Args[1], qfields)
+			}
 		}
-
-		headerRow = append(headerRow, field)
 	}
+	return storage.TypeVarchar
+}
 
-	return headerRow, nil
+// zeroValue returns a value of type dt.
+func zeroValue(dt storage.DataType) interface{} {
+	switch dt {
+	case storage.TypeInt, storage.TypeBigInt:
+		return int64(0)
+	case storage.TypeReal, storage.TypeDouble:
+		return float64(0)
+	case storage.TypeDecimal:
+		return storage.NewDecimal(0, 0)
+	case storage.TypeDate:
+		return storage.Date(0)
+	case storage.TypeTime:
+		return storage.Time(0)
+	case storage.TypeTimestamp:
+		return storage.Timestamp(0)
+	case storage.TypeInterval:
+		return storage.Interval{}
+	case storage.TypeBoolean:
+		return false
+	}
+	return ""
 }
 
 // findColumnInFieldList returns the index of the select column in the result
@@ -296,170 +168,28 @@ func findColumnInFieldList(selectCol sql.ColumnReference, resultCols storage.Fie
 	return resultCols.LookupFieldIdx(selectCol.ColumnName)
 }
 
-func aggregateRows(selectList sql.SelectList, groupBy []sql.ColumnReference, rows []*storage.Row) ([]*storage.Row, error) {
-	if !selectList.HasAggrFunc() {
-		return rows, nil
-	}
-
-	// if implicit group by with no results, return a single row that contains
-	// 0-value results
-	if len(groupBy) == 0 && len(rows) == 0 {
-		return emptyAggregateRow(selectList, rows)
-	}
-
-	// map columns to indexes on the select list
-	colToIdx := map[sql.ColumnReference]int{}
-	for idx, col := range selectList {
-		switch col := col.ValueExpressionPrimary.(type) {
-		case sql.ColumnReference:
-			colToIdx[col] = idx
-		}
-	}
-
-	// generate keys for GROUP BY values
-	groupKey := func(row *storage.Row) string {
-		var key string
-		for _, groupByCol := range groupBy {
-			idx := colToIdx[groupByCol]
-			key += fmt.Sprintf("%v", row.Vals[idx])
-		}
-		return key
-	}
-
-	// map group key to the index of the row that contains the aggregated value
-	groupKeyToRow := map[string]int{}
-
-	// this auxiliary data structure stores the counts per aggregated value
-	// used in the cumulative average calculation
-	counts := map[string]int64{}
-
-	// calculate the aggregate values. de-dupe the rows by group key
-	rowIdx := 0
-	for _, row := range rows {
-		key := groupKey(row)
-		if _, ok := groupKeyToRow[key]; !ok {
-			// this is the first time we encounter this unique group key. the
-			// aggregate value for all subsequent rows that have this particular
-			// group key is maintained in this row, and the rest of the rows
-			// are discarded from the result set.
-			groupKeyToRow[key] = rowIdx
-			rows[rowIdx] = row
-			rowIdx++
-		}
-
-		// the index of the de-duped row that maintains the aggregated value
-		groupKeyIdx := groupKeyToRow[key]
-
-		for colIdx, selectCol := range selectList {
-			switch selectCol := selectCol.ValueExpressionPrimary.(type) {
-			case sql.Count:
-				if rows[groupKeyIdx] == row {
-					// this is the first row, don't increment
-					break
-				}
-				rows[groupKeyIdx].Vals[colIdx] = rows[groupKeyIdx].Vals[colIdx].(int64) + row.Vals[colIdx].(int64)
-			case sql.Average:
-				avgCol, ok := selectCol.ValueExpression.(sql.ColumnReference)
-				if !ok {
-					// should be caught in parser
-					panic("avg() param must be a ColumnReference")
-				}
-
-				// update the count of this particular group key + value
-				// combination
-				countKey := fmt.Sprintf("%s%s", key, avgCol)
-				if _, ok := counts[countKey]; !ok {
-					counts[countKey] = 0
-				}
-				counts[countKey]++
-
-				// calculate the cumulative average average
-				avg := rows[groupKeyIdx].Vals[colIdx].(int64) * (counts[countKey] - 1)
-				avg += row.Vals[colIdx].(int64)
-				avg = int64(math.Round(float64(avg) / float64(counts[countKey])))
-
-				// update the de-duped row with the recalculated average
-				rows[groupKeyIdx].Vals[colIdx] = avg
-			}
-		}
-	}
-
-	// discard duplicate rows to avoid memory leaks
-	for idx := rowIdx; idx < len(rows); idx++ {
-		rows[idx] = nil
-	}
-	rows = rows[:rowIdx]
-
-	return rows, nil
-}
-
 // emptyAggregateRow returns a row that represents an aggregation of an empty
 // result set.
-func emptyAggregateRow(selectList sql.SelectList, rows []*storage.Row) ([]*storage.Row, error) {
+func emptyAggregateRow(selectList sql.SelectList) (*storage.Row, error) {
 	row := &storage.Row{}
 	for _, elem := range selectList {
-		switch elem := elem.ValueExpressionPrimary.(type) {
-		case sql.Count, sql.Average:
-			row.Vals = append(row.Vals, int64(0))
-		default:
-			// handle any expressions in the select list
-			result, err := evaluate(elem, storage.Fields{}, row)
+		if fn, ok := asSetFunction(elem.ValueExpressionPrimary); ok {
+			// there are no values to aggregate
+			val, err := newAggState(fn, row, len(row.Vals)).result()
 			if err != nil {
-				return rows, err
+				return nil, err
 			}
-			row.Vals = append(row.Vals, result)
+			row.Vals = append(row.Vals, val)
+			continue
 		}
-
-	}
-	return []*storage.Row{row}, nil
-}
-
-func sortColumns(ssl []sql.SortSpecification, qfields storage.Fields, rows []*storage.Row) error {
-
-	var sortIdxs []int
-	for _, ss := range ssl {
-		idx, err := findColumnInFieldList(ss.SortKey, qfields)
+		// handle any expressions in the select list
+		result, err := evaluate(elem.ValueExpressionPrimary, storage.Fields{}, row)
 		if err != nil {
-			if errors.Is(err, storage.ErrFieldNotFound) {
-				return fmt.Errorf("%w: %s", ErrSortFieldNotFound, err)
-			}
-			return err
+			return nil, err
 		}
-		sortIdxs = append(sortIdxs, idx)
+		row.Vals = append(row.Vals, result)
 	}
-
-	sort.Slice(rows, func(i, j int) bool {
-		for sortIdx, fieldIdx := range sortIdxs {
-			lhs := rows[i].Vals[fieldIdx]
-			rhs := rows[j].Vals[fieldIdx]
-
-			if lhs == rhs {
-				continue
-			}
-
-			sortAsc := false
-			switch lhs.(type) {
-			case int64:
-				sortAsc = lhs.(int64) < rhs.(int64)
-			case string:
-				sortAsc = strings.Compare(lhs.(string), rhs.(string)) < 0
-			case bool:
-				sortAsc = !lhs.(bool) && rhs.(bool)
-			default:
-				panic(fmt.Sprintf("no comparison available for type %T", lhs))
-			}
-			if ssl[sortIdx].OrderingSpecification.Type == sql.DESC {
-				sortAsc = !sortAsc
-			}
-			return sortAsc
-
-		}
-
-		// i & j are considered equal
-		return false
-	})
-
-	return nil
+	return row, nil
 }
 
 func filterRows(q sql.WhereClause, qfields storage.Fields, rows []*storage.Row) ([]*storage.Row, error) {
@@ -478,6 +208,8 @@ func filterRows(q sql.WhereClause, qfields storage.Fields, rows []*storage.Row)
 	return ans, nil
 }
 
+// evaluate returns the value of expression q for row. Boolean expressions
+// follow SQL's three-valued logic, in which nil stands for UNKNOWN.
 func evaluate(q interface{}, qfields storage.Fields, row *storage.Row) (any, error) {
 	switch v := q.(type) {
 	case sql.SearchCondition: // or
@@ -486,53 +218,154 @@ func evaluate(q interface{}, qfields storage.Fields, row *storage.Row) (any, err
 		return evalAnd(v, qfields, row)
 	case sql.Predicate:
 		return evalComparisonPredicate(v.ComparisonPredicate, qfields, row)
-	case int64, string, bool:
+	case sql.ArithmeticExpression:
+		return evalArithmetic(v, qfields, row)
+	case sql.Extract:
+		val, err := evalPrimary(v.Source, qfields, row)
+		if err != nil || val == nil {
+			return nil, err
+		}
+		return extractField(v.Field, val)
+	case sql.FunctionCall:
+		return evalFunctionCall(v, qfields, row)
+	case int64, float64, storage.Decimal, string, bool, nil,
+		storage.Date, storage.Time, storage.Timestamp, storage.Interval:
 		return q, nil
+	case nowValue:
+		return storage.Timestamp(v), nil
 	}
 	return false, fmt.Errorf("nothing to evaluate here")
 }
 
-func evalOr(q sql.SearchCondition, qfields storage.Fields, row *storage.Row) (bool, error) {
-	lhs, err := evaluate(q.LHS, qfields, row)
+// evalArithmetic returns the sum or difference of the operands of q, which
+// is null if either of them is.
+func evalArithmetic(q sql.ArithmeticExpression, qfields storage.Fields, row *storage.Row) (any, error) {
+	lhs, err := evalPrimary(q.LHS, qfields, row)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	rhs, err := evaluate(q.RHS, qfields, row)
+	rhs, err := evalPrimary(q.RHS, qfields, row)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	if lhs == nil || rhs == nil {
+		return nil, nil
+	}
+	return arithmetic(lhs, q.Op, rhs)
+}
+
+// arithmetic adds (op PLUS) or subtracts (op MINUS) non-null values lhs and
+// rhs.
+func arithmetic(lhs interface{}, op sql.TokenType, rhs interface{}) (any, error) {
+	plus := op == sql.PLUS
+	if isNumeric(lhs) && isNumeric(rhs) {
+		return numericArithmetic(lhs, plus, rhs)
+	}
+	if val, ok := temporalArithmetic(lhs, plus, rhs); ok {
+		return val, nil
+	}
+	return nil, fmt.Errorf("%w: %v %s %v", ErrUndefinedOperator, formatExpr(lhs), sql.Tokens[op], formatExpr(rhs))
+}
+
+// evalFunctionCall returns the result of scalar function call q. Functions
+// return null if any of their arguments is null.
+func evalFunctionCall(q sql.FunctionCall, qfields storage.Fields, row *storage.Row) (any, error) {
+	var args []interface{}
+	for _, arg := range q.Args {
+		val, err := evalPrimary(arg, qfields, row)
+		if err != nil {
+			return nil, err
+		}
+		if val == nil {
+			return nil, nil
+		}
+		args = append(args, val)
 	}
-	if lhs, ok := lhs.(bool); !ok {
-		return false, newErrIncompatTypeCompare(lhs, rhs)
-	} else if rhs, ok := rhs.(bool); !ok {
-		return false, newErrIncompatTypeCompare(lhs, rhs)
-	} else {
-		return lhs || rhs, nil
+
+	switch q.Name {
+	case "NOW":
+		// the calls are replaced by the time their statement started
+		// before it runs, so this is only reached by expressions that are
+		// evaluated outside of a statement
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%w: NOW takes no arguments", ErrInvalidFunctionArg)
+		}
+		return storage.TimestampFromTime(time.Now()), nil
+	case "DATE_TRUNC":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: DATE_TRUNC takes a field name and a date or timestamp", ErrInvalidFunctionArg)
+		}
+		field, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: DATE_TRUNC takes a field name and a date or timestamp", ErrInvalidFunctionArg)
+		}
+		return dateTrunc(field, args[1])
 	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFunction, q.Name)
 }
 
-func evalAnd(q sql.BooleanTerm, qfields storage.Fields, row *storage.Row) (bool, error) {
-	lhs, err := evaluate(q.LHS, qfields, row)
+// evalOr is true if either side is true, false if both sides are false and
+// UNKNOWN otherwise.
+func evalOr(q sql.SearchCondition, qfields storage.Fields, row *storage.Row) (any, error) {
+	lhs, rhs, err := evalLogicalOperands(q.LHS, q.RHS, qfields, row)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	switch {
+	case lhs == true || rhs == true:
+		return true, nil
+	case lhs == nil || rhs == nil:
+		return nil, nil
+	default:
+		return false, nil
 	}
-	rhs, err := evaluate(q.RHS, qfields, row)
+}
+
+// evalAnd is false if either side is false, true if both sides are true and
+// UNKNOWN otherwise.
+func evalAnd(q sql.BooleanTerm, qfields storage.Fields, row *storage.Row) (any, error) {
+	lhs, rhs, err := evalLogicalOperands(q.LHS, q.RHS, qfields, row)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	switch {
+	case lhs == false || rhs == false:
+		return false, nil
+	case lhs == nil || rhs == nil:
+		return nil, nil
+	default:
+		return true, nil
+	}
+}
+
+// evalLogicalOperands evaluates the operands of AND or OR, which have to be
+// booleans or UNKNOWN.
+func evalLogicalOperands(lhsExpr interface{}, rhsExpr interface{}, qfields storage.Fields, row *storage.Row) (any, any, error) {
+	lhs, err := evaluate(lhsExpr, qfields, row)
+	if err != nil {
+		return nil, nil, err
+	}
+	rhs, err := evaluate(rhsExpr, qfields, row)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := lhs.(bool); !ok && lhs != nil {
+		return nil, nil, newErrIncompatTypeCompare(lhs, rhs)
 	}
-	if lhs, ok := lhs.(bool); !ok {
-		return false, newErrIncompatTypeCompare(lhs, rhs)
-	} else if rhs, ok := rhs.(bool); !ok {
-		return false, newErrIncompatTypeCompare(lhs, rhs)
-	} else {
-		return lhs && rhs, nil
+	if _, ok := rhs.(bool); !ok && rhs != nil {
+		return nil, nil, newErrIncompatTypeCompare(lhs, rhs)
 	}
+	return lhs, rhs, nil
 }
 
 func newErrIncompatTypeCompare(LHS any, RHS any) error {
 	return fmt.Errorf("%w: cannot compare %v with %v", ErrIncompatTypeCompare, LHS, RHS)
 }
 
-func evalComparisonPredicate(q sql.ComparisonPredicate, qfields storage.Fields, row *storage.Row) (bool, error) {
+// evalComparisonPredicate returns the result of a comparison, which is
+// UNKNOWN if either value is null. IS NULL and IS NOT NULL are always true
+// or false.
+func evalComparisonPredicate(q sql.ComparisonPredicate, qfields storage.Fields, row *storage.Row) (any, error) {
 	lhs, err := evalPrimary(q.LHS, qfields, row)
 	if err != nil {
 		return false, err
@@ -543,129 +376,98 @@ func evalComparisonPredicate(q sql.ComparisonPredicate, qfields storage.Fields,
 	}
 
 	switch q.CompOp {
-	case sql.EQ:
-		return lhs == rhs, nil
-	case sql.NEQ:
-		return lhs != rhs, nil
-	case sql.GT:
-		switch lhs := lhs.(type) {
-		case int64:
-			if _rhs, ok := rhs.(int64); ok {
-				return lhs > _rhs, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		case string:
-			if rhs, ok := rhs.(string); ok {
-				return strings.Compare(lhs, rhs) > 0, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
+	case sql.IS:
+		return lhs == nil, nil
+	case sql.IS_NOT:
+		return lhs != nil, nil
+	}
+	if lhs == nil || rhs == nil {
+		return nil, nil
+	}
+
+	if lhs, rhs, err = coerceTemporal(lhs, rhs); err != nil {
+		return false, err
+	}
+
+	switch q.CompOp {
+	case sql.EQ, sql.NEQ:
+		eq := lhs == rhs
+		switch {
+		case isNumeric(lhs) && isNumeric(rhs):
+			// numbers of different types can be equal, e.g. 2 and 2.0
+			eq = compareNumeric(lhs, rhs) == 0
+		case comparableTemporal(lhs, rhs):
+			// a date equals a timestamp at midnight at its start
+			eq = compareTemporal(lhs, rhs) == 0
 		}
-	case sql.GTE:
-		switch lhs := lhs.(type) {
-		case int64:
-			if rhs, ok := rhs.(int64); ok {
-				return lhs >= rhs, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		case string:
-			if rhs, ok := rhs.(string); ok {
-				return strings.Compare(lhs, rhs) >= 0, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		default:
+		return eq == (q.CompOp == sql.EQ), nil
+	}
+
+	var cmp int
+	switch {
+	case isNumeric(lhs) && isNumeric(rhs):
+		cmp = compareNumeric(lhs, rhs)
+	case comparableTemporal(lhs, rhs):
+		cmp = compareTemporal(lhs, rhs)
+	default:
+		lhs, lOk := lhs.(string)
+		rhs, rOk := rhs.(string)
+		if !lOk || !rOk {
 			return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
 		}
+		cmp = strings.Compare(lhs, rhs)
+	}
+
+	switch q.CompOp {
+	case sql.GT:
+		return cmp > 0, nil
+	case sql.GTE:
+		return cmp >= 0, nil
 	case sql.LT:
-		switch lhs := lhs.(type) {
-		case int64:
-			if rhs, ok := rhs.(int64); ok {
-				return lhs < rhs, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		case string:
-			if rhs, ok := rhs.(string); ok {
-				return strings.Compare(lhs, rhs) < 0, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		}
+		return cmp < 0, nil
 	case sql.LTE:
-		switch lhs := lhs.(type) {
-		case int64:
-			if rhs, ok := rhs.(int64); ok {
-				return lhs <= rhs, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		case string:
-			if rhs, ok := rhs.(string); ok {
-				return strings.Compare(lhs, rhs) <= 0, nil
-			} else {
-				return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-			}
-		default:
-			return false, newErrIncompatTypeCompare(q.LHS, q.RHS)
-		}
+		return cmp <= 0, nil
 	}
 
 	return false, fmt.Errorf("nothing to compare here")
 }
 
+// evalPrimary returns the value of value expression q for row.
 func evalPrimary(q interface{}, qfields storage.Fields, row *storage.Row) (interface{}, error) {
 	if col, ok := q.(sql.ColumnReference); ok {
 		idx, err := findColumnInFieldList(col, qfields)
 		if err != nil {
+			if isRowIDColumn(col, qfields) {
+				return rowIDVal(row), nil
+			}
 			return nil, err
 		}
 		return row.Vals[idx], nil
 	}
-	return q, nil
+	return evaluate(q, qfields, row)
 }
 
-func printTable(rows []*storage.Row, fields []*storage.Field) {
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
-
-	fmt.Print("\n\r\n\r")
-
-	for _, field := range fields {
-		fmt.Fprintf(w, "| [%s]\t", field.Column)
-	}
-
-	fmt.Fprint(w, "|\n\r")
-
-	for range fields {
-		fmt.Fprint(w, "| --------------------\t")
+// rowIDVal returns the value of the rowid pseudo-column of row, which is null
+// for the rows of tables that are clustered on their primary key.
+func rowIDVal(row *storage.Row) interface{} {
+	if row.RowID == 0 {
+		return nil
 	}
-
-	fmt.Fprint(w, "|\n\r")
-
-	for _, row := range rows {
-		for _, elem := range row.Vals {
-			fmt.Fprintf(w, "| %v\t", elem)
-		}
-		fmt.Fprint(w, "|\n\r")
-	}
-
-	w.Flush()
-
-	fmt.Printf("\n\r%d result(s) returned\n\r", len(rows))
+	return int64(row.RowID)
 }
 
-func limit(limit int, rows []*storage.Row) []*storage.Row {
-	if limit > len(rows) {
-		return rows
+// isRowIDColumn reports whether col refers to the rowid pseudo-column of the
+// table that the columns qfields belong to. Row IDs are only known for rows
+// read from a single table, so rows produced by a join don't have one.
+func isRowIDColumn(col sql.ColumnReference, qfields storage.Fields) bool {
+	if col.ColumnName != storage.RowIDColumn || len(qfields) == 0 {
+		return false
 	}
-	return rows[0:limit]
-}
-
-func offset(offset int, rows []*storage.Row) []*storage.Row {
-	if offset >= len(rows) {
-		return []*storage.Row{}
+	tableID := qfields[0].TableID
+	for _, fd := range qfields {
+		if fd.TableID != tableID {
+			return false
+		}
 	}
-	return rows[offset:]
+	return col.Qualifier == "" || col.Qualifier == tableID
 }
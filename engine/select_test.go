@@ -11,24 +11,30 @@ import (
 
 type mockRelationManager struct {
 	createTable   func(r *storage.Relation, tableName string) error
-	markDeleted   func(tableName string, rowID uint32) (storage.WALBatch, error)
+	markDeleted   func(tableName string, key []byte) (storage.WALBatch, error)
 	fetch         func(tableName string) ([]*storage.Row, []*storage.Field, error)
-	update        func(tableName string, rowID uint32, cols []string, updateSrc []interface{}) (storage.WALBatch, error)
+	update        func(tableName string, key []byte, cols []string, updateSrc []interface{}) (storage.WALBatch, error)
 	insert        func(tableName string, cols []string, vals []interface{}) (storage.WALBatch, error)
 	flushWALBatch func(batch storage.WALBatch) error
+	createIndex   func(idx *storage.Index) error
+	dropIndex     func(indexName string) error
+	indexes       func(tableName string) ([]*storage.Index, error)
+	fetchByIndex  func(idx *storage.Index, vals []interface{}) ([]*storage.Row, []*storage.Field, error)
+	tableStats    func(tableName string) (storage.TableStats, error)
 }
 
 func (m *mockRelationManager) CreateTable(r *storage.Relation, tableName string) error {
 	return m.createTable(r, tableName)
 }
-func (m *mockRelationManager) MarkDeleted(tableName string, rowID uint32) (storage.WALBatch, error) {
-	return m.markDeleted(tableName, rowID)
+func (m *mockRelationManager) MarkDeleted(tableName string, key []byte) (storage.WALBatch, error) {
+	return m.markDeleted(tableName, key)
 }
-func (m *mockRelationManager) Fetch(tableName string) ([]*storage.Row, []*storage.Field, error) {
-	return m.fetch(tableName)
+func (m *mockRelationManager) Scan(tableName string) (storage.RowIterator, []*storage.Field, error) {
+	rows, fields, err := m.fetch(tableName)
+	return &rowSlice{rows: rows}, fields, err
 }
-func (m *mockRelationManager) Update(tableName string, rowID uint32, cols []string, updateSrc []interface{}) (storage.WALBatch, error) {
-	return m.update(tableName, rowID, cols, updateSrc)
+func (m *mockRelationManager) Update(tableName string, key []byte, cols []string, updateSrc []interface{}) (storage.WALBatch, error) {
+	return m.update(tableName, key, cols, updateSrc)
 }
 func (m *mockRelationManager) Insert(tableName string, cols []string, vals []interface{}) (storage.WALBatch, error) {
 	return m.insert(tableName, cols, vals)
@@ -36,13 +42,116 @@ func (m *mockRelationManager) Insert(tableName string, cols []string, vals []int
 func (m *mockRelationManager) FlushWALBatch(batch storage.WALBatch) error {
 	return m.flushWALBatch(batch)
 }
+func (m *mockRelationManager) CreateIndex(idx *storage.Index) error {
+	return m.createIndex(idx)
+}
+func (m *mockRelationManager) DropIndex(indexName string) error {
+	return m.dropIndex(indexName)
+}
+func (m *mockRelationManager) Indexes(tableName string) ([]*storage.Index, error) {
+	if m.indexes == nil {
+		return nil, nil
+	}
+	return m.indexes(tableName)
+}
+func (m *mockRelationManager) ScanByIndex(idx *storage.Index, vals []interface{}) (storage.RowIterator, []*storage.Field, error) {
+	rows, fields, err := m.fetchByIndex(idx, vals)
+	return &rowSlice{rows: rows}, fields, err
+}
+func (m *mockRelationManager) ScanByIndexRange(idx *storage.Index, vals []interface{}, rng storage.KeyRange) (storage.RowIterator, []*storage.Field, error) {
+	return m.ScanByIndex(idx, vals)
+}
 
-func (m *mockRelationManager) StartTxn() {
+func (m *mockRelationManager) SeekRowID(tableName string, rowID uint32) (storage.RowIterator, []*storage.Field, error) {
+	rows, fields, err := m.fetch(tableName)
+	it := &rowSlice{}
+	for _, row := range rows {
+		if row.RowID == rowID {
+			it.rows = append(it.rows, row)
+		}
+	}
+	return it, fields, err
+}
+func (m *mockRelationManager) TableStats(tableName string) (storage.TableStats, error) {
+	if m.tableStats != nil {
+		return m.tableStats(tableName)
+	}
+	if m.fetch == nil {
+		return storage.TableStats{}, nil
+	}
+	rows, _, err := m.fetch(tableName)
+	return storage.TableStats{RowCount: int64(len(rows))}, err
+}
+
+// rowSlice iterates over rows returned by a mock fetch.
+type rowSlice struct {
+	rows []*storage.Row
+}
+
+func (r *rowSlice) Next() (*storage.Row, error) {
+	if len(r.rows) == 0 {
+		return nil, nil
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func (m *mockRelationManager) Analyze(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) Schema(tableName string) (*storage.Relation, error) {
+	return &storage.Relation{}, nil
+}
+
+func (m *mockRelationManager) ReferencingKeys(tableName string) ([]*storage.ForeignKey, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) AddColumn(tableName string, fd storage.FieldDef) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) DropColumn(tableName string, col string) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) RenameColumn(tableName string, oldName string, newName string) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) RenameTable(oldName string, newName string) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) UpdateCheck(check *storage.Check) (storage.WALBatch, error) {
+	return nil, nil
+}
+
+func (m *mockRelationManager) DropTable(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) TruncateTable(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) Vacuum(tableName string) error {
+	return nil
+}
+
+func (m *mockRelationManager) StartTxn() error {
+	return nil
 }
 
 func (m *mockRelationManager) EndTxn() {
 }
 
+func (m *mockRelationManager) AbortStmt() error {
+	return nil
+}
+
 func TestSelect(t *testing.T) {
 
 	tc := []struct {
@@ -362,7 +471,7 @@ func TestSelect(t *testing.T) {
 					{Vals: []interface{}{"3"}},
 				},
 			},
-			expectRows: []*storage.Row{},
+			expectRows: nil,
 		},
 		{
 			name: "SELECT with OFFSET value 0: SELECT * FROM tbl1 OFFSET 100",
@@ -460,7 +569,7 @@ func TestSelect(t *testing.T) {
 					{Vals: []interface{}{"3"}},
 				},
 			},
-			expectRows: []*storage.Row{},
+			expectRows: nil,
 		},
 		{
 			name: "SELECT with OFFSET value that exceeds result set size: SELECT * FROM tbl1 OFFSET 100",
@@ -490,7 +599,7 @@ func TestSelect(t *testing.T) {
 					{Vals: []interface{}{"3"}},
 				},
 			},
-			expectRows: []*storage.Row{},
+			expectRows: nil,
 		},
 		{
 			name: "SELECT with LIMIT and OFFSET: SELECT * FROM tbl1 LIMIT 2 OFFSET 1",
@@ -804,7 +913,7 @@ func TestSelect(t *testing.T) {
 			},
 			expectFields: []*storage.Field{
 				{Column: "col1", TableID: "tbl1"},
-				{Column: "?"},
+				{Column: "?", DataType: storage.TypeBoolean},
 			},
 			givenRows: map[string][]*storage.Row{
 				"tbl1": {
@@ -852,8 +961,8 @@ func TestSelect(t *testing.T) {
 				},
 			},
 			expectFields: []*storage.Field{
-				{Column: "?"},
-				{Column: "?"},
+				{Column: "?", DataType: storage.TypeBoolean},
+				{Column: "?", DataType: storage.TypeBoolean},
 			},
 			givenRows: map[string][]*storage.Row{},
 			expectRows: []*storage.Row{
@@ -893,7 +1002,7 @@ func TestSelect(t *testing.T) {
 				},
 			},
 			expectFields: []*storage.Field{
-				{Column: "?"},
+				{Column: "?", DataType: storage.TypeBoolean},
 			},
 			givenRows: map[string][]*storage.Row{},
 			expectRows: []*storage.Row{
@@ -986,7 +1095,7 @@ func TestSelect(t *testing.T) {
 			expectFields: []*storage.Field{
 				{Column: "customer_id", TableID: "orders"},
 				{Column: "product_id", TableID: "orders"},
-				{Column: "total"},
+				{Column: "total", DataType: storage.TypeBigInt},
 			},
 			givenRows: map[string][]*storage.Row{
 				"orders": {
@@ -1030,7 +1139,7 @@ func TestSelect(t *testing.T) {
 				},
 			},
 			expectFields: []*storage.Field{
-				{Column: "count(*)"},
+				{Column: "count(*)", DataType: storage.TypeBigInt},
 			},
 			expectRows: []*storage.Row{
 				{Vals: []interface{}{int64(4)}},
@@ -1059,8 +1168,8 @@ func TestSelect(t *testing.T) {
 				"tbl1": {},
 			},
 			expectFields: []*storage.Field{
-				{Column: "?"},
-				{Column: "count(*)"},
+				{Column: "?", DataType: storage.TypeBigInt},
+				{Column: "count(*)", DataType: storage.TypeBigInt},
 			},
 			expectRows: []*storage.Row{
 				{Vals: []interface{}{int64(1), int64(0)}},
@@ -1121,7 +1230,7 @@ func TestSelect(t *testing.T) {
 			},
 			expectFields: []*storage.Field{
 				{Column: "year", TableID: "tbl2"},
-				{Column: "count(*)"},
+				{Column: "count(*)", DataType: storage.TypeBigInt},
 			},
 			givenRows: map[string][]*storage.Row{
 				"tbl1": {
@@ -1211,7 +1320,7 @@ func TestSelect(t *testing.T) {
 			},
 			expectFields: []*storage.Field{
 				{Column: "yr", TableID: "tbl2"},
-				{Column: "count(*)"},
+				{Column: "count(*)", DataType: storage.TypeBigInt},
 			},
 			givenRows: map[string][]*storage.Row{
 				"tbl1": {
@@ -1291,11 +1400,11 @@ func TestSelect(t *testing.T) {
 				},
 			},
 			expectFields: []*storage.Field{
-				{Column: "avg(grades.math)"},
-				{Column: "sci_avg"},
+				{Column: "avg(grades.math)", DataType: storage.TypeDecimal},
+				{Column: "sci_avg", DataType: storage.TypeDecimal},
 			},
 			expectRows: []*storage.Row{
-				{Vals: []interface{}{int64(74), int64(49)}},
+				{Vals: []interface{}{storage.NewDecimal(74500000, 6), storage.NewDecimal(49500000, 6)}},
 			},
 		},
 		{
@@ -1341,11 +1450,11 @@ func TestSelect(t *testing.T) {
 				},
 			},
 			expectFields: []*storage.Field{
-				{Column: "avg(math)"},
-				{Column: "avg(science)"},
+				{Column: "avg(math)", DataType: storage.TypeDecimal},
+				{Column: "avg(science)", DataType: storage.TypeDecimal},
 			},
 			expectRows: []*storage.Row{
-				{Vals: []interface{}{int64(74), int64(49)}},
+				{Vals: []interface{}{storage.NewDecimal(74500000, 6), storage.NewDecimal(49500000, 6)}},
 			},
 		},
 		{
@@ -1383,11 +1492,11 @@ func TestSelect(t *testing.T) {
 				},
 			},
 			expectFields: []*storage.Field{
-				{Column: "avg(math)"},
-				{Column: "avg(science)"},
+				{Column: "avg(math)", DataType: storage.TypeDecimal},
+				{Column: "avg(science)", DataType: storage.TypeDecimal},
 			},
 			expectRows: []*storage.Row{
-				{Vals: []interface{}{int64(0), int64(0)}},
+				{Vals: []interface{}{nil, nil}},
 			},
 		},
 		{
@@ -1446,14 +1555,14 @@ func TestSelect(t *testing.T) {
 			},
 			expectFields: []*storage.Field{
 				{TableID: "grades", Column: "id"},
-				{Column: "avg(math)"},
-				{Column: "avg(science)"},
+				{Column: "avg(math)", DataType: storage.TypeDecimal},
+				{Column: "avg(science)", DataType: storage.TypeDecimal},
 			},
 			expectRows: []*storage.Row{
-				{Vals: []interface{}{int64(4), int64(62), int64(49)}},
-				{Vals: []interface{}{int64(1), int64(95), int64(50)}},
-				{Vals: []interface{}{int64(2), int64(22), int64(63)}},
-				{Vals: []interface{}{int64(3), int64(60), int64(38)}},
+				{Vals: []interface{}{int64(4), storage.NewDecimal(61500000, 6), storage.NewDecimal(48500000, 6)}},
+				{Vals: []interface{}{int64(1), storage.NewDecimal(95000000, 6), storage.NewDecimal(50000000, 6)}},
+				{Vals: []interface{}{int64(2), storage.NewDecimal(22000000, 6), storage.NewDecimal(63000000, 6)}},
+				{Vals: []interface{}{int64(3), storage.NewDecimal(59500000, 6), storage.NewDecimal(37500000, 6)}},
 			},
 		},
 		{
@@ -1492,7 +1601,7 @@ func TestSelect(t *testing.T) {
 			},
 			expectFields: []*storage.Field{
 				{Column: "customer_id", TableID: "orders"},
-				{Column: "count(product_id)"},
+				{Column: "count(product_id)", DataType: storage.TypeBigInt},
 			},
 			givenRows: map[string][]*storage.Row{
 				"orders": {
@@ -1810,9 +1919,9 @@ func TestSelectScalar(t *testing.T) {
 		},
 	}
 	expectFields := []*storage.Field{
-		{Column: "?"},
+		{Column: "?", DataType: storage.TypeBigInt},
 		{TableID: "tbl1", Column: "col1"},
-		{Column: "?"},
+		{Column: "?", DataType: storage.TypeVarchar},
 	}
 	expectRows := []*storage.Row{
 		{Vals: []interface{}{int64(123), int64(1), "Test"}},
@@ -3,7 +3,9 @@ package engine
 import (
 	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/mk6i/mkdb/sql"
 	"github.com/mk6i/mkdb/storage"
@@ -12,98 +14,486 @@ import (
 type Session struct {
 	CurDB           string
 	RelationService *storage.RelationService
+	// Pool, if set, lets the session share its database with the other
+	// sessions of the pool.
+	Pool *DBPool
+	db   *database
+	// txn is true while the session has an explicit transaction open.
+	txn bool
+	// aborted is true while the session's transaction has been rolled back
+	// by a statement that failed, until the session ends it.
+	aborted bool
+}
+
+// Column describes a column of a query result.
+type Column struct {
+	Name string
+	Type storage.DataType
+}
+
+// Result is the outcome of a statement. Columns describes the rows returned
+// by a query and is nil for statements that don't return rows. Each row holds
+// one value per column, which is nil for NULL or else an int64 for INT and
+// BIGINT, a float64 for REAL and DOUBLE PRECISION, a storage.Decimal for
+// DECIMAL, a storage.Date, storage.Time, storage.Timestamp or
+// storage.Interval for the temporal types, a string for VARCHAR, TEXT and
+// BLOB, or a bool for BOOLEAN. Tag names the kind of statement that ran, e.g.
+// INSERT or CREATE TABLE, and RowsAffected counts the rows it returned,
+// inserted, updated or deleted. Message is a human-readable summary of the
+// outcome.
+type Result struct {
+	Columns      []Column
+	Rows         [][]interface{}
+	RowsAffected int
+	Tag          string
+	Message      string
+}
+
+// ResultWriter receives the result of a query as it's produced: first its
+// columns, and then each of its rows.
+type ResultWriter interface {
+	WriteColumns(cols []Column) error
+	WriteRow(vals []interface{}) error
 }
 
 type RelationManager interface {
-	StartTxn()
+	StartTxn() error
 	EndTxn()
+	AbortStmt() error
 	CreateTable(r *storage.Relation, tableName string) error
-	MarkDeleted(tableName string, rowID uint32) (storage.WALBatch, error)
-	Fetch(tableName string) ([]*storage.Row, []*storage.Field, error)
-	Update(tableName string, rowID uint32, cols []string, updateSrc []interface{}) (storage.WALBatch, error)
+	MarkDeleted(tableName string, key []byte) (storage.WALBatch, error)
+	Scan(tableName string) (storage.RowIterator, []*storage.Field, error)
+	Update(tableName string, key []byte, cols []string, updateSrc []interface{}) (storage.WALBatch, error)
 	Insert(tableName string, cols []string, vals []interface{}) (storage.WALBatch, error)
 	FlushWALBatch(batch storage.WALBatch) error
+	CreateIndex(idx *storage.Index) error
+	DropIndex(indexName string) error
+	Indexes(tableName string) ([]*storage.Index, error)
+	ScanByIndex(idx *storage.Index, vals []interface{}) (storage.RowIterator, []*storage.Field, error)
+	ScanByIndexRange(idx *storage.Index, vals []interface{}, rng storage.KeyRange) (storage.RowIterator, []*storage.Field, error)
+	SeekRowID(tableName string, rowID uint32) (storage.RowIterator, []*storage.Field, error)
+	TableStats(tableName string) (storage.TableStats, error)
+	Analyze(tableName string) error
+	Schema(tableName string) (*storage.Relation, error)
+	ReferencingKeys(tableName string) ([]*storage.ForeignKey, error)
+	AddColumn(tableName string, fd storage.FieldDef) (storage.WALBatch, error)
+	DropColumn(tableName string, col string) (storage.WALBatch, error)
+	RenameColumn(tableName string, oldName string, newName string) (storage.WALBatch, error)
+	RenameTable(oldName string, newName string) (storage.WALBatch, error)
+	UpdateCheck(check *storage.Check) (storage.WALBatch, error)
+	DropTable(tableName string) error
+	TruncateTable(tableName string) error
+	Vacuum(tableName string) error
 }
 
+var (
+	ErrNotQuery   = errors.New("statement does not return rows")
+	ErrStmtInTxn  = errors.New("statement cannot run inside a transaction")
+	ErrTxnAborted = errors.New("the transaction was aborted, statements are ignored until it ends")
+)
+
 func (s *Session) Close() error {
-	if s.RelationService != nil {
-		return s.RelationService.Close()
+	if s.db == nil {
+		return nil
+	}
+	if s.txn {
+		var err error
+		if !s.aborted {
+			err = s.RelationService.Rollback()
+		}
+		s.txn, s.aborted = false, false
+		s.db.turn.Unlock()
+		if err != nil {
+			return err
+		}
 	}
+	db := s.db
+	s.db, s.RelationService, s.CurDB = nil, nil, ""
+	return s.Pool.release(db)
+}
+
+// InTxn returns true if the session has an explicit transaction open,
+// including one that was aborted.
+func (s *Session) InTxn() bool {
+	return s.txn
+}
+
+// TxnAborted returns true if a statement failed inside the session's
+// transaction. The transaction has been rolled back, and the session rejects
+// statements with ErrTxnAborted until it's ended by ROLLBACK or COMMIT.
+func (s *Session) TxnAborted() bool {
+	return s.aborted
+}
+
+// Use selects database dbName like the USE statement does.
+func (s *Session) Use(dbName string) error {
+	if s.txn {
+		return ErrStmtInTxn
+	}
+	if s.db != nil {
+		db := s.db
+		s.db, s.RelationService, s.CurDB = nil, nil, ""
+		if err := s.Pool.release(db); err != nil {
+			return err
+		}
+	}
+	db, err := s.Pool.open(dbName)
+	if err != nil {
+		return err
+	}
+	s.db, s.RelationService, s.CurDB = db, db.rs, dbName
 	return nil
 }
 
+// Exec runs statement q and returns its result. Parameters in q (`?` or
+// `$n`) are bound to the values in args.
+func (s *Session) Exec(q string, args ...interface{}) (*Result, error) {
+	stmt, err := parseSQL(q, args)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse sql: %w", err)
+	}
+	return s.exec(stmt, nil)
+}
+
+// ExecTo runs statement q like Exec, but writes the columns and rows of a
+// query's result to w as they're produced instead of collecting them, so the
+// returned Result holds no rows. Rows may have been written to w before the
+// statement fails.
+func (s *Session) ExecTo(w ResultWriter, q string, args ...interface{}) (*Result, error) {
+	stmt, err := parseSQL(q, args)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse sql: %w", err)
+	}
+	return s.exec(stmt, w)
+}
+
+// Query runs query q and returns its result like Exec does, but fails with
+// ErrNotQuery without running q if q doesn't return rows.
+func (s *Session) Query(q string, args ...interface{}) (*Result, error) {
+	stmt, err := parseSQL(q, args)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse sql: %w", err)
+	}
+	switch stmt.(type) {
+	case sql.Select, sql.ShowDatabase, sql.ShowStats, sql.ExplainStatement:
+		return s.exec(stmt, nil)
+	default:
+		return nil, ErrNotQuery
+	}
+}
+
+// ExecQuery runs statement q and prints its result to stdout.
+//
+// Deprecated: use Exec or Query, which return the result instead.
 func (s *Session) ExecQuery(q string) error {
-	stmt, err := parseSQL(q)
+	res, err := s.Exec(q)
 	if err != nil {
-		return fmt.Errorf("unable to parse sql: %s", err.Error())
+		return err
+	}
+	if res.Columns != nil {
+		printTable(res)
+		fmt.Print("\n\r")
+	}
+	fmt.Printf("%s\n\r", res.Message)
+	return nil
+}
+
+func printTable(res *Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+
+	fmt.Print("\n\r\n\r")
+
+	for _, col := range res.Columns {
+		fmt.Fprintf(w, "| [%s]\t", col.Name)
+	}
+
+	fmt.Fprint(w, "|\n\r")
+
+	for range res.Columns {
+		fmt.Fprint(w, "| --------------------\t")
+	}
+
+	fmt.Fprint(w, "|\n\r")
+
+	for _, row := range res.Rows {
+		for _, elem := range row {
+			if elem == nil {
+				elem = "NULL"
+			}
+			fmt.Fprintf(w, "| %v\t", elem)
+		}
+		fmt.Fprint(w, "|\n\r")
+	}
+
+	w.Flush()
+}
+
+// exec runs stmt. If w is set, the rows of a query's result are written to w
+// rather than collected in the Result.
+func (s *Session) exec(stmt interface{}, w ResultWriter) (res *Result, err error) {
+	if s.aborted {
+		switch stmt.(type) {
+		case sql.CommitStatement, sql.RollbackStatement:
+			// the transaction's changes were undone when it was aborted
+			s.txn, s.aborted = false, false
+			s.db.turn.Unlock()
+			return &Result{Tag: "ROLLBACK", Message: "transaction rolled back"}, nil
+		}
+		return nil, ErrTxnAborted
+	}
+
+	// schema changes flush pages to disk directly and switching databases
+	// would orphan the open transaction, so neither can be rolled back
+	if s.txn {
+		switch stmt.(type) {
+		case sql.CreateDatabase, sql.DropDatabase, sql.UseStatement, sql.CreateTable, sql.DropTable, sql.TruncateTable,
+			sql.CreateIndex, sql.DropIndex, sql.AnalyzeStatement, sql.VacuumStatement:
+			return nil, ErrStmtInTxn
+		}
 	}
 
 	switch stmt := stmt.(type) {
 	case sql.CreateDatabase:
 		if err := EvaluateCreateDatabase(stmt); err != nil {
-			return err
+			return nil, err
 		}
-		fmt.Printf("created database %s\n\r", stmt.Name)
-		return nil
+		return &Result{Tag: "CREATE DATABASE", Message: fmt.Sprintf("created database %s", stmt.Name)}, nil
+	case sql.DropDatabase:
+		if err := EvaluateDropDatabase(stmt); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "DROP DATABASE", Message: fmt.Sprintf("dropped database %s", stmt.Name)}, nil
 	case sql.UseStatement:
-		var err error
-		s.CurDB = stmt.DBName
-		s.RelationService, err = storage.OpenRelation(stmt.DBName, true)
-		if err != nil {
-			return err
+		if err := s.Use(stmt.DBName); err != nil {
+			return nil, err
 		}
-		fmt.Printf("selected database %s\n\r", stmt.DBName)
-		return nil
+		return &Result{Tag: "USE", Message: fmt.Sprintf("selected database %s", stmt.DBName)}, nil
 	case sql.ShowDatabase:
 		rows, fields, err := EvaluateShowDatabase(stmt)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		printTable(rows, fields)
-		return nil
+		return writeResult(w, queryResult("SHOW", rows, fields))
 	}
 
 	if s.CurDB == "" {
-		return errors.New("please select a database")
+		return nil, errors.New("please select a database")
+	}
+
+	// wait for our turn on the database, unless an open transaction already
+	// holds it. the turn is kept for as long as a transaction is open.
+	if !s.txn {
+		s.db.turn.Lock()
 	}
+	defer func() {
+		inTxn := s.RelationService.InTxn()
+		switch stmt.(type) {
+		case sql.CommitStatement, sql.RollbackStatement:
+		default:
+			if s.txn && !inTxn && err != nil {
+				// the statement failed and rolled back the transaction. the
+				// session stays in it, so that the statements that follow
+				// don't run on their own.
+				s.aborted = true
+				err = fmt.Errorf("%w; %s", err, ErrTxnAborted.Error())
+				return
+			}
+		}
+		s.txn = inTxn
+		if !s.txn {
+			s.db.turn.Unlock()
+		}
+	}()
 
 	switch stmt := stmt.(type) {
+	case sql.BeginStatement:
+		if err := s.RelationService.Begin(); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "BEGIN", Message: "transaction started"}, nil
+	case sql.CommitStatement:
+		if err := s.RelationService.Commit(); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "COMMIT", Message: "transaction committed"}, nil
+	case sql.RollbackStatement:
+		if err := s.RelationService.Rollback(); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "ROLLBACK", Message: "transaction rolled back"}, nil
+	case sql.CheckpointStatement:
+		if err := s.RelationService.Checkpoint(); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "CHECKPOINT", Message: "checkpoint complete"}, nil
 	case sql.CreateTable:
 		if err := EvaluateCreateTable(stmt, s.RelationService); err != nil {
-			return err
+			return nil, err
+		}
+		return &Result{Tag: "CREATE TABLE", Message: fmt.Sprintf("created table %s", stmt.Name)}, nil
+	case sql.DropTable:
+		if err := EvaluateDropTable(stmt, s.RelationService); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "DROP TABLE", Message: fmt.Sprintf("dropped table %s", stmt.Name)}, nil
+	case sql.TruncateTable:
+		if err := EvaluateTruncateTable(stmt, s.RelationService); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "TRUNCATE TABLE", Message: fmt.Sprintf("truncated table %s", stmt.Name)}, nil
+	case sql.CreateIndex:
+		if err := EvaluateCreateIndex(stmt, s.RelationService); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "CREATE INDEX", Message: fmt.Sprintf("created index %s", stmt.Name)}, nil
+	case sql.DropIndex:
+		if err := EvaluateDropIndex(stmt, s.RelationService); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "DROP INDEX", Message: fmt.Sprintf("dropped index %s", stmt.Name)}, nil
+	case sql.AlterTable:
+		if err := EvaluateAlterTable(stmt, s.RelationService); err != nil {
+			return nil, err
 		}
-		fmt.Printf("created table %s\n\r", stmt.Name)
+		return &Result{Tag: "ALTER TABLE", Message: fmt.Sprintf("altered table %s", stmt.TableName)}, nil
+	case sql.AnalyzeStatement:
+		if err := EvaluateAnalyze(stmt, s.RelationService); err != nil {
+			return nil, err
+		}
+		if stmt.TableName == "" {
+			return &Result{Tag: "ANALYZE", Message: "analyzed all tables"}, nil
+		}
+		return &Result{Tag: "ANALYZE", Message: fmt.Sprintf("analyzed table %s", stmt.TableName)}, nil
+	case sql.VacuumStatement:
+		if err := EvaluateVacuum(stmt, s.RelationService); err != nil {
+			return nil, err
+		}
+		if stmt.TableName == "" {
+			return &Result{Tag: "VACUUM", Message: "vacuumed all tables"}, nil
+		}
+		return &Result{Tag: "VACUUM", Message: fmt.Sprintf("vacuumed table %s", stmt.TableName)}, nil
+	case sql.ShowStats:
+		rows, fields, err := EvaluateShowStats(stmt, s.RelationService)
+		if err != nil {
+			return nil, err
+		}
+		return writeResult(w, queryResult("SHOW", rows, fields))
 	case sql.Select:
+		if w != nil {
+			cols, count, err := streamSelect(stmt, s.RelationService, w)
+			if err != nil {
+				return nil, err
+			}
+			return &Result{
+				Columns:      cols,
+				Tag:          "SELECT",
+				RowsAffected: count,
+				Message:      fmt.Sprintf("%d result(s) returned", count),
+			}, nil
+		}
 		rows, fields, err := EvaluateSelect(stmt, s.RelationService)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		return queryResult("SELECT", rows, fields), nil
+	case sql.ExplainStatement:
+		rows, fields, err := EvaluateExplain(stmt, s.RelationService)
+		if err != nil {
+			return nil, err
 		}
-		printTable(rows, fields)
+		return writeResult(w, queryResult("EXPLAIN", rows, fields))
 	case sql.InsertStatement:
-		if count, err := EvaluateInsert(stmt, s.RelationService); err != nil {
-			return err
-		} else {
-			fmt.Printf("inserted %d record(s) into %s\n\r", count, stmt.TableName)
+		count, err := EvaluateInsert(stmt, s.RelationService)
+		if err != nil {
+			return nil, err
 		}
+		return &Result{
+			Tag:          "INSERT",
+			RowsAffected: count,
+			Message:      fmt.Sprintf("inserted %d record(s) into %s", count, stmt.TableName),
+		}, nil
 	case sql.UpdateStatementSearched:
-		if err := EvaluateUpdate(stmt, s.RelationService); err != nil {
-			return err
+		count, err := EvaluateUpdate(stmt, s.RelationService)
+		if err != nil {
+			return nil, err
 		}
-		fmt.Printf("update successful %d %s\n\r", 1, stmt.TableName)
+		return &Result{
+			Tag:          "UPDATE",
+			RowsAffected: count,
+			Message:      fmt.Sprintf("updated %d record(s) in %s", count, stmt.TableName),
+		}, nil
 	case sql.DeleteStatementSearched:
-		if count, err := EvaluateDelete(stmt, s.RelationService); err != nil {
-			return err
-		} else {
-			fmt.Printf("deleted %d record(s) into %s\n\r", count, stmt.TableName)
+		count, err := EvaluateDelete(stmt, s.RelationService)
+		if err != nil {
+			return nil, err
 		}
+		return &Result{
+			Tag:          "DELETE",
+			RowsAffected: count,
+			Message:      fmt.Sprintf("deleted %d record(s) into %s", count, stmt.TableName),
+		}, nil
 	default:
-		return fmt.Errorf("unsupported statement type")
+		return nil, fmt.Errorf("unsupported statement type")
 	}
+}
 
-	return nil
+// abortOnError undoes the changes of the statement that rm runs if *err is
+// set. It's deferred after EndTxn, so that it runs before the statement
+// ends.
+func abortOnError(rm RelationManager, err *error) {
+	if *err == nil {
+		return
+	}
+	if abortErr := rm.AbortStmt(); abortErr != nil {
+		*err = fmt.Errorf("%w (unable to undo the statement: %s)", *err, abortErr.Error())
+	}
 }
 
-func parseSQL(q string) (interface{}, error) {
+func queryResult(tag string, rows []*storage.Row, fields []*storage.Field) *Result {
+	res := &Result{
+		Columns:      resultColumns(fields),
+		Rows:         make([][]interface{}, len(rows)),
+		Tag:          tag,
+		RowsAffected: len(rows),
+		Message:      fmt.Sprintf("%d result(s) returned", len(rows)),
+	}
+	for i, row := range rows {
+		res.Rows[i] = row.Vals
+	}
+	return res
+}
+
+// resultColumns describes the columns of a result with the given fields.
+func resultColumns(fields []*storage.Field) []Column {
+	cols := make([]Column, len(fields))
+	for i, field := range fields {
+		cols[i] = Column{
+			Name: fmt.Sprintf("%s", field.Column),
+			Type: field.DataType,
+		}
+	}
+	return cols
+}
+
+// writeResult writes the columns and rows of query result res to w, if set,
+// and removes the rows from res.
+func writeResult(w ResultWriter, res *Result) (*Result, error) {
+	if w == nil {
+		return res, nil
+	}
+	if err := w.WriteColumns(res.Columns); err != nil {
+		return nil, err
+	}
+	for _, row := range res.Rows {
+		if err := w.WriteRow(row); err != nil {
+			return nil, err
+		}
+	}
+	res.Rows = nil
+	return res, nil
+}
+
+func parseSQL(q string, args []interface{}) (interface{}, error) {
 	ts := sql.NewTokenScanner(strings.NewReader(q))
 	tl := sql.TokenList{}
 
@@ -111,7 +501,15 @@ func parseSQL(q string) (interface{}, error) {
 		tl.Add(ts.Cur())
 	}
 
+	if err := tl.Bind(bindArgs(args)); err != nil {
+		return nil, err
+	}
+
 	p := sql.Parser{TokenList: tl}
 
-	return p.Parse()
+	stmt, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	return convertLiterals(stmt)
 }
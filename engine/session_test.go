@@ -1,8 +1,14 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mk6i/mkdb/sql"
 	"github.com/mk6i/mkdb/storage"
 )
 
@@ -74,7 +80,7 @@ func TestIntegration(t *testing.T) {
 
 	s := Session{}
 	for _, q := range queries {
-		if err := s.ExecQuery(q); err != nil {
+		if _, err := s.Exec(q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
 		}
 	}
@@ -91,13 +97,13 @@ func TestInsertNonExistentTable(t *testing.T) {
 		`USE testdb`,
 	}
 	for _, q := range queries {
-		if err := s.ExecQuery(q); err != nil {
+		if _, err := s.Exec(q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
 		}
 	}
 
 	q := `INSERT INTO people (person_id, first_name, last_name) VALUES (1, 'John', 'Doe')`
-	err := s.ExecQuery(q)
+	_, err := s.Exec(q)
 
 	if err != storage.ErrTableNotExist {
 		t.Errorf("expected ErrTableNotExist error")
@@ -120,13 +126,13 @@ func TestInsertColCountMismatch(t *testing.T) {
 		)`,
 	}
 	for _, q := range queries {
-		if err := s.ExecQuery(q); err != nil {
+		if _, err := s.Exec(q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
 		}
 	}
 
 	q := `INSERT INTO people (person_id, first_name, last_name) VALUES ('John', 'Doe')`
-	err := s.ExecQuery(q)
+	_, err := s.Exec(q)
 
 	if err != storage.ErrColCountMismatch {
 		t.Errorf("expected ErrColCountMismatch error")
@@ -149,13 +155,13 @@ func TestInsertSansColListColCountMismatch(t *testing.T) {
 		)`,
 	}
 	for _, q := range queries {
-		if err := s.ExecQuery(q); err != nil {
+		if _, err := s.Exec(q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
 		}
 	}
 
 	q := `INSERT INTO people VALUES ('John', 'Doe')`
-	err := s.ExecQuery(q)
+	_, err := s.Exec(q)
 
 	if err != storage.ErrColCountMismatch {
 		t.Errorf("expected ErrColCountMismatch error")
@@ -173,13 +179,13 @@ func TestSelectNonExistentTable(t *testing.T) {
 		`USE testdb`,
 	}
 	for _, q := range queries {
-		if err := s.ExecQuery(q); err != nil {
+		if _, err := s.Exec(q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
 		}
 	}
 
 	q := `SELECT person_id, first_name, last_name FROM people`
-	err := s.ExecQuery(q)
+	_, err := s.Exec(q)
 
 	if err != storage.ErrTableNotExist {
 		t.Errorf("expected ErrTableNotExist error")
@@ -198,15 +204,1968 @@ func TestCreateDuplicateTable(t *testing.T) {
 		`CREATE TABLE motorcycles (name varchar(255))`,
 	}
 	for _, q := range queries {
-		if err := s.ExecQuery(q); err != nil {
+		if _, err := s.Exec(q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
 		}
 	}
 
 	q := `CREATE TABLE motorcycles (name varchar(255))`
-	err := s.ExecQuery(q)
+	_, err := s.Exec(q)
 
 	if err != storage.ErrTableAlreadyExist {
 		t.Errorf("expected ErrTableAlreadyExist error")
 	}
 }
+
+func TestIndex(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (
+			person_id int,
+			first_name varchar(255),
+			last_name varchar(255)
+		)`,
+		`INSERT INTO people VALUES (1, 'John', 'Doe')`,
+	}
+	for i := 2; i <= 50; i++ {
+		queries = append(queries, fmt.Sprintf(`INSERT INTO people VALUES (%d, 'Jane', 'Smith%d')`, i, i%10))
+	}
+	queries = append(queries,
+		`CREATE UNIQUE INDEX person_id_idx ON people (person_id)`,
+		`CREATE INDEX name_idx ON people (last_name, first_name)`,
+	)
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	q := `INSERT INTO people VALUES (1, 'John', 'Doe')`
+	if _, err := s.Exec(q); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Errorf("expected ErrUniqueViolation error, got %v", err)
+	}
+
+	q = `CREATE UNIQUE INDEX last_name_idx ON people (last_name)`
+	if _, err := s.Exec(q); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Errorf("expected ErrUniqueViolation error, got %v", err)
+	}
+
+	queries = []string{
+		`INSERT INTO people VALUES (51, 'Jim', 'Smith3')`,
+		`UPDATE people SET person_id = 100 WHERE person_id = 20`,
+		`DELETE FROM people WHERE last_name = 'Smith4'`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	tbl := []struct {
+		query    string
+		expected [][]interface{}
+	}{
+		{
+			query:    `SELECT person_id FROM people WHERE person_id = 1`,
+			expected: [][]interface{}{{int64(1)}},
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE person_id = 20`,
+			expected: nil,
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE person_id = 100`,
+			expected: [][]interface{}{{int64(100)}},
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE last_name = 'Smith3' ORDER BY person_id`,
+			expected: [][]interface{}{{int64(3)}, {int64(13)}, {int64(23)}, {int64(33)}, {int64(43)}, {int64(51)}},
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE last_name = 'Smith3' AND first_name = 'Jim'`,
+			expected: [][]interface{}{{int64(51)}},
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE last_name = 'Smith4'`,
+			expected: nil,
+		},
+		{
+			query:    `SELECT person_id FROM people p WHERE p.person_id = 7 AND p.last_name = 'Smith7'`,
+			expected: [][]interface{}{{int64(7)}},
+		},
+	}
+
+	for _, test := range tbl {
+		actual := selectVals(t, &s, test.query)
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expected, actual)
+		}
+	}
+
+	queries = []string{
+		`DROP INDEX name_idx`,
+		`CREATE UNIQUE INDEX name_idx ON people (first_name, last_name, person_id)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	q = `DROP INDEX name_idx_2`
+	if _, err := s.Exec(q); !errors.Is(err, storage.ErrIndexNotExist) {
+		t.Errorf("expected ErrIndexNotExist error, got %v", err)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (person_id int, first_name varchar(255))`,
+		`CREATE TABLE pets (name varchar(255))`,
+		`CREATE UNIQUE INDEX person_id_idx ON people (person_id)`,
+		`INSERT INTO people VALUES (1, 'John')`,
+		`BEGIN`,
+		`INSERT INTO people VALUES (2, 'Jane')`,
+		`INSERT INTO pets VALUES ('Rex')`,
+		`UPDATE people SET first_name = 'Johnny' WHERE person_id = 1`,
+	}
+	// insert enough rows to split the people table and its index
+	for i := 3; i <= 30; i++ {
+		queries = append(queries, fmt.Sprintf(`INSERT INTO people VALUES (%d, 'Jim')`, i))
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// the transaction sees its own writes
+	q := `SELECT first_name FROM people WHERE person_id = 2`
+	if actual := selectVals(t, &s, q); !reflect.DeepEqual([][]interface{}{{"Jane"}}, actual) {
+		t.Errorf("unexpected result for query:\n %s\nactual: %v", q, actual)
+	}
+
+	if _, err := s.Exec(`BEGIN`); !errors.Is(err, storage.ErrTxnInProgress) {
+		t.Errorf("expected ErrTxnInProgress error, got %v", err)
+	}
+	if _, err := s.Exec(`CREATE TABLE cars (name varchar(255))`); !errors.Is(err, ErrStmtInTxn) {
+		t.Errorf("expected ErrStmtInTxn error, got %v", err)
+	}
+
+	// give the background page flusher a chance to run
+	time.Sleep(300 * time.Millisecond)
+
+	if _, err := s.Exec(`ROLLBACK`); err != nil {
+		t.Fatalf("error rolling back: %s", err.Error())
+	}
+
+	tbl := []struct {
+		query    string
+		expected [][]interface{}
+	}{
+		{
+			query:    `SELECT person_id, first_name FROM people`,
+			expected: [][]interface{}{{int64(1), "John"}},
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE person_id = 2`,
+			expected: nil,
+		},
+		{
+			query:    `SELECT name FROM pets`,
+			expected: nil,
+		},
+	}
+	for _, test := range tbl {
+		actual := selectVals(t, &s, test.query)
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expected, actual)
+		}
+	}
+
+	if _, err := s.Exec(`COMMIT`); !errors.Is(err, storage.ErrNoTxnInProgress) {
+		t.Errorf("expected ErrNoTxnInProgress error, got %v", err)
+	}
+
+	queries = []string{
+		`BEGIN TRANSACTION`,
+		`INSERT INTO people VALUES (2, 'Jane')`,
+		`INSERT INTO pets VALUES ('Rex')`,
+		`COMMIT`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// committed changes survive reopening the database
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tbl = []struct {
+		query    string
+		expected [][]interface{}
+	}{
+		{
+			query:    `SELECT person_id, first_name FROM people`,
+			expected: [][]interface{}{{int64(1), "John"}, {int64(2), "Jane"}},
+		},
+		{
+			query:    `SELECT person_id FROM people WHERE person_id = 2`,
+			expected: [][]interface{}{{int64(2)}},
+		},
+		{
+			query:    `SELECT name FROM pets`,
+			expected: [][]interface{}{{"Rex"}},
+		},
+	}
+	for _, test := range tbl {
+		actual := selectVals(t, &s, test.query)
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expected, actual)
+		}
+	}
+}
+
+func selectVals(t *testing.T, s *Session, q string) [][]interface{} {
+	res, err := s.Query(q)
+	if err != nil {
+		t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+	}
+	var vals [][]interface{}
+	for _, row := range res.Rows {
+		vals = append(vals, row)
+	}
+	return vals
+}
+
+func TestCheckpoint(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (person_id int, first_name varchar(255))`,
+		`INSERT INTO people VALUES (1, 'John')`,
+		`CHECKPOINT`,
+		`INSERT INTO people VALUES (2, 'Jane')`,
+		`BEGIN`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	if _, err := s.Exec(`CHECKPOINT`); !errors.Is(err, storage.ErrTxnInProgress) {
+		t.Errorf("expected ErrTxnInProgress error, got %v", err)
+	}
+
+	queries = []string{
+		`INSERT INTO people VALUES (3, 'Jim')`,
+		`COMMIT`,
+		`CHECKPOINT`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	q := `SELECT person_id FROM people`
+	expected := [][]interface{}{{int64(1)}, {int64(2)}, {int64(3)}}
+	if actual := selectVals(t, &s, q); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", q, expected, actual)
+	}
+}
+
+func TestExplainAndRowID(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (
+			person_id int,
+			first_name varchar(255)
+		)`,
+	}
+	for i := 1; i <= 50; i++ {
+		queries = append(queries, fmt.Sprintf(`INSERT INTO people VALUES (%d, 'Jane%d')`, i, i))
+	}
+	queries = append(queries, `CREATE UNIQUE INDEX person_id_idx ON people (person_id)`)
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	q := `CREATE TABLE cars (rowid int)`
+	if _, err := s.Exec(q); !errors.Is(err, storage.ErrReservedColumn) {
+		t.Errorf("expected ErrReservedColumn error, got %v", err)
+	}
+
+	expected := [][]interface{}{
+		{"Project: first_name"},
+		{"-> Filter: person_id = 7 (rows=1)"},
+		{"   -> Index Scan using person_id_idx on people (person_id = 7) (rows=1)"},
+	}
+	actual := selectVals(t, &s, `EXPLAIN SELECT first_name FROM people WHERE person_id = 7`)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected plan. expected: %v actual: %v", expected, actual)
+	}
+
+	// look up a row by ID, then seek back to it
+	vals := selectVals(t, &s, `SELECT rowid FROM people WHERE person_id = 7`)
+	if len(vals) != 1 {
+		t.Fatalf("expected 1 row, got %v", vals)
+	}
+	q = fmt.Sprintf(`SELECT first_name FROM people WHERE rowid = %d`, vals[0][0])
+	if actual := selectVals(t, &s, q); !reflect.DeepEqual([][]interface{}{{"Jane7"}}, actual) {
+		t.Errorf("unexpected result for query:\n %s\nactual: %v", q, actual)
+	}
+	q = fmt.Sprintf(`EXPLAIN SELECT first_name FROM people WHERE rowid = %d`, vals[0][0])
+	if actual := selectVals(t, &s, q); len(actual) != 3 || actual[2][0] != fmt.Sprintf("   -> Row ID Seek on people (rowid = %d) (rows=1)", vals[0][0]) {
+		t.Errorf("unexpected plan for query:\n %s\nactual: %v", q, actual)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (
+			person_id int,
+			team varchar(255)
+		)`,
+	}
+	for i := 1; i <= 100; i++ {
+		queries = append(queries, fmt.Sprintf(`INSERT INTO people VALUES (%d, 'team%d')`, i, i%4))
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	if actual := selectVals(t, &s, `SHOW STATS`); len(actual) != 0 {
+		t.Errorf("expected no stats before ANALYZE, got %v", actual)
+	}
+
+	plans := []string{
+		`EXPLAIN SELECT * FROM people WHERE team = 'team1'`,
+		`EXPLAIN SELECT * FROM people WHERE person_id < 26`,
+	}
+	expected := [][]interface{}{
+		{"Project: *"},
+		{"-> Filter: team = 'team1' (rows=10)"},
+		{"   -> Seq Scan on people (rows=100)"},
+	}
+	if actual := selectVals(t, &s, plans[0]); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected plan. expected: %v actual: %v", expected, actual)
+	}
+
+	res, err := s.Exec(`ANALYZE people`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tag != "ANALYZE" {
+		t.Errorf("expected ANALYZE tag, got %s", res.Tag)
+	}
+
+	expected = [][]interface{}{
+		{"people", "person_id", int64(100), int64(100), "0.0000", "1, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100"},
+		{"people", "team", int64(100), int64(4), "0.0000", "'team0', 'team0', 'team0', 'team1', 'team1', 'team1', 'team2', 'team2', 'team3', 'team3', 'team3'"},
+	}
+	if actual := selectVals(t, &s, `SHOW STATS people`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected stats. expected: %v actual: %v", expected, actual)
+	}
+
+	// the estimates now come from the stats, applied to the row count
+	// estimated from the table's pages
+	expected = [][]interface{}{
+		{"Project: *"},
+		{"-> Filter: team = 'team1' (rows=25)"},
+		{"   -> Seq Scan on people (rows=100)"},
+	}
+	if actual := selectVals(t, &s, plans[0]); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected plan. expected: %v actual: %v", expected, actual)
+	}
+	expected = [][]interface{}{
+		{"Project: *"},
+		{"-> Filter: person_id < 26 (rows=25)"},
+		{"   -> Seq Scan on people (rows=100)"},
+	}
+	if actual := selectVals(t, &s, plans[1]); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected plan. expected: %v actual: %v", expected, actual)
+	}
+
+	if _, err := s.Exec(`ANALYZE missing`); err != storage.ErrTableNotExist {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+
+	if _, err := s.Exec(`BEGIN`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Exec(`ANALYZE`); err != ErrStmtInTxn {
+		t.Errorf("expected ErrStmtInTxn error, got %v", err)
+	}
+	if _, err := s.Exec(`ROLLBACK`); err != nil {
+		t.Fatal(err)
+	}
+
+	// analyzing all tables replaces the stats computed before
+	if _, err := s.Exec(`ANALYZE`); err != nil {
+		t.Fatal(err)
+	}
+	if actual := selectVals(t, &s, `SHOW STATS`); len(actual) != 2 {
+		t.Errorf("expected 2 rows of stats, got %v", actual)
+	}
+}
+
+func TestNull(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (
+			person_id int,
+			team_id int,
+			age int
+		)`,
+		`CREATE TABLE teams (
+			team_id int,
+			name varchar(255)
+		)`,
+		`INSERT INTO people VALUES (1, 1, 30), (2, NULL, 40), (3, 2, NULL), (4, NULL, NULL)`,
+		`INSERT INTO people (person_id, team_id) VALUES (5, 1)`,
+		`INSERT INTO teams VALUES (1, 'red'), (2, 'blue'), (NULL, 'none')`,
+		`CREATE INDEX team_id_idx ON people (team_id)`,
+		`UPDATE people SET age = NULL WHERE person_id = 1`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	tbl := []struct {
+		query  string
+		expect [][]interface{}
+	}{
+		{
+			query:  `SELECT person_id FROM people WHERE team_id IS NULL`,
+			expect: [][]interface{}{{int64(2)}, {int64(4)}},
+		},
+		{
+			query:  `SELECT person_id FROM people WHERE age IS NOT NULL`,
+			expect: [][]interface{}{{int64(2)}},
+		},
+		{
+			// comparisons with NULL are never true
+			query:  `SELECT person_id FROM people WHERE team_id = NULL OR team_id != NULL`,
+			expect: nil,
+		},
+		{
+			// UNKNOWN OR TRUE is TRUE
+			query:  `SELECT person_id FROM people WHERE age > 35 OR person_id = 3`,
+			expect: [][]interface{}{{int64(2)}, {int64(3)}},
+		},
+		{
+			// UNKNOWN AND FALSE is FALSE, UNKNOWN AND TRUE is UNKNOWN
+			query:  `SELECT person_id FROM people WHERE team_id = 1 AND age < 100`,
+			expect: nil,
+		},
+		{
+			query:  `SELECT person_id, team_id FROM people ORDER BY team_id, person_id`,
+			expect: [][]interface{}{{int64(1), int64(1)}, {int64(5), int64(1)}, {int64(3), int64(2)}, {int64(2), nil}, {int64(4), nil}},
+		},
+		{
+			query:  `SELECT person_id, team_id FROM people ORDER BY team_id DESC, person_id`,
+			expect: [][]interface{}{{int64(2), nil}, {int64(4), nil}, {int64(3), int64(2)}, {int64(1), int64(1)}, {int64(5), int64(1)}},
+		},
+		{
+			query:  `SELECT person_id, team_id FROM people ORDER BY team_id NULLS FIRST, person_id DESC`,
+			expect: [][]interface{}{{int64(4), nil}, {int64(2), nil}, {int64(5), int64(1)}, {int64(1), int64(1)}, {int64(3), int64(2)}},
+		},
+		{
+			query:  `SELECT person_id, team_id FROM people ORDER BY team_id DESC NULLS LAST, person_id`,
+			expect: [][]interface{}{{int64(3), int64(2)}, {int64(1), int64(1)}, {int64(5), int64(1)}, {int64(2), nil}, {int64(4), nil}},
+		},
+		{
+			query:  `SELECT count(*), count(team_id), count(age), avg(age) FROM people`,
+			expect: [][]interface{}{{int64(5), int64(3), int64(1), storage.NewDecimal(40000000, 6)}},
+		},
+		{
+			// nulls are grouped together
+			query:  `SELECT team_id, count(*), avg(age) FROM people GROUP BY team_id`,
+			expect: [][]interface{}{{int64(1), int64(2), nil}, {nil, int64(2), storage.NewDecimal(40000000, 6)}, {int64(2), int64(1), nil}},
+		},
+		{
+			query:  `SELECT avg(age) FROM people WHERE person_id > 100`,
+			expect: [][]interface{}{{nil}},
+		},
+		{
+			// null join keys don't match, not even each other
+			query:  `SELECT people.person_id, teams.name FROM people LEFT JOIN teams ON people.team_id = teams.team_id ORDER BY people.person_id`,
+			expect: [][]interface{}{{int64(1), "red"}, {int64(2), nil}, {int64(3), "blue"}, {int64(4), nil}, {int64(5), "red"}},
+		},
+		{
+			query:  `SELECT name FROM teams WHERE team_id IS NULL`,
+			expect: [][]interface{}{{"none"}},
+		},
+	}
+
+	for _, test := range tbl {
+		if actual := selectVals(t, &s, test.query); !reflect.DeepEqual(test.expect, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, actual)
+		}
+	}
+
+	expected := [][]interface{}{
+		{"Project: person_id"},
+		{"-> Filter: team_id IS NULL (rows=1)"},
+		{"   -> Index Scan using team_id_idx on people (team_id IS NULL) (rows=1)"},
+	}
+	if actual := selectVals(t, &s, `EXPLAIN SELECT person_id FROM people WHERE team_id IS NULL`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected plan. expected: %v actual: %v", expected, actual)
+	}
+}
+
+func TestConstraints(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (
+			person_id int PRIMARY KEY,
+			email varchar(255) UNIQUE,
+			name varchar(255) NOT NULL DEFAULT 'anonymous',
+			age int CHECK (age >= 0 AND age < 150),
+			active boolean DEFAULT true,
+			CHECK (email IS NOT NULL OR active = false)
+		)`,
+		`INSERT INTO people (person_id, email, age) VALUES (1, 'a@example.com', 30)`,
+		`INSERT INTO people (person_id, email, name, active) VALUES (2, 'b@example.com', 'bob', false)`,
+		`INSERT INTO people (person_id, active) VALUES (3, false)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	expect := [][]interface{}{
+		{int64(1), "a@example.com", "anonymous", int64(30), true},
+		{int64(2), "b@example.com", "bob", nil, false},
+		{int64(3), nil, "anonymous", nil, false},
+	}
+	if actual := selectVals(t, &s, `SELECT * FROM people ORDER BY person_id`); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expect, actual)
+	}
+
+	tbl := []struct {
+		query  string
+		expect error
+	}{
+		{
+			query:  `INSERT INTO people (person_id, email) VALUES (1, 'c@example.com')`,
+			expect: storage.ErrUniqueViolation,
+		},
+		{
+			query:  `INSERT INTO people (person_id, email) VALUES (NULL, 'c@example.com')`,
+			expect: storage.ErrNotNullViolation,
+		},
+		{
+			query:  `INSERT INTO people (person_id, email) VALUES (4, 'a@example.com')`,
+			expect: storage.ErrUniqueViolation,
+		},
+		{
+			query:  `INSERT INTO people (person_id, email, name) VALUES (4, 'c@example.com', NULL)`,
+			expect: storage.ErrNotNullViolation,
+		},
+		{
+			query:  `INSERT INTO people (person_id, email, age) VALUES (4, 'c@example.com', 150)`,
+			expect: ErrCheckViolation,
+		},
+		{
+			// the default of active makes the table constraint false
+			query:  `INSERT INTO people (person_id) VALUES (4)`,
+			expect: ErrCheckViolation,
+		},
+		{
+			query:  `UPDATE people SET age = 200 WHERE person_id = 1`,
+			expect: ErrCheckViolation,
+		},
+		{
+			query:  `UPDATE people SET name = NULL WHERE person_id = 2`,
+			expect: storage.ErrNotNullViolation,
+		},
+		{
+			query:  `UPDATE people SET person_id = 2 WHERE person_id = 1`,
+			expect: storage.ErrUniqueViolation,
+		},
+		{
+			query:  `CREATE TABLE t1 (id int PRIMARY KEY, other int, PRIMARY KEY (other))`,
+			expect: ErrMultiplePrimaryKeys,
+		},
+		{
+			query:  `CREATE TABLE t1 (id int DEFAULT 'one')`,
+			expect: storage.ErrTypeMismatch,
+		},
+		{
+			query:  `CREATE TABLE t1 (id int CHECK (missing > 0))`,
+			expect: storage.ErrFieldNotFound,
+		},
+	}
+
+	for _, test := range tbl {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.expect) {
+			t.Errorf("unexpected error for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, err)
+		}
+	}
+
+	// a null age makes the column constraint UNKNOWN, which satisfies it
+	if _, err := s.Exec(`UPDATE people SET age = NULL WHERE person_id = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	indexes, err := s.RelationService.Indexes("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectIdx := []*storage.Index{
+		{Name: "people_pkey", TableName: "people", Columns: []string{"person_id"}, Unique: true, Primary: true},
+		{Name: "people_email_key", TableName: "people", Columns: []string{"email"}, Unique: true},
+	}
+	if !reflect.DeepEqual(expectIdx, indexes) {
+		t.Errorf("unexpected indexes. expected: %v actual: %v", expectIdx, indexes)
+	}
+}
+
+func TestFailedStatementIsUndone(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE a (id int PRIMARY KEY, v int CHECK (v < 100))`,
+		`CREATE INDEX a_v_idx ON a (v)`,
+		`INSERT INTO a VALUES (1, 10), (4, 95)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// each statement fails on a row after changing the rows before it
+	tbl := []struct {
+		query  string
+		expect error
+	}{
+		{
+			query:  `INSERT INTO a VALUES (2, 20), (3, 30), (1, 40)`,
+			expect: storage.ErrUniqueViolation,
+		},
+		{
+			query:  `UPDATE a SET v = v + 10`,
+			expect: ErrCheckViolation,
+		},
+	}
+	for _, test := range tbl {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.expect) {
+			t.Errorf("unexpected error for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, err)
+		}
+	}
+
+	// a statement that fails inside a transaction aborts the transaction
+	queries = []string{
+		`BEGIN`,
+		`INSERT INTO a VALUES (6, 60)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+	if _, err := s.Exec(`INSERT INTO a VALUES (5, 50), (1, 10)`); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Errorf("expected error %v, got %v", storage.ErrUniqueViolation, err)
+	}
+	if !s.InTxn() || !s.TxnAborted() {
+		t.Error("expected the transaction to be aborted")
+	}
+	// the statements that follow don't run until the transaction ends, and
+	// COMMIT rolls it back
+	for _, q := range []string{`INSERT INTO a VALUES (7, 70)`, `SELECT id, v FROM a`} {
+		if _, err := s.Exec(q); !errors.Is(err, ErrTxnAborted) {
+			t.Errorf("query %s: expected error %v, got %v", q, ErrTxnAborted, err)
+		}
+	}
+	res, err := s.Exec(`COMMIT`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tag != "ROLLBACK" {
+		t.Errorf("expected COMMIT of an aborted transaction to roll it back, got %s", res.Tag)
+	}
+	if s.InTxn() || s.TxnAborted() {
+		t.Error("expected the transaction to end")
+	}
+
+	expect := [][]interface{}{{int64(1), int64(10)}, {int64(4), int64(95)}}
+	check := func() {
+		for _, q := range []string{`SELECT id, v FROM a`, `SELECT id, v FROM a WHERE v > 0`} {
+			if actual := selectVals(t, &s, q); !reflect.DeepEqual(expect, actual) {
+				t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", q, expect, actual)
+			}
+		}
+	}
+	check()
+
+	// the pages of the failed statements don't reach the disk either
+	time.Sleep(300 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check()
+
+	// row IDs and keys handed out by the failed statements can be used again
+	if _, err := s.Exec(`INSERT INTO a VALUES (2, 20)`); err != nil {
+		t.Fatal(err)
+	}
+	expect = [][]interface{}{{int64(1), int64(10)}, {int64(2), int64(20)}, {int64(4), int64(95)}}
+	check()
+}
+
+func TestRestrictedDeleteIsUndone(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE par (id int PRIMARY KEY)`,
+		`CREATE TABLE ch (id int, par_id int REFERENCES par (id) ON DELETE CASCADE)`,
+		`CREATE TABLE ch3 (id int, par_id int REFERENCES par (id))`,
+		`INSERT INTO par VALUES (1), (2)`,
+		`INSERT INTO ch VALUES (10, 1)`,
+		`INSERT INTO ch3 VALUES (30, 2)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// the restricted row comes after a row whose deletion cascades
+	if _, err := s.Exec(`DELETE FROM par`); !errors.Is(err, ErrForeignKeyViolation) {
+		t.Errorf("expected error %v, got %v", ErrForeignKeyViolation, err)
+	}
+
+	check := func() {
+		tbl := []struct {
+			query  string
+			expect [][]interface{}
+		}{
+			{
+				query:  `SELECT id FROM par`,
+				expect: [][]interface{}{{int64(1)}, {int64(2)}},
+			},
+			{
+				query:  `SELECT id, par_id FROM ch`,
+				expect: [][]interface{}{{int64(10), int64(1)}},
+			},
+			{
+				query:  `SELECT id, par_id FROM ch3`,
+				expect: [][]interface{}{{int64(30), int64(2)}},
+			},
+		}
+		for _, test := range tbl {
+			if actual := selectVals(t, &s, test.query); !reflect.DeepEqual(test.expect, actual) {
+				t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, actual)
+			}
+		}
+	}
+	check()
+
+	time.Sleep(300 * time.Millisecond)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check()
+}
+
+func TestForeignKeys(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE teams (team_id int PRIMARY KEY, name varchar(255) UNIQUE)`,
+		`CREATE TABLE people (
+			person_id int PRIMARY KEY,
+			team_id int REFERENCES teams ON DELETE CASCADE ON UPDATE CASCADE,
+			team_name varchar(255),
+			manager_id int,
+			FOREIGN KEY (team_name) REFERENCES teams (name) ON DELETE SET NULL,
+			FOREIGN KEY (manager_id) REFERENCES people (person_id)
+		)`,
+		`INSERT INTO teams VALUES (1, 'red'), (2, 'blue'), (3, 'green')`,
+		`INSERT INTO people VALUES (1, 1, 'red', NULL), (2, 1, 'blue', 1), (3, 2, 'green', 1), (4, NULL, NULL, 2)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	tbl := []struct {
+		query  string
+		expect error
+	}{
+		{
+			query:  `INSERT INTO people VALUES (5, 4, NULL, NULL)`,
+			expect: ErrForeignKeyViolation,
+		},
+		{
+			query:  `INSERT INTO people VALUES (5, NULL, 'purple', NULL)`,
+			expect: ErrForeignKeyViolation,
+		},
+		{
+			query:  `UPDATE people SET manager_id = 10 WHERE person_id = 4`,
+			expect: ErrForeignKeyViolation,
+		},
+		{
+			// person 1 manages other people
+			query:  `DELETE FROM people WHERE person_id = 1`,
+			expect: ErrForeignKeyViolation,
+		},
+		{
+			query:  `CREATE TABLE t1 (id int REFERENCES missing)`,
+			expect: storage.ErrTableNotExist,
+		},
+		{
+			query:  `CREATE TABLE t1 (id int REFERENCES people (team_id))`,
+			expect: storage.ErrInvalidForeignKey,
+		},
+		{
+			query:  `CREATE TABLE t1 (id varchar(255) REFERENCES teams)`,
+			expect: storage.ErrInvalidForeignKey,
+		},
+	}
+
+	for _, test := range tbl {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.expect) {
+			t.Errorf("unexpected error for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, err)
+		}
+	}
+
+	queries = []string{
+		`UPDATE teams SET team_id = 10 WHERE team_id = 1`,
+		`DELETE FROM teams WHERE name = 'green'`,
+		`DELETE FROM teams WHERE team_id = 2`,
+	}
+
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// the cascading delete of person 2 is restricted, since person 2 manages
+	// person 4, so nothing is deleted
+	if _, err := s.Exec(`DELETE FROM teams WHERE team_id = 10`); !errors.Is(err, ErrForeignKeyViolation) {
+		t.Errorf("expected ErrForeignKeyViolation error, got %v", err)
+	}
+
+	// recover from the log to check that the referential actions were logged
+	// along with the changes that triggered them
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// person 1 and 2 moved to team 10 along with team 1, person 2 lost the
+	// name of team 2, and person 3 was deleted with team 2
+	expected := [][]interface{}{
+		{int64(1), int64(10), "red", nil},
+		{int64(2), int64(10), nil, int64(1)},
+		{int64(4), nil, nil, int64(2)},
+	}
+	if actual := selectVals(t, &s, `SELECT * FROM people ORDER BY person_id`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+}
+
+func TestClusteredPrimaryKey(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE events (
+			region varchar(10),
+			id int,
+			name varchar(255),
+			PRIMARY KEY (region, id)
+		)`,
+		`CREATE INDEX events_name_idx ON events (name)`,
+	}
+	// insert the rows out of key order
+	for i := 0; i < 100; i++ {
+		id := i * 37 % 100
+		region := "us"
+		if id%2 == 0 {
+			region = "eu"
+		}
+		queries = append(queries, fmt.Sprintf(`INSERT INTO events VALUES ('%s', %d, 'event%d')`, region, id, id))
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// the rows are stored in primary key order
+	vals := selectVals(t, &s, `SELECT region, id FROM events`)
+	if len(vals) != 100 {
+		t.Fatalf("expected 100 rows, got %d", len(vals))
+	}
+	for i, row := range vals {
+		expect := []interface{}{"eu", int64(i * 2)}
+		if i >= 50 {
+			expect = []interface{}{"us", int64((i-50)*2 + 1)}
+		}
+		if !reflect.DeepEqual(expect, row) {
+			t.Fatalf("row %d out of order. expected: %v actual: %v", i, expect, row)
+		}
+	}
+
+	if _, err := s.Exec(`INSERT INTO events VALUES ('eu', 4, 'duplicate')`); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Errorf("expected ErrUniqueViolation error, got %v", err)
+	}
+
+	// the rows of a clustered table don't have row IDs
+	expected := [][]interface{}{{nil, "event4"}}
+	if actual := selectVals(t, &s, `SELECT rowid, name FROM events WHERE region = 'eu' AND id = 4`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+
+	plans := []struct {
+		query  string
+		scan   string
+		expect [][]interface{}
+	}{
+		{
+			query:  `SELECT name FROM events WHERE region = 'us' AND id = 7`,
+			scan:   "   -> Index Scan using events_pkey on events (region = 'us', id = 7) (rows=1)",
+			expect: [][]interface{}{{"event7"}},
+		},
+		{
+			query:  `SELECT id FROM events WHERE region = 'eu' AND id > 10 AND 16 >= id`,
+			scan:   "   -> Index Range Scan using events_pkey on events (region = 'eu', id > 10 AND id <= 16)",
+			expect: [][]interface{}{{int64(12)}, {int64(14)}, {int64(16)}},
+		},
+		{
+			query:  `SELECT region, id FROM events WHERE region < 'us'`,
+			scan:   "   -> Index Range Scan using events_pkey on events (region < 'us')",
+			expect: vals[:50],
+		},
+	}
+
+	for _, test := range plans {
+		plan := selectVals(t, &s, "EXPLAIN "+test.query)
+		if len(plan) != 3 || !strings.HasPrefix(plan[2][0].(string), test.scan) {
+			t.Errorf("unexpected plan for query:\n %s\nexpected scan: %s\nactual: %v", test.query, test.scan, plan)
+		}
+		if actual := selectVals(t, &s, test.query); !reflect.DeepEqual(test.expect, actual) {
+			t.Errorf("unexpected rows for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, actual)
+		}
+	}
+
+	queries = []string{
+		// reuse the key of a deleted row
+		`DELETE FROM events WHERE region = 'eu' AND id = 2`,
+		`INSERT INTO events VALUES ('eu', 2, 'replaced')`,
+		// move rows to new keys
+		`UPDATE events SET id = 101 WHERE region = 'eu' AND id = 0`,
+		`UPDATE events SET region = 'ap' WHERE name = 'event99'`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	if _, err := s.Exec(`UPDATE events SET id = 4 WHERE region = 'eu' AND id = 6`); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Errorf("expected ErrUniqueViolation error, got %v", err)
+	}
+
+	// recover from the log to check that the moved rows were logged
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expected = [][]interface{}{
+		{"ap", int64(99), "event99"},
+		{"eu", int64(2), "replaced"},
+		{"eu", int64(4), "event4"},
+	}
+	if actual := selectVals(t, &s, `SELECT * FROM events WHERE region < 'us' AND (id < 5 OR id = 99)`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+
+	// the secondary index points to the rows at their new keys
+	expected = [][]interface{}{{"eu", int64(101)}}
+	if actual := selectVals(t, &s, `SELECT region, id FROM events WHERE name = 'event0'`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+	if actual := selectVals(t, &s, `SELECT region, id FROM events WHERE id = 0`); len(actual) != 0 {
+		t.Errorf("expected no rows, got %v", actual)
+	}
+}
+
+func TestLongKeys(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE docs (path varchar(600), rev int, PRIMARY KEY (path, rev))`,
+		`CREATE TABLE tags (id int, name varchar(600))`,
+		`CREATE UNIQUE INDEX tags_name_idx ON tags (name)`,
+	}
+	// insert enough rows out of key order to split the internal nodes of
+	// the trees
+	const rowCount = 300
+	longName := func(i int) string {
+		return fmt.Sprintf("%03d%s", i, strings.Repeat("x", 590))
+	}
+	for i := 0; i < rowCount; i++ {
+		id := i * 7 % rowCount
+		queries = append(queries,
+			fmt.Sprintf(`INSERT INTO docs VALUES ('%s', %d)`, longName(id), id),
+			fmt.Sprintf(`INSERT INTO tags VALUES (%d, '%s')`, id, longName(id)))
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// keys that can exceed the maximum key size are rejected up front
+	rejected := []string{
+		`CREATE TABLE big (path varchar(1000) PRIMARY KEY)`,
+		`CREATE TABLE big (path varchar(1000), UNIQUE (path))`,
+		// the entries of the index end in the primary key
+		`CREATE INDEX docs_path_idx ON docs (path)`,
+	}
+	for _, q := range rejected {
+		if _, err := s.Exec(q); !errors.Is(err, storage.ErrKeyTooLarge) {
+			t.Errorf("expected error %v for query:\n %s\nactual: %v", storage.ErrKeyTooLarge, q, err)
+		}
+	}
+	if _, err := s.Query(`SELECT * FROM big`); !errors.Is(err, storage.ErrTableNotExist) {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+
+	check := func() {
+		// the rows are stored in primary key order
+		vals := selectVals(t, &s, `SELECT rev FROM docs`)
+		if len(vals) != rowCount {
+			t.Fatalf("expected %d rows, got %d", rowCount, len(vals))
+		}
+		for i, row := range vals {
+			if !reflect.DeepEqual([]interface{}{int64(i)}, row) {
+				t.Fatalf("row %d out of order. actual: %v", i, row)
+			}
+		}
+		for _, id := range []int{0, 123, rowCount - 1} {
+			q := fmt.Sprintf(`SELECT id FROM tags WHERE name = '%s'`, longName(id))
+			if actual := selectVals(t, &s, q); !reflect.DeepEqual([][]interface{}{{int64(id)}}, actual) {
+				t.Errorf("unexpected rows for query:\n %s\nactual: %v", q, actual)
+			}
+		}
+	}
+	check()
+
+	// rebuilding the trees packs the long keys into internal nodes
+	if _, err := s.Exec(`VACUUM`); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	check()
+}
+
+func TestAlterTable(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (id int PRIMARY KEY, nam varchar(255), age int CHECK (age >= 18), city varchar(255))`,
+		`CREATE TABLE pets (name varchar(255), owner int REFERENCES people (id))`,
+		`INSERT INTO people VALUES (1, 'alice', 30, 'paris')`,
+		`INSERT INTO people VALUES (2, 'bob', 40, 'rome')`,
+		`ALTER TABLE people ADD COLUMN active boolean DEFAULT true`,
+		`ALTER TABLE people ADD nickname varchar(255)`,
+		`ALTER TABLE people DROP COLUMN city`,
+		`ALTER TABLE people RENAME COLUMN nam TO name`,
+		`ALTER TABLE people RENAME age TO years`,
+		`ALTER TABLE people RENAME TO persons`,
+		`INSERT INTO persons (id, name, years, nickname) VALUES (3, 'carol', 50, 'caz')`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	errs := []struct {
+		query string
+		err   error
+	}{
+		{`ALTER TABLE persons ADD COLUMN name int`, storage.ErrColumnExists},
+		{`ALTER TABLE persons ADD COLUMN rowid int`, storage.ErrReservedColumn},
+		{`ALTER TABLE persons ADD COLUMN score int NOT NULL`, storage.ErrNotNullViolation},
+		{`ALTER TABLE persons ADD COLUMN email varchar(255) UNIQUE`, ErrTmpUnsupportedSyntax},
+		{`ALTER TABLE persons DROP COLUMN id`, storage.ErrColumnInUse},
+		{`ALTER TABLE persons DROP COLUMN years`, storage.ErrColumnInUse},
+		{`ALTER TABLE persons DROP COLUMN city`, storage.ErrFieldNotFound},
+		{`ALTER TABLE persons RENAME COLUMN name TO years`, storage.ErrColumnExists},
+		{`ALTER TABLE persons RENAME TO pets`, storage.ErrTableAlreadyExist},
+		{`ALTER TABLE people RENAME TO humans`, storage.ErrTableNotExist},
+		{`ALTER TABLE sys_schema RENAME TO schema`, storage.ErrAlterSystemTable},
+		// the check constraint follows the renamed column
+		{`INSERT INTO persons (id, name, years) VALUES (4, 'dave', 5)`, ErrCheckViolation},
+		// the foreign key follows the renamed table
+		{`INSERT INTO pets VALUES ('rex', 9)`, ErrForeignKeyViolation},
+	}
+	for _, test := range errs {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.err) {
+			t.Errorf("expected error %v for query:\n %s\nactual: %v", test.err, test.query, err)
+		}
+	}
+
+	// a rolled back change leaves the schema as it was
+	queries = []string{
+		`BEGIN`,
+		`ALTER TABLE persons DROP COLUMN nickname`,
+		`ROLLBACK`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	// recover from the log to check that the catalog changes were logged
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+	s = Session{}
+	if _, err := s.Exec(`USE testdb`); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// the rows stored before the columns were added hold their defaults
+	expected := [][]interface{}{
+		{int64(1), "alice", int64(30), true, nil},
+		{int64(2), "bob", int64(40), true, nil},
+		{int64(3), "carol", int64(50), true, "caz"},
+	}
+	if actual := selectVals(t, &s, `SELECT * FROM persons`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+
+	r, err := s.RelationService.Schema("persons")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Checks) != 1 || r.Checks[0].Expr != "years >= 18" {
+		t.Errorf("unexpected check constraints: %v", r.Checks)
+	}
+
+	queries = []string{
+		`UPDATE persons SET years = 31, nickname = 'al' WHERE id = 1`,
+		`INSERT INTO pets VALUES ('rex', 1)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+	expected = [][]interface{}{{"alice", int64(31), "al"}}
+	if actual := selectVals(t, &s, `SELECT name, years, nickname FROM persons WHERE id = 1`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+}
+
+func TestDropAndTruncate(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	// the other tests leave sessions open on testdb, which couldn't be
+	// dropped
+	s := Session{}
+
+	queries := []string{
+		`CREATE DATABASE dropdb`,
+		`CREATE DATABASE keepdb`,
+		`USE dropdb`,
+		`CREATE TABLE owners (id int PRIMARY KEY, name varchar(255))`,
+		`CREATE TABLE pets (name varchar(255) UNIQUE, owner int REFERENCES owners (id))`,
+		`INSERT INTO owners VALUES (1, 'alice'), (2, 'bob')`,
+		`INSERT INTO pets VALUES ('rex', 1), ('tom', 2)`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	errs := []struct {
+		query string
+		err   error
+	}{
+		{`DROP TABLE owners`, storage.ErrTableReferenced},
+		{`TRUNCATE owners`, storage.ErrTableReferenced},
+		{`DROP TABLE sys_schema`, storage.ErrAlterSystemTable},
+		{`DROP TABLE people`, storage.ErrTableNotExist},
+		{`DROP DATABASE dropdb`, storage.ErrDBInUse},
+		{`DROP DATABASE nodb`, storage.ErrDBNotExist},
+	}
+	for _, test := range errs {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.err) {
+			t.Errorf("expected error %v for query:\n %s\nactual: %v", test.err, test.query, err)
+		}
+	}
+
+	queries = []string{
+		`TRUNCATE TABLE pets`,
+		// the unique index was emptied too
+		`INSERT INTO pets VALUES ('rex', 2)`,
+		`DROP TABLE pets`,
+		`DROP TABLE owners`,
+		`CREATE TABLE pets (name varchar(255))`,
+		`INSERT INTO pets VALUES ('felix')`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	expected := [][]interface{}{{"felix"}}
+	if actual := selectVals(t, &s, `SELECT * FROM pets`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+	if _, err := s.Exec(`SELECT * FROM owners`); !errors.Is(err, storage.ErrTableNotExist) {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+
+	queries = []string{
+		`USE keepdb`,
+		`DROP DATABASE dropdb`,
+		`DROP DATABASE IF EXISTS dropdb`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+	defer s.Close()
+
+	expected = [][]interface{}{{"keepdb"}}
+	if actual := selectVals(t, &s, `SHOW DATABASES`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected databases. expected: %v actual: %v", expected, actual)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	defer s.Close()
+
+	var vals []string
+	for i := 0; i < 200; i++ {
+		vals = append(vals, fmt.Sprintf("(%d, 'name%d', %d)", i, i, i%4))
+	}
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (id int PRIMARY KEY, name varchar(255), team int)`,
+		`CREATE INDEX people_team_idx ON people (team)`,
+		`INSERT INTO people VALUES ` + strings.Join(vals, ", "),
+		`DELETE FROM people WHERE id > 9`,
+		`VACUUM people`,
+		`INSERT INTO people VALUES (200, 'name200', 1)`,
+		`DELETE FROM people WHERE id = 0`,
+		`VACUUM`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	expected := [][]interface{}{{int64(5), "name5"}}
+	if actual := selectVals(t, &s, `SELECT id, name FROM people WHERE id = 5`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+	expected = [][]interface{}{{int64(1)}, {int64(5)}, {int64(9)}, {int64(200)}}
+	if actual := selectVals(t, &s, `SELECT id FROM people WHERE team = 1`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+	expected = [][]interface{}{{int64(10)}}
+	if actual := selectVals(t, &s, `SELECT COUNT(*) FROM people`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+
+	if _, err := s.Exec(`VACUUM pets`); !errors.Is(err, storage.ErrTableNotExist) {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+	if _, err := s.Exec(`BEGIN`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Exec(`VACUUM`); err != ErrStmtInTxn {
+		t.Errorf("expected ErrStmtInTxn error, got %v", err)
+	}
+	if _, err := s.Exec(`ROLLBACK`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLargeObjects(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	defer s.Close()
+
+	body := strings.Repeat("lorem ipsum ", 1000)
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE docs (id int PRIMARY KEY, title varchar(255), body text, payload bytea)`,
+		fmt.Sprintf(`INSERT INTO docs VALUES (1, 'first', '%s', '{"a": 1}')`, body),
+		`INSERT INTO docs VALUES (2, 'second', 'short', NULL)`,
+		fmt.Sprintf(`UPDATE docs SET title = '%s' WHERE id = 2`, strings.Repeat("t", 500)),
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	expected := [][]interface{}{
+		{int64(1), body, `{"a": 1}`},
+		{int64(2), "short", nil},
+	}
+	if actual := selectVals(t, &s, `SELECT id, body, payload FROM docs ORDER BY id`); !reflect.DeepEqual(expected, actual) {
+		t.Error("unexpected rows with large values")
+	}
+
+	res, err := s.Exec(`SELECT body, payload FROM docs`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Columns[0].Type != storage.TypeText || res.Columns[1].Type != storage.TypeBlob {
+		t.Errorf("expected TEXT and BLOB columns, got %v and %v", res.Columns[0].Type, res.Columns[1].Type)
+	}
+
+	expected = [][]interface{}{{int64(2)}}
+	if actual := selectVals(t, &s, `SELECT id FROM docs WHERE body = 'short'`); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected rows. expected: %v actual: %v", expected, actual)
+	}
+}
+
+func TestNumericTypes(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	defer s.Close()
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE prices (id int PRIMARY KEY, amount decimal(10, 2), weight real, ratio double precision DEFAULT 1)`,
+		`INSERT INTO prices VALUES (1, 12.50, 0.1, 2.5e-1), (2, 3, 1.5, NULL), (3, 7.125, 0.5, 0.5), (4, NULL, NULL, 3)`,
+		`CREATE INDEX amount_idx ON prices (amount)`,
+		`UPDATE prices SET weight = 2 WHERE id = 3`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	tbl := []struct {
+		query  string
+		expect [][]interface{}
+	}{
+		{
+			// decimals are rounded to the scale of the column and integers
+			// promoted to the column type
+			query: `SELECT id, amount, weight, ratio FROM prices ORDER BY id`,
+			expect: [][]interface{}{
+				{int64(1), storage.NewDecimal(1250, 2), 0.1, 0.25},
+				{int64(2), storage.NewDecimal(300, 2), 1.5, nil},
+				{int64(3), storage.NewDecimal(713, 2), 2.0, 0.5},
+				{int64(4), nil, nil, 3.0},
+			},
+		},
+		{
+			// numbers of different types compare by value
+			query:  `SELECT id FROM prices WHERE amount = 3 OR weight = 1.5 OR ratio = 0.50`,
+			expect: [][]interface{}{{int64(2)}, {int64(3)}},
+		},
+		{
+			query:  `SELECT id FROM prices WHERE amount > 7.12 AND weight < 1e1`,
+			expect: [][]interface{}{{int64(1)}, {int64(3)}},
+		},
+		{
+			query:  `SELECT id, amount FROM prices ORDER BY amount DESC NULLS LAST`,
+			expect: [][]interface{}{{int64(1), storage.NewDecimal(1250, 2)}, {int64(3), storage.NewDecimal(713, 2)}, {int64(2), storage.NewDecimal(300, 2)}, {int64(4), nil}},
+		},
+		{
+			query:  `SELECT id, ratio FROM prices ORDER BY ratio`,
+			expect: [][]interface{}{{int64(1), 0.25}, {int64(3), 0.5}, {int64(4), 3.0}, {int64(2), nil}},
+		},
+		{
+			// the average of decimals is exact, the average of floating-point
+			// numbers is floating-point
+			query:  `SELECT avg(amount), avg(ratio) FROM prices`,
+			expect: [][]interface{}{{storage.NewDecimal(7543333, 6), 1.25}},
+		},
+		{
+			query:  `SELECT avg(id) FROM prices`,
+			expect: [][]interface{}{{storage.NewDecimal(2500000, 6)}},
+		},
+	}
+	for _, test := range tbl {
+		if actual := selectVals(t, &s, test.query); !reflect.DeepEqual(test.expect, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, actual)
+		}
+	}
+
+	if _, err := s.Exec(`INSERT INTO prices VALUES (5, 123456789.5, 1, 1)`); !errors.Is(err, storage.ErrNumericOutOfRange) {
+		t.Errorf("expected error %v, got %v", storage.ErrNumericOutOfRange, err)
+	}
+	if _, err := s.Exec(`INSERT INTO prices VALUES (5, 'a lot', 1, 1)`); !errors.Is(err, storage.ErrTypeMismatch) {
+		t.Errorf("expected error %v, got %v", storage.ErrTypeMismatch, err)
+	}
+	if _, err := s.Exec(`SELECT id FROM prices WHERE amount > 'a lot'`); !errors.Is(err, ErrIncompatTypeCompare) {
+		t.Errorf("expected error %v, got %v", ErrIncompatTypeCompare, err)
+	}
+	if _, err := s.Exec(`CREATE TABLE totals (amount decimal(19))`); !errors.Is(err, sql.ErrInvalidNumericType) {
+		t.Errorf("expected error %v, got %v", sql.ErrInvalidNumericType, err)
+	}
+
+	res, err := s.Query(`SELECT id FROM prices WHERE amount = ?`, storage.NewDecimal(1250, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := [][]interface{}{{int64(1)}}; !reflect.DeepEqual(expect, res.Rows) {
+		t.Errorf("expected the decimal parameter to match %v, got %v", expect, res.Rows)
+	}
+}
+
+func TestTemporalTypes(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	defer s.Close()
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE events (id int PRIMARY KEY, day date, starts time, created timestamp, length interval DEFAULT INTERVAL '1 hour')`,
+		`INSERT INTO events VALUES
+			(1, DATE '2026-01-31', TIME '09:30', TIMESTAMP '2026-01-31 23:15:30.5', INTERVAL '2 days'),
+			(2, '2026-03-01', '23:00', '2026-03-01T08:00:00+02:00', '90 minutes')`,
+		`INSERT INTO events (id, day, created) VALUES (3, DATE '2025-12-31' + 1, TIMESTAMP '2026-01-01' - INTERVAL '1 month')`,
+		`CREATE INDEX created_idx ON events (created)`,
+		`ANALYZE events`,
+		`UPDATE events SET day = day + 7, length = length + INTERVAL '1 day' WHERE id = 3`,
+		`CREATE TABLE shifts (starts time, ends time, CHECK (ends > starts + INTERVAL '-30 minutes'))`,
+		`INSERT INTO shifts VALUES (TIME '10:00', TIME '09:45')`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	date := func(s string) storage.Date {
+		d, err := storage.ParseDate(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	ts := func(s string) storage.Timestamp {
+		val, err := storage.ParseTimestamp(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return val
+	}
+
+	tbl := []struct {
+		query  string
+		expect [][]interface{}
+	}{
+		{
+			// strings are parsed as the column type and timestamps converted
+			// to UTC
+			query: `SELECT id, day, starts, created, length FROM events ORDER BY id`,
+			expect: [][]interface{}{
+				{int64(1), date("2026-01-31"), storage.Time(34200000000), ts("2026-01-31 23:15:30.5"), storage.Interval{Days: 2}},
+				{int64(2), date("2026-03-01"), storage.Time(82800000000), ts("2026-03-01 06:00"), storage.Interval{Micros: 5400000000}},
+				{int64(3), date("2026-01-08"), nil, ts("2025-12-01"), storage.Interval{Days: 1, Micros: 3600000000}},
+			},
+		},
+		{
+			// dates compare with timestamps and strings
+			query:  `SELECT id FROM events WHERE created >= DATE '2026-01-31' AND day < '2026-03-01'`,
+			expect: [][]interface{}{{int64(1)}},
+		},
+		{
+			query:  `SELECT id, created FROM events ORDER BY created DESC`,
+			expect: [][]interface{}{{int64(2), ts("2026-03-01 06:00")}, {int64(1), ts("2026-01-31 23:15:30.5")}, {int64(3), ts("2025-12-01")}},
+		},
+		{
+			// adding a month to January 31 yields the last day of February
+			query: `SELECT created + INTERVAL '1 month', day - DATE '2026-01-01', created - TIMESTAMP '2026-01-31', starts + length FROM events WHERE id = 1`,
+			expect: [][]interface{}{{
+				ts("2026-02-28 23:15:30.5"),
+				int64(30),
+				storage.Interval{Micros: 83730500000},
+				storage.Time(34200000000),
+			}},
+		},
+		{
+			// a time wraps around midnight
+			query:  `SELECT starts + length, length > INTERVAL '1 hour' FROM events WHERE id = 2`,
+			expect: [][]interface{}{{storage.Time(1800000000), true}},
+		},
+		{
+			query: `SELECT EXTRACT(YEAR FROM created), EXTRACT(month FROM day), EXTRACT(DOW FROM day), EXTRACT(SECOND FROM created), EXTRACT(HOUR FROM starts), EXTRACT(EPOCH FROM length) FROM events WHERE id = 1`,
+			expect: [][]interface{}{{
+				int64(2026), int64(1), int64(6), storage.NewDecimal(30500000, 6), int64(9), storage.NewDecimal(172800000000, 6),
+			}},
+		},
+		{
+			query:  `SELECT DATE_TRUNC('month', created), DATE_TRUNC('week', day), DATE_TRUNC('hour', created) FROM events WHERE id = 1`,
+			expect: [][]interface{}{{ts("2026-01-01"), date("2026-01-26"), ts("2026-01-31 23:00")}},
+		},
+		{
+			query:  `SELECT id FROM events WHERE created > TIMESTAMP '2026-01-31 23:15:30.5'`,
+			expect: [][]interface{}{{int64(2)}},
+		},
+		{
+			query:  `SELECT id FROM events WHERE length = INTERVAL '25 hours' OR length = '1 day 01:00'`,
+			expect: [][]interface{}{{int64(3)}},
+		},
+		{
+			query:  `SELECT id FROM events WHERE created < NOW() - INTERVAL '100 years'`,
+			expect: nil,
+		},
+	}
+	for _, test := range tbl {
+		if actual := selectVals(t, &s, test.query); !reflect.DeepEqual(test.expect, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, actual)
+		}
+	}
+
+	res, err := s.Exec(`SELECT day + 1, created - day, NOW() FROM events WHERE id = 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, dt := range []storage.DataType{storage.TypeDate, storage.TypeInterval, storage.TypeTimestamp} {
+		if res.Columns[i].Type != dt {
+			t.Errorf("expected column %d to have type %d, got %d", i, dt, res.Columns[i].Type)
+		}
+	}
+
+	// every row of a statement sees the time that the statement started
+	var vals []string
+	for i := 0; i < 500; i++ {
+		vals = append(vals, fmt.Sprintf("(%d, NOW())", i))
+	}
+	for _, q := range []string{
+		`CREATE TABLE ticks (id int, at timestamp, CHECK (at <= NOW()))`,
+		`INSERT INTO ticks VALUES ` + strings.Join(vals, ", "),
+	} {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+	for _, q := range []string{
+		`SELECT COUNT(DISTINCT at) FROM ticks`,
+		`SELECT COUNT(DISTINCT NOW()) FROM ticks`,
+	} {
+		expect := [][]interface{}{{int64(1)}}
+		if actual := selectVals(t, &s, q); !reflect.DeepEqual(expect, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", q, expect, actual)
+		}
+	}
+	if _, err := s.Exec(`UPDATE ticks SET at = NOW()`); err != nil {
+		t.Fatal(err)
+	}
+	if actual := selectVals(t, &s, `SELECT COUNT(DISTINCT at) FROM ticks`); !reflect.DeepEqual([][]interface{}{{int64(1)}}, actual) {
+		t.Errorf("expected the updated rows to have one time, got %v", actual)
+	}
+
+	for _, test := range []struct {
+		query string
+		err   error
+	}{
+		{`INSERT INTO events VALUES (4, '2026-02-30', NULL, NULL, NULL)`, storage.ErrInvalidDatetime},
+		{`INSERT INTO events VALUES (4, DATE '2026-02-30', NULL, NULL, NULL)`, storage.ErrInvalidDatetime},
+		{`INSERT INTO events VALUES (4, NULL, NULL, NULL, 5)`, storage.ErrTypeMismatch},
+		{`SELECT day + created FROM events`, ErrUndefinedOperator},
+		{`SELECT id FROM events WHERE starts > day`, ErrIncompatTypeCompare},
+		{`SELECT EXTRACT(YEAR FROM starts) FROM events`, ErrInvalidFunctionArg},
+		{`SELECT DATE_TRUNC('fortnight', day) FROM events`, ErrInvalidFunctionArg},
+		{`SELECT TODAY() FROM events`, ErrUnknownFunction},
+		{`INSERT INTO shifts VALUES (TIME '10:00', TIME '09:00')`, ErrCheckViolation},
+	} {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.err) {
+			t.Errorf("query %s: expected error %v, got %v", test.query, test.err, err)
+		}
+	}
+}
+
+func TestSetFunctions(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	defer s.Close()
+
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE orders (id int PRIMARY KEY, region varchar(10), customer_id int, qty int, price decimal(6, 2), discount decimal(6, 2), weight double precision, placed date)`,
+		`INSERT INTO orders VALUES (1, 'east', 1, 2, 10.00, 1.50, 0.5, DATE '2026-01-05'), (2, 'east', 1, 1, 4.25, 0, 1.5, DATE '2026-02-01')`,
+		`INSERT INTO orders VALUES (3, 'west', 2, 5, 7.00, NULL, NULL, DATE '2026-01-20'), (4, 'west', 3, NULL, 7.00, 0.5, 2.0, NULL)`,
+		`INSERT INTO orders VALUES (5, NULL, 2, 3, NULL, NULL, 1.0, DATE '2025-12-31')`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	date := func(str string) storage.Date {
+		d, err := storage.ParseDate(str)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	tbl := []struct {
+		query  string
+		expect [][]interface{}
+	}{
+		{
+			query:  `SELECT sum(qty), min(price), max(price), count(DISTINCT customer_id), count(DISTINCT price) FROM orders`,
+			expect: [][]interface{}{{int64(11), storage.NewDecimal(425, 2), storage.NewDecimal(1000, 2), int64(3), int64(3)}},
+		},
+		{
+			// nulls are left out of the aggregates of each group
+			query: `SELECT region, count(*), sum(price - discount), max(placed) FROM orders GROUP BY region`,
+			expect: [][]interface{}{
+				{"east", int64(2), storage.NewDecimal(1275, 2), date("2026-02-01")},
+				{"west", int64(2), storage.NewDecimal(650, 2), date("2026-01-20")},
+				{nil, int64(1), nil, date("2025-12-31")},
+			},
+		},
+		{
+			// the count of no values is 0, the other aggregates are null
+			query:  `SELECT count(*), count(DISTINCT region), sum(qty), min(region), max(weight), avg(qty) FROM orders WHERE id > 100`,
+			expect: [][]interface{}{{int64(0), int64(0), nil, nil, nil, nil}},
+		},
+		{
+			query:  `SELECT sum(weight), sum(DISTINCT price), avg(DISTINCT price) FROM orders`,
+			expect: [][]interface{}{{5.0, storage.NewDecimal(2125, 2), storage.NewDecimal(7083333, 6)}},
+		},
+		{
+			query:  `SELECT min(region), max(region), count(qty + 1), max(qty - 1) FROM orders`,
+			expect: [][]interface{}{{"east", "west", int64(4), int64(4)}},
+		},
+	}
+	for _, test := range tbl {
+		if actual := selectVals(t, &s, test.query); !reflect.DeepEqual(test.expect, actual) {
+			t.Errorf("unexpected result for query:\n %s\nexpected: %v actual: %v", test.query, test.expect, actual)
+		}
+	}
+
+	res, err := s.Exec(`SELECT sum(qty), sum(weight), sum(price - discount), min(placed), count(DISTINCT region) FROM orders`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCols := []Column{
+		{Name: "sum(qty)", Type: storage.TypeBigInt},
+		{Name: "sum(weight)", Type: storage.TypeDouble},
+		{Name: "sum(price - discount)", Type: storage.TypeDecimal},
+		{Name: "min(placed)", Type: storage.TypeDate},
+		{Name: "count(DISTINCT region)", Type: storage.TypeBigInt},
+	}
+	if !reflect.DeepEqual(expectCols, res.Columns) {
+		t.Errorf("expected columns %v, got %v", expectCols, res.Columns)
+	}
+
+	for _, test := range []struct {
+		query string
+		err   error
+	}{
+		{`SELECT sum(region) FROM orders`, ErrNonNumericArg},
+		{`SELECT max(nope) FROM orders WHERE id > 100`, storage.ErrFieldNotFound},
+		{`SELECT region, sum(qty) FROM orders`, sql.ErrInvalidGroupByColumn},
+	} {
+		if _, err := s.Exec(test.query); !errors.Is(err, test.err) {
+			t.Errorf("query %s: expected error %v, got %v", test.query, test.err, err)
+		}
+	}
+}
+
+// resultRecorder records the result that a statement writes to it, and fails
+// once it's written failAfter rows if failAfter is set.
+type resultRecorder struct {
+	cols      []Column
+	rows      [][]interface{}
+	failAfter int
+}
+
+var errRecorderFull = errors.New("recorder is full")
+
+func (r *resultRecorder) WriteColumns(cols []Column) error {
+	r.cols = cols
+	return nil
+}
+
+func (r *resultRecorder) WriteRow(vals []interface{}) error {
+	if r.failAfter > 0 && len(r.rows) == r.failAfter {
+		return errRecorderFull
+	}
+	r.rows = append(r.rows, vals)
+	return nil
+}
+
+func TestExecTo(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (person_id int, first_name varchar(255))`,
+		`INSERT INTO people VALUES (1, 'John'), (2, 'Jane'), (3, 'Jack')`,
+	}
+	for _, q := range queries {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+
+	rec := &resultRecorder{}
+	res, err := s.ExecTo(rec, `SELECT person_id, first_name FROM people WHERE person_id > ?`, int64(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCols := []Column{
+		{Name: "person_id", Type: storage.TypeInt},
+		{Name: "first_name", Type: storage.TypeVarchar},
+	}
+	if !reflect.DeepEqual(expectedCols, rec.cols) {
+		t.Errorf("expected columns %v, got %v", expectedCols, rec.cols)
+	}
+	if expected := [][]interface{}{{int64(2), "Jane"}, {int64(3), "Jack"}}; !reflect.DeepEqual(expected, rec.rows) {
+		t.Errorf("expected rows %v, got %v", expected, rec.rows)
+	}
+	if res.Rows != nil || res.RowsAffected != 2 || !reflect.DeepEqual(expectedCols, res.Columns) {
+		t.Errorf("unexpected result %+v", res)
+	}
+
+	// the columns are written even if there are no rows
+	rec = &resultRecorder{}
+	if _, err := s.ExecTo(rec, `SELECT first_name FROM people WHERE person_id = 4`); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Column{{Name: "first_name", Type: storage.TypeVarchar}}; !reflect.DeepEqual(expected, rec.cols) || rec.rows != nil {
+		t.Errorf("unexpected result %v %v", rec.cols, rec.rows)
+	}
+
+	// results of other queries are written too
+	rec = &resultRecorder{}
+	if _, err := s.ExecTo(rec, `EXPLAIN SELECT * FROM people`); err != nil {
+		t.Fatal(err)
+	}
+	if rec.cols == nil || len(rec.rows) == 0 {
+		t.Errorf("expected EXPLAIN result, got %v %v", rec.cols, rec.rows)
+	}
+
+	// a writer error fails the statement part way through
+	rec = &resultRecorder{failAfter: 1}
+	if _, err := s.ExecTo(rec, `SELECT * FROM people`); !errors.Is(err, errRecorderFull) {
+		t.Errorf("expected errRecorderFull, got %v", err)
+	}
+	if len(rec.rows) != 1 {
+		t.Errorf("expected 1 row to be written, got %d", len(rec.rows))
+	}
+
+	// statements that don't return rows write nothing
+	rec = &resultRecorder{}
+	res, err = s.ExecTo(rec, `DELETE FROM people WHERE person_id = 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.cols != nil || res.RowsAffected != 1 {
+		t.Errorf("unexpected result %+v %v", res, rec.cols)
+	}
+}
+
+func TestExecQuery(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	s := Session{}
+	queries := []string{
+		`CREATE DATABASE testdb`,
+		`USE testdb`,
+		`CREATE TABLE people (person_id int, first_name varchar(255))`,
+		`INSERT INTO people VALUES (1, 'John'), (2, NULL)`,
+		`SELECT * FROM people`,
+	}
+	for _, q := range queries {
+		if err := s.ExecQuery(q); err != nil {
+			t.Errorf("error running query:\n %s\nError: %s", q, err.Error())
+		}
+	}
+	if err := s.ExecQuery(`SELECT * FROM cars`); !errors.Is(err, storage.ErrTableNotExist) {
+		t.Errorf("expected ErrTableNotExist, got %v", err)
+	}
+}
@@ -17,17 +17,17 @@ func TestShowDatabase(t *testing.T) {
 		{"SHOW DATABASES", "0 Result"},
 	}
 	for _, c := range cases {
-		if err := s.ExecQuery(c.q); err != nil {
+		if _, err := s.Exec(c.q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", c.q, err.Error())
 		}
 	}
 	q := `CREATE DATABASE testshow`
-	err := s.ExecQuery(q)
+	_, err := s.Exec(q)
 	if err != nil {
 		t.Error(q)
 	}
 	for _, c := range cases {
-		if err := s.ExecQuery(c.q); err != nil {
+		if _, err := s.Exec(c.q); err != nil {
 			t.Errorf("error running query:\n %s\nError: %s", c.q, err.Error())
 		}
 	}
@@ -0,0 +1,414 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// isTemporal reports whether val is a date, time, timestamp or interval.
+func isTemporal(val interface{}) bool {
+	switch val.(type) {
+	case storage.Date, storage.Time, storage.Timestamp, storage.Interval:
+		return true
+	}
+	return false
+}
+
+// comparableTemporal reports whether temporal values lhs and rhs can be
+// compared. Dates and timestamps compare with each other, times and
+// intervals only with their own kind.
+func comparableTemporal(lhs interface{}, rhs interface{}) bool {
+	isDateTime := func(val interface{}) bool {
+		switch val.(type) {
+		case storage.Date, storage.Timestamp:
+			return true
+		}
+		return false
+	}
+	switch lhs.(type) {
+	case storage.Date, storage.Timestamp:
+		return isDateTime(rhs)
+	case storage.Time:
+		_, ok := rhs.(storage.Time)
+		return ok
+	case storage.Interval:
+		_, ok := rhs.(storage.Interval)
+		return ok
+	}
+	return false
+}
+
+// compareTemporal compares temporal values lhs and rhs, which have to be
+// comparable, returning -1, 0 or 1. A date compares as midnight at its
+// start.
+func compareTemporal(lhs interface{}, rhs interface{}) int {
+	if l, ok := lhs.(storage.Interval); ok {
+		return l.Cmp(rhs.(storage.Interval))
+	}
+	var l, r int64
+	switch v := lhs.(type) {
+	case storage.Time:
+		l, r = int64(v), int64(rhs.(storage.Time))
+	default:
+		l, r = int64(toTimestamp(lhs)), int64(toTimestamp(rhs))
+	}
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// toTimestamp converts date or timestamp val to a timestamp.
+func toTimestamp(val interface{}) storage.Timestamp {
+	if d, ok := val.(storage.Date); ok {
+		return storage.TimestampFromTime(d.Time())
+	}
+	return val.(storage.Timestamp)
+}
+
+// coerceTemporal parses a string compared with a temporal value as a value
+// of the same type, so that `col > '2026-01-01'` compares dates. Other
+// values are returned as is.
+func coerceTemporal(lhs interface{}, rhs interface{}) (interface{}, interface{}, error) {
+	parse := func(str string, like interface{}) (interface{}, error) {
+		switch like.(type) {
+		case storage.Date:
+			return storage.ParseDate(str)
+		case storage.Time:
+			return storage.ParseTime(str)
+		case storage.Timestamp:
+			return storage.ParseTimestamp(str)
+		}
+		return storage.ParseInterval(str)
+	}
+	var err error
+	if str, ok := lhs.(string); ok && isTemporal(rhs) {
+		lhs, err = parse(str, rhs)
+	} else if str, ok := rhs.(string); ok && isTemporal(lhs) {
+		rhs, err = parse(str, lhs)
+	}
+	return lhs, rhs, err
+}
+
+// temporalArithmetic adds (op PLUS) or subtracts (op MINUS) lhs and rhs, at
+// least one of which is temporal. It returns false if the operation isn't
+// defined for their types.
+//
+//	date ± integer           → date
+//	date - date              → integer (days)
+//	date ± interval          → timestamp
+//	date + time              → timestamp
+//	timestamp ± interval     → timestamp
+//	timestamp - timestamp    → interval
+//	time ± interval          → time
+//	time - time              → interval
+//	interval ± interval      → interval
+func temporalArithmetic(lhs interface{}, plus bool, rhs interface{}) (interface{}, bool) {
+	// the commutative additions are handled with the temporal value first
+	if plus {
+		switch lhs.(type) {
+		case int64, storage.Interval, storage.Time:
+			switch rhs.(type) {
+			case storage.Date, storage.Timestamp:
+				lhs, rhs = rhs, lhs
+			case storage.Time:
+				if _, ok := lhs.(storage.Interval); ok {
+					lhs, rhs = rhs, lhs
+				}
+			}
+		}
+	}
+
+	sign := func(iv storage.Interval) storage.Interval {
+		if plus {
+			return iv
+		}
+		return iv.Neg()
+	}
+
+	switch l := lhs.(type) {
+	case storage.Date:
+		switch r := rhs.(type) {
+		case int64:
+			if !plus {
+				r = -r
+			}
+			return l.AddDays(r), true
+		case storage.Date:
+			if !plus {
+				return int64(l) - int64(r), true
+			}
+		case storage.Timestamp:
+			if !plus {
+				return toTimestamp(l).Sub(r), true
+			}
+		case storage.Interval:
+			return toTimestamp(l).AddInterval(sign(r)), true
+		case storage.Time:
+			if plus {
+				return toTimestamp(l) + storage.Timestamp(r), true
+			}
+		}
+	case storage.Timestamp:
+		switch r := rhs.(type) {
+		case storage.Interval:
+			return l.AddInterval(sign(r)), true
+		case storage.Date, storage.Timestamp:
+			if !plus {
+				return l.Sub(toTimestamp(r)), true
+			}
+		}
+	case storage.Time:
+		switch r := rhs.(type) {
+		case storage.Interval:
+			return l.Add(sign(r)), true
+		case storage.Time:
+			if !plus {
+				return storage.Interval{Micros: int64(l) - int64(r)}, true
+			}
+		}
+	case storage.Interval:
+		if r, ok := rhs.(storage.Interval); ok {
+			return l.Add(sign(r)), true
+		}
+	}
+	return nil, false
+}
+
+// extractField returns the part field of date, time, timestamp or interval
+// val. Seconds and epochs are decimals with microsecond precision, the other
+// parts are integers. A date is treated as midnight at its start.
+func extractField(field string, val interface{}) (interface{}, error) {
+	var micros int64
+	var t time.Time
+	switch v := val.(type) {
+	case storage.Date:
+		t = v.Time()
+		micros = int64(toTimestamp(v))
+	case storage.Timestamp:
+		t = v.Time()
+		micros = int64(v)
+	case storage.Time:
+		switch field {
+		case "HOUR", "MINUTE", "SECOND", "EPOCH":
+		default:
+			return nil, fmt.Errorf("%w: %s of time", ErrInvalidFunctionArg, field)
+		}
+		t = time.UnixMicro(int64(v)).UTC()
+		micros = int64(v)
+	case storage.Interval:
+		return extractInterval(field, v)
+	default:
+		return nil, fmt.Errorf("%w: cannot extract %s from %v", ErrInvalidFunctionArg, field, val)
+	}
+
+	switch field {
+	case "YEAR":
+		return int64(t.Year()), nil
+	case "QUARTER":
+		return int64(t.Month()-1)/3 + 1, nil
+	case "MONTH":
+		return int64(t.Month()), nil
+	case "WEEK":
+		_, week := t.ISOWeek()
+		return int64(week), nil
+	case "DAY":
+		return int64(t.Day()), nil
+	case "DOW":
+		return int64(t.Weekday()), nil
+	case "DOY":
+		return int64(t.YearDay()), nil
+	case "HOUR":
+		return int64(t.Hour()), nil
+	case "MINUTE":
+		return int64(t.Minute()), nil
+	case "SECOND":
+		return storage.NewDecimal(int64(t.Second())*1e6+int64(t.Nanosecond())/1000, 6), nil
+	case "EPOCH":
+		return storage.NewDecimal(micros, 6), nil
+	}
+	return nil, fmt.Errorf("%w: unknown field %s", ErrInvalidFunctionArg, field)
+}
+
+// extractInterval returns the part field of interval iv.
+func extractInterval(field string, iv storage.Interval) (interface{}, error) {
+	const microsPerSecond = int64(time.Second / time.Microsecond)
+	switch field {
+	case "YEAR":
+		return int64(iv.Months / 12), nil
+	case "MONTH":
+		return int64(iv.Months % 12), nil
+	case "DAY":
+		return int64(iv.Days), nil
+	case "HOUR":
+		return iv.Micros / (3600 * microsPerSecond), nil
+	case "MINUTE":
+		return iv.Micros / (60 * microsPerSecond) % 60, nil
+	case "SECOND":
+		return storage.NewDecimal(iv.Micros%(60*microsPerSecond), 6), nil
+	case "EPOCH":
+		days, micros := iv.Length()
+		return storage.NewDecimal(days*24*3600*microsPerSecond+micros, 6), nil
+	}
+	return nil, fmt.Errorf("%w: %s of interval", ErrInvalidFunctionArg, field)
+}
+
+// dateTrunc returns date or timestamp val truncated to the start of the
+// field it falls in, e.g. the first day of its month for MONTH. The result
+// has the type of val.
+func dateTrunc(field string, val interface{}) (interface{}, error) {
+	var t time.Time
+	switch v := val.(type) {
+	case storage.Date:
+		t = v.Time()
+	case storage.Timestamp:
+		t = v.Time()
+	default:
+		return nil, fmt.Errorf("%w: cannot truncate %v", ErrInvalidFunctionArg, val)
+	}
+
+	y, m, d := t.Date()
+	switch strings.ToUpper(field) {
+	case "YEAR":
+		t = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	case "QUARTER":
+		t = time.Date(y, (m-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+	case "MONTH":
+		t = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	case "WEEK":
+		// weeks start on Monday
+		t = time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, time.UTC)
+	case "DAY":
+		t = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	case "HOUR":
+		t = t.Truncate(time.Hour)
+	case "MINUTE":
+		t = t.Truncate(time.Minute)
+	case "SECOND":
+		t = t.Truncate(time.Second)
+	default:
+		return nil, fmt.Errorf("%w: unknown field %s", ErrInvalidFunctionArg, field)
+	}
+
+	if _, ok := val.(storage.Date); ok {
+		return storage.DateFromTime(t), nil
+	}
+	return storage.TimestampFromTime(t), nil
+}
+
+// temporalKey returns the same string for temporal values that compare as
+// equal, e.g. a date and a timestamp at midnight at its start.
+func temporalKey(val interface{}) string {
+	switch v := val.(type) {
+	case storage.Date, storage.Timestamp:
+		return "t" + strconv.FormatInt(int64(toTimestamp(v)), 10)
+	case storage.Time:
+		return "c" + strconv.FormatInt(int64(v), 10)
+	}
+	days, micros := val.(storage.Interval).Length()
+	return "i" + strconv.FormatInt(days, 10) + "." + strconv.FormatInt(micros, 10)
+}
+
+// nowValue takes the place of the NOW() calls of a statement. It holds the
+// time that the statement started, so that every row the statement reads or
+// writes sees the same time.
+type nowValue storage.Timestamp
+
+// statementTime returns the value of the NOW() calls of a statement that
+// starts now.
+func statementTime() nowValue {
+	return nowValue(storage.TimestampFromTime(time.Now()))
+}
+
+// bindNowInSelect returns a copy of query q in which the NOW() calls are
+// replaced by now.
+func bindNowInSelect(q sql.Select, now nowValue) sql.Select {
+	selectList := make(sql.SelectList, len(q.SelectList))
+	for i, dc := range q.SelectList {
+		dc.ValueExpressionPrimary = bindNowInExpr(dc.ValueExpressionPrimary, now)
+		selectList[i] = dc
+	}
+	q.SelectList = selectList
+	from := make(sql.FromClause, len(q.FromClause))
+	for i, tf := range q.FromClause {
+		from[i] = bindNowInTableRef(tf, now)
+	}
+	q.FromClause = from
+	q.WhereClause = bindNowInExpr(q.WhereClause, now)
+	return q
+}
+
+// bindNowInTableRef returns a copy of table reference tf in which the NOW()
+// calls of the join conditions are replaced by now.
+func bindNowInTableRef(tf sql.TableReference, now nowValue) sql.TableReference {
+	if join, ok := tf.(sql.QualifiedJoin); ok {
+		join.LHS = bindNowInTableRef(join.LHS, now)
+		join.RHS = bindNowInTableRef(join.RHS, now)
+		join.JoinCondition = bindNowInExpr(join.JoinCondition, now)
+		return join
+	}
+	return tf
+}
+
+// bindNowInExpr returns a copy of expr in which the NOW() calls are
+// replaced by now.
+func bindNowInExpr(expr interface{}, now nowValue) interface{} {
+	switch v := expr.(type) {
+	case sql.WhereClause:
+		v.SearchCondition = bindNowInExpr(v.SearchCondition, now)
+		return v
+	case sql.SearchCondition:
+		v.LHS = bindNowInExpr(v.LHS, now)
+		v.RHS = bindNowInExpr(v.RHS, now)
+		return v
+	case sql.BooleanTerm:
+		v.LHS = bindNowInExpr(v.LHS, now)
+		v.RHS = bindNowInExpr(v.RHS, now)
+		return v
+	case sql.Predicate:
+		v.LHS = bindNowInExpr(v.LHS, now)
+		v.RHS = bindNowInExpr(v.RHS, now)
+		return v
+	case sql.ArithmeticExpression:
+		v.LHS = bindNowInExpr(v.LHS, now)
+		v.RHS = bindNowInExpr(v.RHS, now)
+		return v
+	case sql.Extract:
+		v.Source = bindNowInExpr(v.Source, now)
+		return v
+	case sql.Count:
+		v.ValueExpression = bindNowInExpr(v.ValueExpression, now)
+		return v
+	case sql.Average:
+		v.ValueExpression = bindNowInExpr(v.ValueExpression, now)
+		return v
+	case sql.Sum:
+		v.ValueExpression = bindNowInExpr(v.ValueExpression, now)
+		return v
+	case sql.Min:
+		v.ValueExpression = bindNowInExpr(v.ValueExpression, now)
+		return v
+	case sql.Max:
+		v.ValueExpression = bindNowInExpr(v.ValueExpression, now)
+		return v
+	case sql.FunctionCall:
+		if v.Name == "NOW" && len(v.Args) == 0 {
+			return now
+		}
+		args := make([]sql.ValueExpression, len(v.Args))
+		for i, arg := range v.Args {
+			args[i] = bindNowInExpr(arg, now)
+		}
+		v.Args = args
+		return v
+	}
+	return expr
+}
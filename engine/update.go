@@ -1,55 +1,58 @@
 package engine
 
 import (
-	"fmt"
-
 	"github.com/mk6i/mkdb/sql"
-	"github.com/mk6i/mkdb/storage"
 )
 
-func EvaluateUpdate(q sql.UpdateStatementSearched, rm RelationManager) error {
-	rm.StartTxn()
+func EvaluateUpdate(q sql.UpdateStatementSearched, rm RelationManager) (count int, err error) {
+	if err := rm.StartTxn(); err != nil {
+		return 0, err
+	}
 	defer rm.EndTxn()
+	defer abortOnError(rm, &err)
 
-	for _, set := range q.Set {
-		if _, ok := set.UpdateSource.(sql.ColumnReference); ok {
-			return fmt.Errorf("%w: can't set field value from another field", ErrTmpUnsupportedSyntax)
-		}
-	}
+	now := statementTime()
+	q.Where = bindNowInExpr(q.Where, now)
 
 	table := q.TableName
-	rows, fields, err := rm.Fetch(table)
+	rows, fields, err := fetchTable(rm, table, table, q.Where)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if q.Where != nil {
 		rows, err = filterRows(q.Where.(sql.WhereClause), fields, rows)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	}
 
 	var cols []string
-	var updateSrc []interface{}
-
+	var srcs []interface{}
 	for _, set := range q.Set {
 		cols = append(cols, set.ObjectColumn)
-		updateSrc = append(updateSrc, set.UpdateSource)
+		srcs = append(srcs, bindNowInExpr(set.UpdateSource, now))
 	}
 
-	var batch storage.WALBatch
+	w := newRowWriter(rm, now)
 	for _, row := range rows {
-		walEntries, err := rm.Update(q.TableName, row.RowID, cols, updateSrc)
-		if err != nil {
-			return err
+		// the new values are computed from the row's current values
+		vals := make([]interface{}, len(q.Set))
+		for i, src := range srcs {
+			val, err := evalPrimary(src, fields, row)
+			if err != nil {
+				return 0, err
+			}
+			vals[i] = val
+		}
+		if err := w.update(q.TableName, row, fields, cols, vals); err != nil {
+			return 0, err
 		}
-		batch = append(batch, walEntries...)
 	}
 
-	if err := rm.FlushWALBatch(batch); err != nil {
-		return err
+	if err := rm.FlushWALBatch(w.batch); err != nil {
+		return 0, err
 	}
 
-	return nil
+	return len(rows), nil
 }
@@ -0,0 +1,547 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/mk6i/mkdb/engine"
+	"github.com/mk6i/mkdb/sql"
+	"github.com/mk6i/mkdb/storage"
+)
+
+// This file implements the subset of the PostgreSQL v3 frontend/backend
+// protocol needed by psql and drivers that use the simple query flow:
+// startup without authentication, Query, and Terminate. Results are always
+// sent in text format. See
+// https://www.postgresql.org/docs/current/protocol.html.
+
+const (
+	pgProtocolVersion  = 196608 // 3.0
+	pgSSLRequest       = 80877103
+	pgGSSENCRequest    = 80877104
+	pgCancelRequest    = 80877102
+	pgMaxStartupSize   = 10000
+	pgMaxMessageSize   = 16 << 20
+	pgServerVersion    = "14.0 (mkdb)"
+	pgStatusIdle       = 'I'
+	pgStatusInTxn      = 'T'
+	pgStatusFailedTxn  = 'E'
+	pgSeverityError    = "ERROR"
+	pgSeverityFatal    = "FATAL"
+	pgSeverityNotice   = "NOTICE"
+	pgInternalError    = "XX000"
+	pgProtocolError    = "08P01"
+	pgFeatureNotSupp   = "0A000"
+	pgUndefinedDB      = "3D000"
+	pgOidBool          = 16
+	pgOidBytea         = 17
+	pgOidInt8          = 20
+	pgOidInt4          = 23
+	pgOidText          = 25
+	pgOidFloat4        = 700
+	pgOidFloat8        = 701
+	pgOidVarchar       = 1043
+	pgOidDate          = 1082
+	pgOidTime          = 1083
+	pgOidTimestamp     = 1114
+	pgOidInterval      = 1186
+	pgOidNumeric       = 1700
+	pgFormatText       = 0
+	pgTypeModifierNone = -1
+)
+
+// frontend message types
+const (
+	pgMsgQuery     = 'Q'
+	pgMsgTerminate = 'X'
+	pgMsgSync      = 'S'
+	pgMsgFlush     = 'H'
+	pgMsgParse     = 'P'
+	pgMsgBind      = 'B'
+	pgMsgDescribe  = 'D'
+	pgMsgExecute   = 'E'
+	pgMsgClose     = 'C'
+	pgMsgFunction  = 'F'
+)
+
+// backend message types
+const (
+	pgMsgAuthentication  = 'R'
+	pgMsgParameterStatus = 'S'
+	pgMsgReadyForQuery   = 'Z'
+	pgMsgRowDescription  = 'T'
+	pgMsgDataRow         = 'D'
+	pgMsgCommandComplete = 'C'
+	pgMsgEmptyQuery      = 'I'
+	pgMsgErrorResponse   = 'E'
+	pgMsgNoticeResponse  = 'N'
+)
+
+// pgSQLStates maps errors to the SQLSTATE codes sent with ErrorResponse.
+// Errors that aren't listed are reported as internal errors.
+var pgSQLStates = []struct {
+	err  error
+	code string
+}{
+	{sql.ErrSyntax, "42601"},
+	{sql.ErrUnexpectedToken, "42601"},
+	{sql.ErrTmpUnsupportedSyntax, pgFeatureNotSupp},
+	{sql.ErrAmbiguousGroupByColumn, "42803"},
+	{sql.ErrInvalidGroupByColumn, "42803"},
+	{engine.ErrTmpUnsupportedSyntax, pgFeatureNotSupp},
+	{engine.ErrStmtInTxn, "25001"},
+	{engine.ErrTxnAborted, "25P02"},
+	{engine.ErrUndefinedOperator, "42883"},
+	{engine.ErrUnknownFunction, "42883"},
+	{engine.ErrInvalidFunctionArg, "22023"},
+	{engine.ErrCheckViolation, "23514"},
+	{engine.ErrForeignKeyViolation, "23503"},
+	{storage.ErrColCountMismatch, "42601"},
+	{storage.ErrDBExists, "42P04"},
+	{storage.ErrDBNotExist, pgUndefinedDB},
+	{storage.ErrDBInUse, "55006"},
+	{storage.ErrFieldAmbiguous, "42702"},
+	{storage.ErrFieldNotFound, "42703"},
+	{storage.ErrColumnExists, "42701"},
+	{storage.ErrColumnInUse, "2BP01"},
+	{storage.ErrAlterSystemTable, "42501"},
+	{storage.ErrTableReferenced, "2BP01"},
+	{storage.ErrTableAlreadyExist, "42P07"},
+	{storage.ErrTableNotExist, "42P01"},
+	{storage.ErrTypeMismatch, "42804"},
+	{storage.ErrIntOutOfRange, "22003"},
+	{storage.ErrNumericOutOfRange, "22003"},
+	{storage.ErrInvalidDatetime, "22007"},
+	{storage.ErrIndexAlreadyExist, "42P07"},
+	{storage.ErrIndexNotExist, "42704"},
+	{storage.ErrUniqueViolation, "23505"},
+	{storage.ErrNotNullViolation, "23502"},
+	{storage.ErrTxnInProgress, "25001"},
+	{storage.ErrNoTxnInProgress, "25P01"},
+}
+
+var errPGProtocol = errors.New("postgres protocol violation")
+
+func (s *Server) handlePG(conn net.Conn) (err error) {
+	defer conn.Close()
+
+	sess := &engine.Session{Pool: s.pool}
+	defer func() {
+		if cerr := sess.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	params, err := pgStartup(r, conn)
+	if err != nil || params == nil {
+		return err
+	}
+
+	if err := pgWriteMsg(w, pgMsgAuthentication, pgInt32(0)); err != nil {
+		return err
+	}
+	status := [][2]string{
+		{"server_version", pgServerVersion},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	}
+	for _, p := range status {
+		if err := pgWriteMsg(w, pgMsgParameterStatus, pgCString(p[0]), pgCString(p[1])); err != nil {
+			return err
+		}
+	}
+
+	// psql and most drivers always name a database, defaulting to the user
+	// name. A missing database isn't fatal so that clients can still connect
+	// to create one.
+	if dbName := params["database"]; dbName != "" {
+		if err := sess.Use(dbName); errors.Is(err, storage.ErrDBNotExist) {
+			msg := fmt.Sprintf("database %s does not exist, no database selected", dbName)
+			if err := pgWriteNotice(w, pgUndefinedDB, msg); err != nil {
+				return err
+			}
+		} else if err != nil {
+			pgWriteError(w, pgSeverityFatal, err)
+			return w.Flush()
+		}
+	}
+
+	if err := pgReady(w, sess); err != nil {
+		return err
+	}
+
+	// after an error in an extended query message, the frontend's messages
+	// are discarded until it sends Sync
+	skipUntilSync := false
+
+	for {
+		t, payload, err := pgReadMsg(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if skipUntilSync && t != pgMsgSync {
+			continue
+		}
+
+		switch t {
+		case pgMsgQuery:
+			q := string(bytes.TrimSuffix(payload, []byte{0}))
+			if err := pgQuery(w, sess, q); err != nil {
+				return err
+			}
+			if err := pgReady(w, sess); err != nil {
+				return err
+			}
+		case pgMsgTerminate:
+			return nil
+		case pgMsgSync:
+			skipUntilSync = false
+			if err := pgReady(w, sess); err != nil {
+				return err
+			}
+		case pgMsgFlush:
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case pgMsgParse, pgMsgBind, pgMsgDescribe, pgMsgExecute, pgMsgClose, pgMsgFunction:
+			err := &pgError{code: pgFeatureNotSupp, msg: "extended query protocol is not supported"}
+			if err := pgWriteError(w, pgSeverityError, err); err != nil {
+				return err
+			}
+			skipUntilSync = true
+		default:
+			err := fmt.Errorf("%w: unexpected message type %q", errPGProtocol, t)
+			pgWriteError(w, pgSeverityFatal, &pgError{code: pgProtocolError, msg: err.Error()})
+			w.Flush()
+			return err
+		}
+	}
+}
+
+// pgStartup reads the startup message and returns its parameters. It
+// declines SSL and GSSAPI encryption requests, which clients follow up with
+// a regular startup message. A nil map is returned for cancel requests,
+// which aren't supported.
+func pgStartup(r io.Reader, w io.Writer) (map[string]string, error) {
+	for {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, err
+		}
+		if size < 8 || size > pgMaxStartupSize {
+			return nil, fmt.Errorf("%w: invalid startup message size %d", errPGProtocol, size)
+		}
+		payload := make([]byte, size-4)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+
+		code := binary.BigEndian.Uint32(payload)
+		switch code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if _, err := w.Write([]byte{'N'}); err != nil {
+				return nil, err
+			}
+			continue
+		case pgCancelRequest:
+			return nil, nil
+		case pgProtocolVersion:
+		default:
+			err := &pgError{
+				code: pgFeatureNotSupp,
+				msg:  fmt.Sprintf("unsupported frontend protocol %d.%d", code>>16, code&0xFFFF),
+			}
+			bw := bufio.NewWriter(w)
+			pgWriteError(bw, pgSeverityFatal, err)
+			bw.Flush()
+			return nil, err
+		}
+
+		// the parameters are a list of name/value string pairs followed
+		// by a terminating zero byte
+		params := make(map[string]string)
+		fields := bytes.Split(payload[4:], []byte{0})
+		for i := 0; i+1 < len(fields); i += 2 {
+			if len(fields[i]) == 0 {
+				break
+			}
+			params[string(fields[i])] = string(fields[i+1])
+		}
+		return params, nil
+	}
+}
+
+// pgQuery runs the statements of a simple query message. Execution stops at
+// the first statement that fails.
+func pgQuery(w *bufio.Writer, sess *engine.Session, q string) error {
+	stmts := splitStatements(q)
+	if len(stmts) == 0 {
+		return pgWriteMsg(w, pgMsgEmptyQuery)
+	}
+
+	for _, stmt := range stmts {
+		res, err := sess.Exec(stmt)
+		if err != nil {
+			return pgWriteError(w, pgSeverityError, err)
+		}
+
+		if res.Columns != nil {
+			desc := [][]byte{pgInt16(len(res.Columns))}
+			for _, col := range res.Columns {
+				oid, size := pgType(col.Type)
+				desc = append(desc,
+					pgCString(col.Name),
+					pgInt32(0), // table OID
+					pgInt16(0), // column attribute number
+					pgInt32(oid),
+					pgInt16(size),
+					pgInt32(pgTypeModifierNone),
+					pgInt16(pgFormatText),
+				)
+			}
+			if err := pgWriteMsg(w, pgMsgRowDescription, desc...); err != nil {
+				return err
+			}
+
+			for _, row := range res.Rows {
+				data := [][]byte{pgInt16(len(row))}
+				for i, val := range row {
+					data = append(data, pgTextValue(val, res.Columns[i].Type)...)
+				}
+				if err := pgWriteMsg(w, pgMsgDataRow, data...); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := pgWriteMsg(w, pgMsgCommandComplete, pgCString(pgCommandTag(res))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitStatements splits a query string into its semicolon-separated
+// statements, ignoring semicolons inside quotes.
+func splitStatements(q string) []string {
+	var stmts []string
+	var quote rune
+	begin := 0
+
+	add := func(stmt string) {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	for i, c := range q {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';':
+			add(q[begin:i])
+			begin = i + 1
+		}
+	}
+	add(q[begin:])
+
+	return stmts
+}
+
+func pgType(dt storage.DataType) (oid int, size int) {
+	switch dt {
+	case storage.TypeInt:
+		return pgOidInt4, 4
+	case storage.TypeBigInt:
+		return pgOidInt8, 8
+	case storage.TypeBoolean:
+		return pgOidBool, 1
+	case storage.TypeText:
+		return pgOidText, -1
+	case storage.TypeBlob:
+		return pgOidBytea, -1
+	case storage.TypeReal:
+		return pgOidFloat4, 4
+	case storage.TypeDouble:
+		return pgOidFloat8, 8
+	case storage.TypeDecimal:
+		return pgOidNumeric, -1
+	case storage.TypeDate:
+		return pgOidDate, 4
+	case storage.TypeTime:
+		return pgOidTime, 8
+	case storage.TypeTimestamp:
+		return pgOidTimestamp, 8
+	case storage.TypeInterval:
+		return pgOidInterval, 16
+	default:
+		return pgOidVarchar, -1
+	}
+}
+
+// pgTextValue returns the length-prefixed text representation of val, a
+// value of type dt.
+func pgTextValue(val interface{}, dt storage.DataType) [][]byte {
+	var text string
+	switch val := val.(type) {
+	case nil:
+		return [][]byte{pgInt32(-1)}
+	case bool:
+		text = "f"
+		if val {
+			text = "t"
+		}
+	case string:
+		text = val
+		if dt == storage.TypeBlob {
+			// bytea values are sent in hex format
+			text = `\x` + hex.EncodeToString([]byte(val))
+		}
+	default:
+		text = fmt.Sprintf("%v", val)
+	}
+	return [][]byte{pgInt32(len(text)), []byte(text)}
+}
+
+func pgCommandTag(res *engine.Result) string {
+	switch res.Tag {
+	case "INSERT":
+		// the zero is the OID of the inserted row, which is always zero
+		// for tables without OIDs
+		return fmt.Sprintf("INSERT 0 %d", res.RowsAffected)
+	case "SELECT", "UPDATE", "DELETE":
+		return fmt.Sprintf("%s %d", res.Tag, res.RowsAffected)
+	default:
+		return res.Tag
+	}
+}
+
+func pgReady(w *bufio.Writer, sess *engine.Session) error {
+	status := byte(pgStatusIdle)
+	if sess.TxnAborted() {
+		status = pgStatusFailedTxn
+	} else if sess.InTxn() {
+		status = pgStatusInTxn
+	}
+	if err := pgWriteMsg(w, pgMsgReadyForQuery, []byte{status}); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// pgError is an error with an explicit SQLSTATE code.
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (e *pgError) Error() string {
+	return e.msg
+}
+
+func pgSQLState(err error) string {
+	var pgErr *pgError
+	if errors.As(err, &pgErr) {
+		return pgErr.code
+	}
+	for _, s := range pgSQLStates {
+		if errors.Is(err, s.err) {
+			return s.code
+		}
+	}
+	return pgInternalError
+}
+
+func pgWriteError(w io.Writer, severity string, err error) error {
+	return pgWriteMsg(w, pgMsgErrorResponse, pgNoticeFields(severity, pgSQLState(err), err.Error())...)
+}
+
+func pgWriteNotice(w io.Writer, code string, msg string) error {
+	return pgWriteMsg(w, pgMsgNoticeResponse, pgNoticeFields(pgSeverityNotice, code, msg)...)
+}
+
+// pgNoticeFields returns the body shared by ErrorResponse and
+// NoticeResponse messages.
+func pgNoticeFields(severity string, code string, msg string) [][]byte {
+	return [][]byte{
+		{'S'}, pgCString(severity),
+		{'V'}, pgCString(severity),
+		{'C'}, pgCString(code),
+		{'M'}, pgCString(msg),
+		{0},
+	}
+}
+
+// pgReadMsg reads a regular message, made of a message type byte and an
+// int32 length that counts itself and the payload.
+func pgReadMsg(r io.Reader) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(hdr[1:]))
+	if size < 4 || size > pgMaxMessageSize {
+		return 0, nil, fmt.Errorf("%w: invalid message size %d", errPGProtocol, size)
+	}
+	payload := make([]byte, size-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return hdr[0], payload, nil
+}
+
+func pgWriteMsg(w io.Writer, t byte, parts ...[]byte) error {
+	size := 4
+	for _, p := range parts {
+		size += len(p)
+	}
+	buf := make([]byte, 0, size+1)
+	buf = append(buf, t)
+	buf = append(buf, pgInt32(size)...)
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func pgInt16(v int) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, uint16(v))
+	return b
+}
+
+func pgInt32(v int) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(v))
+	return b
+}
+
+func pgCString(s string) []byte {
+	return append([]byte(s), 0)
+}
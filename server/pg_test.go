@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mk6i/mkdb/storage"
+)
+
+// pgFrontend is a bare-bones PostgreSQL client used to exercise the server.
+type pgFrontend struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+type pgMsg struct {
+	typ     byte
+	payload []byte
+}
+
+func startPGServer(t *testing.T) net.Addr {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New()
+	go srv.ServePG(l)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+	return l.Addr()
+}
+
+func dialPG(t *testing.T, addr net.Addr, params map[string]string) (*pgFrontend, []pgMsg) {
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	fe := &pgFrontend{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	// ask for SSL first like psql does
+	fe.write(append(pgInt32(8), pgInt32(pgSSLRequest)...))
+	if b, err := fe.r.ReadByte(); err != nil || b != 'N' {
+		t.Fatalf("expected SSL to be declined, got %q %v", b, err)
+	}
+
+	startup := pgInt32(pgProtocolVersion)
+	for k, v := range params {
+		startup = append(startup, pgCString(k)...)
+		startup = append(startup, pgCString(v)...)
+	}
+	startup = append(startup, 0)
+	fe.write(append(pgInt32(len(startup)+4), startup...))
+
+	return fe, fe.readUntilReady()
+}
+
+func (fe *pgFrontend) write(b []byte) {
+	if _, err := fe.conn.Write(b); err != nil {
+		fe.t.Fatal(err)
+	}
+}
+
+func (fe *pgFrontend) send(typ byte, payload []byte) {
+	buf := &bytes.Buffer{}
+	if err := pgWriteMsg(buf, typ, payload); err != nil {
+		fe.t.Fatal(err)
+	}
+	fe.write(buf.Bytes())
+}
+
+func (fe *pgFrontend) readUntilReady() []pgMsg {
+	var msgs []pgMsg
+	for {
+		typ, payload, err := pgReadMsg(fe.r)
+		if err != nil {
+			fe.t.Fatal(err)
+		}
+		msgs = append(msgs, pgMsg{typ, payload})
+		if typ == pgMsgReadyForQuery {
+			return msgs
+		}
+	}
+}
+
+func (fe *pgFrontend) query(q string) []pgMsg {
+	fe.send(pgMsgQuery, pgCString(q))
+	return fe.readUntilReady()
+}
+
+func msgTypes(msgs []pgMsg) string {
+	var types []byte
+	for _, msg := range msgs {
+		types = append(types, msg.typ)
+	}
+	return string(types)
+}
+
+// noticeField returns field f of an ErrorResponse or NoticeResponse.
+func noticeField(payload []byte, f byte) string {
+	for _, field := range bytes.Split(payload, []byte{0}) {
+		if len(field) > 0 && field[0] == f {
+			return string(field[1:])
+		}
+	}
+	return ""
+}
+
+// rowDescription returns the column names and type OIDs of a RowDescription.
+func rowDescription(payload []byte) ([]string, []uint32) {
+	var names []string
+	var oids []uint32
+	for payload = payload[2:]; len(payload) > 0; payload = payload[18:] {
+		end := bytes.IndexByte(payload, 0)
+		names = append(names, string(payload[:end]))
+		payload = payload[end+1:]
+		oids = append(oids, binary.BigEndian.Uint32(payload[6:]))
+	}
+	return names, oids
+}
+
+func TestPGSimpleQuery(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	addr := startPGServer(t)
+
+	fe, msgs := dialPG(t, addr, map[string]string{"user": "mkdb", "database": "testdb"})
+
+	// the database doesn't exist yet, so none is selected
+	if types := msgTypes(msgs); types != "RSSSSSSNZ" {
+		t.Fatalf("unexpected startup messages %q", types)
+	}
+	if code := noticeField(msgs[7].payload, 'C'); code != pgUndefinedDB {
+		t.Errorf("expected notice code %s, got %s", pgUndefinedDB, code)
+	}
+
+	msgs = fe.query(`CREATE DATABASE testdb; USE testdb;
+		CREATE TABLE people (person_id int, first_name varchar(255), is_admin boolean);
+		INSERT INTO people VALUES (1, 'John; Doe', true), (2, 'Jane', false)`)
+	if types := msgTypes(msgs); types != "CCCCZ" {
+		t.Fatalf("unexpected messages %q", types)
+	}
+	expected := []string{"CREATE DATABASE", "USE", "CREATE TABLE", "INSERT 0 2"}
+	for i, tag := range expected {
+		if actual := string(bytes.TrimSuffix(msgs[i].payload, []byte{0})); actual != tag {
+			t.Errorf("expected command tag %q, got %q", tag, actual)
+		}
+	}
+
+	msgs = fe.query(`SELECT person_id, first_name, is_admin FROM people ORDER BY person_id`)
+	if types := msgTypes(msgs); types != "TDDCZ" {
+		t.Fatalf("unexpected messages %q", types)
+	}
+
+	names, oids := rowDescription(msgs[0].payload)
+	if expected := []string{"person_id", "first_name", "is_admin"}; !reflect.DeepEqual(expected, names) {
+		t.Errorf("expected columns %v, got %v", expected, names)
+	}
+	if expected := []uint32{pgOidInt4, pgOidVarchar, pgOidBool}; !reflect.DeepEqual(expected, oids) {
+		t.Errorf("expected type OIDs %v, got %v", expected, oids)
+	}
+
+	expectedRow := append(pgInt16(3), bytes.Join([][]byte{
+		pgInt32(1), []byte("1"),
+		pgInt32(9), []byte("John; Doe"),
+		pgInt32(1), []byte("t"),
+	}, nil)...)
+	if !bytes.Equal(expectedRow, msgs[1].payload) {
+		t.Errorf("unexpected data row %q", msgs[1].payload)
+	}
+	if tag := string(msgs[3].payload); tag != "SELECT 2\x00" {
+		t.Errorf("unexpected command tag %q", tag)
+	}
+
+	msgs = fe.query(`SELECT count(*) FROM people`)
+	if _, oids := rowDescription(msgs[0].payload); !reflect.DeepEqual([]uint32{pgOidInt8}, oids) {
+		t.Errorf("expected type OIDs %v, got %v", []uint32{pgOidInt8}, oids)
+	}
+
+	// bytea values are sent in hex format
+	fe.query(`CREATE TABLE docs (body text, data bytea); INSERT INTO docs VALUES ('hi', 'AB')`)
+	msgs = fe.query(`SELECT body, data FROM docs`)
+	if _, oids := rowDescription(msgs[0].payload); !reflect.DeepEqual([]uint32{pgOidText, pgOidBytea}, oids) {
+		t.Errorf("expected type OIDs %v, got %v", []uint32{pgOidText, pgOidBytea}, oids)
+	}
+	expectedRow = append(pgInt16(2), bytes.Join([][]byte{
+		pgInt32(2), []byte("hi"),
+		pgInt32(6), []byte(`\x4142`),
+	}, nil)...)
+	if !bytes.Equal(expectedRow, msgs[1].payload) {
+		t.Errorf("unexpected data row %q", msgs[1].payload)
+	}
+
+	// the transaction status is reported with ReadyForQuery
+	msgs = fe.query(`BEGIN`)
+	if status := msgs[len(msgs)-1].payload[0]; status != pgStatusInTxn {
+		t.Errorf("expected transaction status %q, got %q", pgStatusInTxn, status)
+	}
+	msgs = fe.query(`UPDATE people SET is_admin = true; COMMIT`)
+	if tag := string(msgs[0].payload); tag != "UPDATE 2\x00" {
+		t.Errorf("unexpected command tag %q", tag)
+	}
+	if status := msgs[len(msgs)-1].payload[0]; status != pgStatusIdle {
+		t.Errorf("expected transaction status %q, got %q", pgStatusIdle, status)
+	}
+
+	// a statement that fails inside a transaction aborts it, and the
+	// statements that follow are rejected until it ends
+	fe.query(`BEGIN`)
+	msgs = fe.query(`INSERT INTO people VALUES (3, 'Jane', 'yes')`)
+	if status := msgs[len(msgs)-1].payload[0]; status != pgStatusFailedTxn {
+		t.Errorf("expected transaction status %q, got %q", pgStatusFailedTxn, status)
+	}
+	msgs = fe.query(`SELECT count(*) FROM people`)
+	if code := noticeField(msgs[0].payload, 'C'); code != "25P02" {
+		t.Errorf("expected SQLSTATE 25P02, got %s", code)
+	}
+	msgs = fe.query(`COMMIT`)
+	if tag := string(msgs[0].payload); tag != "ROLLBACK\x00" {
+		t.Errorf("unexpected command tag %q", tag)
+	}
+	if status := msgs[len(msgs)-1].payload[0]; status != pgStatusIdle {
+		t.Errorf("expected transaction status %q, got %q", pgStatusIdle, status)
+	}
+
+	if msgs := fe.query(` ; `); msgTypes(msgs) != "IZ" {
+		t.Errorf("unexpected messages %q", msgTypes(msgs))
+	}
+
+	// a second connection selects the database on startup
+	fe2, msgs := dialPG(t, addr, map[string]string{"user": "mkdb", "database": "testdb"})
+	if types := msgTypes(msgs); types != "RSSSSSSZ" {
+		t.Fatalf("unexpected startup messages %q", types)
+	}
+	if msgs := fe2.query(`SELECT first_name FROM people WHERE person_id = 2`); msgTypes(msgs) != "TDCZ" {
+		t.Errorf("unexpected messages %q", msgTypes(msgs))
+	}
+}
+
+func TestPGErrorResponse(t *testing.T) {
+
+	defer storage.ClearDataDir()
+
+	addr := startPGServer(t)
+
+	fe, _ := dialPG(t, addr, map[string]string{"user": "mkdb"})
+	fe.query(`CREATE DATABASE testdb; USE testdb`)
+
+	// statements after the one that fails are skipped
+	msgs := fe.query(`SELECT * FROM people; CREATE TABLE people (name varchar(255))`)
+	if types := msgTypes(msgs); types != "EZ" {
+		t.Fatalf("unexpected messages %q", types)
+	}
+	if code := noticeField(msgs[0].payload, 'C'); code != "42P01" {
+		t.Errorf("expected SQLSTATE 42P01, got %s", code)
+	}
+	if msg := noticeField(msgs[0].payload, 'M'); msg != storage.ErrTableNotExist.Error() {
+		t.Errorf("unexpected error message %q", msg)
+	}
+
+	msgs = fe.query(`SELEC 1`)
+	if code := noticeField(msgs[0].payload, 'C'); code != "42601" {
+		t.Errorf("expected SQLSTATE 42601, got %s", code)
+	}
+
+	// extended query messages are rejected and skipped until Sync
+	fe.send(pgMsgParse, []byte("\x00SELECT 1\x00\x00\x00"))
+	fe.send(pgMsgBind, []byte("\x00\x00\x00\x00\x00\x00\x00\x00"))
+	fe.send(pgMsgSync, nil)
+	msgs = fe.readUntilReady()
+	if types := msgTypes(msgs); types != "EZ" {
+		t.Fatalf("unexpected messages %q", types)
+	}
+	if code := noticeField(msgs[0].payload, 'C'); code != pgFeatureNotSupp {
+		t.Errorf("expected SQLSTATE %s, got %s", pgFeatureNotSupp, code)
+	}
+
+	// constraint violations and values out of range are reported with their
+	// own SQLSTATEs
+	fe.query(`CREATE TABLE items (id int PRIMARY KEY, qty int NOT NULL CHECK (qty > 0))`)
+	fe.query(`CREATE TABLE orders (id int, item_id int REFERENCES items (id))`)
+	fe.query(`CREATE TABLE prices (amount decimal(4, 2))`)
+	for _, test := range []struct {
+		query string
+		code  string
+	}{
+		{`INSERT INTO items VALUES (1, NULL)`, "23502"},
+		{`INSERT INTO items VALUES (1, 0)`, "23514"},
+		{`INSERT INTO orders VALUES (1, 1)`, "23503"},
+		{`INSERT INTO prices VALUES (123.45)`, "22003"},
+	} {
+		msgs := fe.query(test.query)
+		if code := noticeField(msgs[0].payload, 'C'); code != test.code {
+			t.Errorf("query %s: expected SQLSTATE %s, got %s", test.query, test.code, code)
+		}
+	}
+
+	if msgs := fe.query(`CREATE TABLE people (name varchar(255))`); msgTypes(msgs) != "CZ" {
+		t.Errorf("unexpected messages %q", msgTypes(msgs))
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tbl := []struct {
+		query    string
+		expected []string
+	}{
+		{query: ``, expected: nil},
+		{query: ` ;; `, expected: nil},
+		{query: `SELECT 1`, expected: []string{`SELECT 1`}},
+		{query: `SELECT 1; SELECT 2;`, expected: []string{`SELECT 1`, `SELECT 2`}},
+		{query: `SELECT 'a;b'; SELECT "c;d"`, expected: []string{`SELECT 'a;b'`, `SELECT "c;d"`}},
+		{query: `SELECT 'it''s; fine'`, expected: []string{`SELECT 'it''s; fine'`}},
+	}
+	for _, test := range tbl {
+		if actual := splitStatements(test.query); !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("splitting %q: expected %q, got %q", test.query, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Package server serves mkdb databases to network clients using the protocol
+// described in package wire.
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mk6i/mkdb/engine"
+	"github.com/mk6i/mkdb/storage"
+	"github.com/mk6i/mkdb/wire"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+// Server accepts client connections and gives each one its own session.
+// Sessions that select the same database share it through the server's
+// database pool.
+type Server struct {
+	// ErrorLog logs the errors that end connections and the errors of
+	// auto-vacuum runs. If nil, errors are logged with the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	pool *engine.DBPool
+
+	mtx       sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+
+	stopAutoVacuum func()
+}
+
+func New() *Server {
+	return &Server{
+		pool:      engine.NewDBPool(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on l until l fails or the server is closed.
+// Clients are expected to speak the protocol described in package wire.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, s.handle)
+}
+
+// ServePG accepts connections on l until l fails or the server is closed.
+// Clients are expected to speak the PostgreSQL frontend/backend protocol.
+func (s *Server) ServePG(l net.Listener) error {
+	return s.serve(l, s.handlePG)
+}
+
+func (s *Server) serve(l net.Listener, handle func(net.Conn) error) error {
+	if !s.track(l, nil) {
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(nil, conn)
+			if err := handle(conn); err != nil {
+				s.logf("connection %s: %s", conn.RemoteAddr(), err.Error())
+			}
+		}()
+	}
+}
+
+// AutoVacuum starts vacuuming the tables of the open databases that have
+// many deleted rows every interval until the server is closed.
+func (s *Server) AutoVacuum(interval time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed || s.stopAutoVacuum != nil {
+		return
+	}
+	s.stopAutoVacuum = s.pool.StartAutoVacuum(interval, func(dbName string, err error) {
+		s.logf("auto-vacuum of database %s: %s", dbName, err.Error())
+	})
+}
+
+// Close stops all listeners and connections and waits for the sessions of
+// the closed connections to shut down.
+func (s *Server) Close() error {
+	s.mtx.Lock()
+	s.closed = true
+	stopAutoVacuum := s.stopAutoVacuum
+	s.stopAutoVacuum = nil
+	var err error
+	for l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+
+	if stopAutoVacuum != nil {
+		stopAutoVacuum()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(l net.Listener, conn net.Conn) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+	}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener, conn net.Conn) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if l != nil {
+		delete(s.listeners, l)
+	}
+	if conn != nil {
+		delete(s.conns, conn)
+		s.wg.Done()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) (err error) {
+	defer conn.Close()
+
+	sess := &engine.Session{Pool: s.pool}
+	defer func() {
+		if cerr := sess.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	if err := s.ready(w); err != nil {
+		return err
+	}
+
+	for {
+		t, payload, err := wire.ReadFrame(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		switch t {
+		case wire.MsgQuery:
+			if err := s.query(w, sess, string(payload)); err != nil {
+				return err
+			}
+		case wire.MsgTerminate:
+			return nil
+		default:
+			msg := fmt.Sprintf("unexpected message type %q", byte(t))
+			if err := wire.WriteFrame(w, wire.MsgError, []byte(msg)); err != nil {
+				return err
+			}
+		}
+
+		if err := s.ready(w); err != nil {
+			return err
+		}
+	}
+}
+
+// query runs q in sess and sends its result, or the error it failed with.
+// The rows of the result are sent as they're produced. The returned error is
+// only set if the client can no longer be written to.
+func (s *Server) query(w io.Writer, sess *engine.Session, q string) error {
+	rw := &rowWriter{w: w}
+	res, err := sess.ExecTo(rw, q)
+	if rw.err != nil {
+		return rw.err
+	}
+	if err != nil {
+		return wire.WriteFrame(w, wire.MsgError, []byte(err.Error()))
+	}
+	return wire.WriteFrame(w, wire.MsgComplete, []byte(res.Message))
+}
+
+// rowWriter sends the columns and rows of a query result to a client.
+type rowWriter struct {
+	w io.Writer
+	// err is set once the client can no longer be written to.
+	err error
+}
+
+func (rw *rowWriter) WriteColumns(cols []engine.Column) error {
+	wcols := make([]wire.Column, len(cols))
+	for i, col := range cols {
+		wcols[i] = wire.Column{Name: col.Name, Type: wireType(col.Type)}
+	}
+	payload, err := wire.EncodeColumns(wcols)
+	if err != nil {
+		return err
+	}
+	return rw.write(wire.MsgColumns, payload)
+}
+
+func (rw *rowWriter) WriteRow(vals []interface{}) error {
+	payload, err := wire.EncodeRow(vals)
+	if err != nil {
+		return err
+	}
+	return rw.write(wire.MsgRow, payload)
+}
+
+func (rw *rowWriter) write(t wire.MsgType, payload []byte) error {
+	if err := wire.WriteFrame(rw.w, t, payload); err != nil {
+		rw.err = err
+		return err
+	}
+	return nil
+}
+
+// wireType returns the tag that values of data type dt are sent with.
+func wireType(dt storage.DataType) byte {
+	switch dt {
+	case storage.TypeInt, storage.TypeBigInt:
+		return wire.TypeInt
+	case storage.TypeBoolean:
+		return wire.TypeBool
+	case storage.TypeReal, storage.TypeDouble:
+		return wire.TypeFloat
+	case storage.TypeDecimal:
+		return wire.TypeDecimal
+	case storage.TypeDate:
+		return wire.TypeDate
+	case storage.TypeTime:
+		return wire.TypeTime
+	case storage.TypeTimestamp:
+		return wire.TypeTimestamp
+	case storage.TypeInterval:
+		return wire.TypeInterval
+	default:
+		return wire.TypeString
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) ready(w *bufio.Writer) error {
+	if err := wire.WriteFrame(w, wire.MsgReady, nil); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mk6i/mkdb/wire"
+)
+
+func TestErrorLog(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	srv := New()
+	srv.ErrorLog = log.New(buf, "", 0)
+	go srv.Serve(l)
+
+	conn, err := net.Dial(l.Addr().Network(), l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// a frame that's too large ends the connection
+	if _, err := conn.Write([]byte{byte(wire.MsgQuery), 0xFF, 0xFF, 0xFF, 0xFF}); err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, conn)
+
+	// the connection's error is logged before Close returns
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), wire.ErrFrameTooLarge.Error()) {
+		t.Errorf("expected %q to be logged, got %q", wire.ErrFrameTooLarge, buf.String())
+	}
+}
@@ -18,8 +18,11 @@ const (
 var (
 	ErrAmbiguousGroupByColumn = errors.New("group by column is ambiguous")
 	ErrInvalidGroupByColumn   = errors.New("cannot include column in result set without grouping or aggregation")
+	ErrInvalidNumericType     = errors.New("invalid numeric type")
 	ErrNegativeLimit          = errors.New("LIMIT clause can not be negative")
 	ErrNegativeOffset         = errors.New("OFFSET clause can not be negative")
+	ErrParamCount             = errors.New("parameter count does not match value count")
+	ErrParamType              = errors.New("unsupported parameter value type")
 	ErrSyntax                 = errors.New("syntax error")
 	ErrTmpUnsupportedSyntax   = errors.New("temporarily unsupported syntax")
 	ErrUnexpectedToken        = errors.New("unexpected token")
@@ -49,6 +52,10 @@ type TableExpression struct {
 type SortSpecification struct {
 	SortKey               ColumnReference
 	OrderingSpecification Token
+	// NullOrdering is a FIRST or LAST token if NULLS FIRST or NULLS LAST was
+	// given. Otherwise, nulls sort as if they were larger than any other
+	// value.
+	NullOrdering Token
 }
 
 type LimitOffsetClause struct {
@@ -127,7 +134,8 @@ func (d DerivedColumn) Matches(rhs ColumnReference) bool {
 	return false
 }
 
-// ValueExpressionPrimary is one of ColumnReference or Count or Avg
+// ValueExpressionPrimary is one of ColumnReference, a set function such as
+// Count or Sum, or a ValueExpression
 type ValueExpressionPrimary any
 
 type Pattern string
@@ -135,10 +143,8 @@ type SelectList []DerivedColumn
 
 func (s SelectList) HasAggrFunc() bool {
 	for _, selectCol := range s {
-		if _, isAggr := selectCol.ValueExpressionPrimary.(Count); isAggr {
-			return true
-		}
-		if _, isAggr := selectCol.ValueExpressionPrimary.(Average); isAggr {
+		switch selectCol.ValueExpressionPrimary.(type) {
+		case Count, Average, Sum, Min, Max:
 			return true
 		}
 	}
@@ -174,7 +180,7 @@ type SearchCondition struct {
 }
 
 type BooleanTerm struct {
-	LHS Predicate
+	LHS interface{}
 	RHS interface{}
 }
 
@@ -182,15 +188,42 @@ type Predicate struct {
 	ComparisonPredicate
 }
 
+// ComparisonPredicate compares two values. `x IS NULL` and `x IS NOT NULL`
+// have CompOp IS and IS_NOT and a nil RHS.
 type ComparisonPredicate struct {
 	LHS    interface{}
 	CompOp TokenType
 	RHS    interface{}
 }
 
+// ArithmeticExpression adds or subtracts two values. Op is PLUS or MINUS.
+type ArithmeticExpression struct {
+	LHS ValueExpression
+	Op  TokenType
+	RHS ValueExpression
+}
+
+// Extract is EXTRACT(field FROM source), which returns a part of a date,
+// time, timestamp or interval. Field is the upper-cased name of the part,
+// e.g. YEAR.
+type Extract struct {
+	Field  string
+	Source ValueExpression
+}
+
+// FunctionCall calls the scalar function named Name, upper-cased, with
+// arguments Args, e.g. NOW() or DATE_TRUNC('day', col).
+type FunctionCall struct {
+	Name string
+	Args []ValueExpression
+}
+
 type CreateTable struct {
 	Name     string
 	Elements []TableElement
+	// Constraints holds the constraints declared apart from the column
+	// definitions
+	Constraints []TableConstraint
 }
 
 type TableElement struct {
@@ -200,6 +233,75 @@ type TableElement struct {
 type ColumnDefinition struct {
 	DataType interface{}
 	Name     string
+	NotNull  bool
+	// Default is the value of the column when an INSERT leaves it out
+	Default    interface{}
+	Unique     bool
+	PrimaryKey bool
+	// Check is a search condition that the column's values have to satisfy
+	Check interface{}
+	// References is set if the column is a foreign key
+	References *References
+}
+
+// TableConstraint is a PRIMARY KEY, UNIQUE, CHECK or FOREIGN KEY constraint
+// declared apart from the column definitions. A CHECK constraint has a search
+// condition instead of columns.
+type TableConstraint struct {
+	Type       TokenType
+	Columns    []string
+	Check      interface{}
+	References *References
+}
+
+// References is the parent table of a foreign key. Columns is empty if the
+// foreign key refers to the parent's primary key. OnDelete and OnUpdate are
+// the referential actions CASCADE, RESTRICT, NULL (for SET NULL) or NO (for
+// NO ACTION, the default).
+type References struct {
+	TableName string
+	Columns   []string
+	OnDelete  TokenType
+	OnUpdate  TokenType
+}
+
+type CreateIndex struct {
+	Name      string
+	TableName string
+	Columns   []string
+	Unique    bool
+}
+
+type DropIndex struct {
+	Name string
+}
+
+type DropTable struct {
+	Name string
+}
+
+type DropDatabase struct {
+	Name string
+	// IfExists is true if a database that doesn't exist is ignored
+	IfExists bool
+}
+
+type TruncateTable struct {
+	Name string
+}
+
+// AlterTable changes the schema of a table.
+type AlterTable struct {
+	TableName string
+	// Action is ADD, DROP or RENAME
+	Action TokenType
+	// Column is the dropped or renamed column. It's empty if the table is
+	// renamed.
+	Column string
+	// NewName is the new name of the renamed column or table
+	NewName string
+	// ColumnDefinition defines the added column
+	ColumnDefinition ColumnDefinition
 }
 
 type BooleanType struct {
@@ -213,15 +315,76 @@ type CharacterStringType struct {
 type NumericType struct {
 }
 
+// TextType is a string of any length.
+type TextType struct {
+}
+
+// BlobType is a string of bytes of any length, declared as BLOB or BYTEA.
+type BlobType struct {
+}
+
 type BigIntType struct {
 }
 
+// RealType is a single-precision floating-point number.
+type RealType struct {
+}
+
+// DoubleType is a double-precision floating-point number, declared as
+// DOUBLE PRECISION.
+type DoubleType struct {
+}
+
+// DecimalType is an exact number of up to Precision digits, Scale of which
+// follow the decimal point. It's declared as DECIMAL(p, s) or NUMERIC(p, s).
+// Precision is 0 if the declaration doesn't give one.
+type DecimalType struct {
+	Precision int64
+	Scale     int64
+}
+
+// DateType is a calendar date.
+type DateType struct {
+}
+
+// TimeType is a time of day.
+type TimeType struct {
+}
+
+// TimestampType is a date and time of day.
+type TimestampType struct {
+}
+
+// IntervalType is a span of time.
+type IntervalType struct {
+}
+
+// DecimalLiteral is the text of an exact number such as 1.50. The engine
+// converts it to a decimal value.
+type DecimalLiteral string
+
+// TypedLiteral is a string typed by a keyword, such as DATE '2026-01-01' or
+// INTERVAL '1 day'. Type is T_DATE, T_TIME, T_TIMESTAMP or INTERVAL, and
+// Negative is set for an interval preceded by a minus sign. The engine
+// converts it to a value of the type it names.
+type TypedLiteral struct {
+	Type     TokenType
+	Text     string
+	Negative bool
+}
+
 type Parser struct {
 	TokenList
 }
 
 type ShowDatabase struct{}
 
+// ShowStats lists the statistics computed by ANALYZE for table TableName, or
+// for all tables if TableName is empty.
+type ShowStats struct {
+	TableName string
+}
+
 type CreateDatabase struct {
 	Name string
 }
@@ -263,17 +426,63 @@ type UseStatement struct {
 	DBName string
 }
 
+type BeginStatement struct{}
+
+type CommitStatement struct{}
+
+type RollbackStatement struct{}
+
+type CheckpointStatement struct{}
+
+// AnalyzeStatement computes the statistics of table TableName, or of all
+// tables if TableName is empty.
+type AnalyzeStatement struct {
+	TableName string
+}
+
+// VacuumStatement removes the deleted rows of table TableName, or of all
+// tables if TableName is empty.
+type VacuumStatement struct {
+	TableName string
+}
+
+// ExplainStatement asks for the plan of a query instead of its results.
+type ExplainStatement struct {
+	Select Select
+}
+
 type DeleteStatementSearched struct {
 	TableName   string
 	WhereClause interface{}
 }
 
+// Count, Average, Sum, Min and Max are the set functions. They aggregate
+// the non-null values of ValueExpression, or only the distinct ones if
+// Distinct is set. A Count without a ValueExpression is count(*), which
+// counts rows.
 type Count struct {
 	ValueExpression
+	Distinct bool
 }
 
 type Average struct {
 	ValueExpression
+	Distinct bool
+}
+
+type Sum struct {
+	ValueExpression
+	Distinct bool
+}
+
+type Min struct {
+	ValueExpression
+	Distinct bool
+}
+
+type Max struct {
+	ValueExpression
+	Distinct bool
 }
 
 type Asterisk struct{}
@@ -296,6 +505,26 @@ func (p *Parser) Parse() (interface{}, error) {
 		return p.Delete()
 	case SHOW:
 		return p.Show()
+	case DROP:
+		return p.Drop()
+	case TRUNCATE:
+		return p.TruncateTable()
+	case ALTER:
+		return p.AlterTable()
+	case BEGIN:
+		return p.Begin()
+	case COMMIT:
+		return p.Commit()
+	case ROLLBACK:
+		return p.Rollback()
+	case CHECKPOINT:
+		return CheckpointStatement{}, nil
+	case EXPLAIN:
+		return p.Explain()
+	case ANALYZE:
+		return p.Analyze()
+	case VACUUM:
+		return p.Vacuum()
 	default:
 		return nil, syntaxErr(cur)
 	}
@@ -308,13 +537,24 @@ func (p *Parser) Show() (interface{}, error) {
 	case DATABASE:
 		return p.ShowDatabase()
 	case IDENT:
-		if strings.ToLower(cur.Text) == "databases" {
+		switch strings.ToLower(cur.Text) {
+		case "databases":
 			return p.ShowDatabase()
+		case "stats":
+			return p.ShowStats()
 		}
 	}
 	return nil, syntaxErr(cur)
 }
 
+func (p *Parser) ShowStats() (ShowStats, error) {
+	ss := ShowStats{}
+	if p.match(IDENT) {
+		ss.TableName = p.Prev().Text
+	}
+	return ss, nil
+}
+
 func (p *Parser) ShowDatabase() (ShowDatabase, error) {
 	return ShowDatabase{}, nil
 }
@@ -327,11 +567,170 @@ func (p *Parser) Create() (interface{}, error) {
 		return p.CreateDatabase()
 	case TABLE:
 		return p.CreateTable()
+	case UNIQUE:
+		if err := p.requireMatch(INDEX); err != nil {
+			return nil, err
+		}
+		ci, err := p.CreateIndex()
+		ci.Unique = true
+		return ci, err
+	case INDEX:
+		return p.CreateIndex()
+	default:
+		return nil, syntaxErr(cur)
+	}
+}
+
+func (p *Parser) CreateIndex() (CreateIndex, error) {
+	ci := CreateIndex{}
+
+	if err := p.requireMatch(IDENT); err != nil {
+		return ci, err
+	}
+	ci.Name = p.Prev().Text
+
+	if err := p.requireMatch(ON); err != nil {
+		return ci, err
+	}
+
+	if err := p.requireMatch(IDENT); err != nil {
+		return ci, err
+	}
+	ci.TableName = p.Prev().Text
+
+	if err := p.requireMatch(LPAREN); err != nil {
+		return ci, err
+	}
+
+	for {
+		if err := p.requireMatch(IDENT); err != nil {
+			return ci, err
+		}
+		ci.Columns = append(ci.Columns, p.Prev().Text)
+		if !p.match(COMMA) {
+			break
+		}
+	}
+
+	if err := p.requireMatch(RPAREN); err != nil {
+		return ci, err
+	}
+
+	return ci, nil
+}
+
+func (p *Parser) Drop() (interface{}, error) {
+	cur := p.Cur()
+	p.Advance()
+	switch cur.Type {
+	case INDEX:
+		return p.DropIndex()
+	case TABLE:
+		return p.DropTable()
+	case DATABASE:
+		return p.DropDatabase()
 	default:
 		return nil, syntaxErr(cur)
 	}
 }
 
+func (p *Parser) AlterTable() (AlterTable, error) {
+	at := AlterTable{}
+
+	if err := p.requireMatch(TABLE); err != nil {
+		return at, err
+	}
+	if err := p.requireMatch(IDENT); err != nil {
+		return at, err
+	}
+	at.TableName = p.Prev().Text
+
+	cur := p.Cur()
+	p.Advance()
+	at.Action = cur.Type
+
+	switch cur.Type {
+	case ADD:
+		p.match(COLUMN)
+		if err := p.requireMatch(IDENT); err != nil {
+			return at, err
+		}
+		te, err := p.ColumnDefinition()
+		if err != nil {
+			return at, err
+		}
+		at.ColumnDefinition = te.ColumnDefinition
+	case DROP:
+		p.match(COLUMN)
+		if err := p.requireMatch(IDENT); err != nil {
+			return at, err
+		}
+		at.Column = p.Prev().Text
+	case RENAME:
+		if !p.match(TO) {
+			p.match(COLUMN)
+			if err := p.requireMatch(IDENT); err != nil {
+				return at, err
+			}
+			at.Column = p.Prev().Text
+			if err := p.requireMatch(TO); err != nil {
+				return at, err
+			}
+		}
+		if err := p.requireMatch(IDENT); err != nil {
+			return at, err
+		}
+		at.NewName = p.Prev().Text
+	default:
+		return at, syntaxErr(cur)
+	}
+
+	return at, nil
+}
+
+func (p *Parser) DropIndex() (DropIndex, error) {
+	di := DropIndex{}
+	if err := p.requireMatch(IDENT); err != nil {
+		return di, err
+	}
+	di.Name = p.Prev().Text
+	return di, nil
+}
+
+func (p *Parser) DropTable() (DropTable, error) {
+	dt := DropTable{}
+	if err := p.requireMatch(IDENT); err != nil {
+		return dt, err
+	}
+	dt.Name = p.Prev().Text
+	return dt, nil
+}
+
+func (p *Parser) DropDatabase() (DropDatabase, error) {
+	dd := DropDatabase{}
+	if p.match(IF) {
+		if err := p.requireMatch(EXISTS); err != nil {
+			return dd, err
+		}
+		dd.IfExists = true
+	}
+	if err := p.requireMatch(IDENT); err != nil {
+		return dd, err
+	}
+	dd.Name = p.Prev().Text
+	return dd, nil
+}
+
+func (p *Parser) TruncateTable() (TruncateTable, error) {
+	tt := TruncateTable{}
+	p.match(TABLE)
+	if err := p.requireMatch(IDENT); err != nil {
+		return tt, err
+	}
+	tt.Name = p.Prev().Text
+	return tt, nil
+}
+
 func (p *Parser) CreateDatabase() (CreateDatabase, error) {
 	cd := CreateDatabase{}
 	if err := p.requireMatch(IDENT); err != nil {
@@ -349,7 +748,7 @@ func (p *Parser) CreateTable() (CreateTable, error) {
 		ct.Name = p.Prev().Text
 	}
 
-	ct.Elements, err = p.TableElements()
+	ct.Elements, ct.Constraints, err = p.TableElements()
 	if err != nil {
 		return ct, err
 	}
@@ -357,64 +756,273 @@ func (p *Parser) CreateTable() (CreateTable, error) {
 	return ct, nil
 }
 
-func (p *Parser) TableElements() ([]TableElement, error) {
+func (p *Parser) TableElements() ([]TableElement, []TableConstraint, error) {
 
 	var ret []TableElement
+	var constraints []TableConstraint
 
 	if err := p.requireMatch(LPAREN); err != nil {
-		return ret, err
+		return ret, constraints, err
 	}
 
-	for p.match(IDENT) {
+	for {
+		if p.curType(PRIMARY, UNIQUE, CHECK, FOREIGN) {
+			tc, err := p.TableConstraint()
+			if err != nil {
+				return ret, constraints, err
+			}
+			constraints = append(constraints, tc)
+		} else {
+			if !p.match(IDENT) {
+				break
+			}
+			te, err := p.ColumnDefinition()
+			if err != nil {
+				return ret, constraints, err
+			}
+			ret = append(ret, te)
+		}
 
-		te := TableElement{
-			ColumnDefinition: ColumnDefinition{
-				Name: p.Prev().Text,
-			},
+		if !p.match(COMMA) {
+			break
 		}
+	}
 
-		cur := p.Cur()
-		p.Advance()
+	if err := p.requireMatch(RPAREN); err != nil {
+		return ret, constraints, err
+	}
+
+	return ret, constraints, nil
+}
+
+// ColumnDefinition parses the data type and constraints of a column whose
+// name was just matched.
+func (p *Parser) ColumnDefinition() (TableElement, error) {
+	te := TableElement{
+		ColumnDefinition: ColumnDefinition{
+			Name: p.Prev().Text,
+		},
+	}
+
+	cur := p.Cur()
+	p.Advance()
+
+	switch cur.Type {
+	case T_INT:
+		te.ColumnDefinition.DataType = NumericType{}
+	case T_BIGINT:
+		te.ColumnDefinition.DataType = BigIntType{}
+	case T_VARCHAR:
+		cst := CharacterStringType{
+			Type: cur.Type,
+		}
+		if err := p.requireMatch(LPAREN); err != nil {
+			return te, err
+		}
+		if intVal, err := p.requireInt(); err != nil {
+			return te, err
+		} else {
+			cst.Len = intVal
+		}
+		if err := p.requireMatch(RPAREN); err != nil {
+			return te, err
+		}
+		te.ColumnDefinition.DataType = cst
+	case T_TEXT:
+		te.ColumnDefinition.DataType = TextType{}
+	case T_BLOB, T_BYTEA:
+		te.ColumnDefinition.DataType = BlobType{}
+	case T_BOOL:
+		te.ColumnDefinition.DataType = BooleanType{}
+	case T_REAL:
+		te.ColumnDefinition.DataType = RealType{}
+	case T_DOUBLE:
+		if err := p.requireMatch(PRECISION); err != nil {
+			return te, err
+		}
+		te.ColumnDefinition.DataType = DoubleType{}
+	case T_DECIMAL, T_NUMERIC:
+		dt, err := p.decimalType()
+		if err != nil {
+			return te, err
+		}
+		te.ColumnDefinition.DataType = dt
+	case T_DATE:
+		te.ColumnDefinition.DataType = DateType{}
+	case T_TIME:
+		te.ColumnDefinition.DataType = TimeType{}
+	case T_TIMESTAMP:
+		te.ColumnDefinition.DataType = TimestampType{}
+	case INTERVAL:
+		te.ColumnDefinition.DataType = IntervalType{}
+	default:
+		return te, syntaxErr(cur)
+	}
 
-		switch cur.Type {
-		case T_INT:
-			te.ColumnDefinition.DataType = NumericType{}
-		case T_BIGINT:
-			te.ColumnDefinition.DataType = BigIntType{}
-		case T_VARCHAR:
-			cst := CharacterStringType{
-				Type: cur.Type,
+	cd := &te.ColumnDefinition
+	for {
+		switch {
+		case p.match(NOT):
+			if err := p.requireMatch(NULL); err != nil {
+				return te, err
+			}
+			cd.NotNull = true
+		case p.match(NULL):
+			cd.NotNull = false
+		case p.match(DEFAULT):
+			found, val, err := p.literal()
+			if err != nil {
+				return te, err
 			}
-			if err := p.requireMatch(LPAREN); err != nil {
-				return ret, err
+			if !found {
+				return te, p.unexpectedTypeErr(literals...)
+			}
+			cd.Default = val
+		case p.match(UNIQUE):
+			cd.Unique = true
+		case p.match(PRIMARY):
+			if err := p.requireMatch(KEY); err != nil {
+				return te, err
+			}
+			cd.PrimaryKey = true
+		case p.match(CHECK):
+			cond, err := p.checkCondition()
+			if err != nil {
+				return te, err
 			}
-			if intVal, err := p.requireInt(); err != nil {
-				return ret, err
-			} else {
-				cst.Len = intVal
+			cd.Check = cond
+		case p.match(REFERENCES):
+			ref, err := p.References()
+			if err != nil {
+				return te, err
+			}
+			cd.References = ref
+		default:
+			return te, nil
+		}
+	}
+}
+
+func (p *Parser) TableConstraint() (TableConstraint, error) {
+	tc := TableConstraint{Type: p.Cur().Type}
+	p.Advance()
+
+	switch tc.Type {
+	case CHECK:
+		cond, err := p.checkCondition()
+		if err != nil {
+			return tc, err
+		}
+		tc.Check = cond
+		return tc, nil
+	case PRIMARY, FOREIGN:
+		if err := p.requireMatch(KEY); err != nil {
+			return tc, err
+		}
+	}
+
+	cols, err := p.columnList()
+	if err != nil {
+		return tc, err
+	}
+	tc.Columns = cols
+
+	if tc.Type == FOREIGN {
+		if err := p.requireMatch(REFERENCES); err != nil {
+			return tc, err
+		}
+		if tc.References, err = p.References(); err != nil {
+			return tc, err
+		}
+	}
+
+	return tc, nil
+}
+
+// References parses the parent table and referential actions of a foreign
+// key that follow the REFERENCES keyword.
+func (p *Parser) References() (*References, error) {
+	if err := p.requireMatch(IDENT); err != nil {
+		return nil, err
+	}
+
+	ref := &References{
+		TableName: p.Prev().Text,
+		OnDelete:  NO,
+		OnUpdate:  NO,
+	}
+
+	if p.curType(LPAREN) {
+		cols, err := p.columnList()
+		if err != nil {
+			return nil, err
+		}
+		ref.Columns = cols
+	}
+
+	for p.match(ON) {
+		if err := p.requireMatch(DELETE, UPDATE); err != nil {
+			return nil, err
+		}
+		event := p.Prev().Type
+
+		var action TokenType
+		switch {
+		case p.match(CASCADE, RESTRICT):
+			action = p.Prev().Type
+		case p.match(SET):
+			if err := p.requireMatch(NULL); err != nil {
+				return nil, err
 			}
-			if err := p.requireMatch(RPAREN); err != nil {
-				return ret, err
+			action = NULL
+		case p.match(NO):
+			if err := p.requireMatch(ACTION); err != nil {
+				return nil, err
 			}
-			te.ColumnDefinition.DataType = cst
-		case T_BOOL:
-			te.ColumnDefinition.DataType = BooleanType{}
+			action = NO
 		default:
-			return ret, syntaxErr(cur)
+			return nil, p.unexpectedTypeErr(CASCADE, RESTRICT, SET, NO)
 		}
 
-		ret = append(ret, te)
+		if event == DELETE {
+			ref.OnDelete = action
+		} else {
+			ref.OnUpdate = action
+		}
+	}
 
+	return ref, nil
+}
+
+// columnList parses a parenthesized list of column names.
+func (p *Parser) columnList() ([]string, error) {
+	var cols []string
+	if err := p.requireMatch(LPAREN); err != nil {
+		return cols, err
+	}
+	for {
+		if err := p.requireMatch(IDENT); err != nil {
+			return cols, err
+		}
+		cols = append(cols, p.Prev().Text)
 		if !p.match(COMMA) {
 			break
 		}
 	}
+	return cols, p.requireMatch(RPAREN)
+}
 
-	if err := p.requireMatch(RPAREN); err != nil {
-		return ret, err
+// checkCondition parses the parenthesized search condition of a CHECK
+// constraint.
+func (p *Parser) checkCondition() (interface{}, error) {
+	if err := p.requireMatch(LPAREN); err != nil {
+		return nil, err
 	}
-
-	return ret, nil
+	cond, err := p.OrCondition()
+	if err != nil {
+		return nil, err
+	}
+	return cond, p.requireMatch(RPAREN)
 }
 
 func (p *Parser) Select() (Select, error) {
@@ -540,6 +1148,13 @@ func (p *Parser) SortSpecificationList() ([]SortSpecification, error) {
 			s.OrderingSpecification = p.Prev()
 		}
 
+		if p.match(NULLS) {
+			if err := p.requireMatch(FIRST, LAST); err != nil {
+				return ss, err
+			}
+			s.NullOrdering = p.Prev()
+		}
+
 		ss = append(ss, s)
 
 		if !p.match(COMMA) {
@@ -713,7 +1328,7 @@ func (p *Parser) OrCondition() (interface{}, error) {
 	}
 
 	for p.match(OR) {
-		ac := SearchCondition{LHS: ret.(Predicate)}
+		ac := SearchCondition{LHS: ret}
 		ac.RHS, err = p.OrCondition()
 		if err != nil {
 			return nil, err
@@ -733,7 +1348,7 @@ func (p *Parser) AndCondition() (interface{}, error) {
 	}
 
 	for p.match(AND) {
-		ac := BooleanTerm{LHS: ret.(Predicate)}
+		ac := BooleanTerm{LHS: ret}
 		ac.RHS, err = p.AndCondition()
 		if err != nil {
 			return nil, err
@@ -745,6 +1360,14 @@ func (p *Parser) AndCondition() (interface{}, error) {
 }
 
 func (p *Parser) Predicate() (interface{}, error) {
+	if p.match(LPAREN) {
+		cond, err := p.OrCondition()
+		if err != nil {
+			return nil, err
+		}
+		return cond, p.requireMatch(RPAREN)
+	}
+
 	pred, err := p.ComparisonPredicate()
 	if err != nil {
 		return nil, err
@@ -767,6 +1390,17 @@ func (p *Parser) ComparisonPredicate() (interface{}, error) {
 		return lhs, err
 	}
 
+	if p.match(IS) {
+		cp := ComparisonPredicate{
+			LHS:    lhs,
+			CompOp: IS,
+		}
+		if p.match(NOT) {
+			cp.CompOp = IS_NOT
+		}
+		return cp, p.requireMatch(NULL)
+	}
+
 	if !p.match(EQ, NEQ, LT, GT, LTE, GTE) {
 		return lhs, nil
 	}
@@ -784,12 +1418,39 @@ func (p *Parser) ComparisonPredicate() (interface{}, error) {
 	return cp, nil
 }
 
-// ValueExpression is one of ColumnReference, integer literal, string literal, or nil
+// ValueExpression is one of ColumnReference, a literal value, nil,
+// ArithmeticExpression, Extract or FunctionCall
 type ValueExpression any
 
+// ValueExpression parses terms added to or subtracted from each other, left
+// to right.
 func (p *Parser) ValueExpression() (ValueExpression, error) {
-	if p.match(literals...) {
-		return p.Prev().Val()
+	ve, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.match(PLUS, MINUS) {
+		ae := ArithmeticExpression{LHS: ve, Op: p.Prev().Type}
+		if ae.RHS, err = p.term(); err != nil {
+			return nil, err
+		}
+		ve = ae
+	}
+	return ve, nil
+}
+
+// term parses a literal, a column reference, EXTRACT or a function call.
+func (p *Parser) term() (ValueExpression, error) {
+	if found, val, err := p.literal(); err != nil || found {
+		return val, err
+	}
+
+	if p.match(EXTRACT) {
+		return p.extract()
+	}
+
+	if p.curType(IDENT) && p.Peek().Type == LPAREN {
+		return p.functionCall()
 	}
 
 	if ok, cr, err := p.ColumnReference(); err != nil {
@@ -801,6 +1462,90 @@ func (p *Parser) ValueExpression() (ValueExpression, error) {
 	return nil, p.unexpectedTypeErr(literals...)
 }
 
+// literal parses a literal value, which is a plain literal such as 1 or
+// 'a', a negative number such as -1, or a string typed by a keyword such as
+// DATE '2026-01-01' or INTERVAL '1 day'. It returns false if there's no
+// literal.
+func (p *Parser) literal() (bool, interface{}, error) {
+	switch {
+	case p.match(literals...):
+		val, err := p.Prev().Val()
+		return true, val, err
+	case p.match(MINUS):
+		if err := p.requireMatch(INT, NUM, FLOAT, INTERVAL); err != nil {
+			return false, nil, err
+		}
+		if p.Prev().Type == INTERVAL {
+			lit, err := p.typedLiteral(INTERVAL)
+			if err != nil {
+				return false, nil, err
+			}
+			lit.Negative = true
+			return true, lit, nil
+		}
+		// the minus sign is part of the number, so that the smallest
+		// integer doesn't overflow
+		tok := p.Prev()
+		tok.Text = "-" + tok.Text
+		val, err := tok.Val()
+		return true, val, err
+	case p.match(T_DATE, T_TIME, T_TIMESTAMP, INTERVAL):
+		val, err := p.typedLiteral(p.Prev().Type)
+		return true, val, err
+	}
+	return false, nil, nil
+}
+
+// typedLiteral parses the string that follows keyword typ.
+func (p *Parser) typedLiteral(typ TokenType) (TypedLiteral, error) {
+	if err := p.requireMatch(STR); err != nil {
+		return TypedLiteral{}, err
+	}
+	return TypedLiteral{Type: typ, Text: p.Prev().Text}, nil
+}
+
+// extract parses the parenthesized field and source of EXTRACT.
+func (p *Parser) extract() (Extract, error) {
+	ex := Extract{}
+	if err := p.requireMatch(LPAREN); err != nil {
+		return ex, err
+	}
+	if err := p.requireMatch(IDENT); err != nil {
+		return ex, err
+	}
+	ex.Field = strings.ToUpper(p.Prev().Text)
+	if err := p.requireMatch(FROM); err != nil {
+		return ex, err
+	}
+	var err error
+	if ex.Source, err = p.ValueExpression(); err != nil {
+		return ex, err
+	}
+	return ex, p.requireMatch(RPAREN)
+}
+
+// functionCall parses the name and parenthesized arguments of a function
+// call.
+func (p *Parser) functionCall() (FunctionCall, error) {
+	fc := FunctionCall{Name: strings.ToUpper(p.Cur().Text)}
+	p.Advance()
+	p.Advance()
+	if p.match(RPAREN) {
+		return fc, nil
+	}
+	for {
+		arg, err := p.ValueExpression()
+		if err != nil {
+			return fc, err
+		}
+		fc.Args = append(fc.Args, arg)
+		if !p.match(COMMA) {
+			break
+		}
+	}
+	return fc, p.requireMatch(RPAREN)
+}
+
 func (p *Parser) ColumnReference() (bool, ColumnReference, error) {
 	ve := ColumnReference{}
 
@@ -873,48 +1618,41 @@ func (p *Parser) DerivedColumn() (DerivedColumn, error) {
 	return dc, err
 }
 
+// SetFunctionSpecification parses a set function such as count(*),
+// sum(price - discount) or count(DISTINCT customer_id).
 func (p *Parser) SetFunctionSpecification() (bool, any, error) {
-	var setFunc any
+	if !p.match(COUNT, AVG, SUM, MIN, MAX) {
+		return false, nil, nil
+	}
+	fn := p.Prev().Type
 
-	switch {
-	case p.match(COUNT):
-		if err := p.requireMatch(LPAREN); err != nil {
-			return false, setFunc, err
-		}
-		count := Count{}
-		foundColRef, ve, err := p.ColumnReference()
-		if err != nil {
-			return false, setFunc, err
-		}
-		if foundColRef {
-			count.ValueExpression = ve
-		} else if err := p.requireMatch(ASTRSK); err != nil {
-			return false, setFunc, err
-		}
-		setFunc = count
-		if err := p.requireMatch(RPAREN); err != nil {
-			return false, setFunc, err
-		}
-	case p.match(AVG):
-		if err := p.requireMatch(LPAREN); err != nil {
-			return false, setFunc, err
-		}
-		foundColRef, ve, err := p.ColumnReference()
-		if err != nil {
-			return false, setFunc, err
-		}
-		if !foundColRef {
-			return false, setFunc, errors.New("avg() requires a column argument")
-		}
-		setFunc = Average{
-			ValueExpression: ve,
-		}
-		if err := p.requireMatch(RPAREN); err != nil {
-			return false, setFunc, err
+	if err := p.requireMatch(LPAREN); err != nil {
+		return false, nil, err
+	}
+	var ve ValueExpression
+	var distinct bool
+	if fn != COUNT || !p.match(ASTRSK) {
+		distinct = p.match(DISTINCT)
+		var err error
+		if ve, err = p.ValueExpression(); err != nil {
+			return false, nil, err
 		}
 	}
+	if err := p.requireMatch(RPAREN); err != nil {
+		return false, nil, err
+	}
 
-	return setFunc != nil, setFunc, nil
+	switch fn {
+	case COUNT:
+		return true, Count{ValueExpression: ve, Distinct: distinct}, nil
+	case AVG:
+		return true, Average{ValueExpression: ve, Distinct: distinct}, nil
+	case SUM:
+		return true, Sum{ValueExpression: ve, Distinct: distinct}, nil
+	case MIN:
+		return true, Min{ValueExpression: ve, Distinct: distinct}, nil
+	}
+	return true, Max{ValueExpression: ve, Distinct: distinct}, nil
 }
 
 func (p *Parser) TableName() (TableName, error) {
@@ -968,8 +1706,8 @@ func (p *Parser) Insert() (InsertStatement, error) {
 	for p.match(LPAREN) {
 		var rvc RowValueConstructor
 
-		for p.match(literals...) {
-			val, err := p.Prev().Val()
+		for !p.curType(RPAREN) {
+			val, err := p.ValueExpression()
 			if err != nil {
 				return is, err
 			}
@@ -1049,6 +1787,48 @@ func (p *Parser) Use() (UseStatement, error) {
 	return us, nil
 }
 
+func (p *Parser) Begin() (BeginStatement, error) {
+	p.match(TRANSACTION)
+	return BeginStatement{}, nil
+}
+
+func (p *Parser) Commit() (CommitStatement, error) {
+	p.match(TRANSACTION)
+	return CommitStatement{}, nil
+}
+
+func (p *Parser) Rollback() (RollbackStatement, error) {
+	p.match(TRANSACTION)
+	return RollbackStatement{}, nil
+}
+
+func (p *Parser) Explain() (ExplainStatement, error) {
+	if err := p.requireMatch(SELECT); err != nil {
+		return ExplainStatement{}, err
+	}
+	q, err := p.Select()
+	if err != nil {
+		return ExplainStatement{}, err
+	}
+	return ExplainStatement{Select: q}, nil
+}
+
+func (p *Parser) Analyze() (AnalyzeStatement, error) {
+	as := AnalyzeStatement{}
+	if p.match(IDENT) {
+		as.TableName = p.Prev().Text
+	}
+	return as, nil
+}
+
+func (p *Parser) Vacuum() (VacuumStatement, error) {
+	vs := VacuumStatement{}
+	if p.match(IDENT) {
+		vs.TableName = p.Prev().Text
+	}
+	return vs, nil
+}
+
 func (p *Parser) Delete() (DeleteStatementSearched, error) {
 	del := DeleteStatementSearched{}
 
@@ -1114,6 +1894,34 @@ func (p *Parser) unexpectedTypeErr(types ...TokenType) error {
 	return fmt.Errorf("%w %s, expected %s", ErrUnexpectedToken, unex, strings.Join(typeNames, ", "))
 }
 
+// decimalType parses the optional precision and scale of a DECIMAL type. The
+// precision is 0 if it's not given, and the scale defaults to 0.
+func (p *Parser) decimalType() (DecimalType, error) {
+	dt := DecimalType{}
+	if !p.match(LPAREN) {
+		return dt, nil
+	}
+	var err error
+	if dt.Precision, err = p.requireInt(); err != nil {
+		return dt, err
+	}
+	if p.match(COMMA) {
+		if dt.Scale, err = p.requireInt(); err != nil {
+			return dt, err
+		}
+	}
+	if err := p.requireMatch(RPAREN); err != nil {
+		return dt, err
+	}
+	if dt.Precision < 1 {
+		return dt, fmt.Errorf("%w: DECIMAL precision must be at least 1", ErrInvalidNumericType)
+	}
+	if dt.Scale < 0 || dt.Scale > dt.Precision {
+		return dt, fmt.Errorf("%w: DECIMAL scale must be between 0 and the precision", ErrInvalidNumericType)
+	}
+	return dt, nil
+}
+
 func (p *Parser) requireInt() (int64, error) {
 	if err := p.requireMatch(INT); err != nil {
 		return 0, err
@@ -1370,6 +1370,112 @@ func TestParseCreateTable(t *testing.T) {
 	}
 }
 
+func TestParseCreateTableLargeObjectTypes(t *testing.T) {
+
+	p := &Parser{TokenList{tokens: []Token{
+		{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "docs"}, {Type: LPAREN},
+		{Type: IDENT, Text: "body"}, {Type: T_TEXT}, {Type: COMMA},
+		{Type: IDENT, Text: "data"}, {Type: T_BLOB}, {Type: COMMA},
+		{Type: IDENT, Text: "raw"}, {Type: T_BYTEA}, {Type: NOT}, {Type: NULL},
+		{Type: RPAREN},
+	}}}
+
+	expected := CreateTable{
+		Name: "docs",
+		Elements: []TableElement{
+			{ColumnDefinition{DataType: TextType{}, Name: "body"}},
+			{ColumnDefinition{DataType: BlobType{}, Name: "data"}},
+			{ColumnDefinition{DataType: BlobType{}, Name: "raw", NotNull: true}},
+		},
+	}
+
+	actual, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
+
+func TestParseCreateTableNumericTypes(t *testing.T) {
+
+	p := &Parser{TokenList{tokens: []Token{
+		{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "prices"}, {Type: LPAREN},
+		{Type: IDENT, Text: "weight"}, {Type: T_REAL}, {Type: COMMA},
+		{Type: IDENT, Text: "ratio"}, {Type: T_DOUBLE}, {Type: PRECISION}, {Type: COMMA},
+		{Type: IDENT, Text: "amount"}, {Type: T_DECIMAL}, {Type: LPAREN}, {Type: INT, Text: "10"},
+		{Type: COMMA}, {Type: INT, Text: "2"}, {Type: RPAREN},
+		{Type: DEFAULT}, {Type: NUM, Text: "1.50"}, {Type: COMMA},
+		{Type: IDENT, Text: "qty"}, {Type: T_NUMERIC}, {Type: COMMA},
+		{Type: IDENT, Text: "rate"}, {Type: T_REAL}, {Type: DEFAULT}, {Type: FLOAT, Text: "2.5e-1"},
+		{Type: RPAREN},
+	}}}
+
+	expected := CreateTable{
+		Name: "prices",
+		Elements: []TableElement{
+			{ColumnDefinition{DataType: RealType{}, Name: "weight"}},
+			{ColumnDefinition{DataType: DoubleType{}, Name: "ratio"}},
+			{ColumnDefinition{DataType: DecimalType{Precision: 10, Scale: 2}, Name: "amount", Default: DecimalLiteral("1.50")}},
+			{ColumnDefinition{DataType: DecimalType{}, Name: "qty"}},
+			{ColumnDefinition{DataType: RealType{}, Name: "rate", Default: 0.25}},
+		},
+	}
+
+	actual, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+
+	for _, typ := range [][]Token{
+		{{Type: T_DECIMAL}, {Type: LPAREN}, {Type: INT, Text: "0"}, {Type: RPAREN}},
+		{{Type: T_DECIMAL}, {Type: LPAREN}, {Type: INT, Text: "4"}, {Type: COMMA}, {Type: INT, Text: "5"}, {Type: RPAREN}},
+	} {
+		tokens := []Token{{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "t"}, {Type: LPAREN}, {Type: IDENT, Text: "c"}}
+		tokens = append(append(tokens, typ...), Token{Type: RPAREN})
+		p := &Parser{TokenList{tokens: tokens}}
+		if _, err := p.Parse(); !errors.Is(err, ErrInvalidNumericType) {
+			t.Errorf("expected error %v, got %v", ErrInvalidNumericType, err)
+		}
+	}
+}
+
+func TestParseCreateTableTemporalTypes(t *testing.T) {
+
+	p := &Parser{TokenList{tokens: []Token{
+		{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "events"}, {Type: LPAREN},
+		{Type: IDENT, Text: "day"}, {Type: T_DATE}, {Type: DEFAULT}, {Type: T_DATE}, {Type: STR, Text: "2026-01-01"}, {Type: COMMA},
+		{Type: IDENT, Text: "at"}, {Type: T_TIME}, {Type: COMMA},
+		{Type: IDENT, Text: "created"}, {Type: T_TIMESTAMP}, {Type: COMMA},
+		{Type: IDENT, Text: "length"}, {Type: INTERVAL}, {Type: DEFAULT}, {Type: MINUS}, {Type: INTERVAL}, {Type: STR, Text: "1 hour"}, {Type: COMMA},
+		{Type: IDENT, Text: "delta"}, {Type: T_INT}, {Type: DEFAULT}, {Type: MINUS}, {Type: INT, Text: "1"},
+		{Type: RPAREN},
+	}}}
+
+	expected := CreateTable{
+		Name: "events",
+		Elements: []TableElement{
+			{ColumnDefinition{DataType: DateType{}, Name: "day", Default: TypedLiteral{Type: T_DATE, Text: "2026-01-01"}}},
+			{ColumnDefinition{DataType: TimeType{}, Name: "at"}},
+			{ColumnDefinition{DataType: TimestampType{}, Name: "created"}},
+			{ColumnDefinition{DataType: IntervalType{}, Name: "length", Default: TypedLiteral{Type: INTERVAL, Text: "1 hour", Negative: true}}},
+			{ColumnDefinition{DataType: NumericType{}, Name: "delta", Default: int64(-1)}},
+		},
+	}
+
+	actual, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
+
 func TestParseCreateDatabase(t *testing.T) {
 
 	input := []Token{
@@ -1406,6 +1512,158 @@ func TestParseCreateDatabase(t *testing.T) {
 	}
 }
 
+func TestParseCreateIndex(t *testing.T) {
+
+	input := []Token{
+		{
+			Type: CREATE,
+		},
+		{
+			Type: UNIQUE,
+		},
+		{
+			Type: INDEX,
+		},
+		{
+			Type: IDENT,
+			Text: "name_idx",
+		},
+		{
+			Type: ON,
+		},
+		{
+			Type: IDENT,
+			Text: "Persons",
+		},
+		{
+			Type: LPAREN,
+		},
+		{
+			Type: IDENT,
+			Text: "LastName",
+		},
+		{
+			Type: COMMA,
+		},
+		{
+			Type: IDENT,
+			Text: "FirstName",
+		},
+		{
+			Type: RPAREN,
+		},
+	}
+
+	expected := CreateIndex{
+		Name:      "name_idx",
+		TableName: "Persons",
+		Columns:   []string{"LastName", "FirstName"},
+		Unique:    true,
+	}
+
+	tl := TokenList{
+		tokens: input,
+		cur:    0,
+	}
+	p := &Parser{tl}
+
+	actual, err := p.Parse()
+
+	if err != nil {
+		t.Errorf("parsing failed: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
+
+func TestParseDropIndex(t *testing.T) {
+
+	input := []Token{
+		{
+			Type: DROP,
+		},
+		{
+			Type: INDEX,
+		},
+		{
+			Type: IDENT,
+			Text: "name_idx",
+		},
+	}
+
+	expected := DropIndex{
+		Name: "name_idx",
+	}
+
+	tl := TokenList{
+		tokens: input,
+		cur:    0,
+	}
+	p := &Parser{tl}
+
+	actual, err := p.Parse()
+
+	if err != nil {
+		t.Errorf("parsing failed: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
+
+func TestParseDropTableAndDatabase(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		input    []Token
+		expected interface{}
+	}{
+		{
+			name:     "drop table",
+			input:    []Token{{Type: DROP}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}},
+			expected: DropTable{Name: "the_table"},
+		},
+		{
+			name:     "drop database",
+			input:    []Token{{Type: DROP}, {Type: DATABASE}, {Type: IDENT, Text: "the_db"}},
+			expected: DropDatabase{Name: "the_db"},
+		},
+		{
+			name:     "drop database if exists",
+			input:    []Token{{Type: DROP}, {Type: DATABASE}, {Type: IF}, {Type: EXISTS}, {Type: IDENT, Text: "the_db"}},
+			expected: DropDatabase{Name: "the_db", IfExists: true},
+		},
+		{
+			name:     "truncate",
+			input:    []Token{{Type: TRUNCATE}, {Type: IDENT, Text: "the_table"}},
+			expected: TruncateTable{Name: "the_table"},
+		},
+		{
+			name:     "truncate table",
+			input:    []Token{{Type: TRUNCATE}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}},
+			expected: TruncateTable{Name: "the_table"},
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Parser{TokenList{tokens: test.input}}
+
+			actual, err := p.Parse()
+			if err != nil {
+				t.Fatalf("parsing failed: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("ASTs are not the same. expected: %+v actual :%+v", test.expected, actual)
+			}
+		})
+	}
+}
+
 func TestParseInsert(t *testing.T) {
 
 	input := []Token{
@@ -1786,6 +2044,525 @@ func TestParseUse(t *testing.T) {
 	}
 }
 
+func TestParseTransactionControl(t *testing.T) {
+	tbl := []struct {
+		input    []Token
+		expected interface{}
+	}{
+		{
+			input:    []Token{{Type: BEGIN}},
+			expected: BeginStatement{},
+		},
+		{
+			input:    []Token{{Type: BEGIN}, {Type: TRANSACTION}},
+			expected: BeginStatement{},
+		},
+		{
+			input:    []Token{{Type: COMMIT}},
+			expected: CommitStatement{},
+		},
+		{
+			input:    []Token{{Type: ROLLBACK}, {Type: TRANSACTION}},
+			expected: RollbackStatement{},
+		},
+	}
+
+	for _, test := range tbl {
+		tl := TokenList{
+			tokens: test.input,
+			cur:    0,
+		}
+		p := &Parser{tl}
+
+		actual, err := p.Parse()
+
+		if err != nil {
+			t.Errorf("parsing failed: %s", err.Error())
+		}
+
+		if !reflect.DeepEqual(test.expected, actual) {
+			t.Errorf("ASTs are not the same. expected: %+v actual :%+v", test.expected, actual)
+		}
+	}
+}
+
+func TestParseCheckpoint(t *testing.T) {
+
+	input := []Token{
+		{
+			Type: CHECKPOINT,
+		},
+	}
+
+	expected := CheckpointStatement{}
+
+	tl := TokenList{
+		tokens: input,
+		cur:    0,
+	}
+	p := &Parser{tl}
+
+	actual, err := p.Parse()
+
+	if err != nil {
+		t.Errorf("parsing failed: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
+
+func TestParseExplain(t *testing.T) {
+
+	input := []Token{
+		{
+			Type: EXPLAIN,
+		},
+		{
+			Type: SELECT,
+		},
+		{
+			Type: ASTRSK,
+		},
+		{
+			Type: FROM,
+		},
+		{
+			Type: IDENT,
+			Text: "the_table",
+		},
+	}
+
+	expected := ExplainStatement{
+		Select: Select{
+			SelectList: SelectList{
+				DerivedColumn{
+					ValueExpressionPrimary: Asterisk{},
+				},
+			},
+			TableExpression: TableExpression{
+				FromClause: FromClause{
+					TableName{
+						Name: "the_table",
+					},
+				},
+			},
+		},
+	}
+
+	tl := TokenList{
+		tokens: input,
+		cur:    0,
+	}
+	p := &Parser{tl}
+
+	actual, err := p.Parse()
+
+	if err != nil {
+		t.Errorf("parsing failed: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
+
+func TestParseAnalyzeVacuumAndShowStats(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		input    []Token
+		expected interface{}
+	}{
+		{
+			name:     "analyze all tables",
+			input:    []Token{{Type: ANALYZE}},
+			expected: AnalyzeStatement{},
+		},
+		{
+			name:     "analyze one table",
+			input:    []Token{{Type: ANALYZE}, {Type: IDENT, Text: "the_table"}},
+			expected: AnalyzeStatement{TableName: "the_table"},
+		},
+		{
+			name:     "vacuum all tables",
+			input:    []Token{{Type: VACUUM}},
+			expected: VacuumStatement{},
+		},
+		{
+			name:     "vacuum one table",
+			input:    []Token{{Type: VACUUM}, {Type: IDENT, Text: "the_table"}},
+			expected: VacuumStatement{TableName: "the_table"},
+		},
+		{
+			name:     "show stats of all tables",
+			input:    []Token{{Type: SHOW}, {Type: IDENT, Text: "STATS"}},
+			expected: ShowStats{},
+		},
+		{
+			name:     "show stats of one table",
+			input:    []Token{{Type: SHOW}, {Type: IDENT, Text: "stats"}, {Type: IDENT, Text: "the_table"}},
+			expected: ShowStats{TableName: "the_table"},
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Parser{TokenList{tokens: test.input}}
+
+			actual, err := p.Parse()
+			if err != nil {
+				t.Fatalf("parsing failed: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("ASTs are not the same. expected: %+v actual :%+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseNull(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		input    []Token
+		expected interface{}
+	}{
+		{
+			name: "null literal in insert",
+			input: []Token{
+				{Type: INSERT}, {Type: INTO}, {Type: IDENT, Text: "the_table"}, {Type: VALUES},
+				{Type: LPAREN}, {Type: INT, Text: "1"}, {Type: COMMA}, {Type: NULL}, {Type: RPAREN},
+			},
+			expected: InsertStatement{
+				TableName: "the_table",
+				InsertColumnsAndSource: InsertColumnsAndSource{
+					QueryExpression: TableValueConstructor{
+						TableValueConstructorList: []RowValueConstructor{
+							{RowValueConstructorList: []interface{}{int64(1), nil}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "null literal in update",
+			input: []Token{
+				{Type: UPDATE}, {Type: IDENT, Text: "the_table"}, {Type: SET},
+				{Type: IDENT, Text: "col1"}, {Type: EQ}, {Type: NULL},
+			},
+			expected: UpdateStatementSearched{
+				TableName: "the_table",
+				Set:       []SetClause{{ObjectColumn: "col1", UpdateSource: nil}},
+			},
+		},
+		{
+			name: "is null and is not null",
+			input: []Token{
+				{Type: DELETE}, {Type: FROM}, {Type: IDENT, Text: "the_table"}, {Type: WHERE},
+				{Type: IDENT, Text: "col1"}, {Type: IS}, {Type: NULL}, {Type: AND},
+				{Type: IDENT, Text: "col2"}, {Type: IS}, {Type: NOT}, {Type: NULL},
+			},
+			expected: DeleteStatementSearched{
+				TableName: "the_table",
+				WhereClause: WhereClause{
+					SearchCondition: BooleanTerm{
+						LHS: Predicate{
+							ComparisonPredicate{
+								LHS:    ColumnReference{ColumnName: "col1"},
+								CompOp: IS,
+							},
+						},
+						RHS: Predicate{
+							ComparisonPredicate{
+								LHS:    ColumnReference{ColumnName: "col2"},
+								CompOp: IS_NOT,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "nulls first and last",
+			input: []Token{
+				{Type: SELECT}, {Type: ASTRSK}, {Type: FROM}, {Type: IDENT, Text: "the_table"},
+				{Type: ORDER}, {Type: BY},
+				{Type: IDENT, Text: "col1"}, {Type: NULLS}, {Type: FIRST}, {Type: COMMA},
+				{Type: IDENT, Text: "col2"}, {Type: DESC}, {Type: NULLS}, {Type: LAST},
+			},
+			expected: Select{
+				SelectList: SelectList{{ValueExpressionPrimary: Asterisk{}}},
+				TableExpression: TableExpression{
+					FromClause: FromClause{TableName{Name: "the_table"}},
+				},
+				SortSpecificationList: []SortSpecification{
+					{
+						SortKey:               ColumnReference{ColumnName: "col1"},
+						OrderingSpecification: Token{Type: ASC},
+						NullOrdering:          Token{Type: FIRST},
+					},
+					{
+						SortKey:               ColumnReference{ColumnName: "col2"},
+						OrderingSpecification: Token{Type: DESC},
+						NullOrdering:          Token{Type: LAST},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Parser{TokenList{tokens: test.input}}
+
+			actual, err := p.Parse()
+			if err != nil {
+				t.Fatalf("parsing failed: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("ASTs are not the same. expected: %+v actual :%+v", test.expected, actual)
+			}
+		})
+	}
+
+	// IS has to be followed by NULL
+	p := &Parser{TokenList{tokens: []Token{
+		{Type: DELETE}, {Type: FROM}, {Type: IDENT, Text: "the_table"}, {Type: WHERE},
+		{Type: IDENT, Text: "col1"}, {Type: IS}, {Type: INT, Text: "1"},
+	}}}
+	if _, err := p.Parse(); !errors.Is(err, ErrUnexpectedToken) {
+		t.Errorf("expected ErrUnexpectedToken error, got %v", err)
+	}
+}
+
+func TestParseConstraints(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		input    []Token
+		expected interface{}
+	}{
+		{
+			name: "column constraints",
+			input: []Token{
+				{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: LPAREN},
+				{Type: IDENT, Text: "id"}, {Type: T_INT}, {Type: PRIMARY}, {Type: KEY}, {Type: COMMA},
+				{Type: IDENT, Text: "name"}, {Type: T_VARCHAR}, {Type: LPAREN}, {Type: INT, Text: "10"}, {Type: RPAREN},
+				{Type: NOT}, {Type: NULL}, {Type: UNIQUE}, {Type: DEFAULT}, {Type: STR, Text: "none"}, {Type: COMMA},
+				{Type: IDENT, Text: "age"}, {Type: T_INT}, {Type: NULL},
+				{Type: CHECK}, {Type: LPAREN}, {Type: IDENT, Text: "age"}, {Type: GTE}, {Type: INT, Text: "0"}, {Type: RPAREN},
+				{Type: RPAREN},
+			},
+			expected: CreateTable{
+				Name: "the_table",
+				Elements: []TableElement{
+					{ColumnDefinition{DataType: NumericType{}, Name: "id", PrimaryKey: true}},
+					{ColumnDefinition{
+						DataType: CharacterStringType{Len: 10, Type: T_VARCHAR},
+						Name:     "name",
+						NotNull:  true,
+						Unique:   true,
+						Default:  "none",
+					}},
+					{ColumnDefinition{
+						DataType: NumericType{},
+						Name:     "age",
+						Check: Predicate{
+							ComparisonPredicate{
+								LHS:    ColumnReference{ColumnName: "age"},
+								CompOp: GTE,
+								RHS:    int64(0),
+							},
+						},
+					}},
+				},
+			},
+		},
+		{
+			name: "table constraints",
+			input: []Token{
+				{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: LPAREN},
+				{Type: IDENT, Text: "col1"}, {Type: T_INT}, {Type: COMMA},
+				{Type: IDENT, Text: "col2"}, {Type: T_INT}, {Type: COMMA},
+				{Type: PRIMARY}, {Type: KEY}, {Type: LPAREN}, {Type: IDENT, Text: "col1"}, {Type: COMMA}, {Type: IDENT, Text: "col2"}, {Type: RPAREN}, {Type: COMMA},
+				{Type: UNIQUE}, {Type: LPAREN}, {Type: IDENT, Text: "col2"}, {Type: RPAREN}, {Type: COMMA},
+				{Type: CHECK}, {Type: LPAREN},
+				{Type: LPAREN}, {Type: IDENT, Text: "col1"}, {Type: LT}, {Type: INT, Text: "5"}, {Type: OR},
+				{Type: IDENT, Text: "col2"}, {Type: LT}, {Type: INT, Text: "5"}, {Type: RPAREN},
+				{Type: AND}, {Type: IDENT, Text: "col1"}, {Type: NEQ}, {Type: IDENT, Text: "col2"},
+				{Type: RPAREN},
+				{Type: RPAREN},
+			},
+			expected: CreateTable{
+				Name: "the_table",
+				Elements: []TableElement{
+					{ColumnDefinition{DataType: NumericType{}, Name: "col1"}},
+					{ColumnDefinition{DataType: NumericType{}, Name: "col2"}},
+				},
+				Constraints: []TableConstraint{
+					{Type: PRIMARY, Columns: []string{"col1", "col2"}},
+					{Type: UNIQUE, Columns: []string{"col2"}},
+					{
+						Type: CHECK,
+						Check: BooleanTerm{
+							LHS: SearchCondition{
+								LHS: Predicate{
+									ComparisonPredicate{
+										LHS:    ColumnReference{ColumnName: "col1"},
+										CompOp: LT,
+										RHS:    int64(5),
+									},
+								},
+								RHS: Predicate{
+									ComparisonPredicate{
+										LHS:    ColumnReference{ColumnName: "col2"},
+										CompOp: LT,
+										RHS:    int64(5),
+									},
+								},
+							},
+							RHS: Predicate{
+								ComparisonPredicate{
+									LHS:    ColumnReference{ColumnName: "col1"},
+									CompOp: NEQ,
+									RHS:    ColumnReference{ColumnName: "col2"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "foreign keys",
+			input: []Token{
+				{Type: CREATE}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: LPAREN},
+				{Type: IDENT, Text: "col1"}, {Type: T_INT}, {Type: REFERENCES}, {Type: IDENT, Text: "parent1"}, {Type: COMMA},
+				{Type: IDENT, Text: "col2"}, {Type: T_INT}, {Type: COMMA},
+				{Type: FOREIGN}, {Type: KEY}, {Type: LPAREN}, {Type: IDENT, Text: "col2"}, {Type: RPAREN},
+				{Type: REFERENCES}, {Type: IDENT, Text: "parent2"}, {Type: LPAREN}, {Type: IDENT, Text: "id"}, {Type: RPAREN},
+				{Type: ON}, {Type: DELETE}, {Type: SET}, {Type: NULL},
+				{Type: ON}, {Type: UPDATE}, {Type: CASCADE},
+				{Type: RPAREN},
+			},
+			expected: CreateTable{
+				Name: "the_table",
+				Elements: []TableElement{
+					{ColumnDefinition{
+						DataType:   NumericType{},
+						Name:       "col1",
+						References: &References{TableName: "parent1", OnDelete: NO, OnUpdate: NO},
+					}},
+					{ColumnDefinition{DataType: NumericType{}, Name: "col2"}},
+				},
+				Constraints: []TableConstraint{
+					{
+						Type:    FOREIGN,
+						Columns: []string{"col2"},
+						References: &References{
+							TableName: "parent2",
+							Columns:   []string{"id"},
+							OnDelete:  NULL,
+							OnUpdate:  CASCADE,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Parser{TokenList{tokens: test.input}}
+
+			actual, err := p.Parse()
+			if err != nil {
+				t.Fatalf("parsing failed: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("ASTs are not the same. expected: %+v actual :%+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseAlterTable(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		input    []Token
+		expected interface{}
+	}{
+		{
+			name: "add column",
+			input: []Token{
+				{Type: ALTER}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: ADD}, {Type: COLUMN},
+				{Type: IDENT, Text: "age"}, {Type: T_INT}, {Type: NOT}, {Type: NULL}, {Type: DEFAULT}, {Type: INT, Text: "0"},
+			},
+			expected: AlterTable{
+				TableName:        "the_table",
+				Action:           ADD,
+				ColumnDefinition: ColumnDefinition{DataType: NumericType{}, Name: "age", NotNull: true, Default: int64(0)},
+			},
+		},
+		{
+			name: "drop column",
+			input: []Token{
+				{Type: ALTER}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: DROP}, {Type: IDENT, Text: "age"},
+			},
+			expected: AlterTable{
+				TableName: "the_table",
+				Action:    DROP,
+				Column:    "age",
+			},
+		},
+		{
+			name: "rename column",
+			input: []Token{
+				{Type: ALTER}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: RENAME}, {Type: COLUMN},
+				{Type: IDENT, Text: "age"}, {Type: TO}, {Type: IDENT, Text: "years"},
+			},
+			expected: AlterTable{
+				TableName: "the_table",
+				Action:    RENAME,
+				Column:    "age",
+				NewName:   "years",
+			},
+		},
+		{
+			name: "rename table",
+			input: []Token{
+				{Type: ALTER}, {Type: TABLE}, {Type: IDENT, Text: "the_table"}, {Type: RENAME}, {Type: TO}, {Type: IDENT, Text: "other_table"},
+			},
+			expected: AlterTable{
+				TableName: "the_table",
+				Action:    RENAME,
+				NewName:   "other_table",
+			},
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Parser{TokenList{tokens: test.input}}
+
+			actual, err := p.Parse()
+			if err != nil {
+				t.Fatalf("parsing failed: %s", err.Error())
+			}
+
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("ASTs are not the same. expected: %+v actual :%+v", test.expected, actual)
+			}
+		})
+	}
+}
+
 func TestParseDelete(t *testing.T) {
 
 	input := []Token{
@@ -2020,6 +2797,86 @@ func TestParseSelectAvg(t *testing.T) {
 	}
 }
 
+func TestParseSelectSetFunctions(t *testing.T) {
+
+	// SELECT region, sum(price - discount), count(DISTINCT customer_id),
+	// min(price), max(price) AS top
+	// FROM orders
+	// GROUP BY region
+	input := []Token{
+		{Type: SELECT},
+		{Type: IDENT, Text: "region"},
+		{Type: COMMA},
+		{Type: SUM}, {Type: LPAREN}, {Type: IDENT, Text: "price"}, {Type: MINUS}, {Type: IDENT, Text: "discount"}, {Type: RPAREN},
+		{Type: COMMA},
+		{Type: COUNT}, {Type: LPAREN}, {Type: DISTINCT}, {Type: IDENT, Text: "customer_id"}, {Type: RPAREN},
+		{Type: COMMA},
+		{Type: MIN}, {Type: LPAREN}, {Type: IDENT, Text: "price"}, {Type: RPAREN},
+		{Type: COMMA},
+		{Type: MAX}, {Type: LPAREN}, {Type: IDENT, Text: "price"}, {Type: RPAREN}, {Type: AS}, {Type: IDENT, Text: "top"},
+		{Type: FROM}, {Type: IDENT, Text: "orders"},
+		{Type: GROUP}, {Type: BY}, {Type: IDENT, Text: "region"},
+	}
+
+	expected := Select{
+		SelectList: SelectList{
+			DerivedColumn{
+				ValueExpressionPrimary: ColumnReference{ColumnName: "region"},
+			},
+			DerivedColumn{
+				ValueExpressionPrimary: Sum{
+					ValueExpression: ArithmeticExpression{
+						LHS: ColumnReference{ColumnName: "price"},
+						Op:  MINUS,
+						RHS: ColumnReference{ColumnName: "discount"},
+					},
+				},
+			},
+			DerivedColumn{
+				ValueExpressionPrimary: Count{
+					ValueExpression: ColumnReference{ColumnName: "customer_id"},
+					Distinct:        true,
+				},
+			},
+			DerivedColumn{
+				ValueExpressionPrimary: Min{ValueExpression: ColumnReference{ColumnName: "price"}},
+			},
+			DerivedColumn{
+				ValueExpressionPrimary: Max{ValueExpression: ColumnReference{ColumnName: "price"}},
+				AsClause:               "top",
+			},
+		},
+		TableExpression: TableExpression{
+			FromClause: FromClause{
+				TableName{Name: "orders"},
+			},
+			GroupByClause: []ColumnReference{
+				{ColumnName: "region"},
+			},
+		},
+	}
+
+	p := &Parser{TokenList{tokens: input}}
+	actual, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+
+	// only count() takes *
+	input = []Token{
+		{Type: SELECT},
+		{Type: SUM}, {Type: LPAREN}, {Type: ASTRSK}, {Type: RPAREN},
+		{Type: FROM}, {Type: IDENT, Text: "orders"},
+	}
+	p = &Parser{TokenList{tokens: input}}
+	if _, err := p.Parse(); !errors.Is(err, ErrUnexpectedToken) {
+		t.Errorf("expected ErrUnexpectedToken, got %v", err)
+	}
+}
+
 func TestParseSelectBooleanExpressionWithoutFrom(t *testing.T) {
 
 	input := []Token{
@@ -2831,3 +3688,68 @@ func TestParseSelectCountGroupBy(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTemporalExpressions(t *testing.T) {
+
+	// SELECT EXTRACT(year FROM created), date_trunc('month', created), NOW()
+	// FROM events
+	// WHERE created > TIMESTAMP '2026-01-01 00:00' - INTERVAL '1 day' + 1
+	input := []Token{
+		{Type: SELECT},
+		{Type: EXTRACT}, {Type: LPAREN}, {Type: IDENT, Text: "year"}, {Type: FROM}, {Type: IDENT, Text: "created"}, {Type: RPAREN},
+		{Type: COMMA},
+		{Type: IDENT, Text: "date_trunc"}, {Type: LPAREN}, {Type: STR, Text: "month"}, {Type: COMMA}, {Type: IDENT, Text: "created"}, {Type: RPAREN},
+		{Type: COMMA},
+		{Type: IDENT, Text: "NOW"}, {Type: LPAREN}, {Type: RPAREN},
+		{Type: FROM}, {Type: IDENT, Text: "events"},
+		{Type: WHERE}, {Type: IDENT, Text: "created"}, {Type: GT},
+		{Type: T_TIMESTAMP}, {Type: STR, Text: "2026-01-01 00:00"},
+		{Type: MINUS}, {Type: INTERVAL}, {Type: STR, Text: "1 day"},
+		{Type: PLUS}, {Type: INT, Text: "1"},
+	}
+
+	expected := Select{
+		SelectList: SelectList{
+			DerivedColumn{
+				ValueExpressionPrimary: Extract{Field: "YEAR", Source: ColumnReference{ColumnName: "created"}},
+			},
+			DerivedColumn{
+				ValueExpressionPrimary: FunctionCall{Name: "DATE_TRUNC", Args: []ValueExpression{"month", ColumnReference{ColumnName: "created"}}},
+			},
+			DerivedColumn{
+				ValueExpressionPrimary: FunctionCall{Name: "NOW"},
+			},
+		},
+		TableExpression: TableExpression{
+			FromClause: FromClause{
+				TableName{Name: "events"},
+			},
+			WhereClause: WhereClause{
+				SearchCondition: Predicate{
+					ComparisonPredicate{
+						LHS:    ColumnReference{ColumnName: "created"},
+						CompOp: GT,
+						RHS: ArithmeticExpression{
+							LHS: ArithmeticExpression{
+								LHS: TypedLiteral{Type: T_TIMESTAMP, Text: "2026-01-01 00:00"},
+								Op:  MINUS,
+								RHS: TypedLiteral{Type: INTERVAL, Text: "1 day"},
+							},
+							Op:  PLUS,
+							RHS: int64(1),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	p := &Parser{TokenList{tokens: input}}
+	actual, err := p.Parse()
+	if err != nil {
+		t.Fatalf("parsing failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ASTs are not the same. expected: %+v actual :%+v", expected, actual)
+	}
+}
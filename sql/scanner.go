@@ -5,16 +5,22 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	IDENT = iota
 	literal_start
 	INT
+	// NUM is an exact number with a decimal point, e.g. 1.50
+	NUM
+	// FLOAT is an approximate number with an exponent, e.g. 1.5e3
+	FLOAT
 	STR
 	reserved_word_start
 	TRUE
 	FALSE
+	NULL
 	literal_end
 
 	BANG
@@ -27,48 +33,79 @@ const (
 	LT
 	LTE
 	GTE
+	IS_NOT
 	LPAREN
 	RPAREN
+	PARAM
+	PLUS
+	MINUS
 
+	ACTION
+	ADD
+	ALTER
+	ANALYZE
 	AS
 	ASC
 	AVG
 	BEGIN
 	BY
+	CASCADE
 	CASE
+	CHECK
+	CHECKPOINT
+	COLUMN
 	COMMA
 	COMMIT
 	COUNT
 	CREATE
 	DATABASE
+	DEFAULT
 	DELETE
 	DESC
 	DISTINCT
 	DOT
+	DROP
 	ELSE
 	END
 	EXISTS
+	EXPLAIN
+	EXTRACT
+	FIRST
+	FOREIGN
 	FROM
 	FULL
 	GROUP
 	HAVING
+	IF
 	IN
+	INDEX
 	INNER
 	INSERT
+	INTERVAL
 	INTO
+	IS
 	JOIN
+	KEY
+	LAST
 	LEFT
 	LIKE
 	LIMIT
 	MAX
 	MIN
+	NO
 	NOT
-	NULL
+	NULLS
 	OFFSET
 	ON
 	ORDER
 	OUTER
+	PRECISION
+	PRIMARY
+	REFERENCES
+	RENAME
+	RESTRICT
 	RIGHT
+	ROLLBACK
 	SELECT
 	SEMICOLON
 	SET
@@ -78,12 +115,26 @@ const (
 	T_INT
 	T_BIGINT
 	T_VARCHAR
+	T_TEXT
+	T_BLOB
+	T_BYTEA
+	T_REAL
+	T_DOUBLE
+	T_DECIMAL
+	T_NUMERIC
+	T_DATE
+	T_TIME
+	T_TIMESTAMP
 	TABLE
 	THEN
+	TO
+	TRANSACTION
+	TRUNCATE
 	UNION
 	UNIQUE
 	UPDATE
 	USE
+	VACUUM
 	VALUES
 	WHEN
 	WHERE
@@ -103,9 +154,12 @@ func (t TokenType) IsLiteral() bool {
 
 var Tokens = map[TokenType]string{
 	INT:   "an integer",
+	NUM:   "a number",
+	FLOAT: "a floating-point number",
 	STR:   "a string",
 	TRUE:  "TRUE",
 	FALSE: "FALSE",
+	NULL:  "NULL",
 
 	IDENT: "an identifier",
 
@@ -119,67 +173,112 @@ var Tokens = map[TokenType]string{
 	LT:     "<",
 	LTE:    "<=",
 	GTE:    ">=",
+	IS_NOT: "IS NOT",
 	LPAREN: "(",
 	RPAREN: ")",
+	PARAM:  "?",
+	PLUS:   "+",
+	MINUS:  "-",
 
-	AS:        "AS",
-	ASC:       "ASC",
-	AVG:       "AVG",
-	BEGIN:     "BEGIN",
-	BY:        "BY",
-	CASE:      "CASE",
-	COMMA:     ",",
-	COMMIT:    "COMMIT",
-	COUNT:     "COUNT",
-	CREATE:    "CREATE",
-	DATABASE:  "DATABASE",
-	DELETE:    "DELETE",
-	DESC:      "DESC",
-	DISTINCT:  "DISTINCT",
-	DOT:       ".",
-	ELSE:      "ELSE",
-	END:       "END",
-	EXISTS:    "EXISTS",
-	FROM:      "FROM",
-	FULL:      "FULL",
-	GROUP:     "GROUP",
-	HAVING:    "HAVING",
-	IN:        "IN",
-	INNER:     "INNER",
-	INSERT:    "INSERT",
-	INTO:      "INTO",
-	JOIN:      "JOIN",
-	LEFT:      "LEFT",
-	LIKE:      "LIKE",
-	LIMIT:     "LIMIT",
-	MAX:       "MAX",
-	MIN:       "MIN",
-	NOT:       "NOT",
-	NULL:      "NULL",
-	OFFSET:    "OFFSET",
-	ON:        "ON",
-	ORDER:     "ORDER",
-	OUTER:     "OUTER",
-	RIGHT:     "RIGHT",
-	SELECT:    "SELECT",
-	SEMICOLON: ";",
-	SET:       "SET",
-	SHOW:      "SHOW",
-	SUM:       "SUM",
-	T_BOOL:    "BOOLEAN",
-	T_INT:     "INT",
-	T_BIGINT:  "BIGINT",
-	T_VARCHAR: "VARCHAR",
-	TABLE:     "TABLE",
-	THEN:      "THEN",
-	UNION:     "UNION",
-	UNIQUE:    "UNIQUE",
-	UPDATE:    "UPDATE",
-	USE:       "USE",
-	VALUES:    "VALUES",
-	WHEN:      "WHEN",
-	WHERE:     "WHERE",
-	WITH:      "WITH",
+	ACTION:      "ACTION",
+	ADD:         "ADD",
+	ALTER:       "ALTER",
+	ANALYZE:     "ANALYZE",
+	AS:          "AS",
+	ASC:         "ASC",
+	AVG:         "AVG",
+	BEGIN:       "BEGIN",
+	BY:          "BY",
+	CASCADE:     "CASCADE",
+	CASE:        "CASE",
+	CHECK:       "CHECK",
+	CHECKPOINT:  "CHECKPOINT",
+	COLUMN:      "COLUMN",
+	COMMA:       ",",
+	COMMIT:      "COMMIT",
+	COUNT:       "COUNT",
+	CREATE:      "CREATE",
+	DATABASE:    "DATABASE",
+	DEFAULT:     "DEFAULT",
+	DELETE:      "DELETE",
+	DESC:        "DESC",
+	DISTINCT:    "DISTINCT",
+	DOT:         ".",
+	DROP:        "DROP",
+	ELSE:        "ELSE",
+	END:         "END",
+	EXISTS:      "EXISTS",
+	EXPLAIN:     "EXPLAIN",
+	EXTRACT:     "EXTRACT",
+	FIRST:       "FIRST",
+	FOREIGN:     "FOREIGN",
+	FROM:        "FROM",
+	FULL:        "FULL",
+	GROUP:       "GROUP",
+	HAVING:      "HAVING",
+	IF:          "IF",
+	IN:          "IN",
+	INDEX:       "INDEX",
+	INNER:       "INNER",
+	INSERT:      "INSERT",
+	INTERVAL:    "INTERVAL",
+	INTO:        "INTO",
+	IS:          "IS",
+	JOIN:        "JOIN",
+	KEY:         "KEY",
+	LAST:        "LAST",
+	LEFT:        "LEFT",
+	LIKE:        "LIKE",
+	LIMIT:       "LIMIT",
+	MAX:         "MAX",
+	MIN:         "MIN",
+	NO:          "NO",
+	NOT:         "NOT",
+	NULLS:       "NULLS",
+	OFFSET:      "OFFSET",
+	ON:          "ON",
+	ORDER:       "ORDER",
+	OUTER:       "OUTER",
+	PRECISION:   "PRECISION",
+	PRIMARY:     "PRIMARY",
+	REFERENCES:  "REFERENCES",
+	RENAME:      "RENAME",
+	RESTRICT:    "RESTRICT",
+	RIGHT:       "RIGHT",
+	ROLLBACK:    "ROLLBACK",
+	SELECT:      "SELECT",
+	SEMICOLON:   ";",
+	SET:         "SET",
+	SHOW:        "SHOW",
+	SUM:         "SUM",
+	T_BOOL:      "BOOLEAN",
+	T_INT:       "INT",
+	T_BIGINT:    "BIGINT",
+	T_VARCHAR:   "VARCHAR",
+	T_TEXT:      "TEXT",
+	T_BLOB:      "BLOB",
+	T_BYTEA:     "BYTEA",
+	T_REAL:      "REAL",
+	T_DOUBLE:    "DOUBLE",
+	T_DECIMAL:   "DECIMAL",
+	T_NUMERIC:   "NUMERIC",
+	T_DATE:      "DATE",
+	T_TIME:      "TIME",
+	T_TIMESTAMP: "TIMESTAMP",
+	TABLE:       "TABLE",
+	THEN:        "THEN",
+	TO:          "TO",
+	TRANSACTION: "TRANSACTION",
+	TRUNCATE:    "TRUNCATE",
+	UNION:       "UNION",
+	UNIQUE:      "UNIQUE",
+	UPDATE:      "UPDATE",
+	USE:         "USE",
+	VACUUM:      "VACUUM",
+	VALUES:      "VALUES",
+	WHEN:        "WHEN",
+	WHERE:       "WHERE",
+	WITH:        "WITH",
 }
 
 var keywords map[string]TokenType
@@ -216,10 +315,16 @@ func (t Token) Val() (interface{}, error) {
 			return nil, err
 		}
 		return int64(intVal), nil
+	case NUM:
+		return DecimalLiteral(t.Text), nil
+	case FLOAT:
+		return strconv.ParseFloat(t.Text, 64)
 	case TRUE:
 		return true, nil
 	case FALSE:
 		return false, nil
+	case NULL:
+		return nil, nil
 	}
 	return nil, fmt.Errorf("unsupported token type: %v", t)
 }
@@ -268,6 +373,78 @@ func (tl *TokenList) Advance() bool {
 	return true
 }
 
+// Bind replaces the parameter tokens in the token list with literal tokens
+// holding the values in args. `?` parameters take values in the order they
+// appear in, `$n` parameters take the nth value. Values must be int64,
+// float64, DecimalLiteral, string, []byte, bool, time.Time or nil for NULL.
+// Times are bound as strings, which are converted to dates and timestamps
+// where they're stored or compared with them.
+func (tl *TokenList) Bind(args []interface{}) error {
+	next, used := 0, 0
+	for i, tok := range tl.tokens {
+		if tok.Type != PARAM {
+			continue
+		}
+
+		idx := next
+		if tok.Text == "?" {
+			next++
+		} else {
+			n, err := strconv.Atoi(tok.Text[1:])
+			if err != nil || n < 1 {
+				return syntaxErr(tok)
+			}
+			idx = n - 1
+		}
+		if idx >= len(args) {
+			return fmt.Errorf("%w: no value for parameter %d", ErrParamCount, idx+1)
+		}
+		if idx+1 > used {
+			used = idx + 1
+		}
+
+		lit := Token{Line: tok.Line, Column: tok.Column}
+		switch v := args[idx].(type) {
+		case int64:
+			lit.Type = INT
+			lit.Text = strconv.FormatInt(v, 10)
+		case float64:
+			lit.Type = FLOAT
+			lit.Text = strconv.FormatFloat(v, 'g', -1, 64)
+		case DecimalLiteral:
+			lit.Type = NUM
+			lit.Text = string(v)
+		case string:
+			lit.Type = STR
+			lit.Text = v
+		case []byte:
+			lit.Type = STR
+			lit.Text = string(v)
+		case time.Time:
+			lit.Type = STR
+			lit.Text = v.Format("2006-01-02 15:04:05.999999Z07:00")
+		case nil:
+			lit.Type = NULL
+			lit.Text = Tokens[NULL]
+		case bool:
+			lit.Type = FALSE
+			lit.Text = Tokens[FALSE]
+			if v {
+				lit.Type = TRUE
+				lit.Text = Tokens[TRUE]
+			}
+		default:
+			return fmt.Errorf("%w: %T", ErrParamType, v)
+		}
+		tl.tokens[i] = lit
+	}
+
+	if used != len(args) {
+		return fmt.Errorf("%w: expected %d value(s), got %d", ErrParamCount, used, len(args))
+	}
+	return nil
+}
+
 type tokenScanner struct {
 	s   Scanner
 	cur rune
@@ -296,11 +473,27 @@ func (ts *tokenScanner) Cur() Token {
 	case Int:
 		tok.Type = INT
 		tok.Text = ts.s.TokenText()
+	case Float:
+		tok.Type = NUM
+		tok.Text = ts.s.TokenText()
+		if strings.ContainsAny(tok.Text, "eE") {
+			tok.Type = FLOAT
+		}
 	case DelimIdent:
 		tok.Type = IDENT
 		// strip quotes
 		tok.Text = ts.s.TokenText()
 		tok.Text = tok.Text[1 : len(tok.Text)-1]
+	case '$':
+		// numbered parameter, e.g. $1
+		if p := ts.s.Peek(); '0' <= p && p <= '9' {
+			ts.Next()
+			tok.Type = PARAM
+			tok.Text = "$" + ts.s.TokenText()
+			break
+		}
+		tok.Type = STR
+		tok.Text = ts.s.TokenText()
 	default:
 		tok.Text = ts.s.TokenText()
 		if kw, isKw := keywords[strings.ToUpper(ts.s.TokenText())]; isKw {
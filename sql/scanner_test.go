@@ -1,9 +1,11 @@
 package sql
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestScanSelect(t *testing.T) {
@@ -1362,3 +1364,197 @@ func TestScanAggregation(t *testing.T) {
 		}
 	}
 }
+
+func TestScanParams(t *testing.T) {
+
+	const src = `SELECT ?, $12, '?', $`
+
+	ts := NewTokenScanner(strings.NewReader(src))
+
+	expected := []Token{
+		{Type: SELECT, Text: "SELECT"},
+		{Type: PARAM, Text: "?"},
+		{Type: COMMA, Text: ","},
+		{Type: PARAM, Text: "$12"},
+		{Type: COMMA, Text: ","},
+		{Type: STR, Text: "?"},
+		{Type: COMMA, Text: ","},
+		{Type: STR, Text: "$"},
+	}
+
+	for _, tok := range expected {
+		if !ts.Next() {
+			t.Fatal("ran out of tokens")
+		}
+		actual := ts.Cur()
+		if tok.Type != actual.Type || tok.Text != actual.Text {
+			t.Errorf("token does not match. expected: %+v actual: %+v", tok, actual)
+		}
+	}
+	if ts.Next() {
+		t.Errorf("there are still tokens that remain in scanner. next: %s", ts.Cur().Text)
+	}
+}
+
+func TestScanNumbers(t *testing.T) {
+
+	const src = `12 1.50 .5 2.5e-1 3E2`
+
+	ts := NewTokenScanner(strings.NewReader(src))
+
+	expected := []struct {
+		tok Token
+		val interface{}
+	}{
+		{Token{Type: INT, Text: "12"}, int64(12)},
+		{Token{Type: NUM, Text: "1.50"}, DecimalLiteral("1.50")},
+		{Token{Type: NUM, Text: ".5"}, DecimalLiteral(".5")},
+		{Token{Type: FLOAT, Text: "2.5e-1"}, 0.25},
+		{Token{Type: FLOAT, Text: "3E2"}, 300.0},
+	}
+
+	for _, exp := range expected {
+		if !ts.Next() {
+			t.Fatal("ran out of tokens")
+		}
+		actual := ts.Cur()
+		if exp.tok.Type != actual.Type || exp.tok.Text != actual.Text {
+			t.Errorf("token does not match. expected: %+v actual: %+v", exp.tok, actual)
+			continue
+		}
+		val, err := actual.Val()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val != exp.val {
+			t.Errorf("value does not match. expected: %v actual: %v", exp.val, val)
+		}
+	}
+	if ts.Next() {
+		t.Errorf("there are still tokens that remain in scanner. next: %s", ts.Cur().Text)
+	}
+}
+
+func TestScanArithmetic(t *testing.T) {
+
+	const src = `d+INTERVAL '1 day' - -2`
+
+	ts := NewTokenScanner(strings.NewReader(src))
+
+	expected := []Token{
+		{Type: IDENT, Text: "d"},
+		{Type: PLUS, Text: "+"},
+		{Type: INTERVAL, Text: "INTERVAL"},
+		{Type: STR, Text: "1 day"},
+		{Type: MINUS, Text: "-"},
+		{Type: MINUS, Text: "-"},
+		{Type: INT, Text: "2"},
+	}
+
+	for _, exp := range expected {
+		if !ts.Next() {
+			t.Fatal("ran out of tokens")
+		}
+		if actual := ts.Cur(); exp.Type != actual.Type || exp.Text != actual.Text {
+			t.Errorf("token does not match. expected: %+v actual: %+v", exp, actual)
+		}
+	}
+	if ts.Next() {
+		t.Errorf("there are still tokens that remain in scanner. next: %s", ts.Cur().Text)
+	}
+}
+
+func TestTokenListBind(t *testing.T) {
+
+	cases := []struct {
+		input  string
+		args   []interface{}
+		expect []Token
+		err    error
+	}{
+		{
+			input: `? ? ?`,
+			args:  []interface{}{int64(-5), "it's", true},
+			expect: []Token{
+				{Type: INT, Text: "-5"},
+				{Type: STR, Text: "it's"},
+				{Type: TRUE, Text: "TRUE"},
+			},
+		},
+		{
+			input: `$2 $1 $2`,
+			args:  []interface{}{false, "a"},
+			expect: []Token{
+				{Type: STR, Text: "a"},
+				{Type: FALSE, Text: "FALSE"},
+				{Type: STR, Text: "a"},
+			},
+		},
+		{
+			input: `?`,
+			args:  []interface{}{nil},
+			expect: []Token{
+				{Type: NULL, Text: "NULL"},
+			},
+		},
+		{
+			input: `? ?`,
+			args:  []interface{}{int64(1)},
+			err:   ErrParamCount,
+		},
+		{
+			input: `?`,
+			args:  []interface{}{int64(1), int64(2)},
+			err:   ErrParamCount,
+		},
+		{
+			input: `$0`,
+			args:  []interface{}{int64(1)},
+			err:   ErrSyntax,
+		},
+		{
+			input: `? ?`,
+			args:  []interface{}{1.5, DecimalLiteral("-12.50")},
+			expect: []Token{
+				{Type: FLOAT, Text: "1.5"},
+				{Type: NUM, Text: "-12.50"},
+			},
+		},
+		{
+			input: `?`,
+			args:  []interface{}{float32(1.5)},
+			err:   ErrParamType,
+		},
+		{
+			input: `?`,
+			args:  []interface{}{time.Date(2026, 1, 2, 3, 4, 5, 600000000, time.FixedZone("", 3600))},
+			expect: []Token{
+				{Type: STR, Text: "2026-01-02 03:04:05.6+01:00"},
+			},
+		},
+	}
+
+	for _, test := range cases {
+		ts := NewTokenScanner(strings.NewReader(test.input))
+		tl := TokenList{}
+		for ts.Next() {
+			tl.Add(ts.Cur())
+		}
+
+		err := tl.Bind(test.args)
+		if !errors.Is(err, test.err) {
+			t.Errorf("binding %s: expected error %v, got %v", test.input, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		for i, tok := range test.expect {
+			actual := tl.tokens[i]
+			if tok.Type != actual.Type || tok.Text != actual.Text {
+				t.Errorf("binding %s: token does not match. expected: %+v actual: %+v", test.input, tok, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrColumnExists     = errors.New("column already exists")
+	ErrColumnInUse      = errors.New("column is in use")
+	ErrLastColumn       = errors.New("table must have at least one column")
+	ErrAlterSystemTable = errors.New("system tables can't be altered")
+)
+
+// AddColumn adds field fd to the end of table tableName. The rows stored
+// before the field was added hold its default, or null if it has none.
+func (rs *RelationService) AddColumn(tableName string, fd FieldDef) (WALBatch, error) {
+	if err := rs.stealPages(); err != nil {
+		return nil, err
+	}
+
+	r, err := rs.alterableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNewColumnName(r, fd.Name); err != nil {
+		return nil, err
+	}
+	if fd.Default != nil {
+		def, err := fd.Convert(fd.Default)
+		if err != nil {
+			return nil, fmt.Errorf("%w: default of column %s", err, fd.Name)
+		}
+		fd.Default = def
+		if err := fd.Validate(fd.Default); err != nil {
+			return nil, fmt.Errorf("%w: default of column %s", err, fd.Name)
+		}
+	}
+	if fd.NotNull && fd.Default == nil {
+		// the existing rows would hold null
+		empty, err := rs.isEmpty(tableName)
+		if err != nil {
+			return nil, err
+		}
+		if !empty {
+			return nil, fmt.Errorf("%w: column %s", ErrNotNullViolation, fd.Name)
+		}
+	}
+
+	vals := map[string]interface{}{
+		"table_name":   tableName,
+		"field_name":   fd.Name,
+		"field_type":   int64(fd.DataType),
+		"field_length": fd.Len,
+		"not_null":     fd.NotNull,
+		"field_scale":  fd.Scale,
+	}
+	if fd.Default != nil {
+		lit, err := formatLiteral(fd.Default)
+		if err != nil {
+			return nil, err
+		}
+		vals["default_value"] = lit
+	}
+
+	// schema rows are read in the order they were inserted, which puts the
+	// field after the ones stored in the existing rows
+	return rs.insertSysRow(schemaTableName, &schemaTableSchema, vals)
+}
+
+// DropColumn drops column col from table tableName. The column can't be part
+// of an index or a foreign key. The values of the column stay in the rows
+// stored before it was dropped, but they can no longer be read.
+func (rs *RelationService) DropColumn(tableName string, col string) (WALBatch, error) {
+	if err := rs.stealPages(); err != nil {
+		return nil, err
+	}
+
+	r, err := rs.alterableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := findFieldDef(r, col); err != nil {
+		return nil, err
+	}
+	if len(r.Fields) == 1 {
+		return nil, ErrLastColumn
+	}
+
+	indexes, err := rs.Indexes(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		if containsString(idx.Columns, col) {
+			return nil, fmt.Errorf("%w: %s is used by index %s", ErrColumnInUse, col, idx.Name)
+		}
+	}
+	fks, err := rs.ForeignKeys(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range fks {
+		if containsString(fk.Columns, col) {
+			return nil, fmt.Errorf("%w: %s is used by foreign key %s", ErrColumnInUse, col, fk.Name)
+		}
+	}
+	refs, err := rs.ReferencingKeys(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range refs {
+		if containsString(fk.RefColumns, col) {
+			return nil, fmt.Errorf("%w: %s is used by foreign key %s", ErrColumnInUse, col, fk.Name)
+		}
+	}
+
+	var walLogs WALBatch
+
+	logs, err := rs.updateSysRows(schemaTableName, &schemaTableSchema, func(vals map[string]interface{}) sysRowAction {
+		if vals["table_name"] != tableName || vals["field_name"] != col || vals["dropped"] == true {
+			return keepSysRow
+		}
+		vals["dropped"] = true
+		return updateSysRow
+	})
+	walLogs = append(walLogs, logs...)
+	if err != nil {
+		return walLogs, err
+	}
+
+	logs, err = rs.updateSysRows(StatsTableName, &statsTableSchema, func(vals map[string]interface{}) sysRowAction {
+		if vals["table_name"] != tableName || vals["column_name"] != col {
+			return keepSysRow
+		}
+		return deleteSysRow
+	})
+	walLogs = append(walLogs, logs...)
+	return walLogs, err
+}
+
+// RenameColumn renames column oldName of table tableName to newName. The
+// indexes, foreign keys and statistics that refer to the column are updated
+// to the new name. CHECK constraints are left for the caller to update.
+func (rs *RelationService) RenameColumn(tableName string, oldName string, newName string) (WALBatch, error) {
+	if err := rs.stealPages(); err != nil {
+		return nil, err
+	}
+
+	r, err := rs.alterableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := findFieldDef(r, oldName); err != nil {
+		return nil, err
+	}
+	if err := checkNewColumnName(r, newName); err != nil {
+		return nil, err
+	}
+
+	updates := []struct {
+		tableName string
+		schema    *Relation
+		tableCol  string
+		col       string
+	}{
+		{schemaTableName, &schemaTableSchema, "table_name", "field_name"},
+		{indexTableName, &indexTableSchema, "table_name", "column_name"},
+		{foreignKeyTableName, &foreignKeyTableSchema, "table_name", "column_name"},
+		{foreignKeyTableName, &foreignKeyTableSchema, "ref_table_name", "ref_column_name"},
+		{StatsTableName, &statsTableSchema, "table_name", "column_name"},
+	}
+
+	var walLogs WALBatch
+	for _, u := range updates {
+		logs, err := rs.updateSysRows(u.tableName, u.schema, func(vals map[string]interface{}) sysRowAction {
+			if vals[u.tableCol] != tableName || vals[u.col] != oldName || vals["dropped"] == true {
+				return keepSysRow
+			}
+			vals[u.col] = newName
+			return updateSysRow
+		})
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
+	return walLogs, nil
+}
+
+// RenameTable renames table oldName to newName along with the references to
+// it in the system tables.
+func (rs *RelationService) RenameTable(oldName string, newName string) (WALBatch, error) {
+	if err := rs.stealPages(); err != nil {
+		return nil, err
+	}
+
+	if _, err := rs.alterableSchema(oldName); err != nil {
+		return nil, err
+	}
+	if _, err := rs.getRelationFileOffset(newName); err != ErrTableNotExist {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %s", ErrTableAlreadyExist, newName)
+	}
+
+	updates := []struct {
+		tableName string
+		schema    *Relation
+		col       string
+	}{
+		{pageTableName, &pageTableSchema, "table_name"},
+		{schemaTableName, &schemaTableSchema, "table_name"},
+		{indexTableName, &indexTableSchema, "table_name"},
+		{checkTableName, &checkTableSchema, "table_name"},
+		{foreignKeyTableName, &foreignKeyTableSchema, "table_name"},
+		{foreignKeyTableName, &foreignKeyTableSchema, "ref_table_name"},
+		{StatsTableName, &statsTableSchema, "table_name"},
+	}
+
+	var walLogs WALBatch
+	for _, u := range updates {
+		logs, err := rs.updateSysRows(u.tableName, u.schema, func(vals map[string]interface{}) sysRowAction {
+			if vals[u.col] != oldName {
+				return keepSysRow
+			}
+			vals[u.col] = newName
+			return updateSysRow
+		})
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
+	return walLogs, nil
+}
+
+// UpdateCheck replaces the expression of CHECK constraint check.Name of
+// table check.TableName with check.Expr.
+func (rs *RelationService) UpdateCheck(check *Check) (WALBatch, error) {
+	if err := rs.stealPages(); err != nil {
+		return nil, err
+	}
+
+	found := false
+	walLogs, err := rs.updateSysRows(checkTableName, &checkTableSchema, func(vals map[string]interface{}) sysRowAction {
+		if vals["table_name"] != check.TableName || vals["check_name"] != check.Name {
+			return keepSysRow
+		}
+		found = true
+		vals["expression"] = check.Expr
+		return updateSysRow
+	})
+	if err != nil {
+		return walLogs, err
+	}
+	if !found {
+		return walLogs, fmt.Errorf("unable to find check constraint %s", check.Name)
+	}
+	return walLogs, nil
+}
+
+// alterableSchema returns the schema of table tableName, which must not be a
+// system table.
+func (rs *RelationService) alterableSchema(tableName string) (*Relation, error) {
+	if isSystemTable(tableName) {
+		return nil, fmt.Errorf("%w: %s", ErrAlterSystemTable, tableName)
+	}
+	if _, err := rs.getRelationFileOffset(tableName); err != nil {
+		return nil, err
+	}
+	return rs.getRelationSchema(tableName)
+}
+
+// checkNewColumnName returns an error if a column named name can't be added
+// to table r.
+func checkNewColumnName(r *Relation, name string) error {
+	if name == RowIDColumn {
+		return fmt.Errorf("%w: %s", ErrReservedColumn, name)
+	}
+	if _, err := findFieldDef(r, name); err == nil {
+		return fmt.Errorf("%w: %s", ErrColumnExists, name)
+	}
+	return nil
+}
+
+// isEmpty returns true if table tableName has no rows.
+func (rs *RelationService) isEmpty(tableName string) (bool, error) {
+	it, _, err := rs.Scan(tableName)
+	if err != nil {
+		return false, err
+	}
+	row, err := it.Next()
+	if err != nil {
+		return false, err
+	}
+	return row == nil, nil
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
+
+// sysRowAction is what updateSysRows does with a row of a system table.
+type sysRowAction int
+
+const (
+	keepSysRow sysRowAction = iota
+	updateSysRow
+	deleteSysRow
+)
+
+// insertSysRow inserts a row with values vals into system table tableName,
+// which has schema r, and returns the WAL entries of the insert.
+func (rs *RelationService) insertSysRow(tableName string, r *Relation, vals map[string]interface{}) (WALBatch, error) {
+	tuple := Tuple{
+		Relation: r,
+		Vals:     vals,
+	}
+	buf, err := tuple.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	key := rowIDToKey(rs.fs.getLastKey() + 1)
+	if err := rs.fs.incrementLastKey(); err != nil {
+		return nil, err
+	}
+	return rs.insertTableCell(tableName, key, buf.Bytes())
+}
+
+// updateSysRows calls f with the values of each row of system table
+// tableName, which has schema r, and updates the row with the values f
+// leaves behind or deletes it as f returns. It returns the WAL entries of
+// the changes. Tables that were added after the database was created are
+// skipped if they don't exist yet.
+func (rs *RelationService) updateSysRows(tableName string, r *Relation, f func(vals map[string]interface{}) sysRowAction) (WALBatch, error) {
+	var walLogs WALBatch
+
+	fileOffset := rs.fs.pageTableRoot
+	if tableName != pageTableName {
+		offset, err := rs.getRelationFileOffset(tableName)
+		if err == ErrTableNotExist {
+			return walLogs, nil
+		}
+		if err != nil {
+			return walLogs, err
+		}
+		fileOffset = uint64(offset)
+	}
+
+	pg, err := rs.fs.fetch(fileOffset)
+	if err != nil {
+		return walLogs, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	// the updated rows may split their leaves, so they're written once the
+	// scan is done
+	var keys, vals [][]byte
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: r,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		switch f(tuple.Vals) {
+		case updateSysRow:
+			buf, err := tuple.Encode()
+			if err != nil {
+				return StopScanning, err
+			}
+			keys = append(keys, cell.key)
+			vals = append(vals, buf.Bytes())
+		case deleteSysRow:
+			walLogs = append(walLogs, rs.markCellDeleted(cell))
+		}
+		return KeepScanning, nil
+	})
+	if err != nil {
+		return walLogs, err
+	}
+
+	for i, key := range keys {
+		logs, err := rs.updateTableCell(tableName, key, vals[i])
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
+	return walLogs, nil
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 )
@@ -32,7 +33,7 @@ func (b *BTree) setRoot(node *btreeNode) {
 func (b *BTree) insert(value []byte) (uint32, uint64, error) {
 	nextKey := b.store.getLastKey() + 1
 	nextLSN := b.store.nextLSN()
-	err := b.insertKey(nextKey, nextLSN, value)
+	err := b.insertKey(rowIDToKey(nextKey), nextLSN, value)
 	if err := b.store.incrementLastKey(); err != nil {
 		return 0, nextLSN, err
 	}
@@ -40,21 +41,38 @@ func (b *BTree) insert(value []byte) (uint32, uint64, error) {
 	return nextKey, nextLSN, err
 }
 
-func (b *BTree) insertKey(key uint32, nextLSN uint64, value []byte) error {
+func (b *BTree) insertKey(key []byte, nextLSN uint64, value []byte) error {
+	return b.modifyKey(key, nextLSN, func(pg *btreeNode) error {
+		return insertCell(pg, key, value)
+	})
+}
+
+// updateKey replaces the value of key. The leaf that holds key is split if
+// the new value no longer fits on its page.
+func (b *BTree) updateKey(key []byte, nextLSN uint64, value []byte) error {
+	return b.modifyKey(key, nextLSN, func(pg *btreeNode) error {
+		return pg.updateCell(key, value)
+	})
+}
+
+// modifyKey calls op with the leaf where key belongs and splits the nodes on
+// the path to it that end up full.
+func (b *BTree) modifyKey(key []byte, nextLSN uint64, op func(pg *btreeNode) error) error {
 	pg, err := b.getRoot()
 	if err != nil {
 		return err
 	}
 	if pg.isLeaf {
-		return b.insertLeaf(nil, pg, key, nextLSN, value)
+		return b.modifyLeaf(nil, pg, nextLSN, op)
 	}
-	return b.insertInternal(nil, pg, key, nextLSN, value)
+	return b.modifyInternal(nil, pg, key, nextLSN, op)
 }
 
-func (b *BTree) insertInternal(parent *btreeNode, curNode *btreeNode, key uint32, nextLSN uint64, value []byte) error {
+func (b *BTree) modifyInternal(parent *btreeNode, curNode *btreeNode, key []byte, nextLSN uint64, op func(pg *btreeNode) error) error {
 	offset, found := curNode.findCellOffsetByKey(key)
 	if found {
-		return fmt.Errorf("%w for key: %d", errKeyAlreadyExists, key)
+		// keys equal to the separator key live in the right subtree
+		offset++
 	}
 
 	var fileOffset uint64
@@ -70,11 +88,11 @@ func (b *BTree) insertInternal(parent *btreeNode, curNode *btreeNode, key uint32
 	}
 
 	if childPg.isLeaf {
-		if err := b.insertLeaf(curNode, childPg, key, nextLSN, value); err != nil {
+		if err := b.modifyLeaf(curNode, childPg, nextLSN, op); err != nil {
 			return err
 		}
 	} else {
-		if err := b.insertInternal(curNode, childPg, key, nextLSN, value); err != nil {
+		if err := b.modifyInternal(curNode, childPg, key, nextLSN, op); err != nil {
 			return err
 		}
 	}
@@ -103,30 +121,40 @@ func (b *BTree) insertInternal(parent *btreeNode, curNode *btreeNode, key uint32
 		if err := parent.appendInternalCell(newKey, curNode.fileOffset); err != nil {
 			return err
 		}
-	} else {
-		if err := parent.appendInternalCell(newKey, parent.rightOffset); err != nil {
-			return err
-		}
-		parent.setRightMostKey(newPg.fileOffset)
+	} else if err := linkSplitNode(parent, curNode, newPg, newKey); err != nil {
+		return err
 	}
 
 	newPg.markDirty(nextLSN)
+	curNode.markDirty(nextLSN)
 	parent.markDirty(nextLSN)
 
-	if parent != nil {
-		parent.markDirty(nextLSN)
-	}
-
 	return nil
 }
 
-func (b *BTree) insertLeaf(parent *btreeNode, curNode *btreeNode, key uint32, nextLSN uint64, value []byte) error {
-	offset, found := curNode.findCellOffsetByKey(key)
-	if found {
-		return fmt.Errorf("%w for key: %d", errKeyAlreadyExists, key)
+// insertCell adds a cell for key to leaf node pg, reusing the cell of a
+// deleted row with the same key.
+func insertCell(pg *btreeNode, key []byte, value []byte) error {
+	offset, found := pg.findCellOffsetByKey(key)
+	if !found {
+		return pg.insertLeafCell(uint32(offset), key, value)
+	}
+	cell := pg.leafCells[pg.offsets[offset]]
+	if !cell.deleted {
+		return fmt.Errorf("%w for key: %v", errKeyAlreadyExists, key)
+	}
+	// the key was deleted and is reused, e.g. by a row that has the
+	// primary key of a deleted row
+	if err := checkRowSizeLimit(value); err != nil {
+		return err
 	}
+	cell.setValue(value)
+	cell.deleted = false
+	return nil
+}
 
-	if err := curNode.insertLeafCell(uint32(offset), key, value); err != nil {
+func (b *BTree) modifyLeaf(parent *btreeNode, curNode *btreeNode, nextLSN uint64, op func(pg *btreeNode) error) error {
+	if err := op(curNode); err != nil {
 		return err
 	}
 
@@ -146,11 +174,23 @@ func (b *BTree) insertLeaf(parent *btreeNode, curNode *btreeNode, key uint32, ne
 		return err
 	}
 
-	oldRSibFileOffset := curNode.rSibFileOffset
-	curNode.hasRSib = true
-	curNode.rSibFileOffset = newPg.fileOffset
+	// splice the new page into the sibling chain
+	newPg.hasRSib = curNode.hasRSib
+	newPg.rSibFileOffset = curNode.rSibFileOffset
 	newPg.hasLSib = true
 	newPg.lSibFileOffset = curNode.fileOffset
+	curNode.hasRSib = true
+	curNode.rSibFileOffset = newPg.fileOffset
+
+	if newPg.hasRSib {
+		// update old right sibling's left pointer
+		rightSib, err := b.store.fetch(newPg.rSibFileOffset)
+		if err != nil {
+			return err
+		}
+		rightSib.lSibFileOffset = newPg.fileOffset
+		rightSib.markDirty(nextLSN)
+	}
 
 	if parent == nil {
 		parent = &btreeNode{}
@@ -162,46 +202,38 @@ func (b *BTree) insertLeaf(parent *btreeNode, curNode *btreeNode, key uint32, ne
 		if err := parent.appendInternalCell(newKey, curNode.fileOffset); err != nil {
 			return err
 		}
-	} else {
-		if newKey > parent.getRightmostKey() {
-			if err := parent.appendInternalCell(newKey, parent.rightOffset); err != nil {
-				return err
-			}
-			parent.setRightMostKey(newPg.fileOffset)
-		} else {
-			newPg.hasRSib = true
-			newPg.rSibFileOffset = oldRSibFileOffset
-
-			offset, found := parent.findCellOffsetByKey(newKey)
-			if found {
-				return fmt.Errorf("%w for key: %d", errKeyAlreadyExists, newKey)
-			}
-			if err := parent.insertInternalCell(uint32(offset), newKey, newPg.fileOffset); err != nil {
-				return err
-			}
-
-			// update old right sibling's left pointer
-			rightSib, err := b.store.fetch(oldRSibFileOffset)
-			if err != nil {
-				return err
-			}
-			rightSib.lSibFileOffset = newPg.fileOffset
-			rightSib.markDirty(nextLSN)
-		}
+	} else if err := linkSplitNode(parent, curNode, newPg, newKey); err != nil {
+		return err
 	}
 
 	newPg.markDirty(nextLSN)
 	curNode.markDirty(nextLSN)
-
-	if parent != nil {
-		parent.markDirty(nextLSN)
-	}
+	parent.markDirty(nextLSN)
 
 	return nil
 }
 
-func (b *BTree) findCell(key uint32) (*leafCell, error) {
+// linkSplitNode adds a separator key to parent that points to newPg, the node
+// that was split off from curNode.
+func linkSplitNode(parent *btreeNode, curNode *btreeNode, newPg *btreeNode, newKey []byte) error {
+	if parent.rightOffset == curNode.fileOffset {
+		if err := parent.appendInternalCell(newKey, parent.rightOffset); err != nil {
+			return err
+		}
+		parent.setRightMostKey(newPg.fileOffset)
+		return nil
+	}
+
+	offset, found := parent.findCellOffsetByKey(newKey)
+	if found {
+		return fmt.Errorf("%w for key: %v", errKeyAlreadyExists, newKey)
+	}
+	return parent.insertInternalCell(uint32(offset), newKey, newPg.fileOffset)
+}
 
+// findLeaf returns the leaf node where key is located, or would be located if
+// it were inserted.
+func (b *BTree) findLeaf(key []byte) (*btreeNode, error) {
 	pg, err := b.getRoot()
 	if err != nil {
 		return nil, err
@@ -210,7 +242,7 @@ func (b *BTree) findCell(key uint32) (*leafCell, error) {
 	for !pg.isLeaf {
 		// todo: replace with binary search
 		for i := 0; i <= len(pg.offsets); i++ {
-			if i == len(pg.offsets) || key < pg.cellKey(pg.offsets[i]) {
+			if i == len(pg.offsets) || bytes.Compare(key, pg.cellKey(pg.offsets[i])) < 0 {
 				var fileOffset uint64
 				if i == len(pg.offsets) {
 					fileOffset = pg.rightOffset
@@ -227,6 +259,15 @@ func (b *BTree) findCell(key uint32) (*leafCell, error) {
 		}
 	}
 
+	return pg, nil
+}
+
+func (b *BTree) findCell(key []byte) (*leafCell, error) {
+	pg, err := b.findLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+
 	offset, found := pg.findCellOffsetByKey(key)
 	if !found {
 		return nil, nil
@@ -242,10 +283,19 @@ func (b *BTree) findCell(key uint32) (*leafCell, error) {
 	return cell, nil
 }
 
-func (b *BTree) scanRight(f func(kv *leafCell) (ScanAction, error)) error {
+// cursor walks the leaf cells of a B+ tree from left to right, one cell at a
+// time. The tree must not be modified while the cursor is in use.
+type cursor struct {
+	b   *BTree
+	pg  *btreeNode
+	pos int
+}
+
+// first returns a cursor positioned before the left-most cell of the tree.
+func (b *BTree) first() (*cursor, error) {
 	pg, err := b.getRoot()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// find left-most leaf node
@@ -255,37 +305,147 @@ func (b *BTree) scanRight(f func(kv *leafCell) (ScanAction, error)) error {
 		var err error
 		pg, err = b.store.fetch(fileOffset)
 		if err != nil {
-			return fmt.Errorf("table scan error: %w", err)
+			return nil, fmt.Errorf("table scan error: %w", err)
 		}
 	}
 
-	for {
-		for _, offset := range pg.offsets {
-			cell := pg.leafCells[offset]
+	return &cursor{b: b, pg: pg}, nil
+}
+
+// seek returns a cursor positioned before the first cell whose key is greater
+// than or equal to key.
+func (b *BTree) seek(key []byte) (*cursor, error) {
+	pg, err := b.findLeaf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	start, _ := pg.findCellOffsetByKey(key)
+
+	return &cursor{b: b, pg: pg, pos: start}, nil
+}
+
+// next advances the cursor and returns the cell it lands on, skipping deleted
+// cells. It returns nil once the cursor has moved past the right-most cell.
+func (c *cursor) next() (*leafCell, error) {
+	for c.pg != nil {
+		for c.pos < len(c.pg.offsets) {
+			cell := c.pg.leafCells[c.pg.offsets[c.pos]]
+			c.pos++
 			if cell.deleted {
 				continue
 			}
-			cell.pg = pg
-			nextScan, err := f(cell)
-			if err != nil {
-				return err
-			}
-			if nextScan == StopScanning {
-				return nil
+			cell.pg = c.pg
+			return cell, nil
+		}
+		if !c.pg.hasRSib {
+			c.pg = nil
+			break
+		}
+		pg, err := c.b.store.fetch(c.pg.rSibFileOffset)
+		if err != nil {
+			return nil, fmt.Errorf("table scan error: %w", err)
+		}
+		c.pg, c.pos = pg, 0
+	}
+	return nil, nil
+}
+
+// scan calls f with each remaining cell until f stops the scan or the cursor
+// moves past the right-most cell.
+func (c *cursor) scan(f func(kv *leafCell) (ScanAction, error)) error {
+	for {
+		cell, err := c.next()
+		if err != nil {
+			return err
+		}
+		if cell == nil {
+			return nil
+		}
+		nextScan, err := f(cell)
+		if err != nil {
+			return err
+		}
+		if nextScan == StopScanning {
+			return nil
+		}
+	}
+}
+
+// scanFrom iterates over the cells in key order, starting from the first cell
+// whose key is greater than or equal to key.
+func (b *BTree) scanFrom(key []byte, f func(kv *leafCell) (ScanAction, error)) error {
+	c, err := b.seek(key)
+	if err != nil {
+		return err
+	}
+	return c.scan(f)
+}
+
+func (b *BTree) scanRight(f func(kv *leafCell) (ScanAction, error)) error {
+	c, err := b.first()
+	if err != nil {
+		return err
+	}
+	return c.scan(f)
+}
+
+// estimateCells estimates the number of cells in the tree without reading all
+// of its leaves. The internal nodes are walked to count the leaves, and the
+// left-most leaf is assumed to hold as many cells as the others.
+func (b *BTree) estimateCells() (int64, error) {
+	pg, err := b.getRoot()
+	if err != nil {
+		return 0, err
+	}
+
+	level := []*btreeNode{pg}
+	for {
+		if level[0].isLeaf {
+			return int64(len(level)) * liveCells(level[0]), nil
+		}
+
+		firstChild, err := b.store.fetch(level[0].internalCells[level[0].offsets[0]].fileOffset)
+		if err != nil {
+			return 0, err
+		}
+
+		if firstChild.isLeaf {
+			leaves := int64(0)
+			for _, node := range level {
+				leaves += int64(len(node.offsets)) + 1
 			}
+			return leaves * liveCells(firstChild), nil
 		}
-		if pg.hasRSib {
-			var err error
-			pg, err = b.store.fetch(pg.rSibFileOffset)
+
+		var next []*btreeNode
+		for _, node := range level {
+			for _, offset := range node.offsets {
+				child, err := b.store.fetch(node.internalCells[offset].fileOffset)
+				if err != nil {
+					return 0, err
+				}
+				next = append(next, child)
+			}
+			child, err := b.store.fetch(node.rightOffset)
 			if err != nil {
-				return fmt.Errorf("table scan error: %w", err)
+				return 0, err
 			}
-		} else {
-			break
+			next = append(next, child)
 		}
+		level = next
 	}
+}
 
-	return nil
+// liveCells returns the number of cells in leaf node pg that aren't deleted.
+func liveCells(pg *btreeNode) int64 {
+	count := int64(0)
+	for _, cell := range pg.leafCells {
+		if !cell.deleted {
+			count++
+		}
+	}
+	return count
 }
 
 func (b *BTree) scanLeft(f func(kv *leafCell) (ScanAction, error)) error {
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"bytes"
+	"errors"
+	"math/rand"
 	"testing"
 )
 
@@ -37,14 +39,14 @@ func TestBTree(t *testing.T) {
 	}
 
 	for _, expect := range tbl {
-		err := bt.insertKey(expect.key, 0, expect.val)
+		err := bt.insertKey(rowIDToKey(expect.key), 0, expect.val)
 		if err != nil {
 			t.Fatalf("got insertion error for %s: %s", expect.val, err.Error())
 		}
 	}
 
 	for _, expect := range tbl {
-		val, err := bt.findCell(expect.key)
+		val, err := bt.findCell(rowIDToKey(expect.key))
 		if err != nil {
 			t.Fatalf("got retrieval error for %d: %s", expect.key, err.Error())
 		}
@@ -103,3 +105,244 @@ func TestBTree(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBTreeRandomInsert(t *testing.T) {
+
+	rootPg := &btreeNode{isLeaf: true}
+
+	bt := &BTree{
+		store: &memoryStore{},
+	}
+
+	if err := bt.store.append(rootPg); err != nil {
+		t.Fatal(err)
+	}
+
+	bt.setRoot(rootPg)
+
+	// insert enough keys in random order to produce a tree with several
+	// internal node levels
+	const keyCount = 5000
+	rnd := rand.New(rand.NewSource(1))
+	for _, i := range rnd.Perm(keyCount) {
+		key := rowIDToKey(uint32(i))
+		if err := bt.insertKey(key, 0, key); err != nil {
+			t.Fatalf("got insertion error for %d: %s", i, err.Error())
+		}
+	}
+
+	for i := 0; i < keyCount; i++ {
+		cell, err := bt.findCell(rowIDToKey(uint32(i)))
+		if err != nil {
+			t.Fatalf("got retrieval error for %d: %s", i, err.Error())
+		}
+		if cell == nil {
+			t.Fatalf("did not find key %d", i)
+		}
+	}
+
+	expect := uint32(0)
+	err := bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		if keyToRowID(cell.key) != expect {
+			t.Fatalf("scan out of order. expected key %d, got %d", expect, keyToRowID(cell.key))
+		}
+		expect++
+		return KeepScanning, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect != keyCount {
+		t.Fatalf("expected to scan %d keys, scanned %d", keyCount, expect)
+	}
+
+	err = bt.scanLeft(func(cell *leafCell) (ScanAction, error) {
+		expect--
+		if keyToRowID(cell.key) != expect {
+			t.Fatalf("reverse scan out of order. expected key %d, got %d", expect, keyToRowID(cell.key))
+		}
+		return KeepScanning, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect != 0 {
+		t.Fatalf("reverse scan stopped early at key %d", expect)
+	}
+
+	expect = 2500
+	err = bt.scanFrom(rowIDToKey(expect), func(cell *leafCell) (ScanAction, error) {
+		if keyToRowID(cell.key) != expect {
+			t.Fatalf("scan from key out of order. expected key %d, got %d", expect, keyToRowID(cell.key))
+		}
+		expect++
+		return KeepScanning, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect != keyCount {
+		t.Fatalf("expected scan from key to end at %d, ended at %d", keyCount, expect)
+	}
+}
+
+func TestCursorSkipsDeletedCells(t *testing.T) {
+
+	rootPg := &btreeNode{isLeaf: true}
+
+	bt := &BTree{
+		store: &memoryStore{},
+	}
+
+	if err := bt.store.append(rootPg); err != nil {
+		t.Fatal(err)
+	}
+
+	bt.setRoot(rootPg)
+
+	const keyCount = 1000
+	for i := 0; i < keyCount; i++ {
+		key := rowIDToKey(uint32(i))
+		if err := bt.insertKey(key, 0, key); err != nil {
+			t.Fatalf("got insertion error for %d: %s", i, err.Error())
+		}
+	}
+
+	// delete the even keys
+	for i := 0; i < keyCount; i += 2 {
+		cell, err := bt.findCell(rowIDToKey(uint32(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cell.deleted = true
+	}
+
+	c, err := bt.first()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := uint32(1)
+	for {
+		cell, err := c.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cell == nil {
+			break
+		}
+		if keyToRowID(cell.key) != expect {
+			t.Fatalf("cursor out of order. expected key %d, got %d", expect, keyToRowID(cell.key))
+		}
+		expect += 2
+	}
+	if expect != keyCount+1 {
+		t.Fatalf("expected cursor to end after key %d, ended before %d", keyCount-1, expect)
+	}
+
+	// an exhausted cursor stays exhausted
+	if cell, err := c.next(); cell != nil || err != nil {
+		t.Fatalf("expected exhausted cursor, got %v %v", cell, err)
+	}
+}
+
+func TestInsertKeyReusesDeletedKey(t *testing.T) {
+
+	rootPg := &btreeNode{isLeaf: true}
+
+	bt := &BTree{
+		store: &memoryStore{},
+	}
+
+	if err := bt.store.append(rootPg); err != nil {
+		t.Fatal(err)
+	}
+
+	bt.setRoot(rootPg)
+
+	key := []byte("key")
+	if err := bt.insertKey(key, 0, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.insertKey(key, 0, []byte("new")); !errors.Is(err, errKeyAlreadyExists) {
+		t.Fatalf("expected errKeyAlreadyExists, got %v", err)
+	}
+
+	cell, err := bt.findCell(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell.deleted = true
+
+	if err := bt.insertKey(key, 0, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	cell, err = bt.findCell(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cell == nil || string(cell.valueBytes) != "new" {
+		t.Fatalf("expected reinserted cell with the new value, got %v", cell)
+	}
+}
+
+func TestUpdateKeySplitsLeaf(t *testing.T) {
+
+	rootPg := &btreeNode{isLeaf: true}
+
+	bt := &BTree{
+		store: &memoryStore{},
+	}
+
+	if err := bt.store.append(rootPg); err != nil {
+		t.Fatal(err)
+	}
+
+	bt.setRoot(rootPg)
+
+	for i := 0; i < leafCellsPerPage; i++ {
+		if err := bt.insertKey(rowIDToKey(uint32(i)), 0, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if root, err := bt.getRoot(); err != nil || !root.isLeaf {
+		t.Fatalf("expected the cells to fit on the root leaf, got %v", err)
+	}
+
+	// the larger value no longer fits on the page
+	val := bytes.Repeat([]byte("a"), maxValueSize)
+	if err := bt.updateKey(rowIDToKey(10), 0, val); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := bt.getRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.isLeaf {
+		t.Fatal("expected the root leaf to split")
+	}
+
+	count := 0
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		expect := []byte("hello")
+		if keyToRowID(cell.key) == 10 {
+			expect = val
+		}
+		if !bytes.Equal(cell.valueBytes, expect) {
+			t.Errorf("value mismatch for key %v. got %s, expected %s", cell.key, cell.valueBytes, expect)
+		}
+		count++
+		return KeepScanning, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != leafCellsPerPage {
+		t.Errorf("expected %d cells, got %d", leafCellsPerPage, count)
+	}
+
+	if err := bt.updateKey(rowIDToKey(uint32(leafCellsPerPage)), 0, val); err == nil {
+		t.Error("expected an error updating a key that doesn't exist")
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+)
+
+const checkTableName = "sys_checks"
+
+var checkTableSchema = Relation{
+	Fields: []FieldDef{
+		{
+			Name:     "table_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "check_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "expression",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+	},
+}
+
+// Check is a CHECK constraint. Expr is the constraint's search condition as
+// SQL text, which the rows of the table must not make false.
+type Check struct {
+	Name      string
+	TableName string
+	Expr      string
+}
+
+// Checks returns the CHECK constraints defined on table tableName.
+func (rs *RelationService) Checks(tableName string) ([]*Check, error) {
+	fileOffset, err := rs.getRelationFileOffset(checkTableName)
+	if errors.Is(err, ErrTableNotExist) {
+		// database was created before check constraint support was added
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	var checks []*Check
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: &checkTableSchema,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		if tuple.Vals["table_name"] == tableName {
+			checks = append(checks, &Check{
+				Name:      tuple.Vals["check_name"].(string),
+				TableName: tableName,
+				Expr:      tuple.Vals["expression"].(string),
+			})
+		}
+		return KeepScanning, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
+
+// ensureCheckTable creates the check table for databases that were created
+// before check constraint support was added.
+func (rs *RelationService) ensureCheckTable() error {
+	_, err := rs.getRelationFileOffset(checkTableName)
+	if err != ErrTableNotExist {
+		return err
+	}
+	return rs.createTable(&checkTableSchema, checkTableName)
+}
+
+func (rs *RelationService) insertCheckTable(checks []*Check) error {
+	var rows []map[string]interface{}
+	for _, check := range checks {
+		rows = append(rows, map[string]interface{}{
+			"table_name": check.TableName,
+			"check_name": check.Name,
+			"expression": check.Expr,
+		})
+	}
+	return rs.insertSysRows(checkTableName, &checkTableSchema, rows)
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MaxDecimalPrecision is the largest number of digits a DECIMAL column can
+// hold, which keeps its coefficient within an int64.
+const MaxDecimalPrecision = 18
+
+var (
+	ErrNumericOutOfRange = errors.New("numeric value out of range")
+	ErrInvalidDecimal    = errors.New("invalid decimal value")
+)
+
+// Decimal is an exact decimal number, the value of a DECIMAL column. It holds
+// an integer coefficient scaled by a power of ten, so 12.50 is 1250 with a
+// scale of 2. Decimals can be compared with ==, but only the ones with the
+// same scale are equal that way; Cmp compares the numbers they stand for.
+type Decimal struct {
+	coef  int64
+	scale uint8
+}
+
+// NewDecimal returns the decimal coef * 10^-scale.
+func NewDecimal(coef int64, scale int) Decimal {
+	return Decimal{coef: coef, scale: uint8(scale)}
+}
+
+// ParseDecimal parses a decimal number such as 12.50 or -3. The scale of the
+// decimal is the number of digits after the decimal point.
+func ParseDecimal(s string) (Decimal, error) {
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return Decimal{}, fmt.Errorf("%w: %s", ErrInvalidDecimal, s)
+	}
+	intPart, frac, _ := strings.Cut(digits, ".")
+	if intPart == "" && frac == "" {
+		return Decimal{}, fmt.Errorf("%w: %s", ErrInvalidDecimal, s)
+	}
+	for _, c := range intPart + frac {
+		if c < '0' || c > '9' {
+			return Decimal{}, fmt.Errorf("%w: %s", ErrInvalidDecimal, s)
+		}
+	}
+	if len(frac) > MaxDecimalPrecision {
+		return Decimal{}, fmt.Errorf("%w: %s", ErrNumericOutOfRange, s)
+	}
+	var coef int64
+	if digits := strings.TrimLeft(intPart+frac, "0"); digits != "" {
+		var err error
+		if coef, err = strconv.ParseInt(digits, 10, 64); err != nil {
+			return Decimal{}, fmt.Errorf("%w: %s", ErrNumericOutOfRange, s)
+		}
+	}
+	if strings.HasPrefix(s, "-") {
+		coef = -coef
+	}
+	return NewDecimal(coef, len(frac)), nil
+}
+
+// DecimalFromRat returns r rounded half away from zero to scale digits after
+// the decimal point.
+func DecimalFromRat(r *big.Rat, scale int) (Decimal, error) {
+	num := new(big.Int).Abs(r.Num())
+	num.Mul(num, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+	coef, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		coef.Add(coef, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		coef.Neg(coef)
+	}
+	if !coef.IsInt64() {
+		return Decimal{}, ErrNumericOutOfRange
+	}
+	return NewDecimal(coef.Int64(), scale), nil
+}
+
+// DecimalFromFloat returns f rounded half away from zero to scale digits
+// after the decimal point.
+func DecimalFromFloat(f float64, scale int) (Decimal, error) {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	if !ok {
+		return Decimal{}, fmt.Errorf("%w: %v", ErrNumericOutOfRange, f)
+	}
+	return DecimalFromRat(r, scale)
+}
+
+// Scale returns the number of digits after the decimal point.
+func (d Decimal) Scale() int {
+	return int(d.scale)
+}
+
+// Precision returns the number of significant digits of the coefficient.
+func (d Decimal) Precision() int {
+	coef := d.coef
+	if coef < 0 {
+		coef = -coef
+	}
+	n := 1
+	for coef >= 10 {
+		coef /= 10
+		n++
+	}
+	return n
+}
+
+// Rescale returns d rounded half away from zero to scale digits after the
+// decimal point.
+func (d Decimal) Rescale(scale int) (Decimal, error) {
+	if scale == int(d.scale) {
+		return d, nil
+	}
+	return DecimalFromRat(d.Rat(), scale)
+}
+
+// Rat returns the value of d as a fraction.
+func (d Decimal) Rat() *big.Rat {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.scale)), nil)
+	return new(big.Rat).SetFrac(big.NewInt(d.coef), denom)
+}
+
+// Float64 returns the floating-point number nearest to d.
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+// Cmp compares the values of d and other, returning -1, 0 or 1.
+func (d Decimal) Cmp(other Decimal) int {
+	return d.Rat().Cmp(other.Rat())
+}
+
+func (d Decimal) String() string {
+	digits := strconv.FormatInt(d.coef, 10)
+	sign := ""
+	if d.coef < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	if d.scale == 0 {
+		return sign + digits
+	}
+	if pad := int(d.scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// GobEncode encodes d as its coefficient followed by its scale, since gob
+// skips the unexported fields of a struct.
+func (d Decimal) GobEncode() ([]byte, error) {
+	buf := make([]byte, 9)
+	binary.LittleEndian.PutUint64(buf, uint64(d.coef))
+	buf[8] = d.scale
+	return buf, nil
+}
+
+// GobDecode decodes a decimal encoded by GobEncode.
+func (d *Decimal) GobDecode(buf []byte) error {
+	if len(buf) != 9 {
+		return fmt.Errorf("%w: expected 9 bytes, got %d", ErrInvalidDecimal, len(buf))
+	}
+	d.coef = int64(binary.LittleEndian.Uint64(buf))
+	d.scale = buf[8]
+	return nil
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestParseDecimal(t *testing.T) {
+
+	tbl := []struct {
+		input  string
+		expect Decimal
+		str    string
+		err    error
+	}{
+		{input: "12.50", expect: NewDecimal(1250, 2), str: "12.50"},
+		{input: "-3", expect: NewDecimal(-3, 0), str: "-3"},
+		{input: "+0.05", expect: NewDecimal(5, 2), str: "0.05"},
+		{input: ".5", expect: NewDecimal(5, 1), str: "0.5"},
+		{input: "7.", expect: NewDecimal(7, 0), str: "7"},
+		{input: "000", expect: NewDecimal(0, 0), str: "0"},
+		{input: "-0.001", expect: NewDecimal(-1, 3), str: "-0.001"},
+		{input: "999999999999999999", expect: NewDecimal(999999999999999999, 0), str: "999999999999999999"},
+		{input: "99999999999999999999", err: ErrNumericOutOfRange},
+		{input: "", err: ErrInvalidDecimal},
+		{input: ".", err: ErrInvalidDecimal},
+		{input: "--1", err: ErrInvalidDecimal},
+		{input: "1.2.3", err: ErrInvalidDecimal},
+		{input: "1e3", err: ErrInvalidDecimal},
+	}
+
+	for _, test := range tbl {
+		actual, err := ParseDecimal(test.input)
+		if !errors.Is(err, test.err) {
+			t.Errorf("parsing %q: expected error %v, got %v", test.input, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if actual != test.expect {
+			t.Errorf("parsing %q: expected %#v, got %#v", test.input, test.expect, actual)
+		}
+		if actual.String() != test.str {
+			t.Errorf("parsing %q: expected %s, got %s", test.input, test.str, actual.String())
+		}
+	}
+}
+
+func TestDecimalRounding(t *testing.T) {
+
+	tbl := []struct {
+		val    Decimal
+		scale  int
+		expect Decimal
+	}{
+		{NewDecimal(1235, 3), 2, NewDecimal(124, 2)},
+		{NewDecimal(-1235, 3), 2, NewDecimal(-124, 2)},
+		{NewDecimal(1234, 3), 2, NewDecimal(123, 2)},
+		{NewDecimal(5, 1), 0, NewDecimal(1, 0)},
+		{NewDecimal(12, 0), 3, NewDecimal(12000, 3)},
+	}
+	for _, test := range tbl {
+		actual, err := test.val.Rescale(test.scale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != test.expect {
+			t.Errorf("rescaling %s to %d: expected %s, got %s", test.val, test.scale, test.expect, actual)
+		}
+	}
+
+	if _, err := NewDecimal(1<<62, 0).Rescale(2); !errors.Is(err, ErrNumericOutOfRange) {
+		t.Errorf("expected error %v, got %v", ErrNumericOutOfRange, err)
+	}
+
+	third, err := DecimalFromRat(big.NewRat(1, 3), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third != NewDecimal(3333, 4) {
+		t.Errorf("expected 0.3333, got %s", third)
+	}
+
+	d, err := DecimalFromFloat(0.1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != NewDecimal(10, 2) {
+		t.Errorf("expected 0.10, got %s", d)
+	}
+}
+
+func TestDecimalCmp(t *testing.T) {
+	if NewDecimal(150, 2).Cmp(NewDecimal(15, 1)) != 0 {
+		t.Error("expected 1.50 to equal 1.5")
+	}
+	if NewDecimal(-2, 0).Cmp(NewDecimal(-15, 1)) >= 0 {
+		t.Error("expected -2 to be less than -1.5")
+	}
+	if p := NewDecimal(-12345, 2).Precision(); p != 5 {
+		t.Errorf("expected a precision of 5, got %d", p)
+	}
+}
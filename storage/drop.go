@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrTableReferenced = errors.New("table is referenced by a foreign key")
+
+// DropTable removes table tableName along with its indexes, constraints and
+// statistics, and puts the pages of the table and its indexes on the free
+// list. A table that other tables refer to with foreign keys can't be
+// dropped.
+func (rs *RelationService) DropTable(tableName string) error {
+	pages, err := rs.dropTable(tableName)
+	if err != nil {
+		return err
+	}
+	return rs.releasePages(pages)
+}
+
+// dropTable removes table tableName from the catalog and returns the pages
+// of its trees. The store is locked the way a statement locks it, so that
+// its pages aren't flushed while they're changed.
+func (rs *RelationService) dropTable(tableName string) ([]uint64, error) {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
+	trees, err := rs.droppableTrees(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []uint64
+	for name, offset := range trees {
+		treePages, err := rs.treePages(offset)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, treePages...)
+		chainPages, err := rs.chainPages(offset, false)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, chainPages...)
+		if _, err := rs.deleteSysRows(rs.fs.pageTableRoot, &pageTableSchema, "table_name", name); err != nil {
+			return nil, err
+		}
+	}
+
+	catalog := []struct {
+		tableName string
+		schema    *Relation
+	}{
+		{schemaTableName, &schemaTableSchema},
+		{indexTableName, &indexTableSchema},
+		{checkTableName, &checkTableSchema},
+		{foreignKeyTableName, &foreignKeyTableSchema},
+		{StatsTableName, &statsTableSchema},
+	}
+	for _, c := range catalog {
+		fileOffset, err := rs.getRelationFileOffset(c.tableName)
+		if errors.Is(err, ErrTableNotExist) {
+			// database was created before the table was added
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rs.deleteSysRows(uint64(fileOffset), c.schema, "table_name", tableName); err != nil {
+			return nil, err
+		}
+	}
+
+	return pages, nil
+}
+
+// TruncateTable removes all the rows of table tableName and the entries of
+// its indexes, and puts the pages they were stored in on the free list. A
+// table that other tables refer to with foreign keys can't be truncated.
+func (rs *RelationService) TruncateTable(tableName string) error {
+	pages, err := rs.truncateTable(tableName)
+	if err != nil {
+		return err
+	}
+	return rs.releasePages(pages)
+}
+
+// truncateTable replaces the trees of table tableName with empty ones and
+// returns the pages of the old trees. The store is locked like it is by
+// dropTable.
+func (rs *RelationService) truncateTable(tableName string) ([]uint64, error) {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
+	trees, err := rs.droppableTrees(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []uint64
+	for name, offset := range trees {
+		treePages, err := rs.treePages(offset)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, treePages...)
+		chainPages, err := rs.chainPages(offset, false)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, chainPages...)
+
+		root, err := rs.createPage()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := rs.updatePageTable(root.getFileOffset(), name); err != nil {
+			return nil, err
+		}
+	}
+
+	// the statistics describe the removed rows
+	fileOffset, err := rs.getRelationFileOffset(StatsTableName)
+	if err != nil && !errors.Is(err, ErrTableNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if _, err := rs.deleteSysRows(uint64(fileOffset), &statsTableSchema, "table_name", tableName); err != nil {
+			return nil, err
+		}
+	}
+
+	return pages, nil
+}
+
+// droppableTrees returns the root page offsets of the trees of table
+// tableName and its indexes by name, after checking that the table can be
+// dropped.
+func (rs *RelationService) droppableTrees(tableName string) (map[string]uint64, error) {
+	if isSystemTable(tableName) {
+		return nil, fmt.Errorf("%w: %s", ErrAlterSystemTable, tableName)
+	}
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := rs.ReferencingKeys(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range refs {
+		// a table may refer to itself
+		if fk.TableName != tableName {
+			return nil, fmt.Errorf("%w: %s is referenced by %s", ErrTableReferenced, tableName, fk.Name)
+		}
+	}
+
+	return rs.tableTrees(tableName, uint64(fileOffset))
+}
+
+// tableTrees returns the root page offsets of the trees of table tableName,
+// whose tree is rooted at offset root, and its indexes by name.
+func (rs *RelationService) tableTrees(tableName string, root uint64) (map[string]uint64, error) {
+	trees := map[string]uint64{tableName: root}
+
+	indexes, err := rs.Indexes(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		fileOffset, err := rs.getRelationFileOffset(idx.Name)
+		if errors.Is(err, ErrTableNotExist) {
+			// the table serves as its primary key index
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		trees[idx.Name] = uint64(fileOffset)
+	}
+
+	return trees, nil
+}
+
+// treePages returns the offsets of the pages of the tree rooted at offset
+// root.
+func (rs *RelationService) treePages(root uint64) ([]uint64, error) {
+	pg, err := rs.fs.fetch(root)
+	if err != nil {
+		return nil, err
+	}
+	pages := []uint64{root}
+	if pg.isLeaf {
+		return pages, nil
+	}
+
+	children := []uint64{pg.rightOffset}
+	for _, cell := range pg.internalCells {
+		children = append(children, cell.fileOffset)
+	}
+	for _, child := range children {
+		childPages, err := rs.treePages(child)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, childPages...)
+	}
+	return pages, nil
+}
+
+// releasePages writes the catalog changes that stopped referring to pages to
+// disk and puts the pages on the free list. A checkpoint is taken first,
+// since the pages may be reused once they're free and the log entries that
+// refer to them must never be replayed.
+func (rs *RelationService) releasePages(pages []uint64) error {
+	if err := rs.Checkpoint(); err != nil {
+		return err
+	}
+	return rs.fs.freePages(pages)
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDropTableReusesPages(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTable := func(tableName string) {
+		r := &Relation{
+			Fields: []FieldDef{
+				{Name: "id", DataType: TypeInt},
+				{Name: "name", DataType: TypeVarchar, Len: 255},
+			},
+			Indexes: []*Index{
+				{Name: tableName + "_name_idx", TableName: tableName, Columns: []string{"name"}},
+			},
+		}
+		if err := rs.CreateTable(r, tableName); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			rs.StartTxn()
+			batch, err := rs.Insert(tableName, []string{"id", "name"}, []interface{}{int64(i), "name"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rs.FlushWALBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+			rs.EndTxn()
+		}
+	}
+
+	start := rs.fs.nextFreeOffset
+	createTable("people")
+	used := rs.fs.nextFreeOffset - start
+	if err := rs.DropTable("people"); err != nil {
+		t.Fatal(err)
+	}
+	rs.StartTxn()
+	if _, err := rs.Schema("people"); !errors.Is(err, ErrTableNotExist) {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+	if indexes, err := rs.Indexes("people"); err != nil || len(indexes) != 0 {
+		t.Errorf("expected no indexes, got %v %v", indexes, err)
+	}
+	rs.EndTxn()
+	if rs.fs.freeListHead == 0 {
+		t.Fatal("expected dropped pages on the free list")
+	}
+
+	// the free list survives reopening the database
+	fileEnd := rs.fs.nextFreeOffset
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	// the catalog tables may grow, but the rows and index entries are stored
+	// in the dropped pages
+	createTable("pets")
+	if grown := rs.fs.nextFreeOffset - fileEnd; grown >= used/2 {
+		t.Errorf("expected the new table to reuse the dropped pages, the file grew by %d bytes", grown)
+	}
+	rs.StartTxn()
+	rows, _, err := rs.Fetch("pets")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 100 {
+		t.Errorf("expected 100 rows, got %d", len(rows))
+	}
+
+	if err := rs.TruncateTable("pets"); err != nil {
+		t.Fatal(err)
+	}
+	rs.StartTxn()
+	rows, _, err = rs.Fetch("pets")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("expected no rows after truncate, got %d", len(rows))
+	}
+	rs.StartTxn()
+	rows, _, err = rs.FetchByIndex(&Index{Name: "pets_name_idx", TableName: "pets", Columns: []string{"name"}}, []interface{}{"name"})
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("expected no index entries after truncate, got %d", len(rows))
+	}
+}
+
+func TestDropDB(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("dropdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("dropdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DropDB("dropdb"); !errors.Is(err, ErrDBInUse) {
+		t.Errorf("expected ErrDBInUse error, got %v", err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DropDB("dropdb"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRelation("dropdb", false); !errors.Is(err, ErrDBNotExist) {
+		t.Errorf("expected ErrDBNotExist error, got %v", err)
+	}
+	if err := DropDB("dropdb"); !errors.Is(err, ErrDBNotExist) {
+		t.Errorf("expected ErrDBNotExist error, got %v", err)
+	}
+}
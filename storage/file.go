@@ -6,7 +6,13 @@ import (
 	"strings"
 )
 
-const dataPath = "data"
+var dataPath = "data"
+
+// SetDataDir sets the directory that databases are stored in. It must be
+// called before InitStorage and before any database is created or opened.
+func SetDataDir(path string) {
+	dataPath = path
+}
 
 func makeDBDir(db string) error {
 	err := os.MkdirAll(filepath.Join(dataPath, strings.ToLower(db)), 0755)
@@ -16,6 +22,10 @@ func makeDBDir(db string) error {
 	return nil
 }
 
+func removeDBDir(db string) error {
+	return os.RemoveAll(filepath.Join(dataPath, strings.ToLower(db)))
+}
+
 func listDBs() ([]string, error) {
 	f, err := os.Open(dataPath)
 	if err != nil {
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const foreignKeyTableName = "sys_foreign_keys"
+
+var ErrInvalidForeignKey = errors.New("invalid foreign key")
+
+var foreignKeyTableSchema = Relation{
+	Fields: []FieldDef{
+		{
+			Name:     "constraint_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "table_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "column_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "column_pos",
+			DataType: TypeInt,
+		},
+		{
+			Name:     "ref_table_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "ref_column_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "on_delete",
+			DataType: TypeInt,
+		},
+		{
+			Name:     "on_update",
+			DataType: TypeInt,
+		},
+	},
+}
+
+// ReferentialAction is what happens to the rows that refer to a parent row
+// when the parent row is deleted or its key is updated.
+type ReferentialAction uint8
+
+const (
+	// NoAction rejects the change while referring rows exist. It's the
+	// default.
+	NoAction ReferentialAction = iota
+	// Restrict rejects the change while referring rows exist.
+	Restrict
+	// Cascade deletes the referring rows, or updates their keys.
+	Cascade
+	// SetNull sets the keys of the referring rows to null.
+	SetNull
+)
+
+// ForeignKey is a FOREIGN KEY constraint, which requires that the values of
+// Columns in each row of TableName, unless one of them is null, match the
+// values of RefColumns in a row of RefTable.
+type ForeignKey struct {
+	Name       string
+	TableName  string
+	Columns    []string
+	RefTable   string
+	RefColumns []string
+	OnDelete   ReferentialAction
+	OnUpdate   ReferentialAction
+}
+
+// ForeignKeys returns the foreign keys of table tableName.
+func (rs *RelationService) ForeignKeys(tableName string) ([]*ForeignKey, error) {
+	return rs.foreignKeys("table_name", tableName)
+}
+
+// ReferencingKeys returns the foreign keys that refer to table tableName.
+func (rs *RelationService) ReferencingKeys(tableName string) ([]*ForeignKey, error) {
+	return rs.foreignKeys("ref_table_name", tableName)
+}
+
+// foreignKeys returns the foreign keys whose column col in the foreign key
+// table equals tableName.
+func (rs *RelationService) foreignKeys(col string, tableName string) ([]*ForeignKey, error) {
+	fileOffset, err := rs.getRelationFileOffset(foreignKeyTableName)
+	if errors.Is(err, ErrTableNotExist) {
+		// database was created before foreign key support was added
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	var fks []*ForeignKey
+	byName := make(map[string]*ForeignKey)
+
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: &foreignKeyTableSchema,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		if tuple.Vals[col] != tableName {
+			return KeepScanning, nil
+		}
+		name := tuple.Vals["constraint_name"].(string)
+		fk, ok := byName[name]
+		if !ok {
+			fk = &ForeignKey{
+				Name:      name,
+				TableName: tuple.Vals["table_name"].(string),
+				RefTable:  tuple.Vals["ref_table_name"].(string),
+				OnDelete:  ReferentialAction(tuple.Vals["on_delete"].(int64)),
+				OnUpdate:  ReferentialAction(tuple.Vals["on_update"].(int64)),
+			}
+			byName[name] = fk
+			fks = append(fks, fk)
+		}
+		pos := int(tuple.Vals["column_pos"].(int64))
+		for len(fk.Columns) <= pos {
+			fk.Columns = append(fk.Columns, "")
+			fk.RefColumns = append(fk.RefColumns, "")
+		}
+		fk.Columns[pos] = tuple.Vals["column_name"].(string)
+		fk.RefColumns[pos] = tuple.Vals["ref_column_name"].(string)
+		return KeepScanning, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fks, nil
+}
+
+// resolveForeignKey verifies that foreign key fk of table tableName, whose
+// schema is r, refers to the columns of a unique index of its parent table.
+// The foreign key refers to the parent's primary key if it names no parent
+// columns.
+func (rs *RelationService) resolveForeignKey(fk *ForeignKey, tableName string, r *Relation) error {
+	parent, indexes := r, r.Indexes
+	if fk.RefTable != tableName {
+		if _, err := rs.getRelationFileOffset(fk.RefTable); err != nil {
+			return fmt.Errorf("%w: %s", err, fk.RefTable)
+		}
+		var err error
+		if parent, err = rs.getRelationSchema(fk.RefTable); err != nil {
+			return err
+		}
+		if indexes, err = rs.Indexes(fk.RefTable); err != nil {
+			return err
+		}
+	}
+
+	if len(fk.RefColumns) == 0 {
+		for _, idx := range indexes {
+			if idx.Primary {
+				fk.RefColumns = idx.Columns
+			}
+		}
+		if len(fk.RefColumns) == 0 {
+			return fmt.Errorf("%w: %s has no primary key", ErrInvalidForeignKey, fk.RefTable)
+		}
+	}
+
+	if len(fk.Columns) != len(fk.RefColumns) {
+		return fmt.Errorf("%w: %s has %d columns but refers to %d", ErrInvalidForeignKey, fk.Name, len(fk.Columns), len(fk.RefColumns))
+	}
+
+	for i, col := range fk.Columns {
+		child, err := findFieldDef(r, col)
+		if err != nil {
+			return err
+		}
+		ref, err := findFieldDef(parent, fk.RefColumns[i])
+		if err != nil {
+			return err
+		}
+		if !comparableTypes(child, ref) {
+			return fmt.Errorf("%w: %s and %s.%s have different types", ErrInvalidForeignKey, col, fk.RefTable, ref.Name)
+		}
+	}
+
+	for _, idx := range indexes {
+		if idx.Unique && strings.Join(idx.Columns, ",") == strings.Join(fk.RefColumns, ",") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: no unique index on %s (%s)", ErrInvalidForeignKey, fk.RefTable, strings.Join(fk.RefColumns, ", "))
+}
+
+func findFieldDef(r *Relation, name string) (*FieldDef, error) {
+	for i := range r.Fields {
+		if r.Fields[i].Name == name {
+			return &r.Fields[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrFieldNotFound, name)
+}
+
+// comparableTypes reports whether the values of fields a and b can be
+// equal. Decimals are only comparable with the ones of the same scale, whose
+// index keys are encoded alike.
+func comparableTypes(a *FieldDef, b *FieldDef) bool {
+	isInt := func(t DataType) bool {
+		return t == TypeInt || t == TypeBigInt
+	}
+	if a.DataType == TypeDecimal && b.DataType == TypeDecimal {
+		return a.Scale == b.Scale
+	}
+	return a.DataType == b.DataType || isInt(a.DataType) && isInt(b.DataType)
+}
+
+// ensureForeignKeyTable creates the foreign key table for databases that
+// were created before foreign key support was added.
+func (rs *RelationService) ensureForeignKeyTable() error {
+	_, err := rs.getRelationFileOffset(foreignKeyTableName)
+	if err != ErrTableNotExist {
+		return err
+	}
+	return rs.createTable(&foreignKeyTableSchema, foreignKeyTableName)
+}
+
+func (rs *RelationService) insertForeignKeyTable(fks []*ForeignKey) error {
+	var rows []map[string]interface{}
+	for _, fk := range fks {
+		for pos, col := range fk.Columns {
+			rows = append(rows, map[string]interface{}{
+				"constraint_name": fk.Name,
+				"table_name":      fk.TableName,
+				"column_name":     col,
+				"column_pos":      int64(pos),
+				"ref_table_name":  fk.RefTable,
+				"ref_column_name": fk.RefColumns[pos],
+				"on_delete":       int64(fk.OnDelete),
+				"on_update":       int64(fk.OnUpdate),
+			})
+		}
+	}
+	return rs.insertSysRows(foreignKeyTableName, &foreignKeyTableSchema, rows)
+}
@@ -0,0 +1,836 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrIndexAlreadyExist = errors.New("index already exists")
+	ErrIndexNotExist     = errors.New("index does not exist")
+	ErrUniqueViolation   = errors.New("duplicate key violates unique index")
+)
+
+var indexTableSchema = Relation{
+	Fields: []FieldDef{
+		{
+			Name:     "index_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "table_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "column_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "column_pos",
+			DataType: TypeInt,
+		},
+		{
+			Name:     "is_unique",
+			DataType: TypeBoolean,
+		},
+		{
+			Name:     "is_primary",
+			DataType: TypeBoolean,
+		},
+	},
+}
+
+// Index is a secondary B+ tree index on one or more table columns. Each index
+// entry maps the encoded column values to the key of the indexed row. The
+// primary key index of a table that is clustered on its primary key has no
+// tree of its own, since the table's rows are stored in primary key order.
+type Index struct {
+	Name      string
+	TableName string
+	Columns   []string
+	Unique    bool
+	// Primary is true if the index backs the table's primary key
+	Primary bool
+}
+
+// keyVals returns the values of the indexed columns found in vals, ordered by
+// index column position.
+func (idx *Index) keyVals(vals map[string]interface{}) []interface{} {
+	ret := make([]interface{}, len(idx.Columns))
+	for i, col := range idx.Columns {
+		ret[i] = vals[col]
+	}
+	return ret
+}
+
+// encodeIndexKey encodes vals into a byte string that sorts in the same order
+// as the values themselves. Because each value is self-delimiting, the key for
+// a list of values is a prefix of the key for any longer list that starts
+// with the same values.
+func encodeIndexKey(vals []interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	for _, val := range vals {
+		if val == nil {
+			// nulls sort before all other values
+			buf.WriteByte(0)
+			continue
+		}
+		buf.WriteByte(1)
+
+		switch val := val.(type) {
+		case int64:
+			// flip the sign bit so that negative numbers sort first
+			if err := binary.Write(buf, binary.BigEndian, uint64(val)^(1<<63)); err != nil {
+				return nil, err
+			}
+		case float64:
+			// flip the sign bit of positive numbers and all bits of negative
+			// ones so that the bits sort like the numbers
+			if val == 0 {
+				// -0 equals 0
+				val = 0
+			}
+			bits := math.Float64bits(val)
+			if bits&(1<<63) == 0 {
+				bits ^= 1 << 63
+			} else {
+				bits = ^bits
+			}
+			if err := binary.Write(buf, binary.BigEndian, bits); err != nil {
+				return nil, err
+			}
+		case Decimal:
+			// the values of a column share a scale, so their coefficients
+			// sort like the values
+			if err := binary.Write(buf, binary.BigEndian, uint64(val.coef)^(1<<63)); err != nil {
+				return nil, err
+			}
+		case Date:
+			if err := binary.Write(buf, binary.BigEndian, uint32(val)^(1<<31)); err != nil {
+				return nil, err
+			}
+		case Time:
+			if err := binary.Write(buf, binary.BigEndian, uint64(val)^(1<<63)); err != nil {
+				return nil, err
+			}
+		case Timestamp:
+			if err := binary.Write(buf, binary.BigEndian, uint64(val)^(1<<63)); err != nil {
+				return nil, err
+			}
+		case Interval:
+			// intervals of equal length are equal, so they're keyed by
+			// their length
+			days, micros := val.Length()
+			if err := binary.Write(buf, binary.BigEndian, uint64(days)^(1<<63)); err != nil {
+				return nil, err
+			}
+			if err := binary.Write(buf, binary.BigEndian, uint64(micros)); err != nil {
+				return nil, err
+			}
+		case bool:
+			if val {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		case string:
+			// escape null bytes so that the terminator sorts before any
+			// string content
+			for i := 0; i < len(val); i++ {
+				buf.WriteByte(val[i])
+				if val[i] == 0 {
+					buf.WriteByte(0xFF)
+				}
+			}
+			buf.Write([]byte{0, 1})
+		default:
+			return nil, fmt.Errorf("unsupported index value type %T", val)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// indexEntryKey creates the key of the index entry for the row with key
+// rowKey. The row key is appended to the encoded values so that each entry
+// key is unique, even when several rows share the same indexed values.
+func indexEntryKey(vals []interface{}, rowKey []byte) ([]byte, error) {
+	key, err := encodeIndexKey(vals)
+	if err != nil {
+		return nil, err
+	}
+	key = append(key, rowKey...)
+	if err := checkKeySizeLimit(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// maxKeyFieldSize returns the size (in bytes) of the longest key that
+// encodeIndexKey encodes a value of field fd into. The keys of strings are
+// as long as the declared length of the field, not counting escaped null
+// bytes. TEXT and BLOB fields have no declared length, so their keys are
+// only checked when they're stored.
+func maxKeyFieldSize(fd *FieldDef) int {
+	size := 1 // null marker
+	switch fd.DataType {
+	case TypeBoolean:
+		size += 1
+	case TypeDate:
+		size += 4
+	case TypeInterval:
+		size += 16
+	case TypeVarchar:
+		size += int(fd.Len) + 2
+	case TypeText, TypeBlob:
+		size += 2
+	default:
+		size += 8
+	}
+	return size
+}
+
+// checkIndexKeySize returns ErrKeyTooLarge if the keys of index idx on
+// table r can exceed the maximum key size. The entries of an index that has
+// a tree of its own end in the key of the indexed row.
+func checkIndexKeySize(r *Relation, idx *Index) error {
+	keySize := func(cols []string) (int, error) {
+		size := 0
+		for _, col := range cols {
+			fd, err := findFieldDef(r, col)
+			if err != nil {
+				return 0, err
+			}
+			size += maxKeyFieldSize(fd)
+		}
+		return size, nil
+	}
+	size, err := keySize(idx.Columns)
+	if err != nil {
+		return err
+	}
+	if !r.clusteredOn(idx) {
+		rowKeySize := 4 // row ID
+		if r.PrimaryKey != nil {
+			if rowKeySize, err = keySize(r.PrimaryKey); err != nil {
+				return err
+			}
+		}
+		size += rowKeySize
+	}
+	if size > maxKeySize {
+		return fmt.Errorf("%w: index %s", ErrKeyTooLarge, idx.Name)
+	}
+	return nil
+}
+
+func hasNull(vals []interface{}) bool {
+	for _, val := range vals {
+		if val == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasKeyPrefix returns true if bt contains a live cell whose key starts with
+// prefix.
+func hasKeyPrefix(bt *BTree, prefix []byte) (bool, error) {
+	found := false
+	err := bt.scanFrom(prefix, func(cell *leafCell) (ScanAction, error) {
+		found = bytes.HasPrefix(cell.key, prefix)
+		return StopScanning, nil
+	})
+	return found, err
+}
+
+func (rs *RelationService) indexTree(indexName string) (*BTree, *btreeNode, error) {
+	fileOffset, err := rs.getRelationFileOffset(indexName)
+	if err != nil {
+		return nil, nil, err
+	}
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, nil, err
+	}
+	bt := &BTree{store: rs.fs}
+	bt.setRoot(pg)
+	return bt, pg, nil
+}
+
+// Indexes returns the indexes defined on table tableName.
+func (rs *RelationService) Indexes(tableName string) ([]*Index, error) {
+	fileOffset, err := rs.getRelationFileOffset(indexTableName)
+	if errors.Is(err, ErrTableNotExist) {
+		// database was created before index support was added
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	var indexes []*Index
+	byName := make(map[string]*Index)
+
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: &indexTableSchema,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		if tuple.Vals["table_name"] != tableName {
+			return KeepScanning, nil
+		}
+		name := tuple.Vals["index_name"].(string)
+		idx, ok := byName[name]
+		if !ok {
+			idx = &Index{
+				Name:      name,
+				TableName: tableName,
+				Unique:    tuple.Vals["is_unique"].(bool),
+				Primary:   tuple.Vals["is_primary"] == true,
+			}
+			byName[name] = idx
+			indexes = append(indexes, idx)
+		}
+		pos := int(tuple.Vals["column_pos"].(int64))
+		for len(idx.Columns) <= pos {
+			idx.Columns = append(idx.Columns, "")
+		}
+		idx.Columns[pos] = tuple.Vals["column_name"].(string)
+		return KeepScanning, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return indexes, nil
+}
+
+// CreateIndex creates index idx and populates it with the rows that already
+// exist in the indexed table. The index is built like a statement, so that
+// a failed build (e.g. on a unique violation) drops the pages it wrote and
+// gives back the pages it allocated.
+func (rs *RelationService) CreateIndex(idx *Index) error {
+	if err := rs.StartTxn(); err != nil {
+		return err
+	}
+	err := rs.createIndex(idx)
+	if err != nil {
+		if abortErr := rs.AbortStmt(); abortErr != nil {
+			err = fmt.Errorf("%w (unable to undo the index build: %s)", err, abortErr.Error())
+		}
+	}
+	rs.EndTxn()
+	if err != nil {
+		return err
+	}
+	return rs.fs.flushPages()
+}
+
+// createIndex adds index idx to the catalog without writing it to disk. The
+// store is locked like it is by createTable.
+func (rs *RelationService) createIndex(idx *Index) error {
+	_, err := rs.getRelationFileOffset(idx.Name)
+	if err != ErrTableNotExist {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrIndexAlreadyExist, idx.Name)
+	}
+
+	tableOffset, err := rs.getRelationFileOffset(idx.TableName)
+	if err != nil {
+		return err
+	}
+
+	schema, err := rs.getRelationSchema(idx.TableName)
+	if err != nil {
+		return err
+	}
+
+	for _, col := range idx.Columns {
+		found := false
+		for _, fd := range schema.Fields {
+			if fd.Name == col {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: %s", ErrFieldNotFound, col)
+		}
+	}
+
+	if err := checkIndexKeySize(schema, idx); err != nil {
+		return err
+	}
+
+	if err := rs.ensureIndexTable(); err != nil {
+		return err
+	}
+
+	// build the index before registering it so that a failed build (e.g. on
+	// a unique violation) leaves no trace in the catalog
+	pg, err := rs.createPage()
+	if err != nil {
+		return err
+	}
+
+	idxTree := &BTree{store: rs.fs}
+	idxTree.setRoot(pg)
+
+	tablePg, err := rs.fs.fetch(uint64(tableOffset))
+	if err != nil {
+		return err
+	}
+
+	tableTree := &BTree{store: rs.fs}
+	tableTree.setRoot(tablePg)
+
+	err = tableTree.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: schema,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		vals := idx.keyVals(tuple.Vals)
+		if idx.Unique && !hasNull(vals) {
+			prefix, err := encodeIndexKey(vals)
+			if err != nil {
+				return StopScanning, err
+			}
+			if found, err := hasKeyPrefix(idxTree, prefix); err != nil {
+				return StopScanning, err
+			} else if found {
+				return StopScanning, fmt.Errorf("%w %s", ErrUniqueViolation, idx.Name)
+			}
+		}
+		key, err := indexEntryKey(vals, cell.key)
+		if err != nil {
+			return StopScanning, err
+		}
+		if err := idxTree.insertKey(key, rs.fs.nextLSN(), cell.key); err != nil {
+			return StopScanning, err
+		}
+		rs.fs.incrLSN()
+		return KeepScanning, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	rootPg, err := idxTree.getRoot()
+	if err != nil {
+		return err
+	}
+	if err := rs.insertPageTable(rootPg, idx.Name); err != nil {
+		return err
+	}
+	if err := rs.insertIndexTable(idx); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DropIndex removes index indexName from the catalog and puts the pages of
+// its tree on the free list.
+func (rs *RelationService) DropIndex(indexName string) error {
+	pages, err := rs.dropIndex(indexName)
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return rs.fs.flushPages()
+	}
+	return rs.releasePages(pages)
+}
+
+// dropIndex removes index indexName from the catalog and returns the pages
+// of its tree, if it has one. The store is locked like it is by createTable.
+func (rs *RelationService) dropIndex(indexName string) ([]uint64, error) {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
+	fileOffset, err := rs.getRelationFileOffset(indexTableName)
+	if errors.Is(err, ErrTableNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotExist, indexName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := rs.deleteSysRows(uint64(fileOffset), &indexTableSchema, "index_name", indexName)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotExist, indexName)
+	}
+
+	// a primary key index that the table is clustered on has no tree
+	root, err := rs.getRelationFileOffset(indexName)
+	if errors.Is(err, ErrTableNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	pages, err := rs.treePages(uint64(root))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := rs.deleteSysRows(rs.fs.pageTableRoot, &pageTableSchema, "table_name", indexName); err != nil {
+		return nil, err
+	}
+
+	return pages, nil
+}
+
+// FetchByIndex returns the rows of the table indexed by idx whose leading
+// index column values equal vals.
+func (rs *RelationService) FetchByIndex(idx *Index, vals []interface{}) ([]*Row, []*Field, error) {
+	it, fields, err := rs.ScanByIndex(idx, vals)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := collectRows(it)
+	return rows, fields, err
+}
+
+// ScanByIndex returns an iterator over the rows of the table indexed by idx
+// whose leading index column values equal vals, in index key order.
+func (rs *RelationService) ScanByIndex(idx *Index, vals []interface{}) (RowIterator, []*Field, error) {
+	return rs.ScanByIndexRange(idx, vals, KeyRange{})
+}
+
+// KeyRange bounds the values of an index column. A nil bound leaves the
+// range open on that side. Null values fall outside of any range that has a
+// bound, since they can't be compared.
+type KeyRange struct {
+	Lower          interface{}
+	LowerInclusive bool
+	Upper          interface{}
+	UpperInclusive bool
+}
+
+// ScanByIndexRange returns an iterator over the rows of the table indexed by
+// idx whose leading index column values equal vals and whose value of the
+// next index column lies within rng, in index key order.
+func (rs *RelationService) ScanByIndexRange(idx *Index, vals []interface{}, rng KeyRange) (RowIterator, []*Field, error) {
+	logf("Index query. Index: %s", idx.Name)
+
+	prefix, err := encodeIndexKey(vals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the self-delimiting encoding makes the keys of the entries whose next
+	// column equals a bound start with the encoded bound
+	start := prefix
+	var lower, upper []byte
+	if rng.Lower != nil {
+		if lower, err = encodeIndexKey(append(vals[:len(vals):len(vals)], rng.Lower)); err != nil {
+			return nil, nil, err
+		}
+		start = lower
+	} else if rng.Upper != nil {
+		// skip the nulls, which sort first
+		start = append(prefix[:len(prefix):len(prefix)], 1)
+	}
+	if rng.Upper != nil {
+		if upper, err = encodeIndexKey(append(vals[:len(vals):len(vals)], rng.Upper)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tableOffset, err := rs.getRelationFileOffset(idx.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tablePg, err := rs.fs.fetch(uint64(tableOffset))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tableTree := &BTree{store: rs.fs}
+	tableTree.setRoot(tablePg)
+
+	schema, err := rs.getRelationSchema(idx.TableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []*Field
+	for _, fd := range schema.Fields {
+		fields = append(fields, &Field{Column: fd.Name, DataType: fd.DataType})
+	}
+
+	// the rows of a clustered table are read straight from the table
+	keyTree := tableTree
+	if !schema.clusteredOn(idx) {
+		if keyTree, _, err = rs.indexTree(idx.Name); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cur, err := keyTree.seek(start)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := &indexScan{
+		idx:            idx,
+		cur:            cur,
+		prefix:         prefix,
+		upper:          upper,
+		upperInclusive: rng.UpperInclusive,
+		clustered:      keyTree == tableTree,
+		table:          tableTree,
+		schema:         schema,
+		fields:         fields,
+	}
+	if !rng.LowerInclusive {
+		s.skip = lower
+	}
+	return s, fields, nil
+}
+
+// indexScan iterates over the rows whose index key starts with prefix and
+// that don't lie past upper.
+type indexScan struct {
+	idx    *Index
+	cur    *cursor
+	prefix []byte
+	// skip is the encoded lower bound of an exclusive range
+	skip           []byte
+	upper          []byte
+	upperInclusive bool
+	// clustered is true if cur walks the rows of the table rather than
+	// index entries
+	clustered bool
+	table     *BTree
+	schema    *Relation
+	fields    Fields
+	done      bool
+}
+
+func (s *indexScan) Next() (*Row, error) {
+	defer s.table.trimCache()
+	for !s.done {
+		cell, err := s.cur.next()
+		if err != nil {
+			return nil, err
+		}
+		if cell == nil || !bytes.HasPrefix(cell.key, s.prefix) || s.pastUpper(cell.key) {
+			s.done = true
+			break
+		}
+		if s.skip != nil && bytes.HasPrefix(cell.key, s.skip) {
+			continue
+		}
+		if s.clustered {
+			return decodeRow(cell, s.schema, s.fields)
+		}
+		rowCell, err := s.table.findCell(cell.valueBytes)
+		if err != nil {
+			return nil, err
+		}
+		if rowCell == nil {
+			return nil, fmt.Errorf("index %s refers to missing row %v", s.idx.Name, cell.valueBytes)
+		}
+		return decodeRow(rowCell, s.schema, s.fields)
+	}
+	return nil, nil
+}
+
+// pastUpper returns true if key sorts after the entries within the upper
+// bound of the scan.
+func (s *indexScan) pastUpper(key []byte) bool {
+	if s.upper == nil {
+		return false
+	}
+	if bytes.HasPrefix(key, s.upper) {
+		return !s.upperInclusive
+	}
+	return bytes.Compare(key, s.upper) > 0
+}
+
+// checkIndexEntry verifies that an entry for vals of the row with key rowKey
+// can be added to idx.
+func (rs *RelationService) checkIndexEntry(idx *Index, vals []interface{}, rowKey []byte) error {
+	if _, err := indexEntryKey(vals, rowKey); err != nil {
+		return fmt.Errorf("%w: index %s", err, idx.Name)
+	}
+
+	// unlike other values, nulls are never equal to each other
+	if !idx.Unique || hasNull(vals) {
+		return nil
+	}
+
+	bt, _, err := rs.indexTree(idx.Name)
+	if err != nil {
+		return err
+	}
+
+	prefix, err := encodeIndexKey(vals)
+	if err != nil {
+		return err
+	}
+
+	found, err := hasKeyPrefix(bt, prefix)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("%w %s", ErrUniqueViolation, idx.Name)
+	}
+
+	return nil
+}
+
+func (rs *RelationService) insertIndexEntry(idx *Index, vals []interface{}, rowKey []byte) (WALBatch, error) {
+	var walLogs WALBatch
+
+	key, err := indexEntryKey(vals, rowKey)
+	if err != nil {
+		return walLogs, err
+	}
+
+	bt, rootPg, err := rs.indexTree(idx.Name)
+	if err != nil {
+		return walLogs, err
+	}
+
+	lsn := rs.fs.nextLSN()
+	if err := bt.insertKey(key, lsn, rowKey); err != nil {
+		return walLogs, err
+	}
+	rs.fs.incrLSN()
+
+	walLogs = append(walLogs, &WALEntry{
+		LSN:    lsn,
+		pageID: rootPg.getFileOffset(),
+		WALOp:  OpIndexInsert,
+		key:    key,
+		val:    rowKey,
+	})
+
+	// update page table with new root if the old root split
+	curPage, err := bt.getRoot()
+	if err != nil {
+		return walLogs, err
+	}
+
+	if curPage.getFileOffset() != rootPg.getFileOffset() {
+		logs, err := rs.updatePageTable(curPage.getFileOffset(), idx.Name)
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
+	return walLogs, nil
+}
+
+func (rs *RelationService) deleteIndexEntry(idx *Index, vals []interface{}, rowKey []byte) (WALBatch, error) {
+	var walLogs WALBatch
+
+	key, err := indexEntryKey(vals, rowKey)
+	if err != nil {
+		return walLogs, err
+	}
+
+	bt, _, err := rs.indexTree(idx.Name)
+	if err != nil {
+		return walLogs, err
+	}
+
+	cell, err := bt.findCell(key)
+	if err != nil {
+		return walLogs, err
+	}
+	if cell == nil {
+		return walLogs, fmt.Errorf("unable to find entry for row key %v in index %s", rowKey, idx.Name)
+	}
+
+	return append(walLogs, rs.markCellDeleted(cell)), nil
+}
+
+// ensureIndexTable creates the index table for databases that were created
+// before index support was added.
+func (rs *RelationService) ensureIndexTable() error {
+	_, err := rs.getRelationFileOffset(indexTableName)
+	if err != ErrTableNotExist {
+		return err
+	}
+	return rs.createTable(&indexTableSchema, indexTableName)
+}
+
+func (rs *RelationService) insertIndexTable(idx *Index) error {
+	var rows []map[string]interface{}
+	for pos, col := range idx.Columns {
+		rows = append(rows, map[string]interface{}{
+			"index_name":  idx.Name,
+			"table_name":  idx.TableName,
+			"column_name": col,
+			"column_pos":  int64(pos),
+			"is_unique":   idx.Unique,
+			"is_primary":  idx.Primary,
+		})
+	}
+	return rs.insertSysRows(indexTableName, &indexTableSchema, rows)
+}
+
+// deleteSysRows marks the rows of the system table located at fileOffset
+// deleted if column col equals val. It returns true if any row was deleted.
+func (rs *RelationService) deleteSysRows(fileOffset uint64, r *Relation, col string, val interface{}) (bool, error) {
+	pg, err := rs.fs.fetch(fileOffset)
+	if err != nil {
+		return false, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	found := false
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: r,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		if tuple.Vals[col] == val {
+			cell.deleted = true
+			cell.pg.markDirty(rs.fs.nextLSN())
+			found = true
+		}
+		return KeepScanning, nil
+	})
+
+	return found, err
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeIndexKeyOrdering(t *testing.T) {
+
+	// each list of values is expected to sort before the next one
+	tbl := [][]interface{}{
+		{nil},
+		{int64(math.MinInt64)},
+		{int64(-10)},
+		{int64(-1)},
+		{int64(0)},
+		{int64(1), nil},
+		{int64(1), ""},
+		{int64(1), "a"},
+		{int64(1), "a\x00"},
+		{int64(1), "ab"},
+		{int64(1), "b"},
+		{int64(10)},
+		{int64(math.MaxInt64)},
+	}
+
+	var prev []byte
+	for i, vals := range tbl {
+		key, err := encodeIndexKey(vals)
+		if err != nil {
+			t.Fatalf("error encoding %v: %s", vals, err.Error())
+		}
+		if i > 0 && bytes.Compare(prev, key) >= 0 {
+			t.Errorf("expected %v to sort before %v", tbl[i-1], vals)
+		}
+		prev = key
+	}
+
+	// each value is expected to sort before the next one of its type. The
+	// decimals of a column share a scale.
+	sorted := [][]interface{}{
+		{math.Inf(-1), -1e300, -1.5, -0.25, 0.0, 0.5, 1e300, math.Inf(1)},
+		{NewDecimal(-150, 2), NewDecimal(-1, 2), NewDecimal(0, 2), NewDecimal(2, 2), NewDecimal(12345, 2)},
+		{Date(math.MinInt32), Date(-1), Date(0), Date(20454)},
+		{Time(0), Time(1), Time(86399999999)},
+		{Timestamp(-1767275130000000), Timestamp(-1), Timestamp(0), Timestamp(1767275130000000)},
+		{Interval{Micros: -1}, Interval{}, Interval{Days: 1}, Interval{Days: 1, Micros: 1}, Interval{Months: 1, Micros: -1}},
+	}
+	for _, vals := range sorted {
+		for i := 1; i < len(vals); i++ {
+			lhs, err := encodeIndexKey(vals[i-1 : i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			rhs, err := encodeIndexKey(vals[i : i+1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Compare(lhs, rhs) >= 0 {
+				t.Errorf("expected %v to sort before %v", vals[i-1], vals[i])
+			}
+		}
+	}
+
+	// -0 equals 0
+	lhs, err := encodeIndexKey([]interface{}{math.Copysign(0, -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rhs, err := encodeIndexKey([]interface{}{0.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lhs, rhs) {
+		t.Error("expected -0 and 0 to have the same key")
+	}
+
+	// intervals of the same length are equal
+	lhs, err = encodeIndexKey([]interface{}{Interval{Months: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rhs, err = encodeIndexKey([]interface{}{Interval{Days: 30}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lhs, rhs) {
+		t.Error("expected 1 month and 30 days to have the same key")
+	}
+
+	bools := [][]interface{}{{false}, {true}}
+	lhs, err = encodeIndexKey(bools[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rhs, err = encodeIndexKey(bools[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Compare(lhs, rhs) >= 0 {
+		t.Error("expected false to sort before true")
+	}
+}
+
+func TestEncodeIndexKeyPrefix(t *testing.T) {
+
+	prefix, err := encodeIndexKey([]interface{}{"ab"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match, err := indexEntryKey([]interface{}{"ab", int64(5)}, rowIDToKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(match, prefix) {
+		t.Error("expected key for (ab, 5) to start with key for (ab)")
+	}
+
+	noMatch, err := indexEntryKey([]interface{}{"abc", int64(5)}, rowIDToKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(noMatch, prefix) {
+		t.Error("expected key for (abc, 5) not to start with key for (ab)")
+	}
+}
+
+func TestIndexEntryKeyTooLarge(t *testing.T) {
+	_, err := indexEntryKey([]interface{}{string(make([]byte, maxKeySize))}, rowIDToKey(1))
+	if err != ErrKeyTooLarge {
+		t.Errorf("expected error `%v`, got `%v`", ErrKeyTooLarge, err)
+	}
+}
+
+func TestCreateIndexFailureFreesPages(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	createTable := func(tableName string) {
+		r := &Relation{
+			Fields: []FieldDef{
+				{Name: "id", DataType: TypeInt},
+				{Name: "name", DataType: TypeVarchar, Len: 255},
+			},
+		}
+		if err := rs.CreateTable(r, tableName); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 500; i++ {
+			rs.StartTxn()
+			batch, err := rs.Insert(tableName, []string{"id", "name"}, []interface{}{int64(i % 400), "name"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rs.FlushWALBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+			rs.EndTxn()
+		}
+	}
+
+	// the build takes pages off the free list before it grows the file
+	createTable("scratch")
+	createTable("people")
+	if err := rs.DropTable("scratch"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.fs.flushPages(); err != nil {
+		t.Fatal(err)
+	}
+
+	state := func() (int64, []uint64) {
+		info, err := rs.fs.file.Stat()
+		if err != nil {
+			t.Fatal(err)
+		}
+		free, err := rs.fs.freeList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size(), free
+	}
+	size, free := state()
+	if len(free) == 0 {
+		t.Fatal("expected dropped pages on the free list")
+	}
+
+	// the ids repeat after the first 400 rows, so the build fails late
+	idx := &Index{Name: "people_id_idx", TableName: "people", Columns: []string{"id"}, Unique: true}
+	if err := rs.CreateIndex(idx); !errors.Is(err, ErrUniqueViolation) {
+		t.Fatalf("expected error %v, got %v", ErrUniqueViolation, err)
+	}
+	if err := rs.fs.flushPages(); err != nil {
+		t.Fatal(err)
+	}
+
+	newSize, newFree := state()
+	if newSize != size {
+		t.Errorf("expected the file size to stay %d, got %d", size, newSize)
+	}
+	if !reflect.DeepEqual(free, newFree) {
+		t.Errorf("expected the free list to stay %v, got %v", free, newFree)
+	}
+	rs.StartTxn()
+	indexes, err := rs.Indexes("people")
+	rs.EndTxn()
+	if err != nil || len(indexes) != 0 {
+		t.Errorf("expected no indexes, got %v %v", indexes, err)
+	}
+}
@@ -0,0 +1,31 @@
+package storage
+
+import "sync"
+
+// Logger receives diagnostic messages from the storage engine, such as page
+// table changes, table scans and errors in background page flushes. A
+// *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+var (
+	loggerMtx sync.RWMutex
+	logger    Logger
+)
+
+// SetLogger sets the logger that diagnostic messages are written to. A nil
+// logger, the default, discards them.
+func SetLogger(l Logger) {
+	loggerMtx.Lock()
+	defer loggerMtx.Unlock()
+	logger = l
+}
+
+func logf(format string, v ...interface{}) {
+	loggerMtx.RLock()
+	defer loggerMtx.RUnlock()
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
@@ -46,12 +46,37 @@ func (lru *LRUCache) set(key any, val *btreeNode) bool {
 		delete(lru.cache, cur.Value.(*cacheEntry).key)
 	}
 
+	lru.push(key, val)
+	return true
+}
+
+// push adds an entry without evicting anything, even if that takes the cache
+// over capacity.
+func (lru *LRUCache) push(key any, val *btreeNode) {
 	elem := lru.list.PushFront(&cacheEntry{
 		key: key,
 		val: val,
 	})
 	lru.cache[key] = elem
-	return true
+}
+
+// overfull returns true if the cache holds more entries than its capacity.
+func (lru *LRUCache) overfull() bool {
+	return len(lru.cache) > lru.maxNodes
+}
+
+// trim evicts the oldest non-dirty entries until the cache is back within
+// capacity.
+func (lru *LRUCache) trim() {
+	cur := lru.list.Back()
+	for cur != nil && lru.overfull() {
+		prev := cur.Prev()
+		if !cur.Value.(*cacheEntry).val.isDirty() {
+			lru.list.Remove(cur)
+			delete(lru.cache, cur.Value.(*cacheEntry).key)
+		}
+		cur = prev
+	}
 }
 
 func (lru *LRUCache) get(key any) (*btreeNode, bool) {
@@ -62,3 +87,10 @@ func (lru *LRUCache) get(key any) (*btreeNode, bool) {
 	}
 	return nil, false
 }
+
+func (lru *LRUCache) remove(key any) {
+	if entry, found := lru.cache[key]; found {
+		lru.list.Remove(entry)
+		delete(lru.cache, key)
+	}
+}
@@ -205,3 +205,54 @@ func TestLRUEviction(t *testing.T) {
 		})
 	}
 }
+
+func TestLRURemove(t *testing.T) {
+	lru := NewLRU(3)
+	lru.set("A", &btreeNode{isLeaf: true, fileOffset: 1})
+	lru.set("B", &btreeNode{isLeaf: true, fileOffset: 2, dirty: true})
+	lru.set("C", &btreeNode{isLeaf: true, fileOffset: 3})
+
+	lru.remove("B")
+	lru.remove("Z")
+
+	if _, ok := lru.get("B"); ok {
+		t.Fatal("expected removed key to be absent from cache")
+	}
+	if len(lru.cache) != 2 || lru.list.Len() != 2 {
+		t.Fatalf("expected 2 cache entries, got %d map entries and %d list entries", len(lru.cache), lru.list.Len())
+	}
+	if !lru.set("D", &btreeNode{isLeaf: true, fileOffset: 4}) {
+		t.Fatal("expected set to succeed after removal")
+	}
+}
+
+func TestLRUTrim(t *testing.T) {
+	lru := NewLRU(2)
+	lru.set("A", &btreeNode{isLeaf: true, fileOffset: 1, dirty: true})
+	lru.set("B", &btreeNode{isLeaf: true, fileOffset: 2, dirty: true})
+	lru.push("C", &btreeNode{isLeaf: true, fileOffset: 3})
+	lru.push("D", &btreeNode{isLeaf: true, fileOffset: 4, dirty: true})
+
+	if !lru.overfull() {
+		t.Fatal("expected cache to be over capacity")
+	}
+
+	// only the clean entry can be evicted
+	lru.trim()
+	if _, ok := lru.get("C"); ok {
+		t.Fatal("expected clean entry to be evicted")
+	}
+	if len(lru.cache) != 3 {
+		t.Fatalf("expected 3 dirty entries to remain, got %d", len(lru.cache))
+	}
+
+	lru.cache["A"].Value.(*cacheEntry).val.markClean()
+	lru.cache["B"].Value.(*cacheEntry).val.markClean()
+	lru.trim()
+	if lru.overfull() {
+		t.Fatal("expected cache to be back within capacity")
+	}
+	if _, ok := lru.get("D"); !ok {
+		t.Fatal("expected dirty entry to remain in cache")
+	}
+}
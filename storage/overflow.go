@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// allocOverflow assigns overflow pages to the cells of the dirty leaves whose
+// values are too large to be stored in the leaf and don't have pages yet. It
+// returns the offsets of the assigned pages.
+func (f *fileStore) allocOverflow() ([]uint64, error) {
+	var offsets []uint64
+	for _, v := range f.cache.cache {
+		node := v.Value.(*cacheEntry).val
+		if !node.isDirty() || !node.isLeaf {
+			continue
+		}
+		for _, cell := range node.leafCells {
+			if !cell.overflows() || cell.overflowPages != nil {
+				continue
+			}
+			count := (len(cell.valueBytes) + maxOverflowPayload - 1) / maxOverflowPayload
+			for i := 0; i < count; i++ {
+				offset, err := f.allocPage()
+				if err != nil {
+					return nil, err
+				}
+				cell.overflowPages = append(cell.overflowPages, offset)
+			}
+			offsets = append(offsets, cell.overflowPages...)
+		}
+	}
+	return offsets, nil
+}
+
+// writeOverflow writes the values of the cells of leaf node to their
+// overflow pages, unless they're already written.
+func (f *fileStore) writeOverflow(node *btreeNode) error {
+	if !node.isLeaf {
+		return nil
+	}
+	for _, cell := range node.leafCells {
+		if !cell.overflows() || cell.overflowSaved {
+			continue
+		}
+		if cell.overflowPages == nil {
+			return fmt.Errorf("value of key %v has no overflow pages", cell.key)
+		}
+		val := cell.valueBytes
+		for i, offset := range cell.overflowPages {
+			var next uint64
+			if i < len(cell.overflowPages)-1 {
+				next = cell.overflowPages[i+1]
+			}
+			payload := val
+			if len(payload) > maxOverflowPayload {
+				payload = payload[:maxOverflowPayload]
+			}
+			val = val[len(payload):]
+
+			buf := bytes.NewBuffer(make([]byte, 0, overflowHeaderSize+len(payload)))
+			buf.WriteByte(OverflowNode)
+			if err := binary.Write(buf, binary.LittleEndian, next); err != nil {
+				return err
+			}
+			if err := binary.Write(buf, binary.LittleEndian, uint16(len(payload))); err != nil {
+				return err
+			}
+			buf.Write(payload)
+			if _, err := f.file.WriteAt(buf.Bytes(), int64(offset)); err != nil {
+				return err
+			}
+		}
+		cell.overflowSaved = true
+	}
+	return nil
+}
+
+// readOverflow reads the values of the cells of leaf node that are stored on
+// overflow pages.
+func (f *fileStore) readOverflow(node *btreeNode) error {
+	if !node.isLeaf {
+		return nil
+	}
+	for _, cell := range node.leafCells {
+		if !cell.overflows() || cell.valueBytes != nil {
+			continue
+		}
+		val := make([]byte, 0, cell.valueSize)
+		page := make([]byte, pageSize)
+		for offset := cell.overflowPages[0]; ; {
+			// the last page of the file may be shorter than a page
+			if _, err := f.file.ReadAt(page, int64(offset)); err != nil && err != io.EOF {
+				return err
+			}
+			if page[0] != OverflowNode {
+				return fmt.Errorf("page %d is not an overflow page", offset)
+			}
+			next := binary.LittleEndian.Uint64(page[1:9])
+			size := binary.LittleEndian.Uint16(page[9:overflowHeaderSize])
+			val = append(val, page[overflowHeaderSize:overflowHeaderSize+int(size)]...)
+			if next == 0 {
+				break
+			}
+			cell.overflowPages = append(cell.overflowPages, next)
+			offset = next
+		}
+		if len(val) != int(cell.valueSize) {
+			return fmt.Errorf("value of key %v is %d bytes, expected %d", cell.key, len(val), cell.valueSize)
+		}
+		cell.valueBytes = val
+	}
+	return nil
+}
+
+// chainPages returns the offsets of the overflow pages of the cells in the
+// leaves of the tree rooted at offset root. If deleted is true, only the
+// pages of deleted cells are returned.
+func (rs *RelationService) chainPages(root uint64, deleted bool) ([]uint64, error) {
+	pages, err := rs.treePages(root)
+	if err != nil {
+		return nil, err
+	}
+	var chains []uint64
+	for _, offset := range pages {
+		pg, err := rs.fs.fetch(offset)
+		if err != nil {
+			return nil, err
+		}
+		if !pg.isLeaf {
+			continue
+		}
+		for _, cell := range pg.leafCells {
+			if cell.deleted || !deleted {
+				chains = append(chains, cell.overflowPages...)
+			}
+		}
+	}
+	return chains, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverflowPages(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{Name: "id", DataType: TypeInt},
+			{Name: "body", DataType: TypeText},
+			{Name: "payload", DataType: TypeBlob},
+		},
+	}
+	if err := rs.CreateTable(r, "docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(f func() (WALBatch, error)) {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		batch, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the values span several overflow pages
+	bodies := []string{
+		strings.Repeat("a", 10000),
+		"short",
+		strings.Repeat("b", maxOverflowPayload),
+	}
+	payload := string([]byte{0, 1, 2, 255})
+	for i, body := range bodies {
+		exec(func() (WALBatch, error) {
+			return rs.Insert("docs", []string{"id", "body", "payload"}, []interface{}{int64(i), body, payload})
+		})
+	}
+
+	rs.StartTxn()
+	if _, err := rs.Insert("docs", []string{"id", "body"}, []interface{}{int64(3), strings.Repeat("c", maxRowSize)}); err != ErrRowTooLarge {
+		t.Errorf("expected ErrRowTooLarge error, got %v", err)
+	}
+	rs.EndTxn()
+
+	reopen := func() {
+		if err := rs.Close(); err != nil {
+			t.Fatal(err)
+		}
+		rs, err = OpenRelation("testdb", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBodies := func() {
+		rs.StartTxn()
+		rows, _, err := rs.Fetch("docs")
+		rs.EndTxn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != len(bodies) {
+			t.Fatalf("expected %d rows, got %d", len(bodies), len(rows))
+		}
+		for i, row := range rows {
+			if row.Vals[1] != bodies[i] {
+				t.Errorf("row %d: expected a body of %d bytes, got %d", i, len(bodies[i]), len(row.Vals[1].(string)))
+			}
+			if row.Vals[2] != payload {
+				t.Errorf("row %d: expected payload %v, got %v", i, payload, row.Vals[2])
+			}
+		}
+	}
+
+	reopen()
+	checkBodies()
+
+	// updating a value writes it to new overflow pages
+	rs.StartTxn()
+	rows, _, err := rs.Fetch("docs")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies[0] = strings.Repeat("d", 20000)
+	exec(func() (WALBatch, error) {
+		return rs.Update("docs", rows[0].Key, []string{"body"}, []interface{}{bodies[0]})
+	})
+	reopen()
+	checkBodies()
+
+	// vacuuming all tables frees the overflow pages of the replaced value
+	// and the deleted row
+	exec(func() (WALBatch, error) {
+		return rs.MarkDeleted("docs", rows[2].Key)
+	})
+	bodies = bodies[:2]
+	fileEnd := rs.fs.nextFreeOffset
+	if err := rs.Vacuum(""); err != nil {
+		t.Fatal(err)
+	}
+	free, err := rs.fs.freeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed := fileEnd - rs.fs.nextFreeOffset + uint64(len(free))*pageSize; reclaimed < 4*pageSize {
+		t.Errorf("expected the overflow pages to be reclaimed, got %d bytes", reclaimed)
+	}
+	reopen()
+	defer rs.Close()
+	checkBodies()
+}
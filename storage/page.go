@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
 	"time"
 )
@@ -14,8 +15,22 @@ import (
 const (
 	InternalNode byte = iota
 	LeafNode
+	// FreeNode marks a page on the free list
+	FreeNode
+	// OverflowNode marks a page that holds part of a value too large to be
+	// stored in its leaf cell
+	OverflowNode
+	// SlottedLeafNode marks a leaf whose cells are packed at the end of the
+	// page and located through the offset array. Leaves written before
+	// format version 1 have type LeafNode, and their cells are found by
+	// reading them one after the other.
+	SlottedLeafNode
 )
 
+// currentFormatVersion is the version of the on-disk format written by this
+// package. Files with an older version are upgraded when they're opened.
+const currentFormatVersion = 1
+
 const (
 	// maximum size (in bytes) of serialized page
 	pageSize = 4096
@@ -42,52 +57,132 @@ const (
 	// size (in bytes) of offset array element
 	offsetElemSize = 2
 
-	// size (in bytes) of key cell
-	nodeCellSize = 4 + // field: key
+	// maximum size (in bytes) of a cell key. A leaf page has room for three
+	// cells with keys of this size, so each half of a split node fits on a
+	// page.
+	maxKeySize = 768
+
+	// size (in bytes) of the fixed fields of a key cell. The key follows the
+	// key size.
+	internalCellHeaderSize = 2 + // field: keySize
 		8 // field: fileOffset
 
-	// maximum size (in bytes) of key-value cell value
+	// maximum size (in bytes) of key-value cell value. Larger values are
+	// stored on a chain of overflow pages, and the cell holds the offset of
+	// the first one.
 	maxValueSize = 400
 
-	// size (in bytes) of key-value cell
-	leafNodeCellSize = 4 + // field: key
-		1 + // field: deleted
-		4 + // field: valueSize
-		maxValueSize
+	// maximum size (in bytes) of a row
+	maxRowSize = 1 << 24
+
+	// overflowFlag is set in the valueSize field of a cell whose value is
+	// stored on overflow pages
+	overflowFlag = 1 << 31
+
+	// size (in bytes) of fixed space used to store overflow page metadata
+	overflowHeaderSize = 1 + // field: cellType
+		8 + // field: nextOffset
+		2 // field: payloadSize
 
-	// maximum number of non-leaf node elements
-	maxInternalNodeCells = (pageSize - internalNodeHeaderSize) / (offsetElemSize + nodeCellSize)
+	// maximum size (in bytes) of the part of a value stored on one overflow
+	// page
+	maxOverflowPayload = pageSize - overflowHeaderSize
+
+	// size (in bytes) of the fixed fields of a key-value cell. The key and
+	// the value (or the offset of its first overflow page) follow them.
+	leafCellHeaderSize = 2 + // field: keySize
+		1 + // field: deleted
+		4 // field: valueSize
 
-	// maximum number of leaf node elements
-	maxLeafNodeCells = (pageSize - leafNodeHeaderSize) / (offsetElemSize + leafNodeCellSize)
 )
 
+// fileHeaderSize is the size of the file header written by fileStore.save
+const fileHeaderSize = 48
+
 // pageFlushInterval is how often to flush dirty pages to disk
 const pageFlushInterval = 100 * time.Millisecond
 
 var (
-	ErrRowTooLarge  = fmt.Errorf("row exceeds %d bytes", maxValueSize)
-	ErrLRUCacheFull = errors.New("cache is full and contains no evictable pages, try increasing page flush frequency")
+	ErrRowTooLarge     = fmt.Errorf("row exceeds %d bytes", maxRowSize)
+	ErrKeyTooLarge     = fmt.Errorf("key exceeds %d bytes", maxKeySize)
+	ErrTxnInProgress   = errors.New("a transaction is already in progress")
+	ErrNoTxnInProgress = errors.New("there is no transaction in progress")
+	ErrFormatVersion   = errors.New("unsupported file format version")
 )
 
 func checkRowSizeLimit(value []byte) error {
-	if len(value) > maxValueSize {
+	if len(value) > maxRowSize {
 		return ErrRowTooLarge
 	}
 	return nil
 }
 
+func checkKeySizeLimit(key []byte) error {
+	if len(key) > maxKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// rowIDToKey encodes a row ID as a cell key. The big-endian encoding preserves
+// the numeric ordering of row IDs when keys are compared byte-wise.
+func rowIDToKey(rowID uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, rowID)
+	return key
+}
+
+// keyToRowID decodes a cell key created by rowIDToKey.
+func keyToRowID(key []byte) uint32 {
+	return binary.BigEndian.Uint32(key)
+}
+
 type internalCell struct {
-	key        uint32
+	key        []byte
 	fileOffset uint64
 }
 
+// size returns the number of bytes the cell takes up in its internal page.
+func (c *internalCell) size() int {
+	return internalCellHeaderSize + len(c.key)
+}
+
 type leafCell struct {
-	key        uint32
+	key        []byte
 	valueSize  uint32
 	valueBytes []byte
 	pg         *btreeNode
 	deleted    bool
+	// overflowPages holds the offsets of the overflow pages that store the
+	// value if it's larger than maxValueSize. The pages are assigned when the
+	// leaf is written to disk.
+	overflowPages []uint64
+	// overflowSaved is true if the value is written to overflowPages
+	overflowSaved bool
+}
+
+// setValue replaces the value of the cell. The overflow pages of the old
+// value are left alone, since a transaction that rolls back or a log entry
+// that is replayed may still refer to them.
+func (c *leafCell) setValue(value []byte) {
+	c.valueBytes = value
+	c.valueSize = uint32(len(value))
+	c.overflowPages = nil
+	c.overflowSaved = false
+}
+
+// overflows returns true if the value of the cell is stored on overflow
+// pages.
+func (c *leafCell) overflows() bool {
+	return c.valueSize > maxValueSize
+}
+
+// size returns the number of bytes the cell takes up in its leaf page.
+func (c *leafCell) size() int {
+	if c.overflows() {
+		return leafCellHeaderSize + len(c.key) + 8
+	}
+	return leafCellHeaderSize + len(c.key) + int(c.valueSize)
 }
 
 type btreeNode struct {
@@ -137,14 +232,14 @@ func (n *btreeNode) setRightMostKey(fileOffset uint64) {
 	n.rightOffset = fileOffset
 }
 
-func (n *btreeNode) cellKey(offset uint16) uint32 {
+func (n *btreeNode) cellKey(offset uint16) []byte {
 	if n.isLeaf {
 		return n.leafCells[offset].key
 	}
 	return n.internalCells[offset].key
 }
 
-func (n *btreeNode) appendLeafCell(key uint32, value []byte) error {
+func (n *btreeNode) appendLeafCell(key []byte, value []byte) error {
 	offset := len(n.offsets)
 	n.offsets = append(n.offsets, uint16(offset))
 	n.leafCells = append(n.leafCells, &leafCell{
@@ -155,7 +250,7 @@ func (n *btreeNode) appendLeafCell(key uint32, value []byte) error {
 	return nil
 }
 
-func (n *btreeNode) appendInternalCell(key uint32, fileOffset uint64) error {
+func (n *btreeNode) appendInternalCell(key []byte, fileOffset uint64) error {
 	offset := len(n.offsets)
 	n.offsets = append(n.offsets, uint16(offset))
 	n.internalCells = append(n.internalCells, &internalCell{
@@ -165,7 +260,7 @@ func (n *btreeNode) appendInternalCell(key uint32, fileOffset uint64) error {
 	return nil
 }
 
-func (n *btreeNode) insertInternalCell(offset uint32, key uint32, fileOffset uint64) error {
+func (n *btreeNode) insertInternalCell(offset uint32, key []byte, fileOffset uint64) error {
 	n.offsets = append(n.offsets[:offset+1], n.offsets[offset:]...)
 	n.offsets[offset] = uint16(len(n.internalCells))
 	n.internalCells = append(n.internalCells, &internalCell{
@@ -177,7 +272,10 @@ func (n *btreeNode) insertInternalCell(offset uint32, key uint32, fileOffset uin
 	return nil
 }
 
-func (n *btreeNode) insertLeafCell(offset uint32, key uint32, value []byte) error {
+func (n *btreeNode) insertLeafCell(offset uint32, key []byte, value []byte) error {
+	if err := checkKeySizeLimit(key); err != nil {
+		return err
+	}
 	if err := checkRowSizeLimit(value); err != nil {
 		return err
 	}
@@ -198,17 +296,17 @@ func (n *btreeNode) insertLeafCell(offset uint32, key uint32, value []byte) erro
 // findCellOffsetByKey searches for a cell by key. if found is true, offset is the
 // position of key in the cell slice. if found is false, offset is key's
 // insertion point (the index of the first element greater than key).
-func (n *btreeNode) findCellOffsetByKey(key uint32) (offset int, found bool) {
+func (n *btreeNode) findCellOffsetByKey(key []byte) (offset int, found bool) {
 	low := 0
 	high := len(n.offsets) - 1
 
 	for low <= high {
 		mid := low + (high-low)/2
 		midVal := n.cellKey(n.offsets[mid])
-		switch {
-		case midVal == key:
+		switch bytes.Compare(midVal, key) {
+		case 0:
 			return mid, true
-		case midVal < key:
+		case -1:
 			low = mid + 1
 		default:
 			high = mid - 1
@@ -218,14 +316,36 @@ func (n *btreeNode) findCellOffsetByKey(key uint32) (offset int, found bool) {
 	return low, false
 }
 
+// isFull returns true if the node has to be split, which is once its cells
+// no longer fit on a page.
 func (n *btreeNode) isFull() bool {
 	if n.isLeaf {
-		return len(n.offsets) >= maxLeafNodeCells
+		return n.leafSize() > pageSize
 	}
-	return len(n.offsets) >= maxInternalNodeCells
+	return n.internalSize() > pageSize
 }
 
-func (n *btreeNode) getRightmostKey() uint32 {
+// leafSize returns the number of bytes leaf node n takes up when encoded,
+// not counting the free space.
+func (n *btreeNode) leafSize() int {
+	size := leafNodeHeaderSize
+	for _, offset := range n.offsets {
+		size += offsetElemSize + n.leafCells[offset].size()
+	}
+	return size
+}
+
+// internalSize returns the number of bytes internal node n takes up when
+// encoded, not counting the free space.
+func (n *btreeNode) internalSize() int {
+	size := internalNodeHeaderSize
+	for _, offset := range n.offsets {
+		size += offsetElemSize + n.internalCells[offset].size()
+	}
+	return size
+}
+
+func (n *btreeNode) getRightmostKey() []byte {
 	return n.internalCells[n.offsets[len(n.offsets)-1]].key
 }
 
@@ -236,10 +356,13 @@ func (n *btreeNode) encode() (*bytes.Buffer, error) {
 	return n.encodeInternal()
 }
 
+// encodeLeaf writes leaf node n as a slotted page. The header and the offset
+// array, which holds the position of each cell in key order, are followed by
+// the free space. The cells are packed at the end of the page.
 func (n *btreeNode) encodeLeaf() (*bytes.Buffer, error) {
 	buf := &bytes.Buffer{}
 
-	if err := binary.Write(buf, binary.LittleEndian, LeafNode); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, SlottedLeafNode); err != nil {
 		return nil, err
 	}
 	if err := binary.Write(buf, binary.LittleEndian, n.fileOffset); err != nil {
@@ -265,22 +388,34 @@ func (n *btreeNode) encodeLeaf() (*bytes.Buffer, error) {
 	if err := binary.Write(buf, binary.LittleEndian, cellCount); err != nil {
 		return nil, err
 	}
-	for i := 0; i < len(n.offsets); i++ {
-		if err := binary.Write(buf, binary.LittleEndian, n.offsets[i]); err != nil {
-			return nil, err
-		}
-	}
 
 	bufFooter := &bytes.Buffer{}
+	positions := make([]int, cellCount)
 
 	for i := uint32(0); i < cellCount; i++ {
+		positions[i] = bufFooter.Len()
 		keyCell := n.leafCells[n.offsets[i]]
+		if err := binary.Write(bufFooter, binary.LittleEndian, uint16(len(keyCell.key))); err != nil {
+			return nil, err
+		}
 		if err := binary.Write(bufFooter, binary.LittleEndian, keyCell.key); err != nil {
 			return nil, err
 		}
 		if err := binary.Write(bufFooter, binary.LittleEndian, keyCell.deleted); err != nil {
 			return nil, err
 		}
+		if keyCell.overflows() {
+			if !keyCell.overflowSaved {
+				return nil, fmt.Errorf("value of key %v is not written to overflow pages", keyCell.key)
+			}
+			if err := binary.Write(bufFooter, binary.LittleEndian, keyCell.valueSize|overflowFlag); err != nil {
+				return nil, err
+			}
+			if err := binary.Write(bufFooter, binary.LittleEndian, keyCell.overflowPages[0]); err != nil {
+				return nil, err
+			}
+			continue
+		}
 		if err := binary.Write(bufFooter, binary.LittleEndian, keyCell.valueSize); err != nil {
 			return nil, err
 		}
@@ -289,12 +424,23 @@ func (n *btreeNode) encodeLeaf() (*bytes.Buffer, error) {
 		}
 	}
 
-	freeSize := uint16(pageSize - buf.Len() - bufFooter.Len() - 2)
+	free := pageSize - leafNodeHeaderSize - len(n.offsets)*offsetElemSize - bufFooter.Len()
+	if free < 0 {
+		return nil, fmt.Errorf("leaf node cells exceed page size by %d bytes", -free)
+	}
+	freeSize := uint16(free)
 
-	// write out the free buffer, which separates the header
 	if err := binary.Write(buf, binary.LittleEndian, freeSize); err != nil {
 		return nil, err
 	}
+	footerStart := pageSize - bufFooter.Len()
+	for _, pos := range positions {
+		if err := binary.Write(buf, binary.LittleEndian, uint16(footerStart+pos)); err != nil {
+			return nil, err
+		}
+	}
+
+	// write out the free buffer, which separates the header
 	if err := binary.Write(buf, binary.LittleEndian, make([]byte, freeSize)); err != nil {
 		return nil, err
 	}
@@ -340,6 +486,9 @@ func (n *btreeNode) encodeInternal() (*bytes.Buffer, error) {
 
 	for i := uint32(0); i < cellCount; i++ {
 		keyCell := n.internalCells[n.offsets[i]]
+		if err := binary.Write(bufFooter, binary.LittleEndian, uint16(len(keyCell.key))); err != nil {
+			return nil, err
+		}
 		if err := binary.Write(bufFooter, binary.LittleEndian, keyCell.key); err != nil {
 			return nil, err
 		}
@@ -348,7 +497,11 @@ func (n *btreeNode) encodeInternal() (*bytes.Buffer, error) {
 		}
 	}
 
-	freeSize := uint16(pageSize - buf.Len() - bufFooter.Len() - 2)
+	free := pageSize - buf.Len() - bufFooter.Len() - 2
+	if free < 0 {
+		return nil, fmt.Errorf("internal node cells exceed page size by %d bytes", -free)
+	}
+	freeSize := uint16(free)
 
 	// write out the free buffer, which separates the header
 	if err := binary.Write(buf, binary.LittleEndian, freeSize); err != nil {
@@ -377,12 +530,14 @@ func (n *btreeNode) decode(buf *bytes.Buffer) error {
 }
 
 func (n *btreeNode) decodeLeaf(buf *bytes.Buffer) error {
+	page := buf.Bytes()
+
 	var cellType byte
 	if err := binary.Read(buf, binary.LittleEndian, &cellType); err != nil {
 		return err
 	}
-	if cellType != LeafNode {
-		return fmt.Errorf("decoding error: expected node type %d, got %d", LeafNode, cellType)
+	if cellType != SlottedLeafNode && cellType != LeafNode {
+		return fmt.Errorf("decoding error: expected node type %d, got %d", SlottedLeafNode, cellType)
 	}
 	if err := binary.Read(buf, binary.LittleEndian, &n.fileOffset); err != nil {
 		return err
@@ -407,6 +562,41 @@ func (n *btreeNode) decodeLeaf(buf *bytes.Buffer) error {
 	if err := binary.Read(buf, binary.LittleEndian, &cellCount); err != nil {
 		return err
 	}
+	if cellType == LeafNode {
+		return n.decodeLegacyLeaf(buf, cellCount)
+	}
+
+	if err := binary.Read(buf, binary.LittleEndian, &n.freeSize); err != nil {
+		return err
+	}
+
+	// the cells are read in key order, so the offsets in memory are the
+	// positions of the cells in leafCells
+	n.offsets = make([]uint16, cellCount)
+	n.leafCells = make([]*leafCell, cellCount)
+	for i := uint32(0); i < cellCount; i++ {
+		var pos uint16
+		if err := binary.Read(buf, binary.LittleEndian, &pos); err != nil {
+			return err
+		}
+		if int(pos) >= len(page) {
+			return fmt.Errorf("decoding error: cell offset %d is out of bounds", pos)
+		}
+		cell, err := decodeLeafCell(bytes.NewBuffer(page[pos:]))
+		if err != nil {
+			return err
+		}
+		n.offsets[i] = uint16(i)
+		n.leafCells[i] = cell
+	}
+
+	return nil
+}
+
+// decodeLegacyLeaf reads the rest of a leaf written before format version 1.
+// Its offset array holds the positions of the cells in leafCells, and the
+// cells follow the free space in key order.
+func (n *btreeNode) decodeLegacyLeaf(buf *bytes.Buffer, cellCount uint32) error {
 	for i := uint32(0); i < cellCount; i++ {
 		var offset uint16
 		if err := binary.Read(buf, binary.LittleEndian, &offset); err != nil {
@@ -423,27 +613,49 @@ func (n *btreeNode) decodeLeaf(buf *bytes.Buffer) error {
 
 	n.leafCells = make([]*leafCell, cellCount)
 	for i := uint32(0); i < cellCount; i++ {
-		cell := &leafCell{}
-		if err := binary.Read(buf, binary.LittleEndian, &cell.key); err != nil {
-			return err
-		}
-		if err := binary.Read(buf, binary.LittleEndian, &cell.deleted); err != nil {
-			return err
-		}
-		if err := binary.Read(buf, binary.LittleEndian, &cell.valueSize); err != nil {
-			return err
-		}
-		strBuf := make([]byte, cell.valueSize)
-		if _, err := buf.Read(strBuf); err != nil {
+		cell, err := decodeLeafCell(buf)
+		if err != nil {
 			return err
 		}
-		cell.valueBytes = strBuf
 		n.leafCells[n.offsets[i]] = cell
 	}
 
 	return nil
 }
 
+// decodeLeafCell reads a key-value cell from buf.
+func decodeLeafCell(buf *bytes.Buffer) (*leafCell, error) {
+	cell := &leafCell{}
+	key, err := decodeCellKey(buf)
+	if err != nil {
+		return nil, err
+	}
+	cell.key = key
+	if err := binary.Read(buf, binary.LittleEndian, &cell.deleted); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &cell.valueSize); err != nil {
+		return nil, err
+	}
+	if cell.valueSize&overflowFlag != 0 {
+		// the value is read from the overflow pages by the store
+		cell.valueSize &^= overflowFlag
+		var first uint64
+		if err := binary.Read(buf, binary.LittleEndian, &first); err != nil {
+			return nil, err
+		}
+		cell.overflowPages = []uint64{first}
+		cell.overflowSaved = true
+		return cell, nil
+	}
+	strBuf := make([]byte, cell.valueSize)
+	if _, err := io.ReadFull(buf, strBuf); err != nil {
+		return nil, err
+	}
+	cell.valueBytes = strBuf
+	return cell, nil
+}
+
 func (n *btreeNode) decodeInternal(buf *bytes.Buffer) error {
 	var cellType byte
 	if err := binary.Read(buf, binary.LittleEndian, &cellType); err != nil {
@@ -483,9 +695,11 @@ func (n *btreeNode) decodeInternal(buf *bytes.Buffer) error {
 	n.internalCells = make([]*internalCell, cellCount)
 	for i := uint32(0); i < cellCount; i++ {
 		cell := &internalCell{}
-		if err := binary.Read(buf, binary.LittleEndian, &cell.key); err != nil {
+		key, err := decodeCellKey(buf)
+		if err != nil {
 			return err
 		}
+		cell.key = key
 		if err := binary.Read(buf, binary.LittleEndian, &cell.fileOffset); err != nil {
 			return err
 		}
@@ -495,55 +709,97 @@ func (n *btreeNode) decodeInternal(buf *bytes.Buffer) error {
 	return nil
 }
 
-func (n *btreeNode) updateCell(key uint32, value []byte) error {
+func (n *btreeNode) updateCell(key []byte, value []byte) error {
 	if err := checkRowSizeLimit(value); err != nil {
 		return err
 	}
 	offset, found := n.findCellOffsetByKey(key)
 	if !found {
-		return fmt.Errorf("unable to find record to update for key %d", key)
+		return fmt.Errorf("unable to find record to update for key %v", key)
 	}
-	n.leafCells[offset].valueBytes = value
-	n.leafCells[offset].valueSize = uint32(len(value))
+	n.leafCells[n.offsets[offset]].setValue(value)
 	return nil
 }
 
-func (n *btreeNode) split(newPg *btreeNode) (uint32, error) {
+func (n *btreeNode) split(newPg *btreeNode) ([]byte, error) {
 	if n.isLeaf {
-		mid := len(n.offsets) / 2
+		// split where the cells on the left take up half of the page, but
+		// leave at least one cell on each side
+		mid, half := 1, (n.leafSize()-leafNodeHeaderSize)/2
+		for used := 0; mid < len(n.offsets)-1; mid++ {
+			used += offsetElemSize + n.leafCells[n.offsets[mid-1]].size()
+			if used >= half {
+				break
+			}
+		}
 
+		// move the cells themselves, so that they keep their deleted flag
+		// and overflow pages
 		for i := mid; i < len(n.offsets); i++ {
-			cell := n.leafCells[n.offsets[i]]
-			if err := newPg.appendLeafCell(cell.key, cell.valueBytes); err != nil {
-				return 0, err
-			}
+			newPg.offsets = append(newPg.offsets, uint16(len(newPg.leafCells)))
+			newPg.leafCells = append(newPg.leafCells, n.leafCells[n.offsets[i]])
 		}
 
+		// compact the remaining cells so that the offset array only refers to
+		// cells that still belong to this node
+		cells := make([]*leafCell, mid)
+		for i := 0; i < mid; i++ {
+			cells[i] = n.leafCells[n.offsets[i]]
+			n.offsets[i] = uint16(i)
+		}
+		n.leafCells = cells
 		n.offsets = n.offsets[0:mid]
-		// todo make old cells reusable
+
 		cell := newPg.leafCells[newPg.offsets[0]]
 		return cell.key, nil
 	}
 
-	mid := len(n.offsets) / 2
+	// split where the cells on the left take up half of the page. The cell
+	// at mid moves up to the parent, and at least one cell is left on each
+	// side.
+	mid, half := 1, (n.internalSize()-internalNodeHeaderSize)/2
+	for used := 0; mid < len(n.offsets)-2; mid++ {
+		used += offsetElemSize + n.internalCells[n.offsets[mid-1]].size()
+		if used >= half {
+			break
+		}
+	}
 
 	for i := mid + 1; i < len(n.offsets); i++ {
 		cell := n.internalCells[n.offsets[i]]
 		if err := newPg.appendInternalCell(cell.key, cell.fileOffset); err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 
 	newPg.setRightMostKey(n.rightOffset)
-	key := n.internalCells[mid].key
-	n.setRightMostKey(n.internalCells[mid].fileOffset)
+	key := n.internalCells[n.offsets[mid]].key
+	n.setRightMostKey(n.internalCells[n.offsets[mid]].fileOffset)
 
+	cells := make([]*internalCell, mid)
+	for i := 0; i < mid; i++ {
+		cells[i] = n.internalCells[n.offsets[i]]
+		n.offsets[i] = uint16(i)
+	}
+	n.internalCells = cells
 	n.offsets = n.offsets[0:mid]
-	// todo make old cells reusable
 
 	return key, nil
 }
 
+// decodeCellKey reads a length-prefixed cell key from buf.
+func decodeCellKey(buf *bytes.Buffer) ([]byte, error) {
+	var keySize uint16
+	if err := binary.Read(buf, binary.LittleEndian, &keySize); err != nil {
+		return nil, err
+	}
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(buf, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 type store interface {
 	append(p *btreeNode) error
 	update(p *btreeNode) error
@@ -554,6 +810,7 @@ type store interface {
 	incrementLastKey() error
 	setPageTableRoot(pg *btreeNode) error
 	flushPages() error
+	trimCache()
 }
 
 type memoryStore struct {
@@ -595,6 +852,8 @@ func (m *memoryStore) flushPages() error {
 	return nil
 }
 
+func (m *memoryStore) trimCache() {}
+
 func (m *memoryStore) nextLSN() uint64 {
 	return 0
 }
@@ -624,7 +883,7 @@ func newFileStore(path string, autoFlushCache bool) (*fileStore, error) {
 					return
 				case <-fs.ticker.C:
 					if err := fs.flushPages(); err != nil {
-						fmt.Printf("error flushing pages: %s", err.Error())
+						logf("error flushing pages: %s", err.Error())
 					}
 				}
 			}
@@ -637,7 +896,13 @@ type fileStore struct {
 	_nextLSN       uint64
 	autoFlushCache bool
 	cache          *LRUCache
+	checkpointLSN  uint64
 	file           *os.File
+	// freeListHead is the offset of the first page on the free list, or 0
+	// if the list is empty. Each free page holds the offset of the next one.
+	freeListHead uint64
+	// formatVersion is the version of the on-disk format of the file
+	formatVersion  uint32
 	lastKey        uint32
 	mtx            sync.RWMutex
 	nextFreeOffset uint64
@@ -645,6 +910,19 @@ type fileStore struct {
 	rootOffset     uint64
 	ticker         *time.Ticker
 	tickerDone     chan bool
+	txn            *txnState
+	// stmt holds the file header values at the start of a statement that
+	// runs outside of an explicit transaction
+	stmt *txnState
+}
+
+// txnState holds the file header values at the start of an explicit
+// transaction or a statement so that they can be restored if it rolls back.
+type txnState struct {
+	freeListHead   uint64
+	lastKey        uint32
+	nextFreeOffset uint64
+	pageTableRoot  uint64
 }
 
 func (f *fileStore) lockShared() {
@@ -708,16 +986,138 @@ func (f *fileStore) update(node *btreeNode) error {
 	return err
 }
 
+// append assigns node a page, reusing a page from the free list before
+// growing the file.
 func (f *fileStore) append(node *btreeNode) error {
-	node.setFileOffset(f.nextFreeOffset)
-
-	if err := f.setCache(node.getFileOffset(), node); err != nil {
+	offset, err := f.allocPage()
+	if err != nil {
 		return err
 	}
+	node.setFileOffset(offset)
+	return f.setCache(node.getFileOffset(), node)
+}
 
+// allocPage returns the offset of a page that isn't in use, taking it off
+// the free list or growing the file.
+func (f *fileStore) allocPage() (uint64, error) {
+	if f.freeListHead != 0 {
+		offset := f.freeListHead
+		next, err := f.nextFreePage(offset)
+		if err != nil {
+			return 0, err
+		}
+		f.freeListHead = next
+		return offset, nil
+	}
+	offset := f.nextFreeOffset
 	f.nextFreeOffset += pageSize
+	return offset, nil
+}
 
-	return nil
+// nextFreePage returns the offset of the page that follows free page offset
+// on the free list.
+func (f *fileStore) nextFreePage(offset uint64) (uint64, error) {
+	buf := make([]byte, 9)
+	if _, err := f.file.ReadAt(buf, int64(offset)); err != nil {
+		return 0, err
+	}
+	if buf[0] != FreeNode {
+		return 0, fmt.Errorf("page %d on the free list is not free", offset)
+	}
+	return binary.LittleEndian.Uint64(buf[1:]), nil
+}
+
+// freePages puts the pages at offsets on the free list. The pages are
+// written to disk right away along with the file header, so there must be no
+// other dirty pages that refer to them.
+func (f *fileStore) freePages(offsets []uint64) error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+
+	buf := make([]byte, 9)
+	buf[0] = FreeNode
+	for _, offset := range offsets {
+		f.cache.remove(offset)
+		binary.LittleEndian.PutUint64(buf[1:], f.freeListHead)
+		if _, err := f.file.WriteAt(buf, int64(offset)); err != nil {
+			return err
+		}
+		f.freeListHead = offset
+	}
+	if err := f.save(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// freeList returns the offsets of the pages on the free list.
+func (f *fileStore) freeList() ([]uint64, error) {
+	var offsets []uint64
+	for offset := f.freeListHead; offset != 0; {
+		next, err := f.nextFreePage(offset)
+		if err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offset)
+		offset = next
+	}
+	return offsets, nil
+}
+
+// shrink truncates the free pages at the end of the file. The file must have
+// no dirty pages.
+func (f *fileStore) shrink() error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+
+	offsets, err := f.freeList()
+	if err != nil {
+		return err
+	}
+	free := make(map[uint64]bool)
+	for _, offset := range offsets {
+		free[offset] = true
+	}
+
+	end := f.nextFreeOffset
+	for free[end-pageSize] {
+		end -= pageSize
+		delete(free, end)
+	}
+	if end == f.nextFreeOffset {
+		return nil
+	}
+
+	// relink the remaining free pages so that the ones closest to the start
+	// of the file are reused first, which leaves the end of the file free to
+	// be truncated the next time
+	offsets = offsets[:0]
+	for offset := range free {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] > offsets[j]
+	})
+	f.freeListHead = 0
+	buf := make([]byte, 9)
+	buf[0] = FreeNode
+	for _, offset := range offsets {
+		binary.LittleEndian.PutUint64(buf[1:], f.freeListHead)
+		if _, err := f.file.WriteAt(buf, int64(offset)); err != nil {
+			return err
+		}
+		f.freeListHead = offset
+	}
+
+	logf("truncated file from %d to %d bytes", f.nextFreeOffset, end)
+	f.nextFreeOffset = end
+	if err := f.save(); err != nil {
+		return err
+	}
+	if err := f.file.Truncate(int64(end)); err != nil {
+		return fmt.Errorf("error truncating file: %w", err)
+	}
+	return f.file.Sync()
 }
 
 func (f *fileStore) fetch(offset uint64) (*btreeNode, error) {
@@ -735,8 +1135,12 @@ func (f *fileStore) fetch(offset uint64) (*btreeNode, error) {
 	switch buf[0] {
 	case InternalNode:
 		n.isLeaf = false
-	case LeafNode:
+	case LeafNode, SlottedLeafNode:
 		n.isLeaf = true
+	case FreeNode:
+		return nil, fmt.Errorf("page %d is free", offset)
+	case OverflowNode:
+		return nil, fmt.Errorf("page %d is an overflow page", offset)
 	default:
 		panic("invalid node type value")
 	}
@@ -744,6 +1148,9 @@ func (f *fileStore) fetch(offset uint64) (*btreeNode, error) {
 	if err := n.decode(bytes.NewBuffer(buf)); err != nil {
 		return nil, err
 	}
+	if err := f.readOverflow(n); err != nil {
+		return nil, err
+	}
 
 	if err := f.setCache(n.getFileOffset(), n); err != nil {
 		return nil, err
@@ -753,7 +1160,7 @@ func (f *fileStore) fetch(offset uint64) (*btreeNode, error) {
 }
 
 func (f *fileStore) save() error {
-	writer := bytes.NewBuffer(make([]byte, 0, 20))
+	writer := bytes.NewBuffer(make([]byte, 0, fileHeaderSize))
 
 	if err := binary.Write(writer, binary.LittleEndian, f.lastKey); err != nil {
 		return err
@@ -767,6 +1174,15 @@ func (f *fileStore) save() error {
 	if err := binary.Write(writer, binary.LittleEndian, f._nextLSN); err != nil {
 		return err
 	}
+	if err := binary.Write(writer, binary.LittleEndian, f.checkpointLSN); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, f.freeListHead); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, f.formatVersion); err != nil {
+		return err
+	}
 	if _, err := f.file.WriteAt(writer.Bytes(), 0); err != nil {
 		return err
 	}
@@ -775,44 +1191,300 @@ func (f *fileStore) save() error {
 }
 
 func (f *fileStore) open() error {
-	if err := binary.Read(f.file, binary.LittleEndian, &f.lastKey); err != nil {
+	r := io.NewSectionReader(f.file, 0, fileHeaderSize)
+	if err := binary.Read(r, binary.LittleEndian, &f.lastKey); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.pageTableRoot); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f.nextFreeOffset); err != nil {
+		return err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &f._nextLSN); err != nil {
 		return err
 	}
-	if err := binary.Read(f.file, binary.LittleEndian, &f.pageTableRoot); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &f.checkpointLSN); err != nil {
 		return err
 	}
-	if err := binary.Read(f.file, binary.LittleEndian, &f.nextFreeOffset); err != nil {
+	// files written before the free list was added have zeros here, which
+	// is an empty list
+	if err := binary.Read(r, binary.LittleEndian, &f.freeListHead); err != nil {
 		return err
 	}
-	if err := binary.Read(f.file, binary.LittleEndian, &f._nextLSN); err != nil {
+	// files written before format versions were added have zeros here,
+	// which is version 0
+	if err := binary.Read(r, binary.LittleEndian, &f.formatVersion); err != nil {
 		return err
 	}
 	return nil
 }
 
+// upgrade rewrites the pages of a file written in an older format version in
+// the current one. Each page records its own format, so an upgrade that is
+// interrupted is picked up again the next time the file is opened. A file
+// with integer keys is upgraded by upgradeIntKeys instead.
+func (f *fileStore) upgrade() error {
+	if f.formatVersion == currentFormatVersion {
+		return nil
+	}
+	if f.formatVersion > currentFormatVersion {
+		return fmt.Errorf("%w: %d", ErrFormatVersion, f.formatVersion)
+	}
+
+	f.lockExclusive()
+	defer f.unlockExclusive()
+
+	intKeys, err := f.hasIntKeys()
+	if err != nil {
+		return err
+	}
+	if intKeys {
+		if err := f.upgradeIntKeys(); err != nil {
+			return fmt.Errorf("error upgrading file with integer keys: %w", err)
+		}
+		logf("upgraded file with integer keys to format version %d", currentFormatVersion)
+		return nil
+	}
+
+	// version 1 writes leaves as slotted pages
+	cellType := make([]byte, 1)
+	for offset := uint64(pageSize); offset < f.nextFreeOffset; offset += pageSize {
+		if _, err := f.file.ReadAt(cellType, int64(offset)); err == io.EOF {
+			// the pages at the end of the file were never written
+			break
+		} else if err != nil {
+			return err
+		}
+		if cellType[0] != LeafNode {
+			continue
+		}
+		node, err := f.fetch(offset)
+		if err != nil {
+			return err
+		}
+		if err := f.update(node); err != nil {
+			return err
+		}
+	}
+
+	logf("upgraded file from format version %d to %d", f.formatVersion, currentFormatVersion)
+	f.formatVersion = currentFormatVersion
+	if err := f.save(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
 func (f *fileStore) flushPages() error {
 	f.lockExclusive()
 	defer f.unlockExclusive()
+	if f.txn != nil {
+		// pages modified by an open transaction must not reach the disk
+		// until the transaction commits
+		return nil
+	}
+	return f.writeDirtyPages()
+}
+
+func (f *fileStore) writeDirtyPages() error {
+	if _, err := f.allocOverflow(); err != nil {
+		return err
+	}
 	for _, v := range f.cache.cache {
 		node := v.Value.(*cacheEntry).val
 		if !node.isDirty() {
 			continue
 		}
+		if err := f.writeOverflow(node); err != nil {
+			return err
+		}
 		if err := f.update(node); err != nil {
 			return err
 		}
 		node.markClean()
 	}
-	return f.save()
+	if err := f.save(); err != nil {
+		return err
+	}
+	// the pages are written between operations, when no page is in use, so
+	// the clean ones can be evicted
+	f.cache.trim()
+	return nil
+}
+
+// checkpoint writes all dirty pages to disk and records in the file header
+// that log entries with a lower LSN than the next LSN are no longer needed
+// for recovery.
+func (f *fileStore) checkpoint() error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+	if f.txn != nil {
+		return ErrTxnInProgress
+	}
+	f.checkpointLSN = f._nextLSN
+	if err := f.writeDirtyPages(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// beginTxn starts an explicit transaction. Dirty pages are written to disk
+// first so that every page dirtied from here on belongs to the transaction.
+func (f *fileStore) beginTxn() error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+	if f.txn != nil {
+		return ErrTxnInProgress
+	}
+	if err := f.writeDirtyPages(); err != nil {
+		return err
+	}
+	f.txn = f.headerState()
+	return nil
+}
+
+// beginStmt starts a statement that runs outside of an explicit transaction.
+// Like beginTxn, it writes the dirty pages to disk first, so that every page
+// dirtied from here on belongs to the statement.
+func (f *fileStore) beginStmt() error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+	if err := f.writeDirtyPages(); err != nil {
+		return err
+	}
+	f.stmt = f.headerState()
+	return nil
+}
+
+// headerState returns the file header values that a rollback restores.
+func (f *fileStore) headerState() *txnState {
+	return &txnState{
+		freeListHead:   f.freeListHead,
+		lastKey:        f.lastKey,
+		nextFreeOffset: f.nextFreeOffset,
+		pageTableRoot:  f.pageTableRoot,
+	}
+}
+
+// commitTxn ends the open transaction. Its pages become eligible for flushing.
+func (f *fileStore) commitTxn() error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+	if f.txn == nil {
+		return ErrNoTxnInProgress
+	}
+	f.txn = nil
+	return nil
+}
+
+// rollbackTxn ends the open transaction and discards the pages it modified.
+// Discarded pages are read back from disk the next time they are fetched.
+// LSNs are not reused, since the transaction's begin record is already in
+// the log.
+func (f *fileStore) rollbackTxn() error {
+	f.lockExclusive()
+	defer f.unlockExclusive()
+	if f.txn == nil {
+		return ErrNoTxnInProgress
+	}
+	f.discard(f.txn)
+	f.txn = nil
+	return nil
+}
+
+// abortStmt discards the pages modified by the current statement. If the
+// statement runs inside an explicit transaction, the pages of the whole
+// transaction are discarded and the transaction ends. The caller holds the
+// shared lock taken for the statement, which keeps the pages from being
+// flushed in the meantime.
+func (f *fileStore) abortStmt() {
+	if f.txn != nil {
+		f.discard(f.txn)
+		f.txn = nil
+		return
+	}
+	if f.stmt != nil {
+		f.discard(f.stmt)
+	}
 }
 
+// discard drops the dirty pages from the cache and restores the file header
+// values of state. Discarded pages are read back from disk the next time
+// they are fetched.
+func (f *fileStore) discard(state *txnState) {
+	for key, v := range f.cache.cache {
+		if v.Value.(*cacheEntry).val.isDirty() {
+			f.cache.remove(key)
+		}
+	}
+	f.freeListHead = state.freeListHead
+	f.lastKey = state.lastKey
+	f.nextFreeOffset = state.nextFreeOffset
+	f.pageTableRoot = state.pageTableRoot
+}
+
+// inTxn returns true if an explicit transaction is open. The transaction
+// state is only changed by the session that owns the store, so no lock is
+// needed to read it from that session.
+func (f *fileStore) inTxn() bool {
+	return f.txn != nil
+}
+
+// setCache adds val to the cache without evicting other pages, since the
+// caller may still modify pages it fetched earlier. The cache is allowed to
+// grow past capacity until it's trimmed at a point where the tree is
+// consistent, such as between the rows of a scan.
 func (f *fileStore) setCache(key any, val *btreeNode) error {
-	if !f.cache.set(key, val) {
-		return ErrLRUCacheFull
+	if _, found := f.cache.get(key); found {
+		f.cache.set(key, val)
+		return nil
 	}
+	f.cache.push(key, val)
 	return nil
 }
 
+// trimCache evicts the least recently used clean pages once the cache holds
+// more pages than its capacity. It must only be called where no page fetched
+// earlier is going to be modified, such as between the rows returned by a
+// RowIterator.
+func (f *fileStore) trimCache() {
+	if f.cache.overfull() {
+		f.cache.trim()
+	}
+}
+
+// readImage returns size bytes of the file starting at offset as they are on
+// disk. Bytes past the end of the file are zero.
+func (f *fileStore) readImage(offset uint64, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := f.file.ReadAt(buf, int64(offset)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeImage writes the raw bytes of img to the file at offset and drops the
+// cached copy of the page at offset.
+func (f *fileStore) writeImage(offset uint64, img []byte) error {
+	if _, err := f.file.WriteAt(img, int64(offset)); err != nil {
+		return err
+	}
+	f.cache.remove(offset)
+	return nil
+}
+
+// dirtyPages returns the file offsets of the dirty pages in the cache.
+func (f *fileStore) dirtyPages() []uint64 {
+	var offsets []uint64
+	for key, v := range f.cache.cache {
+		if v.Value.(*cacheEntry).val.isDirty() {
+			offsets = append(offsets, key.(uint64))
+		}
+	}
+	return offsets
+}
+
 func (f *fileStore) nextLSN() uint64 {
 	return f._nextLSN
 }
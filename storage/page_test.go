@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -12,23 +16,23 @@ func TestEncodeDecodeInternalNode(t *testing.T) {
 	pg := &btreeNode{
 		fileOffset: 10,
 		offsets:    []uint16{2, 1, 0, 3},
-		freeSize:   4009,
+		freeSize:   4001,
 		lastLSN:    1234,
 		internalCells: []*internalCell{
 			{
-				key:        123,
+				key:        rowIDToKey(123),
 				fileOffset: 3,
 			},
 			{
-				key:        12,
+				key:        rowIDToKey(12),
 				fileOffset: 8,
 			},
 			{
-				key:        1,
+				key:        rowIDToKey(1),
 				fileOffset: 6,
 			},
 			{
-				key:        1234,
+				key:        rowIDToKey(1234),
 				fileOffset: 2,
 			},
 		},
@@ -59,27 +63,27 @@ func TestEncodeDecodeLeafNode(t *testing.T) {
 	pg := &btreeNode{
 		isLeaf:     true,
 		fileOffset: 10,
-		freeSize:   3945,
+		freeSize:   3937,
 		offsets:    []uint16{2, 1, 0, 3},
 		lastLSN:    1234,
 		leafCells: []*leafCell{
 			{
-				key:        1,
+				key:        rowIDToKey(1),
 				valueSize:  uint32(len("lorem ipsum")),
 				valueBytes: []byte("lorem ipsum"),
 			},
 			{
-				key:        2,
+				key:        rowIDToKey(2),
 				valueSize:  uint32(len("dolor sit amet")),
 				valueBytes: []byte("dolor sit amet"),
 			},
 			{
-				key:        3,
+				key:        rowIDToKey(3),
 				valueSize:  uint32(len("consectetur adipiscing elit")),
 				valueBytes: []byte("consectetur adipiscing elit"),
 			},
 			{
-				key:        4,
+				key:        rowIDToKey(4),
 				valueSize:  uint32(len("sed do eiusmod")),
 				valueBytes: []byte("sed do eiusmod"),
 			},
@@ -100,11 +104,62 @@ func TestEncodeDecodeLeafNode(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// the cells are decoded in key order
+	expected := *pg
+	expected.offsets = []uint16{0, 1, 2, 3}
+	expected.leafCells = []*leafCell{pg.leafCells[2], pg.leafCells[1], pg.leafCells[0], pg.leafCells[3]}
+
+	if !reflect.DeepEqual(&expected, actual) {
+		t.Errorf("Structs are not the same: %v\n%v", &expected, actual)
+	}
+
+	// leaves written before format version 1 can still be read
+	buf, err = encodeLegacyLeaf(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = &btreeNode{isLeaf: true}
+	if err = actual.decode(buf); err != nil {
+		t.Fatal(err)
+	}
 	if !reflect.DeepEqual(pg, actual) {
 		t.Errorf("Structs are not the same: %v\n%v", pg, actual)
 	}
 }
 
+// encodeLegacyLeaf encodes leaf node n the way it was written before format
+// version 1, with the cells following the free space in key order.
+func encodeLegacyLeaf(n *btreeNode) (*bytes.Buffer, error) {
+	buf := &bytes.Buffer{}
+	for _, v := range []interface{}{LeafNode, n.fileOffset, n.lastLSN, n.hasLSib, n.hasRSib, n.lSibFileOffset, n.rSibFileOffset, uint32(len(n.offsets)), n.offsets} {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	footer := &bytes.Buffer{}
+	for _, offset := range n.offsets {
+		cell := n.leafCells[offset]
+		valueSize, value := cell.valueSize, cell.valueBytes
+		if cell.overflows() {
+			valueSize |= overflowFlag
+			value = make([]byte, 8)
+			binary.LittleEndian.PutUint64(value, cell.overflowPages[0])
+		}
+		for _, v := range []interface{}{uint16(len(cell.key)), cell.key, cell.deleted, valueSize, value} {
+			if err := binary.Write(footer, binary.LittleEndian, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	freeSize := uint16(pageSize - buf.Len() - footer.Len() - 2)
+	if err := binary.Write(buf, binary.LittleEndian, freeSize); err != nil {
+		return nil, err
+	}
+	buf.Write(make([]byte, freeSize))
+	buf.Write(footer.Bytes())
+	return buf, nil
+}
+
 func TestMemoryStore(t *testing.T) {
 
 	pages := []*btreeNode{{isLeaf: true}, {isLeaf: true}, {isLeaf: true}}
@@ -185,11 +240,11 @@ func TestFindCellByKey(t *testing.T) {
 				0, 1, 2, 3, 4,
 			},
 			internalCells: []*internalCell{
-				{key: 1},
-				{key: 3},
-				{key: 5},
-				{key: 7},
-				{key: 9},
+				{key: rowIDToKey(1)},
+				{key: rowIDToKey(3)},
+				{key: rowIDToKey(5)},
+				{key: rowIDToKey(7)},
+				{key: rowIDToKey(9)},
 			},
 		},
 		{
@@ -198,11 +253,11 @@ func TestFindCellByKey(t *testing.T) {
 				0, 1, 2, 3, 4,
 			},
 			leafCells: []*leafCell{
-				{key: 1},
-				{key: 3},
-				{key: 5},
-				{key: 7},
-				{key: 9},
+				{key: rowIDToKey(1)},
+				{key: rowIDToKey(3)},
+				{key: rowIDToKey(5)},
+				{key: rowIDToKey(7)},
+				{key: rowIDToKey(9)},
 			},
 		},
 	}
@@ -281,7 +336,7 @@ func findCellByKeyTestCase(t *testing.T, pg *btreeNode) {
 		var expectedOffset int
 		var expectedFound bool
 
-		expectedOffset, expectedFound = pg.findCellOffsetByKey(v.key)
+		expectedOffset, expectedFound = pg.findCellOffsetByKey(rowIDToKey(v.key))
 
 		if expectedOffset != v.expectedOffset || expectedFound != v.expectedFound {
 			t.Errorf("[key]: %d [page]: %v [expectedOffset]: %d [actualOffset]: %d [expectedFound]: %t [actualFound]: %t",
@@ -290,75 +345,112 @@ func findCellByKeyTestCase(t *testing.T, pg *btreeNode) {
 	}
 }
 
+// leafCellsPerPage is the number of cells with 4-byte keys and 5-byte
+// values that fit on a leaf page
+const leafCellsPerPage = (pageSize - leafNodeHeaderSize) / (offsetElemSize + leafCellHeaderSize + 4 + 5)
+
 func TestIsFullLeafNodeExpectFull(t *testing.T) {
 	pg := &btreeNode{isLeaf: true}
 
-	for i := 0; i < maxLeafNodeCells; i++ {
-		if err := pg.appendLeafCell(uint32(i), []byte("hello")); err != nil {
+	for i := 0; i < leafCellsPerPage+1; i++ {
+		if err := pg.appendLeafCell(rowIDToKey(uint32(i)), []byte("hello")); err != nil {
 			t.Fatal(err)
 		}
 	}
 
 	if !pg.isFull() {
-		t.Errorf("leaf node is supposed to be full but is not. max leaf node cells: %d", maxLeafNodeCells)
+		t.Errorf("leaf node is supposed to be full but is not. max leaf node cells: %d", leafCellsPerPage)
 	}
 }
 
 func TestIsFullLeafNodeExpectNotFull(t *testing.T) {
 	pg := &btreeNode{isLeaf: true}
 
-	for i := 0; i < maxLeafNodeCells-1; i++ {
-		if err := pg.appendLeafCell(uint32(i), []byte("hello")); err != nil {
+	for i := 0; i < leafCellsPerPage; i++ {
+		if err := pg.appendLeafCell(rowIDToKey(uint32(i)), []byte("hello")); err != nil {
 			t.Fatal(err)
 		}
 	}
 
 	if pg.isFull() {
-		t.Errorf("leaf node is not supposed to be full, but it is. max leaf node cells: %d", maxLeafNodeCells)
+		t.Errorf("leaf node is not supposed to be full, but it is. max leaf node cells: %d", leafCellsPerPage)
+	}
+
+	// the cells are packed at their actual size
+	buf, err := pg.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := &btreeNode{isLeaf: true}
+	if err := actual.decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.leafCells) != leafCellsPerPage {
+		t.Errorf("expected %d cells, got %d", leafCellsPerPage, len(actual.leafCells))
+	}
+	if int(actual.freeSize) >= offsetElemSize+leafCellHeaderSize+4+5 {
+		t.Errorf("expected the page to have no room for another cell, got %d free bytes", actual.freeSize)
 	}
 }
 
+// internalCellsPerPage is the number of cells with 4-byte keys that fit on an
+// internal page
+const internalCellsPerPage = (pageSize - internalNodeHeaderSize) / (offsetElemSize + internalCellHeaderSize + 4)
+
 func TestIsFullInternalNodeExpectFull(t *testing.T) {
 	pg := &btreeNode{}
 
-	for i := 0; i < maxInternalNodeCells; i++ {
-		if err := pg.appendInternalCell(uint32(i), 1); err != nil {
+	for i := 0; i < internalCellsPerPage+1; i++ {
+		if err := pg.appendInternalCell(rowIDToKey(uint32(i)), 1); err != nil {
 			t.Fatal(err)
 		}
 	}
 
 	if !pg.isFull() {
-		t.Errorf("internal node is supposed to be full but is not. branch factor: %d", maxInternalNodeCells)
+		t.Errorf("internal node is supposed to be full but is not. branch factor: %d", internalCellsPerPage)
 	}
 }
 
 func TestIsFullInternalNodeExpectNotFull(t *testing.T) {
 	pg := &btreeNode{}
 
-	for i := 0; i < maxInternalNodeCells-1; i++ {
-		if err := pg.appendInternalCell(uint32(i), 1); err != nil {
+	for i := 0; i < internalCellsPerPage; i++ {
+		if err := pg.appendInternalCell(rowIDToKey(uint32(i)), 1); err != nil {
 			t.Fatal(err)
 		}
 	}
 
 	if pg.isFull() {
-		t.Errorf("internal node is not supposed to be full, but it is. branch factor: %d", maxInternalNodeCells)
+		t.Errorf("internal node is not supposed to be full, but it is. branch factor: %d", internalCellsPerPage)
+	}
+
+	// the cells fill the page
+	buf, err := pg.encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := &btreeNode{}
+	if err := actual.decode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.internalCells) != internalCellsPerPage {
+		t.Errorf("expected %d cells, got %d", internalCellsPerPage, len(actual.internalCells))
 	}
 }
 
 func TestSplitLeafNode(t *testing.T) {
 	pg := &btreeNode{isLeaf: true}
 
-	if err := pg.appendLeafCell(0, []byte("hello 0")); err != nil {
+	if err := pg.appendLeafCell(rowIDToKey(0), []byte("hello 0")); err != nil {
 		t.Fatal(err)
 	}
-	if err := pg.appendLeafCell(1, []byte("hello 1")); err != nil {
+	if err := pg.appendLeafCell(rowIDToKey(1), []byte("hello 1")); err != nil {
 		t.Fatal(err)
 	}
-	if err := pg.appendLeafCell(2, []byte("hello 2")); err != nil {
+	if err := pg.appendLeafCell(rowIDToKey(2), []byte("hello 2")); err != nil {
 		t.Fatal(err)
 	}
-	if err := pg.appendLeafCell(3, []byte("hello 3")); err != nil {
+	if err := pg.appendLeafCell(rowIDToKey(3), []byte("hello 3")); err != nil {
 		t.Fatal(err)
 	}
 
@@ -368,8 +460,8 @@ func TestSplitLeafNode(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if parentKey != 2 {
-		t.Errorf("parent key is unexpected. actual: %d", parentKey)
+	if keyToRowID(parentKey) != 2 {
+		t.Errorf("parent key is unexpected. actual: %v", parentKey)
 	}
 	if len(newPg.leafCells) != 2 {
 		t.Errorf("new page is supposed to be half size but is not. size: %d", len(newPg.leafCells))
@@ -377,12 +469,12 @@ func TestSplitLeafNode(t *testing.T) {
 
 	expected := []interface{}{
 		&leafCell{
-			key:        0,
+			key:        rowIDToKey(0),
 			valueSize:  uint32(len([]byte("hello 0"))),
 			valueBytes: []byte("hello 0"),
 		},
 		&leafCell{
-			key:        1,
+			key:        rowIDToKey(1),
 			valueSize:  uint32(len([]byte("hello 1"))),
 			valueBytes: []byte("hello 1"),
 		},
@@ -397,12 +489,12 @@ func TestSplitLeafNode(t *testing.T) {
 
 	expected = []interface{}{
 		&leafCell{
-			key:        2,
+			key:        rowIDToKey(2),
 			valueSize:  uint32(len([]byte("hello 2"))),
 			valueBytes: []byte("hello 2"),
 		},
 		&leafCell{
-			key:        3,
+			key:        rowIDToKey(3),
 			valueSize:  uint32(len([]byte("hello 3"))),
 			valueBytes: []byte("hello 3"),
 		},
@@ -416,6 +508,50 @@ func TestSplitLeafNode(t *testing.T) {
 	}
 }
 
+func TestSplitInternalNodeWithLongKeys(t *testing.T) {
+	pg := &btreeNode{}
+
+	// a few long keys followed by many short ones
+	key := func(i int, size int) []byte {
+		k := make([]byte, size)
+		binary.BigEndian.PutUint32(k, uint32(i))
+		return k
+	}
+	for i := 0; !pg.isFull(); i++ {
+		size := 4
+		if i < 4 {
+			size = maxKeySize
+		}
+		if err := pg.appendInternalCell(key(i, size), uint64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cellCount := len(pg.offsets)
+
+	newPg := &btreeNode{}
+	parentKey, err := pg.split(newPg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the split is by size rather than by cell count, so that both halves
+	// fit on a page
+	if len(pg.offsets) >= len(newPg.offsets) {
+		t.Errorf("expected the left node to have fewer cells, got %d and %d", len(pg.offsets), len(newPg.offsets))
+	}
+	if len(pg.offsets)+len(newPg.offsets)+1 != cellCount {
+		t.Errorf("expected %d cells after the split, got %d", cellCount-1, len(pg.offsets)+len(newPg.offsets))
+	}
+	if !bytes.Equal(parentKey, key(len(pg.offsets), len(parentKey))) {
+		t.Errorf("unexpected parent key %v", parentKey)
+	}
+	for _, n := range []*btreeNode{pg, newPg} {
+		if _, err := n.encode(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func TestLeafNodeSizeLimit(t *testing.T) {
 
 	tbl := []struct {
@@ -427,7 +563,7 @@ func TestLeafNodeSizeLimit(t *testing.T) {
 			name: "insert cell at size limit",
 			fn: func() error {
 				pg := &btreeNode{isLeaf: true}
-				return pg.insertLeafCell(0, 0, make([]byte, maxValueSize))
+				return pg.insertLeafCell(0, rowIDToKey(0), make([]byte, maxRowSize))
 			},
 			expectErr: nil,
 		},
@@ -435,11 +571,11 @@ func TestLeafNodeSizeLimit(t *testing.T) {
 			name: "update cell at size limit",
 			fn: func() error {
 				pg := &btreeNode{isLeaf: true}
-				err := pg.insertLeafCell(0, 0, []byte("test"))
+				err := pg.insertLeafCell(0, rowIDToKey(0), []byte("test"))
 				if err != nil {
 					return err
 				}
-				return pg.updateCell(0, make([]byte, maxValueSize))
+				return pg.updateCell(rowIDToKey(0), make([]byte, maxRowSize))
 			},
 			expectErr: nil,
 		},
@@ -447,7 +583,7 @@ func TestLeafNodeSizeLimit(t *testing.T) {
 			name: "insert cell over size limit",
 			fn: func() error {
 				pg := &btreeNode{isLeaf: true}
-				return pg.insertLeafCell(0, 0, make([]byte, maxValueSize+1))
+				return pg.insertLeafCell(0, rowIDToKey(0), make([]byte, maxRowSize+1))
 			},
 			expectErr: ErrRowTooLarge,
 		},
@@ -455,11 +591,11 @@ func TestLeafNodeSizeLimit(t *testing.T) {
 			name: "update cell over size limit",
 			fn: func() error {
 				pg := &btreeNode{isLeaf: true}
-				err := pg.insertLeafCell(0, 0, []byte("test"))
+				err := pg.insertLeafCell(0, rowIDToKey(0), []byte("test"))
 				if err != nil {
 					return err
 				}
-				return pg.updateCell(0, make([]byte, maxValueSize+1))
+				return pg.updateCell(rowIDToKey(0), make([]byte, maxRowSize+1))
 			},
 			expectErr: ErrRowTooLarge,
 		},
@@ -475,3 +611,324 @@ func TestLeafNodeSizeLimit(t *testing.T) {
 	}
 
 }
+
+func TestSlottedLeafPages(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { rs.Close() }()
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{Name: "a", DataType: TypeInt},
+			{Name: "b", DataType: TypeInt},
+			{Name: "c", DataType: TypeInt},
+		},
+	}
+	if err := rs.CreateTable(r, "nums"); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(f func() (WALBatch, error)) {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		batch, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const rowCount = 1000
+	for i := 0; i < rowCount; i++ {
+		exec(func() (WALBatch, error) {
+			return rs.Insert("nums", []string{"a"}, []interface{}{int64(i)})
+		})
+	}
+
+	leaves := func() int {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		root, err := rs.getRelationFileOffset("nums")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, err := rs.treePages(uint64(root))
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for _, offset := range pages {
+			pg, err := rs.fs.fetch(offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pg.isLeaf {
+				count++
+			}
+		}
+		return count
+	}
+
+	// small rows are packed many to a page
+	before := leaves()
+	if rowsPerPage := rowCount / before; rowsPerPage < 50 {
+		t.Errorf("expected at least 50 rows per leaf, got %d", rowsPerPage)
+	}
+
+	// rows that grow split their leaves
+	rs.StartTxn()
+	rows, _, err := rs.Fetch("nums")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		exec(func() (WALBatch, error) {
+			return rs.Update("nums", row.Key, []string{"b", "c"}, []interface{}{int64(1), int64(2)})
+		})
+	}
+	if after := leaves(); after <= before {
+		t.Errorf("expected more than %d leaves after the update, got %d", before, after)
+	}
+
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.StartTxn()
+	rows, _, err = rs.Fetch("nums")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != rowCount {
+		t.Fatalf("expected %d rows, got %d", rowCount, len(rows))
+	}
+	for i, row := range rows {
+		if !reflect.DeepEqual(row.Vals, []interface{}{int64(i), int64(1), int64(2)}) {
+			t.Errorf("unexpected values for row %d: %v", i, row.Vals)
+		}
+	}
+}
+
+func TestUpgradeFormatVersion(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{Name: "id", DataType: TypeInt},
+			{Name: "body", DataType: TypeText},
+		},
+	}
+	if err := rs.CreateTable(r, "docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	bodies := []string{"short", strings.Repeat("a", 5000)}
+	for i, body := range bodies {
+		rs.StartTxn()
+		batch, err := rs.Insert("docs", []string{"id", "body"}, []interface{}{int64(i), body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		rs.EndTxn()
+	}
+	if err := rs.fs.flushPages(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite the file the way it was written before format version 1
+	rs.StartTxn()
+	var leaves []uint64
+	for offset := uint64(pageSize); offset < rs.fs.nextFreeOffset; offset += pageSize {
+		img, err := rs.fs.readImage(offset, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img[0] != SlottedLeafNode {
+			continue
+		}
+		pg, err := rs.fs.fetch(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf, err := encodeLegacyLeaf(pg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.fs.writeImage(offset, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, offset)
+	}
+	rs.EndTxn()
+	if len(leaves) == 0 {
+		t.Fatal("expected the file to have leaf pages")
+	}
+	rs.fs.formatVersion = 0
+	if err := rs.fs.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	if rs.fs.formatVersion != currentFormatVersion {
+		t.Errorf("expected format version %d, got %d", currentFormatVersion, rs.fs.formatVersion)
+	}
+	for _, offset := range leaves {
+		img, err := rs.fs.readImage(offset, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img[0] != SlottedLeafNode {
+			t.Errorf("expected page %d to be upgraded, got type %d", offset, img[0])
+		}
+	}
+	rs.StartTxn()
+	rows, _, err := rs.Fetch("docs")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(bodies) {
+		t.Fatalf("expected %d rows, got %d", len(bodies), len(rows))
+	}
+	for i, row := range rows {
+		if row.Vals[1] != bodies[i] {
+			t.Errorf("row %d: expected a body of %d bytes, got %v", i, len(bodies[i]), row.Vals[1])
+		}
+	}
+
+	// files from a newer version are refused
+	rs.fs.formatVersion = currentFormatVersion + 1
+	if err := rs.fs.upgrade(); !errors.Is(err, ErrFormatVersion) {
+		t.Errorf("expected ErrFormatVersion, got %v", err)
+	}
+	rs.fs.formatVersion = currentFormatVersion
+}
+
+func TestScanTrimsCache(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	idx := &Index{Name: "people_bio_idx", TableName: "people", Columns: []string{"bio"}}
+	r := &Relation{
+		Fields: []FieldDef{
+			{
+				Name:     "id",
+				DataType: TypeInt,
+			},
+			{
+				Name:     "bio",
+				DataType: TypeVarchar,
+				Len:      400,
+			},
+		},
+		Indexes: []*Index{idx},
+	}
+	if err := rs.CreateTable(r, "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rows are just short enough to be stored on the leaves, and spread
+	// the table and index over many more pages than the cache holds
+	const rowCount = 400
+	for i := 0; i < rowCount; i++ {
+		if err := rs.StartTxn(); err != nil {
+			t.Fatal(err)
+		}
+		bio := fmt.Sprintf("%03d%s", i, strings.Repeat("x", 380))
+		batch, err := rs.Insert("people", []string{"id", "bio"}, []interface{}{int64(i), bio})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		rs.EndTxn()
+	}
+	if err := rs.fs.flushPages(); err != nil {
+		t.Fatal(err)
+	}
+	rs.fs.cache.maxNodes = 8
+
+	scans := map[string]func() (RowIterator, []*Field, error){
+		"table scan": func() (RowIterator, []*Field, error) {
+			return rs.Scan("people")
+		},
+		"index scan": func() (RowIterator, []*Field, error) {
+			return rs.ScanByIndexRange(idx, nil, KeyRange{Lower: "", LowerInclusive: true})
+		},
+	}
+	for name, scan := range scans {
+		if err := rs.StartTxn(); err != nil {
+			t.Fatal(err)
+		}
+		count, maxSize := 0, 0
+		it, _, err := scan()
+		for err == nil {
+			var row *Row
+			if row, err = it.Next(); row == nil {
+				break
+			}
+			count++
+			if size := len(rs.fs.cache.cache); size > maxSize {
+				maxSize = size
+			}
+		}
+		rs.EndTxn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if maxSize > rs.fs.cache.maxNodes {
+			t.Errorf("%s: the cache held %d pages, more than its capacity of %d", name, maxSize, rs.fs.cache.maxNodes)
+		}
+		if count != rowCount {
+			t.Errorf("%s: expected %d rows, got %d", name, rowCount, count)
+		}
+	}
+}
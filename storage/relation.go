@@ -5,11 +5,14 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type DataType uint8
@@ -19,13 +22,37 @@ const (
 	TypeVarchar
 	TypeBoolean
 	TypeBigInt
+	// TypeText is a string of any length
+	TypeText
+	// TypeBlob is a string of bytes of any length. Its values are Go strings
+	// like the values of the other string types, which keeps them comparable.
+	TypeBlob
+	// TypeReal is a single-precision floating-point number. Its values are
+	// float64s rounded to single precision.
+	TypeReal
+	// TypeDouble is a double-precision floating-point number
+	TypeDouble
+	// TypeDecimal is an exact decimal number with up to Len digits, Scale of
+	// which follow the decimal point. Its values are Decimals.
+	TypeDecimal
+	// TypeDate is a calendar date. Its values are Dates.
+	TypeDate
+	// TypeTime is a time of day. Its values are Times.
+	TypeTime
+	// TypeTimestamp is a date and time of day in UTC. Its values are
+	// Timestamps.
+	TypeTimestamp
+	// TypeInterval is a span of time. Its values are Intervals.
+	TypeInterval
 )
 
 const (
 	initialPageTableOffset   = pageSize
 	initialSchemaTableOffset = initialPageTableOffset * 2
+	initialIndexTableOffset  = initialPageTableOffset * 3
 	pageTableName            = "sys_pages"
 	schemaTableName          = "sys_schema"
+	indexTableName           = "sys_indexes"
 )
 
 var (
@@ -33,18 +60,31 @@ var (
 	ErrDBExists          = errors.New("database already exists")
 	ErrDBNotExist        = errors.New("database does not exist")
 	ErrDBNotSelected     = errors.New("database not been selected")
+	ErrDBInUse           = errors.New("database is in use")
 	ErrFieldAmbiguous    = errors.New("field is ambiguous")
 	ErrFieldNotFound     = errors.New("field not found")
 	ErrTableAlreadyExist = errors.New("table already exists")
 	ErrTableNotExist     = errors.New("table does not exist")
 	ErrTypeMismatch      = errors.New("types do not match")
 	ErrIntOutOfRange     = errors.New("integer value out of range")
+	ErrReservedColumn    = errors.New("column name is reserved")
+	ErrNotNullViolation  = errors.New("null value violates not-null constraint")
 )
 
 type FieldDef struct {
 	DataType
 	Name string
 	Len  int64
+	// Scale is the number of digits after the decimal point of a DECIMAL
+	// field.
+	Scale   int64
+	NotNull bool
+	// Default is the value that an INSERT stores in the field when it leaves
+	// the field out. It's nil if the field has no default.
+	Default interface{}
+	// dropped is true if the field was dropped from the table. A dropped
+	// field keeps its place in the rows stored before it was dropped.
+	dropped bool
 }
 
 func (f *FieldDef) Validate(val interface{}) error {
@@ -60,7 +100,7 @@ func (f *FieldDef) Validate(val interface{}) error {
 		if reflect.TypeOf(val).Kind() != reflect.Int64 {
 			return ErrTypeMismatch
 		}
-	case TypeVarchar:
+	case TypeVarchar, TypeText, TypeBlob:
 		if reflect.TypeOf(val).Kind() != reflect.String {
 			return ErrTypeMismatch
 		}
@@ -68,12 +108,125 @@ func (f *FieldDef) Validate(val interface{}) error {
 		if reflect.TypeOf(val).Kind() != reflect.Bool {
 			return ErrTypeMismatch
 		}
+	case TypeReal, TypeDouble:
+		if reflect.TypeOf(val).Kind() != reflect.Float64 {
+			return ErrTypeMismatch
+		}
+	case TypeDecimal:
+		d, ok := val.(Decimal)
+		if !ok || int64(d.Scale()) != f.Scale {
+			return ErrTypeMismatch
+		}
+		if int64(d.Precision()-d.Scale()) > f.Len-f.Scale {
+			return ErrNumericOutOfRange
+		}
+	case TypeDate:
+		if _, ok := val.(Date); !ok {
+			return ErrTypeMismatch
+		}
+	case TypeTime:
+		if _, ok := val.(Time); !ok {
+			return ErrTypeMismatch
+		}
+	case TypeTimestamp:
+		if _, ok := val.(Timestamp); !ok {
+			return ErrTypeMismatch
+		}
+	case TypeInterval:
+		if _, ok := val.(Interval); !ok {
+			return ErrTypeMismatch
+		}
 	default:
 		panic("unsupported validation type")
 	}
 	return nil
 }
 
+// Convert returns val converted to the numeric or temporal type of the
+// field. Integers are promoted to floating-point and decimal numbers, and
+// decimal and floating-point numbers are converted to each other. Decimals
+// are rounded to the scale of the field. Strings are parsed as dates, times,
+// timestamps and intervals, and dates and timestamps are converted to each
+// other. Values of other types are returned as is and left for Validate to
+// reject.
+func (f *FieldDef) Convert(val interface{}) (interface{}, error) {
+	switch f.DataType {
+	case TypeReal, TypeDouble:
+		var fl float64
+		switch v := val.(type) {
+		case int64:
+			fl = float64(v)
+		case float64:
+			fl = v
+		case Decimal:
+			fl = v.Float64()
+		default:
+			return val, nil
+		}
+		if f.DataType == TypeReal {
+			if math.Abs(fl) > math.MaxFloat32 && !math.IsInf(fl, 0) {
+				return nil, ErrNumericOutOfRange
+			}
+			fl = roundReal(fl)
+		}
+		return fl, nil
+	case TypeDecimal:
+		var d Decimal
+		var err error
+		switch v := val.(type) {
+		case int64:
+			d, err = NewDecimal(v, 0).Rescale(int(f.Scale))
+		case float64:
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				return nil, ErrNumericOutOfRange
+			}
+			d, err = DecimalFromFloat(v, int(f.Scale))
+		case Decimal:
+			d, err = v.Rescale(int(f.Scale))
+		default:
+			return val, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int64(d.Precision()-d.Scale()) > f.Len-f.Scale {
+			return nil, ErrNumericOutOfRange
+		}
+		return d, nil
+	case TypeDate:
+		switch v := val.(type) {
+		case string:
+			return ParseDate(v)
+		case Timestamp:
+			return v.Date(), nil
+		}
+	case TypeTime:
+		if v, ok := val.(string); ok {
+			return ParseTime(v)
+		}
+	case TypeTimestamp:
+		switch v := val.(type) {
+		case string:
+			return ParseTimestamp(v)
+		case Date:
+			return TimestampFromTime(v.Time()), nil
+		}
+	case TypeInterval:
+		if v, ok := val.(string); ok {
+			return ParseInterval(v)
+		}
+	}
+	return val, nil
+}
+
+// roundReal rounds f to single precision. It returns the double nearest to
+// the shortest decimal form of the single, so that 0.1 stays 0.1 rather than
+// becoming 0.10000000149011612.
+func roundReal(f float64) float64 {
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+	return r
+}
+
 var pageTableSchema = Relation{
 	Fields: []FieldDef{
 		{
@@ -109,11 +262,101 @@ var schemaTableSchema = Relation{
 			DataType: TypeInt,
 			Len:      255,
 		},
+		{
+			Name:     "not_null",
+			DataType: TypeBoolean,
+		},
+		{
+			Name:     "default_value",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "key_pos",
+			DataType: TypeInt,
+		},
+		{
+			Name:     "dropped",
+			DataType: TypeBoolean,
+		},
+		{
+			Name:     "field_scale",
+			DataType: TypeInt,
+		},
 	},
 }
 
 type Relation struct {
 	Fields []FieldDef
+	// PrimaryKey holds the primary key columns that the table is clustered
+	// on. The rows of a clustered table are keyed by their encoded primary
+	// key values rather than by a row ID. It's nil for tables keyed by row
+	// ID.
+	PrimaryKey []string
+	// Indexes holds the indexes that back the table's PRIMARY KEY and UNIQUE
+	// constraints, which are created along with the table.
+	Indexes []*Index
+	// Checks holds the table's CHECK constraints.
+	Checks []*Check
+	// ForeignKeys holds the table's FOREIGN KEY constraints.
+	ForeignKeys []*ForeignKey
+	// layout holds the fields in the order they're stored in the table's
+	// rows, including the fields that were dropped. It's nil if no field was
+	// dropped, in which case the rows are stored as Fields.
+	layout []FieldDef
+}
+
+// storedFields returns the fields in the order they're stored in the
+// table's rows.
+func (r *Relation) storedFields() []FieldDef {
+	if r.layout != nil {
+		return r.layout
+	}
+	return r.Fields
+}
+
+// clusteredOn returns true if idx is the primary key index that the table is
+// clustered on. Such an index has no tree of its own.
+func (r *Relation) clusteredOn(idx *Index) bool {
+	return idx.Primary && r.PrimaryKey != nil
+}
+
+// rowKey returns the key of the table row with values vals, which is the
+// encoded primary key of a clustered table. The rows of other tables are
+// keyed by row ID, which rowKey returns nil for.
+func (r *Relation) rowKey(vals map[string]interface{}) ([]byte, error) {
+	if r.PrimaryKey == nil {
+		return nil, nil
+	}
+	keyVals := make([]interface{}, len(r.PrimaryKey))
+	for i, col := range r.PrimaryKey {
+		keyVals[i] = vals[col]
+	}
+	key, err := encodeIndexKey(keyVals)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeySizeLimit(key); err != nil {
+		return nil, fmt.Errorf("%w: primary key", err)
+	}
+	return key, nil
+}
+
+// ConvertVals converts the values in vals to the types of the table's
+// fields. See FieldDef.Convert.
+func (r *Relation) ConvertVals(vals map[string]interface{}) error {
+	for _, fd := range r.Fields {
+		val, ok := vals[fd.Name]
+		if !ok || val == nil {
+			continue
+		}
+		val, err := fd.Convert(val)
+		if err != nil {
+			return fmt.Errorf("%w: column %s", err, fd.Name)
+		}
+		vals[fd.Name] = val
+	}
+	return nil
 }
 
 func (r *Relation) Encode() (*bytes.Buffer, error) {
@@ -187,9 +430,19 @@ type Tuple struct {
 func (r *Tuple) Encode() (*bytes.Buffer, error) {
 	buf := &bytes.Buffer{}
 
-	for _, fd := range r.Relation.Fields {
+	for _, fd := range r.Relation.storedFields() {
+		if fd.dropped {
+			if err := binary.Write(buf, binary.LittleEndian, true); err != nil {
+				return buf, err
+			}
+			continue
+		}
+
 		val := r.Vals[fd.Name]
 		isNull := val == nil
+		if isNull && fd.NotNull {
+			return buf, fmt.Errorf("%w: column %s", ErrNotNullViolation, fd.Name)
+		}
 
 		if err := binary.Write(buf, binary.LittleEndian, isNull); err != nil {
 			return buf, err
@@ -216,13 +469,41 @@ func (r *Tuple) Encode() (*bytes.Buffer, error) {
 			if err := binary.Write(buf, binary.LittleEndian, val.(bool)); err != nil {
 				return buf, err
 			}
-		case TypeVarchar:
+		case TypeVarchar, TypeText, TypeBlob:
 			if err := binary.Write(buf, binary.LittleEndian, uint32(len(val.(string)))); err != nil {
 				return buf, err
 			}
 			if err := binary.Write(buf, binary.LittleEndian, []byte(val.(string))); err != nil {
 				return buf, err
 			}
+		case TypeReal:
+			if err := binary.Write(buf, binary.LittleEndian, float32(val.(float64))); err != nil {
+				return buf, err
+			}
+		case TypeDouble:
+			if err := binary.Write(buf, binary.LittleEndian, val.(float64)); err != nil {
+				return buf, err
+			}
+		case TypeDecimal:
+			if err := binary.Write(buf, binary.LittleEndian, val.(Decimal).coef); err != nil {
+				return buf, err
+			}
+		case TypeDate:
+			if err := binary.Write(buf, binary.LittleEndian, int32(val.(Date))); err != nil {
+				return buf, err
+			}
+		case TypeTime:
+			if err := binary.Write(buf, binary.LittleEndian, int64(val.(Time))); err != nil {
+				return buf, err
+			}
+		case TypeTimestamp:
+			if err := binary.Write(buf, binary.LittleEndian, int64(val.(Timestamp))); err != nil {
+				return buf, err
+			}
+		case TypeInterval:
+			if err := binary.Write(buf, binary.LittleEndian, val.(Interval)); err != nil {
+				return buf, err
+			}
 		}
 	}
 
@@ -230,9 +511,19 @@ func (r *Tuple) Encode() (*bytes.Buffer, error) {
 }
 
 func (r *Tuple) Decode(buf *bytes.Buffer) error {
-	for _, fd := range r.Relation.Fields {
+	fields := r.Relation.storedFields()
+	for i, fd := range fields {
 		var isNull bool
-		if err := binary.Read(buf, binary.LittleEndian, &isNull); err != nil {
+		if err := binary.Read(buf, binary.LittleEndian, &isNull); err == io.EOF {
+			// the tuple was written before the rest of the fields were
+			// added, so they hold their defaults
+			for _, fd := range fields[i:] {
+				if !fd.dropped && fd.Default != nil {
+					r.Vals[fd.Name] = fd.Default
+				}
+			}
+			return nil
+		} else if err != nil {
 			return err
 		}
 		if isNull {
@@ -254,7 +545,7 @@ func (r *Tuple) Decode(buf *bytes.Buffer) error {
 				return err
 			}
 			v = val
-		case TypeVarchar:
+		case TypeVarchar, TypeText, TypeBlob:
 			var strLen uint32
 			if err := binary.Read(buf, binary.LittleEndian, &strLen); err != nil {
 				return err
@@ -271,30 +562,298 @@ func (r *Tuple) Decode(buf *bytes.Buffer) error {
 				return err
 			}
 			v = val
+		case TypeReal:
+			var val float32
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = roundReal(float64(val))
+		case TypeDouble:
+			var val float64
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = val
+		case TypeDecimal:
+			var val int64
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = NewDecimal(val, int(fd.Scale))
+		case TypeDate:
+			var val int32
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = Date(val)
+		case TypeTime:
+			var val int64
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = Time(val)
+		case TypeTimestamp:
+			var val int64
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = Timestamp(val)
+		case TypeInterval:
+			var val Interval
+			if err := binary.Read(buf, binary.LittleEndian, &val); err != nil {
+				return err
+			}
+			v = val
 		default:
 			panic("unsupported data type")
 		}
 
-		r.Vals[fd.Name] = v
+		if !fd.dropped {
+			r.Vals[fd.Name] = v
+		}
 	}
 
 	return nil
 }
 
 type RelationService struct {
-	fs  *fileStore
-	wal *wal
+	fs       *fileStore
+	wal      *wal
+	txnBatch WALBatch
+	txnID    uint64
+	// stmtTxnID identifies the implicit transaction of a statement that runs
+	// outside of an explicit transaction once its begin record is logged
+	stmtTxnID uint64
+	// undoImages holds the before-images of the pages that the current
+	// transaction wrote to disk before committing
+	undoImages map[uint64][]byte
+	// dbName is the name of the database opened by OpenRelation
+	dbName string
 }
 
-func (rs *RelationService) StartTxn() {
+// openDBs counts the RelationServices open on each database. A database
+// can't be dropped while it's open.
+var openDBs = struct {
+	sync.Mutex
+	refs map[string]int
+}{refs: make(map[string]int)}
+
+// StartTxn starts a statement. Outside of an explicit transaction, the
+// statement runs as its own transaction, and the pages left dirty by earlier
+// statements are written to disk first so that AbortStmt can discard the
+// statement's changes. EndTxn must be called once the statement is done.
+func (rs *RelationService) StartTxn() error {
+	if !rs.fs.inTxn() {
+		rs.stmtTxnID = 0
+		rs.undoImages = nil
+		if err := rs.fs.beginStmt(); err != nil {
+			return err
+		}
+	}
 	rs.fs.lockShared()
+	return nil
 }
 
 func (rs *RelationService) EndTxn() {
 	rs.fs.unlockShared()
+	if err := rs.autoCheckpoint(); err != nil {
+		logf("error running checkpoint: %s", err.Error())
+	}
+}
+
+// Checkpoint writes all dirty pages to disk and empties the WAL.
+func (rs *RelationService) Checkpoint() error {
+	if err := rs.fs.checkpoint(); err != nil {
+		return err
+	}
+	return rs.wal.truncate(0)
+}
+
+// autoCheckpoint takes a checkpoint once the WAL has outgrown its size
+// threshold. The log has to keep growing until an open transaction ends.
+func (rs *RelationService) autoCheckpoint() error {
+	if rs.wal.size < walCheckpointThreshold || rs.fs.inTxn() {
+		return nil
+	}
+	return rs.Checkpoint()
+}
+
+// Begin starts an explicit transaction and logs its begin record. The WAL
+// entries of the statements that follow are held back until the transaction
+// commits.
+func (rs *RelationService) Begin() error {
+	if err := rs.fs.beginTxn(); err != nil {
+		return err
+	}
+	begin := rs.newTxnRecord(OpBegin, rs.fs.nextLSN())
+	if err := rs.wal.flush(WALBatch{begin}); err != nil {
+		if rbErr := rs.fs.rollbackTxn(); rbErr != nil {
+			return rbErr
+		}
+		return err
+	}
+	rs.txnID = begin.txnID
+	return nil
+}
+
+// Commit writes the WAL entries of the open transaction to the log as a
+// single unit followed by a commit record.
+func (rs *RelationService) Commit() error {
+	if !rs.fs.inTxn() {
+		return ErrNoTxnInProgress
+	}
+	batch := append(rs.txnBatch, rs.newTxnRecord(OpCommit, rs.txnID))
+	if err := rs.wal.flush(batch.withTxnID(rs.txnID)); err != nil {
+		return err
+	}
+	rs.txnBatch = nil
+	rs.undoImages = nil
+	if err := rs.fs.commitTxn(); err != nil {
+		return err
+	}
+	return rs.autoCheckpoint()
+}
+
+// Rollback ends the open transaction, undoes its changes and logs its abort
+// record.
+func (rs *RelationService) Rollback() error {
+	if err := rs.fs.rollbackTxn(); err != nil {
+		return err
+	}
+	return rs.undo(rs.txnID)
+}
+
+// AbortStmt undoes the changes of the statement started by StartTxn, which
+// failed part way, before EndTxn is called. The changes of a statement that
+// runs inside an explicit transaction can't be told apart from the ones of
+// the statements before it, so the whole transaction is rolled back.
+func (rs *RelationService) AbortStmt() error {
+	inTxn := rs.fs.inTxn()
+	rs.fs.abortStmt()
+	if inTxn {
+		return rs.undo(rs.txnID)
+	}
+	txnID := rs.stmtTxnID
+	rs.stmtTxnID = 0
+	if txnID == 0 {
+		// nothing was logged or written to disk
+		return nil
+	}
+	return rs.undo(txnID)
+}
+
+// undo restores the pages that transaction txnID wrote to disk before it
+// committed and logs its abort record. The transaction's pages have already
+// been dropped from the cache.
+func (rs *RelationService) undo(txnID uint64) error {
+	rs.txnBatch = nil
+
+	for offset, img := range rs.undoImages {
+		if err := rs.fs.writeImage(offset, img); err != nil {
+			return err
+		}
+	}
+	if len(rs.undoImages) > 0 {
+		if err := rs.fs.file.Sync(); err != nil {
+			return err
+		}
+	}
+	rs.undoImages = nil
+
+	return rs.wal.flush(WALBatch{rs.newTxnRecord(OpAbort, txnID)})
+}
+
+// stealPages writes the dirty pages to disk once they no longer fit in the
+// page cache, even though the current transaction hasn't committed yet. The
+// before-image of each page is logged first so that recovery can undo the
+// changes if the transaction never commits. It must only be called between
+// row operations, where the on-disk tree is consistent.
+func (rs *RelationService) stealPages() error {
+	// evict the clean pages first, and only steal dirty ones if that isn't
+	// enough
+	rs.fs.cache.trim()
+	if !rs.fs.cache.overfull() {
+		return nil
+	}
+
+	txnID, err := rs.curTxnID()
+	if err != nil {
+		return err
+	}
+
+	if rs.undoImages == nil {
+		rs.undoImages = make(map[uint64][]byte)
+	}
+
+	// overflow pages may be taken off the free list, so they're undone
+	// along with the dirty pages
+	overflow, err := rs.fs.allocOverflow()
+	if err != nil {
+		return err
+	}
+
+	// the file header is written along with the pages
+	offsets := append([]uint64{0}, rs.fs.dirtyPages()...)
+	offsets = append(offsets, overflow...)
+
+	var batch WALBatch
+	for _, offset := range offsets {
+		if _, ok := rs.undoImages[offset]; ok {
+			continue
+		}
+		size := pageSize
+		if offset == 0 {
+			size = fileHeaderSize
+		}
+		img, err := rs.fs.readImage(offset, size)
+		if err != nil {
+			return err
+		}
+		rs.undoImages[offset] = img
+		entry := rs.newTxnRecord(OpUndoImage, txnID)
+		entry.pageID = offset
+		entry.val = img
+		batch = append(batch, entry)
+	}
+
+	// the before-images must be durable before the pages are overwritten
+	if err := rs.wal.flush(batch); err != nil {
+		return err
+	}
+	return rs.fs.writeDirtyPages()
+}
+
+// curTxnID returns the ID of the current transaction. Outside of an explicit
+// transaction, the begin record of the statement's implicit transaction is
+// logged the first time it's needed.
+func (rs *RelationService) curTxnID() (uint64, error) {
+	if rs.fs.inTxn() {
+		return rs.txnID, nil
+	}
+	if rs.stmtTxnID == 0 {
+		begin := rs.newTxnRecord(OpBegin, rs.fs.nextLSN())
+		if err := rs.wal.flush(WALBatch{begin}); err != nil {
+			return 0, err
+		}
+		rs.stmtTxnID = begin.txnID
+	}
+	return rs.stmtTxnID, nil
+}
+
+// InTxn returns true if an explicit transaction is open.
+func (rs *RelationService) InTxn() bool {
+	return rs.fs.inTxn()
 }
 
 func (rs *RelationService) Close() error {
+	if rs.dbName != "" {
+		openDBs.Lock()
+		key := strings.ToLower(rs.dbName)
+		if openDBs.refs[key]--; openDBs.refs[key] == 0 {
+			delete(openDBs.refs, key)
+		}
+		openDBs.Unlock()
+	}
 	if err := rs.wal.close(); err != nil {
 		return err
 	}
@@ -302,6 +861,9 @@ func (rs *RelationService) Close() error {
 }
 
 func OpenRelation(dbName string, forceWALSync bool) (*RelationService, error) {
+	openDBs.Lock()
+	defer openDBs.Unlock()
+
 	path, exists, err := dbFilePath(dbName)
 	if err != nil {
 		return nil, err
@@ -316,19 +878,53 @@ func OpenRelation(dbName string, forceWALSync bool) (*RelationService, error) {
 	if err := fs.open(); err != nil {
 		return nil, err
 	}
+	intKeys, err := fs.hasIntKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.upgrade(); err != nil {
+		return nil, err
+	}
 	wal, err := newWal(dbName, forceWALSync)
 	if err != nil {
 		return nil, err
 	}
-	return &RelationService{
-		fs:  fs,
-		wal: wal,
-	}, nil
+	rs := &RelationService{
+		fs:     fs,
+		wal:    wal,
+		dbName: dbName,
+	}
+	if intKeys {
+		if err := rs.upgradeCatalog(); err != nil {
+			return nil, err
+		}
+	}
+	openDBs.refs[strings.ToLower(dbName)]++
+	return rs, nil
+}
+
+// DropDB deletes database dbName. It fails with ErrDBInUse while the
+// database is open.
+func DropDB(dbName string) error {
+	openDBs.Lock()
+	defer openDBs.Unlock()
+
+	_, exists, err := dbFilePath(dbName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrDBNotExist
+	}
+	if openDBs.refs[strings.ToLower(dbName)] > 0 {
+		return fmt.Errorf("%w: %s", ErrDBInUse, dbName)
+	}
+	return removeDBDir(dbName)
 }
 
 func ShowDB() ([]*Row, []*Field, error) {
 	fields := []*Field{
-		{"", "Name"},
+		{Column: "Name", DataType: TypeVarchar},
 	}
 
 	_, err := os.Stat(dataPath)
@@ -370,6 +966,7 @@ func CreateDB(dbName string) error {
 	}
 
 	fs.nextFreeOffset = pageSize
+	fs.formatVersion = currentFormatVersion
 
 	if err := fs.save(); err != nil {
 		return err
@@ -388,6 +985,18 @@ func CreateDB(dbName string) error {
 
 	defer rs.Close()
 
+	if err := rs.createCatalog(); err != nil {
+		return err
+	}
+	return rs.fs.flushPages()
+}
+
+// createCatalog creates the system tables of a new database. The store is
+// locked like it is by createTable.
+func (rs *RelationService) createCatalog() error {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
 	// create page table page
 	pgTblPg, err := rs.createPage()
 	if err != nil {
@@ -415,44 +1024,190 @@ func CreateDB(dbName string) error {
 		return err
 	}
 
+	// create index table page
+	indexTblPg, err := rs.createPage()
+	if err != nil {
+		return err
+	}
+	if indexTblPg.getFileOffset() != initialIndexTableOffset {
+		return fmt.Errorf("expected index table to be third page at offset %d, at offset %d instead", initialIndexTableOffset, indexTblPg.getFileOffset())
+	}
+	if err := rs.insertPageTable(indexTblPg, indexTableName); err != nil {
+		return err
+	}
+
 	if err := rs.insertSchemaTable(&pageTableSchema, pageTableName); err != nil {
 		return err
 	}
 	if err := rs.insertSchemaTable(&schemaTableSchema, schemaTableName); err != nil {
 		return err
 	}
-
-	return rs.fs.flushPages()
-}
-
-func (rs *RelationService) CreateTable(r *Relation, tableName string) error {
-	_, err := rs.getRelationFileOffset(tableName)
-	if err != ErrTableNotExist {
-		return ErrTableAlreadyExist
+	if err := rs.insertSchemaTable(&indexTableSchema, indexTableName); err != nil {
+		return err
 	}
 
-	pg, err := rs.createPage()
-	if err != nil {
+	// the stats table has no fixed offset, since databases created before it
+	// was added create it when they're first analyzed
+	if err := rs.createTable(&statsTableSchema, StatsTableName); err != nil {
 		return err
 	}
-	if err := rs.insertPageTable(pg, tableName); err != nil {
+	if err := rs.createTable(&checkTableSchema, checkTableName); err != nil {
 		return err
 	}
-	if err := rs.insertSchemaTable(r, tableName); err != nil {
+	if err := rs.createTable(&foreignKeyTableSchema, foreignKeyTableName); err != nil {
 		return err
 	}
 
-	return rs.fs.flushPages()
+	return nil
 }
 
-func (rs *RelationService) createPage() (*btreeNode, error) {
-	rootPg := &btreeNode{isLeaf: true}
-	rootPg.markDirty(0)
+// RowIDColumn is the name of the pseudo-column that holds a row's ID. Tables
+// can't have a column with this name.
+const RowIDColumn = "rowid"
 
-	if err := rs.fs.append(rootPg); err != nil {
-		return rootPg, err
+// CreateTable creates table tableName along with the indexes, CHECK and
+// FOREIGN KEY constraints of r. The table is clustered on r.PrimaryKey if
+// it's set.
+func (rs *RelationService) CreateTable(r *Relation, tableName string) error {
+	rs.fs.lockShared()
+	err := rs.createTable(r, tableName)
+	rs.fs.unlockShared()
+	if err != nil {
+		return err
 	}
-	return rootPg, nil
+	return rs.fs.flushPages()
+}
+
+// createTable adds table tableName to the catalog without writing it to
+// disk. The store must be locked the way a statement locks it, so that its
+// pages aren't flushed while they're changed.
+func (rs *RelationService) createTable(r *Relation, tableName string) error {
+	for i := range r.Fields {
+		fd := &r.Fields[i]
+		if fd.Name == RowIDColumn {
+			return fmt.Errorf("%w: %s", ErrReservedColumn, fd.Name)
+		}
+		if fd.Default != nil {
+			def, err := fd.Convert(fd.Default)
+			if err != nil {
+				return fmt.Errorf("%w: default of column %s", err, fd.Name)
+			}
+			fd.Default = def
+			if err := fd.Validate(fd.Default); err != nil {
+				return fmt.Errorf("%w: default of column %s", err, fd.Name)
+			}
+		}
+	}
+
+	for _, col := range r.PrimaryKey {
+		if _, err := findFieldDef(r, col); err != nil {
+			return err
+		}
+	}
+	for _, idx := range r.Indexes {
+		if err := checkIndexKeySize(r, idx); err != nil {
+			return err
+		}
+	}
+
+	_, err := rs.getRelationFileOffset(tableName)
+	if err != ErrTableNotExist {
+		return ErrTableAlreadyExist
+	}
+
+	// check the index names up front so that the table isn't left behind
+	// without its indexes
+	for _, idx := range r.Indexes {
+		_, err := rs.getRelationFileOffset(idx.Name)
+		if err != ErrTableNotExist {
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("%w: %s", ErrIndexAlreadyExist, idx.Name)
+		}
+	}
+	for _, fk := range r.ForeignKeys {
+		if err := rs.resolveForeignKey(fk, tableName, r); err != nil {
+			return err
+		}
+	}
+
+	pg, err := rs.createPage()
+	if err != nil {
+		return err
+	}
+	if err := rs.insertPageTable(pg, tableName); err != nil {
+		return err
+	}
+	if err := rs.insertSchemaTable(r, tableName); err != nil {
+		return err
+	}
+
+	for _, idx := range r.Indexes {
+		if r.clusteredOn(idx) {
+			// the table itself serves as the primary key index
+			if err := rs.ensureIndexTable(); err != nil {
+				return err
+			}
+			if err := rs.insertIndexTable(idx); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := rs.createIndex(idx); err != nil {
+			return err
+		}
+	}
+	if len(r.Checks) > 0 {
+		if err := rs.ensureCheckTable(); err != nil {
+			return err
+		}
+		if err := rs.insertCheckTable(r.Checks); err != nil {
+			return err
+		}
+	}
+	if len(r.ForeignKeys) > 0 {
+		if err := rs.ensureForeignKeyTable(); err != nil {
+			return err
+		}
+		if err := rs.insertForeignKeyTable(r.ForeignKeys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Schema returns the fields, indexes, CHECK and FOREIGN KEY constraints of
+// table tableName.
+func (rs *RelationService) Schema(tableName string) (*Relation, error) {
+	if _, err := rs.getRelationFileOffset(tableName); err != nil {
+		return nil, err
+	}
+	r, err := rs.getRelationSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if r.Indexes, err = rs.Indexes(tableName); err != nil {
+		return nil, err
+	}
+	if r.Checks, err = rs.Checks(tableName); err != nil {
+		return nil, err
+	}
+	if r.ForeignKeys, err = rs.ForeignKeys(tableName); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (rs *RelationService) createPage() (*btreeNode, error) {
+	rootPg := &btreeNode{isLeaf: true}
+	rootPg.markDirty(0)
+
+	if err := rs.fs.append(rootPg); err != nil {
+		return rootPg, err
+	}
+	return rootPg, nil
 }
 
 func (rs *RelationService) insertPageTable(node *btreeNode, tableName string) error {
@@ -495,7 +1250,7 @@ func (rs *RelationService) insertPageTable(node *btreeNode, tableName string) er
 		}
 	}
 
-	fmt.Printf("inserted new page table record for %s, page id: %d\n\r", tableName, id)
+	logf("inserted new page table record for %s, page id: %d", tableName, id)
 
 	return nil
 }
@@ -511,7 +1266,7 @@ func (rs *RelationService) updatePageTable(fileOffset uint64, tableName string)
 	bt := &BTree{store: rs.fs}
 	bt.setRoot(pgTablePg)
 
-	found := false
+	var key, val []byte
 	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
 		tuple := Tuple{
 			Relation: &pageTableSchema,
@@ -527,21 +1282,8 @@ func (rs *RelationService) updatePageTable(fileOffset uint64, tableName string)
 			if err != nil {
 				return StopScanning, err
 			}
-			if err := cell.pg.updateCell(cell.key, buf.Bytes()); err != nil {
-				return StopScanning, err
-			}
-			cell.pg.markDirty(rs.fs.nextLSN())
-
-			walLogs = append(walLogs, &WALEntry{
-				LSN:    rs.fs.nextLSN(),
-				WALOp:  OpUpdate,
-				pageID: cell.pg.getFileOffset(),
-				cellID: cell.key,
-				val:    buf.Bytes(),
-			})
-			rs.fs.incrLSN()
-			found = true
-			fmt.Printf("updated page table root from %d to %d, triggered by %s\n\r", oldVal, fileOffset, tableName)
+			key, val = cell.key, buf.Bytes()
+			logf("updated page table root from %d to %d, triggered by %s", oldVal, fileOffset, tableName)
 			return StopScanning, nil
 		}
 		return KeepScanning, nil
@@ -550,11 +1292,11 @@ func (rs *RelationService) updatePageTable(fileOffset uint64, tableName string)
 		return walLogs, err
 	}
 
-	if !found {
+	if key == nil {
 		return walLogs, fmt.Errorf("unable to update page table entry for %d", fileOffset)
 	}
 
-	return walLogs, nil
+	return rs.updateTableCell(pageTableName, key, val)
 }
 
 func (rs *RelationService) insertSchemaTable(r *Relation, tableName string) error {
@@ -579,8 +1321,22 @@ func (rs *RelationService) insertSchemaTable(r *Relation, tableName string) erro
 				"field_name":   fd.Name,
 				"field_type":   int64(fd.DataType),
 				"field_length": fd.Len,
+				"not_null":     fd.NotNull,
+				"field_scale":  fd.Scale,
 			},
 		}
+		if fd.Default != nil {
+			lit, err := formatLiteral(fd.Default)
+			if err != nil {
+				return err
+			}
+			tuple.Vals["default_value"] = lit
+		}
+		for pos, col := range r.PrimaryKey {
+			if col == fd.Name {
+				tuple.Vals["key_pos"] = int64(pos)
+			}
+		}
 
 		buf, err := tuple.Encode()
 		if err != nil {
@@ -610,16 +1366,29 @@ func (rs *RelationService) insertSchemaTable(r *Relation, tableName string) erro
 	return nil
 }
 
+// Fetch returns all the rows of table tableName.
 func (rs *RelationService) Fetch(tableName string) ([]*Row, []*Field, error) {
-	fmt.Printf("Select query. Table: %s\n\r", tableName)
-	fmt.Printf("page table root offset: %d\n\r", rs.fs.pageTableRoot)
+	it, fields, err := rs.Scan(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := collectRows(it)
+	return rows, fields, err
+}
+
+// Scan returns an iterator over the rows of table tableName in key order,
+// which is primary key order for clustered tables and row ID order for the
+// others. Rows are read from the table as the iterator advances.
+func (rs *RelationService) Scan(tableName string) (RowIterator, []*Field, error) {
+	logf("Select query. Table: %s", tableName)
+	logf("page table root offset: %d", rs.fs.pageTableRoot)
 
 	fileOffset, err := rs.getRelationFileOffset(tableName)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	fmt.Printf("relation %s page id: %d\n\r", tableName, fileOffset)
+	logf("relation %s page id: %d", tableName, fileOffset)
 
 	schema, err := rs.getRelationSchema(tableName)
 	if err != nil {
@@ -628,14 +1397,114 @@ func (rs *RelationService) Fetch(tableName string) ([]*Row, []*Field, error) {
 
 	var fields []*Field
 	for _, fd := range schema.Fields {
-		fields = append(fields, &Field{Column: fd.Name})
+		fields = append(fields, &Field{Column: fd.Name, DataType: fd.DataType})
 	}
 
-	fmt.Printf("relation %s schema: %v\n\r", tableName, schema)
+	logf("relation %s schema: %v", tableName, schema)
 
-	rows, err := rs.scanRelation(uint64(fileOffset), schema, fields)
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return rows, fields, err
+	bt := &BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	cur, err := bt.first()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &tableScan{cur: cur, schema: schema, fields: fields}, fields, nil
+}
+
+// SeekRowID returns an iterator over the row of table tableName with row ID
+// rowID. The iterator is empty if there is no such row.
+func (rs *RelationService) SeekRowID(tableName string, rowID uint32) (RowIterator, []*Field, error) {
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	schema, err := rs.getRelationSchema(tableName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []*Field
+	for _, fd := range schema.Fields {
+		fields = append(fields, &Field{Column: fd.Name, DataType: fd.DataType})
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bt := &BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	it := &rowSeek{}
+	if schema.PrimaryKey != nil {
+		// the rows of a clustered table don't have row IDs
+		return it, fields, nil
+	}
+
+	cell, err := bt.findCell(rowIDToKey(rowID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cell != nil {
+		if it.row, err = decodeRow(cell, schema, fields); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return it, fields, nil
+}
+
+// TableStats holds the statistics the query planner uses to estimate the cost
+// of reading a table.
+type TableStats struct {
+	// RowCount is the estimated number of rows in the table.
+	RowCount int64
+	// AnalyzedRowCount is the number of rows in the table when it was last
+	// analyzed.
+	AnalyzedRowCount int64
+	// Columns holds the statistics of each column computed by ANALYZE, keyed
+	// by column name. It's nil if the table hasn't been analyzed.
+	Columns map[string]ColumnStats
+}
+
+// TableStats returns the statistics of table tableName. The row count is
+// estimated from the shape of the table's B+ tree rather than counted, so
+// it's up to date even if the table hasn't been analyzed since it changed.
+func (rs *RelationService) TableStats(tableName string) (TableStats, error) {
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return TableStats{}, err
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return TableStats{}, err
+	}
+
+	bt := &BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	rowCount, err := bt.estimateCells()
+	if err != nil {
+		return TableStats{}, err
+	}
+
+	cols, analyzedRowCount, err := rs.columnStats(tableName)
+	if err != nil {
+		return TableStats{}, err
+	}
+
+	return TableStats{RowCount: rowCount, AnalyzedRowCount: analyzedRowCount, Columns: cols}, nil
 }
 
 func (rs *RelationService) getRelationFileOffset(relName string) (int64, error) {
@@ -694,6 +1563,9 @@ func (rs *RelationService) getRelationSchema(relName string) (*Relation, error)
 	bt.setRoot(pg)
 
 	r := &Relation{}
+	var keyCols []string
+	var layout []FieldDef
+	hasDropped := false
 
 	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
 		tuple := Tuple{
@@ -704,24 +1576,56 @@ func (rs *RelationService) getRelationSchema(relName string) (*Relation, error)
 			return StopScanning, err
 		}
 		if tuple.Vals["table_name"] == relName {
-			r.Fields = append(r.Fields, FieldDef{
+			fd := FieldDef{
 				Name:     tuple.Vals["field_name"].(string),
 				Len:      tuple.Vals["field_length"].(int64),
 				DataType: DataType(tuple.Vals["field_type"].(int64)),
-			})
+				// fields defined before constraints were added are nullable
+				NotNull: tuple.Vals["not_null"] == true,
+			}
+			// fields defined before decimals were added have no scale
+			if scale, ok := tuple.Vals["field_scale"].(int64); ok {
+				fd.Scale = scale
+			}
+			if lit, ok := tuple.Vals["default_value"].(string); ok {
+				val, err := parseLiteral(lit, fd.DataType)
+				if err != nil {
+					return StopScanning, err
+				}
+				fd.Default = val
+			}
+			if tuple.Vals["dropped"] == true {
+				fd.dropped = true
+				hasDropped = true
+				layout = append(layout, fd)
+				return KeepScanning, nil
+			}
+			if pos, ok := tuple.Vals["key_pos"].(int64); ok {
+				for len(keyCols) <= int(pos) {
+					keyCols = append(keyCols, "")
+				}
+				keyCols[pos] = fd.Name
+			}
+			layout = append(layout, fd)
+			r.Fields = append(r.Fields, fd)
 		}
 		return KeepScanning, nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	r.PrimaryKey = keyCols
+	if hasDropped {
+		r.layout = layout
+	}
 
 	return r, nil
 }
 
 type Field struct {
-	TableID string
-	Column  interface{}
+	TableID  string
+	Column   interface{}
+	DataType DataType
 }
 
 func (f *Field) String() string {
@@ -761,8 +1665,13 @@ func (fields Fields) LookupColIdxByID(tableID string, fieldName string) (int, er
 }
 
 type Row struct {
+	// RowID is the ID of a row of a table that isn't clustered on its
+	// primary key. It's 0 for the rows of clustered tables.
 	RowID uint32
-	Vals  []interface{}
+	// Key is the key of the row in its table's B+ tree, which identifies the
+	// row when it's updated or deleted.
+	Key  []byte
+	Vals []interface{}
 }
 
 func (r *Row) String() string {
@@ -787,54 +1696,80 @@ func (r *Row) Merge(row *Row) *Row {
 	return newRow
 }
 
-func (rs *RelationService) scanRelation(fileOffset uint64, r *Relation, fields Fields) ([]*Row, error) {
-	bt := BTree{store: rs.fs}
+// RowIterator iterates over the rows of a table. Next returns a nil row once
+// there are no more rows.
+type RowIterator interface {
+	Next() (*Row, error)
+}
 
-	// retrieve page table
-	pg, err := rs.fs.fetch(fileOffset)
-	if err != nil {
+// tableScan iterates over the rows of a table in key order.
+type tableScan struct {
+	cur    *cursor
+	schema *Relation
+	fields Fields
+}
+
+func (s *tableScan) Next() (*Row, error) {
+	// the pages read for the row can be evicted once it's decoded, so that a
+	// scan of a large table doesn't hold all of it in the cache
+	defer s.cur.b.trimCache()
+	cell, err := s.cur.next()
+	if err != nil || cell == nil {
 		return nil, err
 	}
+	return decodeRow(cell, s.schema, s.fields)
+}
 
-	bt.setRoot(pg)
+// rowSeek returns the row found by a row ID lookup, if any.
+type rowSeek struct {
+	row *Row
+}
 
-	var results []*Row
+func (s *rowSeek) Next() (*Row, error) {
+	row := s.row
+	s.row = nil
+	return row, nil
+}
 
-	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
-		tuple := Tuple{
-			Relation: r,
-			Vals:     make(map[string]interface{}),
-		}
-		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
-			return StopScanning, err
-		}
-		row := &Row{
-			RowID: cell.key,
+// collectRows reads the remaining rows of it.
+func collectRows(it RowIterator) ([]*Row, error) {
+	var rows []*Row
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, err
 		}
-		for _, field := range fields {
-			row.Vals = append(row.Vals, tuple.Vals[field.Column.(string)])
+		if row == nil {
+			return rows, nil
 		}
-		results = append(results, row)
-		return KeepScanning, nil
-	})
+		rows = append(rows, row)
+	}
+}
 
-	if err != nil {
+// decodeRow creates a row from the tuple stored in cell. The row values are
+// ordered according to fields.
+func decodeRow(cell *leafCell, r *Relation, fields Fields) (*Row, error) {
+	tuple := Tuple{
+		Relation: r,
+		Vals:     make(map[string]interface{}),
+	}
+	if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
 		return nil, err
 	}
-
-	return results, nil
+	row := &Row{Key: cell.key}
+	if r.PrimaryKey == nil {
+		row.RowID = keyToRowID(cell.key)
+	}
+	for _, field := range fields {
+		row.Vals = append(row.Vals, tuple.Vals[field.Column.(string)])
+	}
+	return row, nil
 }
 
 func (rs *RelationService) Insert(tableName string, cols []string, vals []interface{}) (WALBatch, error) {
 	var walLogs WALBatch
 
-	fileOffset, err := rs.getRelationFileOffset(tableName)
-	if err != nil {
-		return walLogs, err
-	}
-
-	tablePg, err := rs.fs.fetch(uint64(fileOffset))
-	if err != nil {
+	if err := rs.stealPages(); err != nil {
 		return walLogs, err
 	}
 
@@ -862,25 +1797,128 @@ func (rs *RelationService) Insert(tableName string, cols []string, vals []interf
 	for i, col := range cols {
 		tuple.Vals[col] = vals[i]
 	}
+	for _, fd := range schema.Fields {
+		if _, ok := tuple.Vals[fd.Name]; !ok {
+			tuple.Vals[fd.Name] = fd.Default
+		}
+	}
+	if err := schema.ConvertVals(tuple.Vals); err != nil {
+		return walLogs, err
+	}
 
 	buf, err := tuple.Encode()
 	if err != nil {
 		return walLogs, err
 	}
 
+	indexes, err := rs.Indexes(tableName)
+	if err != nil {
+		return walLogs, err
+	}
+
+	key, err := schema.rowKey(tuple.Vals)
+	if err != nil {
+		return walLogs, err
+	}
+	if key == nil {
+		key = rowIDToKey(rs.fs.getLastKey() + 1)
+	}
+
+	// check unique constraints before the row is written
+	for _, idx := range indexes {
+		if schema.clusteredOn(idx) {
+			if err := rs.checkRowKey(tableName, idx, key); err != nil {
+				return walLogs, err
+			}
+			continue
+		}
+		if err := rs.checkIndexEntry(idx, idx.keyVals(tuple.Vals), key); err != nil {
+			return walLogs, err
+		}
+	}
+
+	if schema.PrimaryKey == nil {
+		if err := rs.fs.incrementLastKey(); err != nil {
+			return walLogs, err
+		}
+	}
+
+	logs, err := rs.insertTableCell(tableName, key, buf.Bytes())
+	walLogs = append(walLogs, logs...)
+	if err != nil {
+		return walLogs, err
+	}
+
+	for _, idx := range indexes {
+		if schema.clusteredOn(idx) {
+			continue
+		}
+		logs, err := rs.insertIndexEntry(idx, idx.keyVals(tuple.Vals), key)
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
+	return walLogs, nil
+}
+
+// checkRowKey returns ErrUniqueViolation if table tableName, which is
+// clustered on primary key index idx, already has a row with key key.
+func (rs *RelationService) checkRowKey(tableName string, idx *Index, key []byte) error {
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return err
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return err
+	}
+
 	bt := &BTree{store: rs.fs}
-	bt.setRoot(tablePg)
-	id, lsn, err := bt.insert(buf.Bytes())
+	bt.setRoot(pg)
+
+	cell, err := bt.findCell(key)
+	if err != nil {
+		return err
+	}
+	if cell != nil {
+		return fmt.Errorf("%w %s", ErrUniqueViolation, idx.Name)
+	}
+	return nil
+}
+
+// insertTableCell stores the encoded row val under key key in table
+// tableName.
+func (rs *RelationService) insertTableCell(tableName string, key []byte, val []byte) (WALBatch, error) {
+	var walLogs WALBatch
+
+	fileOffset, err := rs.getRelationFileOffset(tableName)
 	if err != nil {
 		return walLogs, err
 	}
 
+	tablePg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return walLogs, err
+	}
+
+	bt := &BTree{store: rs.fs}
+	bt.setRoot(tablePg)
+
+	lsn := rs.fs.nextLSN()
+	if err := bt.insertKey(key, lsn, val); err != nil {
+		return walLogs, err
+	}
+	rs.fs.incrLSN()
+
 	walLogs = append(walLogs, &WALEntry{
 		LSN:    lsn,
 		pageID: uint64(fileOffset),
 		WALOp:  OpInsert,
-		cellID: id,
-		val:    buf.Bytes(),
+		key:    key,
+		val:    val,
 	})
 
 	// update page table with new root if the old root split
@@ -891,122 +1929,304 @@ func (rs *RelationService) Insert(tableName string, cols []string, vals []interf
 
 	rootChanged := curPage.getFileOffset() != tablePg.getFileOffset()
 	if rootChanged {
-		var logs WALBatch
-		if logs, err = rs.updatePageTable(curPage.getFileOffset(), tableName); err != nil {
+		logs, err := rs.updatePageTable(curPage.getFileOffset(), tableName)
+		walLogs = append(walLogs, logs...)
+		if err != nil {
 			return walLogs, err
 		}
-		walLogs = append(walLogs, logs...)
 	}
 
 	return walLogs, nil
 }
 
-// todo combine with update page table code?
-func (rs *RelationService) Update(tableName string, rowID uint32, cols []string, updateSrc []interface{}) (WALBatch, error) {
+// updateTableCell replaces the value of the cell with key key in table
+// tableName. The leaf that holds the cell is split if the new value doesn't
+// fit on its page.
+func (rs *RelationService) updateTableCell(tableName string, key []byte, val []byte) (WALBatch, error) {
 	var walLogs WALBatch
 
-	fileOffset, err := rs.getRelationFileOffset(tableName)
+	fileOffset := rs.fs.pageTableRoot
+	if tableName != pageTableName {
+		offset, err := rs.getRelationFileOffset(tableName)
+		if err != nil {
+			return walLogs, err
+		}
+		fileOffset = uint64(offset)
+	}
+
+	tablePg, err := rs.fs.fetch(fileOffset)
 	if err != nil {
 		return walLogs, err
 	}
 
-	pg, err := rs.fs.fetch(uint64(fileOffset))
-	if err != nil {
+	bt := &BTree{store: rs.fs}
+	bt.setRoot(tablePg)
+
+	lsn := rs.fs.nextLSN()
+	if err := bt.updateKey(key, lsn, val); err != nil {
 		return walLogs, err
 	}
+	rs.fs.incrLSN()
 
-	r, err := rs.getRelationSchema(tableName)
+	walLogs = append(walLogs, &WALEntry{
+		LSN:    lsn,
+		pageID: fileOffset,
+		WALOp:  OpUpdate,
+		key:    key,
+		val:    val,
+	})
+
+	// update page table with new root if the old root split
+	curPage, err := bt.getRoot()
 	if err != nil {
 		return walLogs, err
 	}
+	if curPage.getFileOffset() == fileOffset {
+		return walLogs, nil
+	}
+	if tableName == pageTableName {
+		return walLogs, rs.fs.setPageTableRoot(curPage)
+	}
+	logs, err := rs.updatePageTable(curPage.getFileOffset(), tableName)
+	return append(walLogs, logs...), err
+}
+
+// findRowCell returns the cell of the row of table tableName with key key.
+func (rs *RelationService) findRowCell(tableName string, key []byte) (*leafCell, error) {
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, err
+	}
 
 	bt := BTree{store: rs.fs}
 	bt.setRoot(pg)
 
-	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
-		if cell.key != rowID {
-			return KeepScanning, nil
-		}
+	cell, err := bt.findCell(key)
+	if err != nil {
+		return nil, err
+	}
+	if cell == nil {
+		return nil, fmt.Errorf("unable to find cell for row key %v", key)
+	}
+	return cell, nil
+}
 
-		tuple := Tuple{
-			Relation: r,
-			Vals:     make(map[string]interface{}),
-		}
-		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
-			return StopScanning, err
-		}
+// markCellDeleted marks the row or index entry stored in cell deleted.
+func (rs *RelationService) markCellDeleted(cell *leafCell) *WALEntry {
+	cell.deleted = true
+	cell.pg.markDirty(rs.fs.nextLSN())
 
-		for i, col := range cols {
-			tuple.Vals[col] = updateSrc[i]
-		}
+	entry := &WALEntry{
+		LSN:    rs.fs.nextLSN(),
+		WALOp:  OpDelete,
+		pageID: cell.pg.getFileOffset(),
+		key:    cell.key,
+	}
 
-		buf, err := tuple.Encode()
-		if err != nil {
-			return StopScanning, err
-		}
+	rs.fs.incrLSN()
+	return entry
+}
 
-		if err := cell.pg.updateCell(cell.key, buf.Bytes()); err != nil {
-			return StopScanning, err
-		}
+// todo combine with update page table code?
 
-		cell.pg.markDirty(rs.fs.nextLSN())
+// Update sets columns cols of the row of table tableName with key key to
+// updateSrc. A row of a clustered table whose primary key changes moves to
+// its new key.
+func (rs *RelationService) Update(tableName string, key []byte, cols []string, updateSrc []interface{}) (WALBatch, error) {
+	var walLogs WALBatch
 
-		walLogs = append(walLogs, &WALEntry{
-			LSN:    rs.fs.nextLSN(),
-			WALOp:  OpUpdate,
-			pageID: cell.pg.getFileOffset(),
-			cellID: cell.key,
-			val:    buf.Bytes(),
-		})
+	if err := rs.stealPages(); err != nil {
+		return walLogs, err
+	}
 
-		rs.fs.incrLSN()
+	r, err := rs.getRelationSchema(tableName)
+	if err != nil {
+		return walLogs, err
+	}
 
-		return KeepScanning, nil
-	})
+	indexes, err := rs.Indexes(tableName)
+	if err != nil {
+		return walLogs, err
+	}
+
+	cell, err := rs.findRowCell(tableName, key)
 	if err != nil {
 		return walLogs, err
 	}
 
+	tuple := Tuple{
+		Relation: r,
+		Vals:     make(map[string]interface{}),
+	}
+	if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+		return walLogs, err
+	}
+
+	oldKeyVals := make([][]interface{}, len(indexes))
+	for i, idx := range indexes {
+		oldKeyVals[i] = idx.keyVals(tuple.Vals)
+	}
+
+	for i, col := range cols {
+		tuple.Vals[col] = updateSrc[i]
+	}
+	if err := r.ConvertVals(tuple.Vals); err != nil {
+		return walLogs, err
+	}
+
+	newKey, err := r.rowKey(tuple.Vals)
+	if err != nil {
+		return walLogs, err
+	}
+	if newKey == nil {
+		newKey = key
+	}
+	moved := !bytes.Equal(key, newKey)
+
+	// only touch the indexes whose key values changed, unless the row moves
+	// and all of its index entries have to point to the new key
+	var changed []int
+	for i, idx := range indexes {
+		if r.clusteredOn(idx) {
+			if moved {
+				if err := rs.checkRowKey(tableName, idx, newKey); err != nil {
+					return walLogs, err
+				}
+			}
+			continue
+		}
+		if !reflect.DeepEqual(oldKeyVals[i], idx.keyVals(tuple.Vals)) {
+			if err := rs.checkIndexEntry(idx, idx.keyVals(tuple.Vals), newKey); err != nil {
+				return walLogs, err
+			}
+		} else if !moved {
+			continue
+		}
+		changed = append(changed, i)
+	}
+
+	buf, err := tuple.Encode()
+	if err != nil {
+		return walLogs, err
+	}
+
+	if moved {
+		walLogs = append(walLogs, rs.markCellDeleted(cell))
+		logs, err := rs.insertTableCell(tableName, newKey, buf.Bytes())
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	} else {
+		logs, err := rs.updateTableCell(tableName, key, buf.Bytes())
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
+	for _, i := range changed {
+		logs, err := rs.deleteIndexEntry(indexes[i], oldKeyVals[i], key)
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+		logs, err = rs.insertIndexEntry(indexes[i], indexes[i].keyVals(tuple.Vals), newKey)
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
+
 	return walLogs, nil
 }
 
-func (rs *RelationService) MarkDeleted(tableName string, rowID uint32) (WALBatch, error) {
+// MarkDeleted deletes the row of table tableName with key key.
+func (rs *RelationService) MarkDeleted(tableName string, key []byte) (WALBatch, error) {
 	var walLogs WALBatch
 
-	fileOffset, err := rs.getRelationFileOffset(tableName)
-	if err != nil {
+	if err := rs.stealPages(); err != nil {
 		return walLogs, err
 	}
 
-	pg, err := rs.fs.fetch(uint64(fileOffset))
+	cell, err := rs.findRowCell(tableName, key)
 	if err != nil {
 		return walLogs, err
 	}
 
-	bt := BTree{store: rs.fs}
-	bt.setRoot(pg)
+	walLogs = append(walLogs, rs.markCellDeleted(cell))
 
-	cell, err := bt.findCell(rowID)
-	if err != nil {
+	indexes, err := rs.Indexes(tableName)
+	if err != nil || len(indexes) == 0 {
 		return walLogs, err
 	}
-	if cell == nil {
-		return walLogs, fmt.Errorf("unable to find cell for rowID %d", rowID)
+
+	r, err := rs.getRelationSchema(tableName)
+	if err != nil {
+		return walLogs, err
 	}
 
-	cell.deleted = true
-	cell.pg.markDirty(rs.fs.nextLSN())
+	tuple := Tuple{
+		Relation: r,
+		Vals:     make(map[string]interface{}),
+	}
+	if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+		return walLogs, err
+	}
 
-	walLogs = append(walLogs, &WALEntry{
-		LSN:    rs.fs.nextLSN(),
-		WALOp:  OpDelete,
-		pageID: cell.pg.getFileOffset(),
-		cellID: cell.key,
-	})
+	for _, idx := range indexes {
+		if r.clusteredOn(idx) {
+			continue
+		}
+		logs, err := rs.deleteIndexEntry(idx, idx.keyVals(tuple.Vals), key)
+		walLogs = append(walLogs, logs...)
+		if err != nil {
+			return walLogs, err
+		}
+	}
 
 	return walLogs, nil
 }
 
+// FlushWALBatch writes the WAL entries of a statement to the log. Outside of
+// an explicit transaction, the statement runs as its own transaction and its
+// entries are logged between a begin and a commit record. Inside an explicit
+// transaction, the entries are held back until the transaction commits.
 func (rs *RelationService) FlushWALBatch(batch WALBatch) error {
-	return rs.wal.flush(batch)
+	if rs.fs.inTxn() {
+		rs.txnBatch = append(rs.txnBatch, batch...)
+		return nil
+	}
+	if len(batch) == 0 && rs.stmtTxnID == 0 {
+		return nil
+	}
+	if rs.stmtTxnID == 0 {
+		begin := rs.newTxnRecord(OpBegin, rs.fs.nextLSN())
+		batch = append(WALBatch{begin}, batch...)
+		rs.stmtTxnID = begin.txnID
+	}
+	batch = append(batch, rs.newTxnRecord(OpCommit, rs.stmtTxnID))
+	if err := rs.wal.flush(batch.withTxnID(rs.stmtTxnID)); err != nil {
+		return err
+	}
+	rs.stmtTxnID = 0
+	rs.undoImages = nil
+	return nil
+}
+
+// newTxnRecord creates a transaction control record. Transactions are
+// identified by the LSN of their begin record.
+func (rs *RelationService) newTxnRecord(op WALOp, txnID uint64) *WALEntry {
+	entry := &WALEntry{
+		LSN:   rs.fs.nextLSN(),
+		txnID: txnID,
+		WALOp: op,
+	}
+	rs.fs.incrLSN()
+	return entry
 }
@@ -86,6 +86,36 @@ func TestTupleEncodeDecode(t *testing.T) {
 				DataType: TypeInt,
 				Name:     "age",
 			},
+			{
+				DataType: TypeReal,
+				Name:     "height",
+			},
+			{
+				DataType: TypeDouble,
+				Name:     "score",
+			},
+			{
+				DataType: TypeDecimal,
+				Len:      10,
+				Scale:    2,
+				Name:     "balance",
+			},
+			{
+				DataType: TypeDate,
+				Name:     "birthday",
+			},
+			{
+				DataType: TypeTime,
+				Name:     "alarm",
+			},
+			{
+				DataType: TypeTimestamp,
+				Name:     "updated_at",
+			},
+			{
+				DataType: TypeInterval,
+				Name:     "tenure",
+			},
 		},
 	}
 
@@ -97,6 +127,13 @@ func TestTupleEncodeDecode(t *testing.T) {
 			"bool_val":   true,
 			"salary":     int64(33000000000),
 			"age":        int64(35),
+			"height":     1.8,
+			"score":      -0.1,
+			"balance":    NewDecimal(-1234567, 2),
+			"birthday":   Date(-3650),
+			"alarm":      Time(25200000000),
+			"updated_at": Timestamp(1767275130000001),
+			"tenure":     Interval{Months: 14, Days: -3, Micros: 1},
 		},
 		Relation: rel,
 	}
@@ -161,6 +198,180 @@ func TestTupleEncodeDecodeErrIntRange(t *testing.T) {
 	}
 }
 
+func TestFieldDefConvert(t *testing.T) {
+
+	realField := FieldDef{DataType: TypeReal}
+	doubleField := FieldDef{DataType: TypeDouble}
+	decimalField := FieldDef{DataType: TypeDecimal, Len: 5, Scale: 2}
+
+	tbl := []struct {
+		fd     FieldDef
+		val    interface{}
+		expect interface{}
+		err    error
+	}{
+		{fd: doubleField, val: int64(3), expect: 3.0},
+		{fd: doubleField, val: NewDecimal(125, 2), expect: 1.25},
+		// reals keep the digits that single precision can hold
+		{fd: realField, val: 0.1, expect: 0.1},
+		{fd: realField, val: 1.23456789, expect: 1.2345679},
+		{fd: realField, val: 1e39, err: ErrNumericOutOfRange},
+		{fd: decimalField, val: int64(7), expect: NewDecimal(700, 2)},
+		{fd: decimalField, val: 2.345, expect: NewDecimal(235, 2)},
+		{fd: decimalField, val: NewDecimal(-12345, 3), expect: NewDecimal(-1235, 2)},
+		{fd: decimalField, val: NewDecimal(99999, 2), expect: NewDecimal(99999, 2)},
+		{fd: decimalField, val: int64(1000), err: ErrNumericOutOfRange},
+		{fd: decimalField, val: NewDecimal(999995, 3), err: ErrNumericOutOfRange},
+		// values of other types are left for Validate to reject
+		{fd: decimalField, val: "1", expect: "1"},
+	}
+
+	for _, test := range tbl {
+		actual, err := test.fd.Convert(test.val)
+		if !errors.Is(err, test.err) {
+			t.Errorf("converting %v to type %d: expected error %v, got %v", test.val, test.fd.DataType, test.err, err)
+			continue
+		}
+		if err == nil && actual != test.expect {
+			t.Errorf("converting %v to type %d: expected %v, got %v", test.val, test.fd.DataType, test.expect, actual)
+		}
+	}
+
+	if err := decimalField.Validate(NewDecimal(1, 1)); !errors.Is(err, ErrTypeMismatch) {
+		t.Errorf("expected error %v, got %v", ErrTypeMismatch, err)
+	}
+}
+
+func TestTupleEncodeErrNotNull(t *testing.T) {
+
+	tup := &Tuple{
+		Vals: map[string]interface{}{
+			"age": nil,
+		},
+		Relation: &Relation{
+			Fields: []FieldDef{
+				{
+					DataType: TypeInt,
+					Name:     "age",
+					NotNull:  true,
+				},
+			},
+		},
+	}
+
+	_, err := tup.Encode()
+	if !errors.Is(err, ErrNotNullViolation) {
+		t.Errorf("expected error `%v`, got `%v`", ErrNotNullViolation, err)
+	}
+}
+
+func TestTupleDecodeMissingFields(t *testing.T) {
+
+	old := &Tuple{
+		Vals: map[string]interface{}{
+			"id": int64(1),
+		},
+		Relation: &Relation{
+			Fields: []FieldDef{
+				{
+					DataType: TypeInt,
+					Name:     "id",
+				},
+			},
+		},
+	}
+
+	encoded, err := old.Encode()
+	if err != nil {
+		t.Fatalf("error encoding tuple: %s", err.Error())
+	}
+
+	// fields that were added after the tuple was written decode as null
+	tup := &Tuple{
+		Vals: make(map[string]interface{}),
+		Relation: &Relation{
+			Fields: []FieldDef{
+				{
+					DataType: TypeInt,
+					Name:     "id",
+				},
+				{
+					DataType: TypeBoolean,
+					Name:     "active",
+				},
+			},
+		},
+	}
+
+	if err := tup.Decode(encoded); err != nil {
+		t.Fatalf("error decoding tuple: %s", err.Error())
+	}
+
+	expected := map[string]interface{}{"id": int64(1)}
+	if !reflect.DeepEqual(expected, tup.Vals) {
+		t.Errorf("unexpected values. expected: %v actual: %v", expected, tup.Vals)
+	}
+}
+
+func TestTupleDroppedAndAddedFields(t *testing.T) {
+
+	old := &Tuple{
+		Vals: map[string]interface{}{
+			"id":   int64(1),
+			"name": "alice",
+		},
+		Relation: &Relation{
+			Fields: []FieldDef{
+				{DataType: TypeInt, Name: "id"},
+				{DataType: TypeVarchar, Name: "name"},
+			},
+		},
+	}
+
+	encoded, err := old.Encode()
+	if err != nil {
+		t.Fatalf("error encoding tuple: %s", err.Error())
+	}
+
+	// name was dropped and active was added with a default after the tuple
+	// was written
+	r := &Relation{
+		Fields: []FieldDef{
+			{DataType: TypeInt, Name: "id"},
+			{DataType: TypeBoolean, Name: "active", Default: true},
+		},
+		layout: []FieldDef{
+			{DataType: TypeInt, Name: "id"},
+			{DataType: TypeVarchar, Name: "name", dropped: true},
+			{DataType: TypeBoolean, Name: "active", Default: true},
+		},
+	}
+
+	tup := &Tuple{Vals: make(map[string]interface{}), Relation: r}
+	if err := tup.Decode(encoded); err != nil {
+		t.Fatalf("error decoding tuple: %s", err.Error())
+	}
+	expected := map[string]interface{}{"id": int64(1), "active": true}
+	if !reflect.DeepEqual(expected, tup.Vals) {
+		t.Errorf("unexpected values. expected: %v actual: %v", expected, tup.Vals)
+	}
+
+	// new tuples hold null in place of the dropped field
+	tup.Vals["active"] = false
+	encoded, err = tup.Encode()
+	if err != nil {
+		t.Fatalf("error encoding tuple: %s", err.Error())
+	}
+	decoded := &Tuple{Vals: make(map[string]interface{}), Relation: r}
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatalf("error decoding tuple: %s", err.Error())
+	}
+	expected = map[string]interface{}{"id": int64(1), "active": false}
+	if !reflect.DeepEqual(expected, decoded.Vals) {
+		t.Errorf("unexpected values. expected: %v actual: %v", expected, decoded.Vals)
+	}
+}
+
 func TestFieldsLookupColIdx(t *testing.T) {
 
 	fields := Fields{
@@ -0,0 +1,649 @@
+package storage
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// StatsTableName is the name of the table that ANALYZE stores column
+// statistics in.
+const StatsTableName = "sys_stats"
+
+const (
+	// statsSampleSize is the number of rows sampled to build histograms
+	statsSampleSize = 10000
+	// histogramBuckets is the number of buckets in a histogram
+	histogramBuckets = 10
+	// maxHistogramLen is the maximum length of an encoded histogram, which
+	// keeps a row of the stats table within the row size limit
+	maxHistogramLen = 200
+	// histogramBoundLen is the maximum length of a string histogram bound.
+	// Longer strings are cut short.
+	histogramBoundLen = 16
+	// distinctSketchSize is the number of hashes kept to estimate the number
+	// of distinct values of a column
+	distinctSketchSize = 1024
+)
+
+// statsTableSchema is the schema of the table that ANALYZE stores column
+// statistics in. Each row describes one column of a table at the time it was
+// analyzed.
+var statsTableSchema = Relation{
+	Fields: []FieldDef{
+		{
+			Name:     "table_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "column_name",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+		{
+			Name:     "row_count",
+			DataType: TypeBigInt,
+		},
+		{
+			Name:     "null_count",
+			DataType: TypeBigInt,
+		},
+		{
+			Name:     "distinct_count",
+			DataType: TypeBigInt,
+		},
+		{
+			Name:     "histogram",
+			DataType: TypeVarchar,
+			Len:      255,
+		},
+	},
+}
+
+// ColumnStats describes the values of a column when its table was last
+// analyzed.
+type ColumnStats struct {
+	// NullCount is the number of rows where the column is null.
+	NullCount int64
+	// DistinctCount is the estimated number of distinct non-null values.
+	DistinctCount int64
+	// Histogram holds the bounds of buckets that each hold about the same
+	// number of non-null values, in ascending order. It's empty if the
+	// column had no values.
+	Histogram []interface{}
+}
+
+// isSystemTable reports whether tableName is one of the tables that describe
+// a database.
+func isSystemTable(tableName string) bool {
+	switch tableName {
+	case pageTableName, schemaTableName, indexTableName, StatsTableName, checkTableName, foreignKeyTableName:
+		return true
+	}
+	return false
+}
+
+// Analyze computes the statistics of table tableName and stores them in the
+// stats table, replacing the ones computed before. All the tables in the
+// database except for the system tables are analyzed if tableName is empty.
+func (rs *RelationService) Analyze(tableName string) error {
+	rs.fs.lockShared()
+	err := rs.analyze(tableName)
+	rs.fs.unlockShared()
+	if err != nil {
+		return err
+	}
+	return rs.fs.flushPages()
+}
+
+// analyze replaces the statistics of table tableName, or of all tables if
+// tableName is empty, without writing them to disk. The store is locked
+// like it is by createTable.
+func (rs *RelationService) analyze(tableName string) error {
+	tables := []string{tableName}
+	if tableName == "" {
+		var err error
+		if tables, err = rs.tableNames(); err != nil {
+			return err
+		}
+	} else if _, err := rs.getRelationFileOffset(tableName); err != nil {
+		return err
+	}
+
+	if err := rs.ensureStatsTable(); err != nil {
+		return err
+	}
+
+	fileOffset, err := rs.getRelationFileOffset(StatsTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		rows, err := rs.analyzeTable(table)
+		if err != nil {
+			return err
+		}
+		if _, err := rs.deleteSysRows(uint64(fileOffset), &statsTableSchema, "table_name", table); err != nil {
+			return err
+		}
+		if err := rs.insertSysRows(StatsTableName, &statsTableSchema, rows); err != nil {
+			return err
+		}
+		logf("analyzed table %s", table)
+	}
+
+	return nil
+}
+
+// tableNames returns the names of the tables in the database, except for the
+// system tables.
+func (rs *RelationService) tableNames() ([]string, error) {
+	schemaTblOffset, err := rs.getRelationFileOffset(schemaTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	pg, err := rs.fs.fetch(uint64(schemaTblOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	var names []string
+	seen := make(map[string]bool)
+
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: &schemaTableSchema,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		name := tuple.Vals["table_name"].(string)
+		if !seen[name] && !isSystemTable(name) {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return KeepScanning, nil
+	})
+
+	return names, err
+}
+
+// analyzeTable scans table tableName and returns a row of the stats table
+// for each of its columns. Row and null counts are exact. Distinct counts
+// are estimated from a sketch of the values' hashes, and histograms are
+// built from a random sample of the rows.
+func (rs *RelationService) analyzeTable(tableName string) ([]map[string]interface{}, error) {
+	it, fields, err := rs.Scan(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var rowCount int64
+	nullCounts := make([]int64, len(fields))
+	sketches := make([]*distinctSketch, len(fields))
+	for i := range sketches {
+		sketches[i] = newDistinctSketch(distinctSketchSize)
+	}
+
+	// reservoir sample of the rows, seeded so that analyzing the same rows
+	// gives the same histograms
+	var sample []*Row
+	rnd := rand.New(rand.NewSource(1))
+
+	for {
+		row, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		rowCount++
+
+		for i, val := range row.Vals {
+			if val == nil {
+				nullCounts[i]++
+				continue
+			}
+			key, err := encodeIndexKey([]interface{}{val})
+			if err != nil {
+				return nil, err
+			}
+			sketches[i].add(key)
+		}
+
+		if len(sample) < statsSampleSize {
+			sample = append(sample, row)
+		} else if j := rnd.Int63n(rowCount); j < statsSampleSize {
+			sample[j] = row
+		}
+	}
+
+	var rows []map[string]interface{}
+	for i, fd := range fields {
+		var vals []interface{}
+		for _, row := range sample {
+			if row.Vals[i] != nil {
+				vals = append(vals, row.Vals[i])
+			}
+		}
+		histogram, err := encodeHistogram(buildHistogram(vals, histogramBuckets))
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, map[string]interface{}{
+			"table_name":     tableName,
+			"column_name":    fd.Column.(string),
+			"row_count":      rowCount,
+			"null_count":     nullCounts[i],
+			"distinct_count": sketches[i].estimate(),
+			"histogram":      histogram,
+		})
+	}
+
+	return rows, nil
+}
+
+// columnStats returns the statistics stored for the columns of table
+// tableName, keyed by column name, along with the number of rows the table
+// had when it was analyzed. It returns a nil map if the table hasn't been
+// analyzed.
+func (rs *RelationService) columnStats(tableName string) (map[string]ColumnStats, int64, error) {
+	fileOffset, err := rs.getRelationFileOffset(StatsTableName)
+	if errors.Is(err, ErrTableNotExist) {
+		// no table has been analyzed yet
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	schema, err := rs.getRelationSchema(tableName)
+	if err != nil {
+		return nil, 0, err
+	}
+	types := make(map[string]DataType)
+	for _, fd := range schema.Fields {
+		types[fd.Name] = fd.DataType
+	}
+
+	pg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bt := BTree{store: rs.fs}
+	bt.setRoot(pg)
+
+	var stats map[string]ColumnStats
+	var rowCount int64
+
+	err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+		tuple := Tuple{
+			Relation: &statsTableSchema,
+			Vals:     make(map[string]interface{}),
+		}
+		if err := tuple.Decode(bytes.NewBuffer(cell.valueBytes)); err != nil {
+			return StopScanning, err
+		}
+		if tuple.Vals["table_name"] != tableName {
+			return KeepScanning, nil
+		}
+		col := tuple.Vals["column_name"].(string)
+		dataType, ok := types[col]
+		if !ok {
+			// the column no longer exists
+			return KeepScanning, nil
+		}
+		histogram, err := decodeHistogram(tuple.Vals["histogram"].(string), dataType)
+		if err != nil {
+			return StopScanning, err
+		}
+		if stats == nil {
+			stats = make(map[string]ColumnStats)
+		}
+		stats[col] = ColumnStats{
+			NullCount:     tuple.Vals["null_count"].(int64),
+			DistinctCount: tuple.Vals["distinct_count"].(int64),
+			Histogram:     histogram,
+		}
+		rowCount = tuple.Vals["row_count"].(int64)
+		return KeepScanning, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return stats, rowCount, nil
+}
+
+// ensureStatsTable creates the stats table the first time a table is
+// analyzed.
+func (rs *RelationService) ensureStatsTable() error {
+	_, err := rs.getRelationFileOffset(StatsTableName)
+	if err != ErrTableNotExist {
+		return err
+	}
+	return rs.createTable(&statsTableSchema, StatsTableName)
+}
+
+// insertSysRows inserts rows into system table tableName, which has schema r.
+func (rs *RelationService) insertSysRows(tableName string, r *Relation, rows []map[string]interface{}) error {
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return err
+	}
+
+	tablePg, err := rs.fs.fetch(uint64(fileOffset))
+	if err != nil {
+		return err
+	}
+
+	bt := &BTree{store: rs.fs}
+
+	for _, vals := range rows {
+		tuple := Tuple{
+			Relation: r,
+			Vals:     vals,
+		}
+
+		buf, err := tuple.Encode()
+		if err != nil {
+			return err
+		}
+
+		bt.setRoot(tablePg)
+		if _, _, err = bt.insert(buf.Bytes()); err != nil {
+			return err
+		}
+
+		newRootPg, err := bt.getRoot()
+		if err != nil {
+			return err
+		}
+
+		rootChanged := newRootPg.getFileOffset() != tablePg.getFileOffset()
+		if rootChanged {
+			if _, err := rs.updatePageTable(newRootPg.getFileOffset(), tableName); err != nil {
+				return err
+			}
+			tablePg = newRootPg
+		}
+	}
+
+	return nil
+}
+
+// buildHistogram returns the bounds of an equi-depth histogram with up to
+// buckets buckets of vals. The first and last bounds are the smallest and
+// largest values.
+func buildHistogram(vals []interface{}, buckets int) []interface{} {
+	if len(vals) == 0 {
+		return nil
+	}
+
+	keys := make([][]byte, len(vals))
+	for i, val := range vals {
+		// values of a column are all the same type, so they can't fail to
+		// encode once they're stored
+		keys[i], _ = encodeIndexKey([]interface{}{val})
+	}
+	sort.Sort(byKey{keys: keys, vals: vals})
+
+	if buckets > len(vals)-1 {
+		buckets = len(vals) - 1
+	}
+	if buckets < 1 {
+		return []interface{}{vals[0], vals[0]}
+	}
+
+	bounds := make([]interface{}, buckets+1)
+	for i := range bounds {
+		bounds[i] = vals[i*(len(vals)-1)/buckets]
+	}
+	return bounds
+}
+
+type byKey struct {
+	keys [][]byte
+	vals []interface{}
+}
+
+func (b byKey) Len() int {
+	return len(b.keys)
+}
+
+func (b byKey) Less(i, j int) bool {
+	return bytes.Compare(b.keys[i], b.keys[j]) < 0
+}
+
+func (b byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.vals[i], b.vals[j] = b.vals[j], b.vals[i]
+}
+
+// encodeHistogram renders histogram bounds as a comma-separated list of
+// SQL literals, e.g. `1, 5, 9` or `'a', 'b'`, with quotes in strings doubled.
+// Long strings are cut short, and buckets are merged until the list fits in
+// maxHistogramLen bytes.
+func encodeHistogram(bounds []interface{}) (string, error) {
+	for len(bounds) > 0 {
+		var strs []string
+		for _, bound := range bounds {
+			switch v := bound.(type) {
+			case int64:
+				strs = append(strs, strconv.FormatInt(v, 10))
+			case bool:
+				strs = append(strs, strconv.FormatBool(v))
+			case float64:
+				strs = append(strs, strconv.FormatFloat(v, 'g', -1, 64))
+			case Decimal:
+				strs = append(strs, v.String())
+			case Date, Time, Timestamp, Interval:
+				strs = append(strs, "'"+v.(fmt.Stringer).String()+"'")
+			case string:
+				strs = append(strs, "'"+strings.ReplaceAll(truncate(v, histogramBoundLen), "'", "''")+"'")
+			default:
+				return "", fmt.Errorf("unsupported histogram value type %T", bound)
+			}
+		}
+		if str := strings.Join(strs, ", "); len(str) <= maxHistogramLen {
+			return str, nil
+		}
+
+		// drop every other inner bound, keeping the first and last
+		var merged []interface{}
+		for i := 0; i < len(bounds)-1; i += 2 {
+			merged = append(merged, bounds[i])
+		}
+		merged = append(merged, bounds[len(bounds)-1])
+		if len(merged) == len(bounds) {
+			return "", nil
+		}
+		bounds = merged
+	}
+	return "", nil
+}
+
+// decodeHistogram parses histogram bounds encoded by encodeHistogram for a
+// column of type dataType.
+func decodeHistogram(str string, dataType DataType) ([]interface{}, error) {
+	var bounds []interface{}
+	for len(str) > 0 {
+		var lit string
+		if str[0] == '\'' {
+			// find the closing quote, skipping escaped quotes
+			end := 1
+			for {
+				i := strings.IndexByte(str[end:], '\'')
+				if i < 0 {
+					return nil, fmt.Errorf("unterminated string in histogram %q", str)
+				}
+				end += i + 1
+				if end < len(str) && str[end] == '\'' {
+					end++
+					continue
+				}
+				break
+			}
+			lit = strings.ReplaceAll(str[1:end-1], "''", "'")
+			str = str[end:]
+		} else {
+			end := strings.IndexByte(str, ',')
+			if end < 0 {
+				end = len(str)
+			}
+			lit = str[:end]
+			str = str[end:]
+		}
+		str = strings.TrimPrefix(str, ", ")
+
+		val, err := parseLiteral(lit, dataType)
+		if err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, val)
+	}
+	return bounds, nil
+}
+
+// formatLiteral renders val as text that parseLiteral turns back into val.
+// Unlike SQL literals, strings aren't quoted.
+func formatLiteral(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case Decimal, Date, Time, Timestamp, Interval:
+		return v.(fmt.Stringer).String(), nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("unsupported literal type %T", val)
+}
+
+// parseLiteral parses the text of a value of type dataType.
+func parseLiteral(lit string, dataType DataType) (interface{}, error) {
+	switch dataType {
+	case TypeInt, TypeBigInt:
+		return strconv.ParseInt(lit, 10, 64)
+	case TypeBoolean:
+		return strconv.ParseBool(lit)
+	case TypeReal, TypeDouble:
+		return strconv.ParseFloat(lit, 64)
+	case TypeDecimal:
+		return ParseDecimal(lit)
+	case TypeDate:
+		return ParseDate(lit)
+	case TypeTime:
+		return ParseTime(lit)
+	case TypeTimestamp:
+		return ParseTimestamp(lit)
+	case TypeInterval:
+		return ParseInterval(lit)
+	}
+	return lit, nil
+}
+
+// truncate cuts str short to at most n bytes without splitting a character.
+func truncate(str string, n int) string {
+	if len(str) <= n {
+		return str
+	}
+	for n > 0 && !utf8.RuneStart(str[n]) {
+		n--
+	}
+	return str[:n]
+}
+
+// distinctSketch estimates the number of distinct values it's given by
+// keeping the k smallest of their hashes. If the hashes are uniformly
+// distributed, the k-th smallest one indicates how densely the hash space is
+// filled.
+type distinctSketch struct {
+	k      int
+	hashes hashHeap
+	seen   map[uint64]bool
+}
+
+func newDistinctSketch(k int) *distinctSketch {
+	return &distinctSketch{k: k, seen: make(map[uint64]bool)}
+}
+
+func (s *distinctSketch) add(val []byte) {
+	h := fnv.New64a()
+	h.Write(val)
+	// mix the bits, since FNV hashes of similar values are close together
+	hash := h.Sum64()
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+
+	if s.seen[hash] {
+		return
+	}
+	if len(s.hashes) < s.k {
+		heap.Push(&s.hashes, hash)
+		s.seen[hash] = true
+		return
+	}
+	if hash < s.hashes[0] {
+		delete(s.seen, s.hashes[0])
+		s.hashes[0] = hash
+		heap.Fix(&s.hashes, 0)
+		s.seen[hash] = true
+	}
+}
+
+func (s *distinctSketch) estimate() int64 {
+	if len(s.hashes) < s.k {
+		// every distinct value was kept
+		return int64(len(s.hashes))
+	}
+	fill := float64(s.hashes[0]) / math.MaxUint64
+	return int64(math.Round(float64(s.k-1) / fill))
+}
+
+// hashHeap is a max-heap of hashes.
+type hashHeap []uint64
+
+func (h hashHeap) Len() int {
+	return len(h)
+}
+
+func (h hashHeap) Less(i, j int) bool {
+	return h[i] > h[j]
+}
+
+func (h hashHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *hashHeap) Push(x interface{}) {
+	*h = append(*h, x.(uint64))
+}
+
+func (h *hashHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHistogramRoundTrip(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		bounds   []interface{}
+		dataType DataType
+		expect   []interface{}
+	}{
+		{
+			name:     "integers",
+			bounds:   []interface{}{int64(-5), int64(0), int64(12)},
+			dataType: TypeBigInt,
+			expect:   []interface{}{int64(-5), int64(0), int64(12)},
+		},
+		{
+			name:     "booleans",
+			bounds:   []interface{}{false, true},
+			dataType: TypeBoolean,
+			expect:   []interface{}{false, true},
+		},
+		{
+			name:     "strings with quotes and commas",
+			bounds:   []interface{}{"", "a, b", "it's", "zzzzzzzzzzzzzzzzzzzz"},
+			dataType: TypeVarchar,
+			expect:   []interface{}{"", "a, b", "it's", "zzzzzzzzzzzzzzzz"},
+		},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			str, err := encodeHistogram(test.bounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, err := decodeHistogram(str, test.dataType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.expect, actual) {
+				t.Errorf("bounds do not match. expected: %v actual: %v", test.expect, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeHistogramMergesBuckets(t *testing.T) {
+
+	var bounds []interface{}
+	for i := 0; i <= histogramBuckets; i++ {
+		bounds = append(bounds, strings.Repeat("x", histogramBoundLen-2)+string(rune('a'+i)))
+	}
+
+	str, err := encodeHistogram(bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(str) > maxHistogramLen {
+		t.Fatalf("expected histogram to fit in %d bytes, got %d", maxHistogramLen, len(str))
+	}
+
+	actual, err := decodeHistogram(str, TypeVarchar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) >= len(bounds) {
+		t.Fatalf("expected buckets to be merged, got %d bounds", len(actual))
+	}
+	if actual[0] != bounds[0] || actual[len(actual)-1] != bounds[len(bounds)-1] {
+		t.Errorf("expected the first and last bounds to be kept, got %v", actual)
+	}
+}
+
+func TestBuildHistogram(t *testing.T) {
+
+	var vals []interface{}
+	for i := 100; i >= 0; i-- {
+		vals = append(vals, int64(i))
+	}
+
+	expect := []interface{}{int64(0), int64(10), int64(20), int64(30), int64(40), int64(50),
+		int64(60), int64(70), int64(80), int64(90), int64(100)}
+	if actual := buildHistogram(vals, 10); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("bounds do not match. expected: %v actual: %v", expect, actual)
+	}
+
+	expect = []interface{}{"a", "a"}
+	if actual := buildHistogram([]interface{}{"a"}, 10); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("bounds do not match. expected: %v actual: %v", expect, actual)
+	}
+}
+
+func TestDistinctSketch(t *testing.T) {
+
+	tbl := []struct {
+		name     string
+		distinct int
+	}{
+		{name: "fewer values than the sketch size", distinct: 100},
+		{name: "more values than the sketch size", distinct: 100000},
+	}
+
+	for _, test := range tbl {
+		t.Run(test.name, func(t *testing.T) {
+			s := newDistinctSketch(distinctSketchSize)
+			buf := make([]byte, 8)
+			// add each value twice
+			for i := 0; i < 2*test.distinct; i++ {
+				binary.BigEndian.PutUint64(buf, uint64(i%test.distinct))
+				s.add(buf)
+			}
+
+			estimate := float64(s.estimate())
+			if math.Abs(estimate-float64(test.distinct)) > 0.1*float64(test.distinct) {
+				t.Errorf("expected an estimate within 10%% of %d, got %v", test.distinct, estimate)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidDatetime = errors.New("invalid date/time value")
+
+const (
+	microsPerSecond = int64(time.Second / time.Microsecond)
+	microsPerDay    = 24 * 60 * 60 * microsPerSecond
+	dateLayout      = "2006-01-02"
+	timeLayout      = "15:04:05"
+)
+
+// Date is a calendar date, the value of a DATE column. It holds the number
+// of days since 1970-01-01.
+type Date int32
+
+// Time is a time of day, the value of a TIME column. It holds the number of
+// microseconds since midnight.
+type Time int64
+
+// Timestamp is a date and time of day in UTC, the value of a TIMESTAMP
+// column. It holds the number of microseconds since 1970-01-01 00:00:00.
+type Timestamp int64
+
+// Interval is a span of time, the value of an INTERVAL column. Months and
+// days are kept apart from the rest of the span because their lengths vary,
+// so that adding a month to January 31 yields the last day of February.
+type Interval struct {
+	Months int32
+	Days   int32
+	Micros int64
+}
+
+// ParseDate parses an ISO 8601 date such as 2026-01-01. The time of day of
+// a date and time such as 2026-01-01 13:45 is dropped.
+func ParseDate(s string) (Date, error) {
+	if t, err := time.Parse(dateLayout, strings.TrimSpace(s)); err == nil {
+		return DateFromTime(t), nil
+	}
+	ts, err := ParseTimestamp(s)
+	if err != nil {
+		return 0, err
+	}
+	return ts.Date(), nil
+}
+
+// ParseTime parses a time of day such as 13:45, 13:45:30 or 13:45:30.25.
+// Fractions of a second finer than a microsecond are truncated.
+func ParseTime(s string) (Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{timeLayout, "15:04"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return TimeFromTime(t), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+}
+
+// timestampLayouts are the formats that ParseTimestamp accepts. Go's parser
+// accepts a fractional second after the seconds of a layout.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	dateLayout,
+}
+
+// ParseTimestamp parses an ISO 8601 date and time such as
+// 2026-01-01 13:45:30, 2026-01-01T13:45:30.25Z or 2026-01-01. A timestamp
+// with a UTC offset is converted to UTC, one without is taken to be UTC.
+func ParseTimestamp(s string) (Timestamp, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return TimestampFromTime(t), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+}
+
+// intervalUnits maps the units of an interval quantity to the number of
+// months, days or microseconds that one of them stands for.
+var intervalUnits = map[string]Interval{
+	"microsecond": {Micros: 1},
+	"millisecond": {Micros: 1000},
+	"second":      {Micros: microsPerSecond},
+	"sec":         {Micros: microsPerSecond},
+	"minute":      {Micros: 60 * microsPerSecond},
+	"min":         {Micros: 60 * microsPerSecond},
+	"hour":        {Micros: 60 * 60 * microsPerSecond},
+	"day":         {Days: 1},
+	"week":        {Days: 7},
+	"month":       {Months: 1},
+	"mon":         {Months: 1},
+	"year":        {Months: 12},
+}
+
+// ParseInterval parses an interval written as quantities and units followed
+// by an optional time span, such as '1 year 2 months 3 days 04:05:06' or
+// '-90 minutes', or in ISO 8601 duration format, such as P1Y2M3DT4H5M6S.
+func ParseInterval(s string) (Interval, error) {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "P") {
+		return parseISOInterval(trimmed)
+	}
+
+	var iv Interval
+	fields := strings.Fields(trimmed)
+	if len(fields) == 0 {
+		return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+	}
+	for i := 0; i < len(fields); i++ {
+		if strings.Contains(fields[i], ":") {
+			micros, err := parseTimeSpan(fields[i])
+			if err != nil {
+				return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+			}
+			iv.Micros += micros
+			continue
+		}
+		n, err := strconv.ParseInt(fields[i], 10, 32)
+		if err != nil || i+1 == len(fields) {
+			return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+		}
+		i++
+		unit, ok := intervalUnits[strings.TrimSuffix(strings.ToLower(fields[i]), "s")]
+		if !ok {
+			return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+		}
+		iv = iv.Add(Interval{
+			Months: unit.Months * int32(n),
+			Days:   unit.Days * int32(n),
+			Micros: unit.Micros * n,
+		})
+	}
+	return iv, nil
+}
+
+// parseTimeSpan parses a span of time such as 04:05, 04:05:06 or
+// -04:05:06.5 into microseconds.
+func parseTimeSpan(s string) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) > 3 {
+		return 0, ErrInvalidDatetime
+	}
+	var micros int64
+	for i, part := range parts {
+		var n int64
+		if i == 2 {
+			sec, frac, _ := strings.Cut(part, ".")
+			var err error
+			if n, err = parseFraction(sec, frac); err != nil {
+				return 0, err
+			}
+		} else {
+			h, err := strconv.ParseUint(part, 10, 31)
+			if err != nil {
+				return 0, ErrInvalidDatetime
+			}
+			n = int64(h) * 60 * microsPerSecond
+			if i == 0 {
+				n *= 60
+			}
+		}
+		micros += n
+	}
+	if neg {
+		micros = -micros
+	}
+	return micros, nil
+}
+
+// parseFraction returns seconds sec with fraction frac in microseconds.
+// Digits of frac past the microseconds are truncated.
+func parseFraction(sec string, frac string) (int64, error) {
+	s, err := strconv.ParseUint(sec, 10, 31)
+	if err != nil {
+		return 0, ErrInvalidDatetime
+	}
+	micros := int64(s) * microsPerSecond
+	if frac == "" {
+		return micros, nil
+	}
+	if len(frac) > 6 {
+		frac = frac[:6]
+	}
+	f, err := strconv.ParseUint(frac+strings.Repeat("0", 6-len(frac)), 10, 32)
+	if err != nil {
+		return 0, ErrInvalidDatetime
+	}
+	return micros + int64(f), nil
+}
+
+// parseISOInterval parses an ISO 8601 duration such as P1Y2M3DT4H5M6.5S.
+func parseISOInterval(s string) (Interval, error) {
+	var iv Interval
+	rest := s[1:]
+	inTime := false
+	if rest == "" {
+		return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+	}
+	for rest != "" {
+		if rest[0] == 'T' && !inTime {
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+		end := strings.IndexAny(rest, "YMWDHS")
+		if end <= 0 {
+			return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+		}
+		num, designator := rest[:end], rest[end]
+		rest = rest[end+1:]
+
+		if inTime && designator == 'S' {
+			sec, frac, _ := strings.Cut(num, ".")
+			micros, err := parseFraction(sec, frac)
+			if err != nil {
+				return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+			}
+			iv.Micros += micros
+			continue
+		}
+		n, err := strconv.ParseInt(num, 10, 32)
+		if err != nil {
+			return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+		}
+		var unit string
+		switch {
+		case !inTime && designator == 'Y':
+			unit = "year"
+		case !inTime && designator == 'M':
+			unit = "month"
+		case !inTime && designator == 'W':
+			unit = "week"
+		case !inTime && designator == 'D':
+			unit = "day"
+		case inTime && designator == 'H':
+			unit = "hour"
+		case inTime && designator == 'M':
+			unit = "minute"
+		default:
+			return iv, fmt.Errorf("%w: %s", ErrInvalidDatetime, s)
+		}
+		u := intervalUnits[unit]
+		iv = iv.Add(Interval{
+			Months: u.Months * int32(n),
+			Days:   u.Days * int32(n),
+			Micros: u.Micros * n,
+		})
+	}
+	return iv, nil
+}
+
+// DateFromTime returns the date of t in its location.
+func DateFromTime(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+}
+
+// Time returns midnight UTC at the start of d.
+func (d Date) Time() time.Time {
+	return time.Unix(int64(d)*24*60*60, 0).UTC()
+}
+
+// AddDays returns the date n days after d.
+func (d Date) AddDays(n int64) Date {
+	return d + Date(n)
+}
+
+func (d Date) String() string {
+	return d.Time().Format(dateLayout)
+}
+
+// TimeFromTime returns the time of day of t in its location, truncated to
+// the microsecond.
+func TimeFromTime(t time.Time) Time {
+	h, m, s := t.Clock()
+	return Time(int64(h*60*60+m*60+s)*microsPerSecond + int64(t.Nanosecond())/1000)
+}
+
+// Add returns the time of day that falls the time span of iv after t,
+// wrapping around midnight. The months and days of iv are ignored.
+func (t Time) Add(iv Interval) Time {
+	micros := (int64(t) + iv.Micros%microsPerDay) % microsPerDay
+	if micros < 0 {
+		micros += microsPerDay
+	}
+	return Time(micros)
+}
+
+func (t Time) String() string {
+	return formatTimeSpan(int64(t))
+}
+
+// TimestampFromTime returns t as a timestamp, truncated to the microsecond.
+func TimestampFromTime(t time.Time) Timestamp {
+	return Timestamp(t.UnixMicro())
+}
+
+// Time returns ts as a time in UTC.
+func (ts Timestamp) Time() time.Time {
+	return time.UnixMicro(int64(ts)).UTC()
+}
+
+// Date returns the date of ts.
+func (ts Timestamp) Date() Date {
+	return DateFromTime(ts.Time())
+}
+
+// AddInterval returns the timestamp iv after ts. The months of iv are
+// added first, moving to the last day of the month if ts falls on a day
+// past its end, followed by the days and the rest of the span.
+func (ts Timestamp) AddInterval(iv Interval) Timestamp {
+	t := ts.Time()
+	if iv.Months != 0 {
+		y, m, d := t.Date()
+		first := time.Date(y, m+time.Month(iv.Months), 1, 0, 0, 0, 0, time.UTC)
+		if last := first.AddDate(0, 1, -1).Day(); d > last {
+			d = last
+		}
+		h, min, s := t.Clock()
+		t = time.Date(first.Year(), first.Month(), d, h, min, s, t.Nanosecond(), time.UTC)
+	}
+	t = t.AddDate(0, 0, int(iv.Days))
+	return TimestampFromTime(t) + Timestamp(iv.Micros)
+}
+
+// Sub returns the interval between other and ts in days and the rest of the
+// span.
+func (ts Timestamp) Sub(other Timestamp) Interval {
+	diff := int64(ts - other)
+	return Interval{
+		Days:   int32(diff / microsPerDay),
+		Micros: diff % microsPerDay,
+	}
+}
+
+func (ts Timestamp) String() string {
+	t := ts.Time()
+	return t.Format(dateLayout) + " " + TimeFromTime(t).String()
+}
+
+// Add returns the sum of iv and other.
+func (iv Interval) Add(other Interval) Interval {
+	return Interval{
+		Months: iv.Months + other.Months,
+		Days:   iv.Days + other.Days,
+		Micros: iv.Micros + other.Micros,
+	}
+}
+
+// Neg returns iv negated.
+func (iv Interval) Neg() Interval {
+	return Interval{Months: -iv.Months, Days: -iv.Days, Micros: -iv.Micros}
+}
+
+// Length returns the length of iv in days and the microseconds past them,
+// counting a month as 30 days. The microseconds are never negative.
+func (iv Interval) Length() (int64, int64) {
+	days := int64(iv.Months)*30 + int64(iv.Days) + iv.Micros/microsPerDay
+	micros := iv.Micros % microsPerDay
+	if micros < 0 {
+		days--
+		micros += microsPerDay
+	}
+	return days, micros
+}
+
+// Cmp compares the lengths of iv and other, returning -1, 0 or 1. A month
+// counts as 30 days, so that 1 month and 30 days compare as equal.
+func (iv Interval) Cmp(other Interval) int {
+	d1, m1 := iv.Length()
+	d2, m2 := other.Length()
+	switch {
+	case d1 < d2 || d1 == d2 && m1 < m2:
+		return -1
+	case d1 > d2 || d1 == d2 && m1 > m2:
+		return 1
+	}
+	return 0
+}
+
+func (iv Interval) String() string {
+	var parts []string
+	plural := func(n int32, unit string) {
+		if n == 0 {
+			return
+		}
+		if n != 1 && n != -1 {
+			unit += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n, unit))
+	}
+	plural(iv.Months/12, "year")
+	plural(iv.Months%12, "mon")
+	plural(iv.Days, "day")
+	if iv.Micros != 0 || len(parts) == 0 {
+		if iv.Micros < 0 {
+			parts = append(parts, "-"+formatTimeSpan(-iv.Micros))
+		} else {
+			parts = append(parts, formatTimeSpan(iv.Micros))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatTimeSpan formats a non-negative span of micros microseconds as
+// hh:mm:ss, followed by the fraction of a second if there's one.
+func formatTimeSpan(micros int64) string {
+	secs := micros / microsPerSecond
+	str := fmt.Sprintf("%02d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	if frac := micros % microsPerSecond; frac != 0 {
+		str += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return str
+}
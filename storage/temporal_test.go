@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseTemporal(t *testing.T) {
+
+	tbl := []struct {
+		input  string
+		parse  func(string) (interface{}, error)
+		expect interface{}
+		str    string
+		err    error
+	}{
+		{input: "2026-01-01", parse: parseDate, expect: Date(20454), str: "2026-01-01"},
+		{input: "1969-12-31", parse: parseDate, expect: Date(-1), str: "1969-12-31"},
+		{input: "2026-01-01 23:59", parse: parseDate, expect: Date(20454), str: "2026-01-01"},
+		{input: "2026-02-30", parse: parseDate, err: ErrInvalidDatetime},
+		{input: "13:45", parse: parseTime, expect: Time(49500000000), str: "13:45:00"},
+		{input: "13:45:30.25", parse: parseTime, expect: Time(49530250000), str: "13:45:30.25"},
+		{input: "25:00", parse: parseTime, err: ErrInvalidDatetime},
+		{input: "2026-01-01 13:45:30", parse: parseTimestamp, expect: Timestamp(1767275130000000), str: "2026-01-01 13:45:30"},
+		{input: "2026-01-01T13:45:30.000001Z", parse: parseTimestamp, expect: Timestamp(1767275130000001), str: "2026-01-01 13:45:30.000001"},
+		{input: "2026-01-01T15:45:30+02:00", parse: parseTimestamp, expect: Timestamp(1767275130000000), str: "2026-01-01 13:45:30"},
+		{input: "2026-01-01", parse: parseTimestamp, expect: Timestamp(1767225600000000), str: "2026-01-01 00:00:00"},
+		{input: "yesterday", parse: parseTimestamp, err: ErrInvalidDatetime},
+		{input: "1 year 2 months 3 days 04:05:06", parse: parseInterval, expect: Interval{Months: 14, Days: 3, Micros: 14706000000}, str: "1 year 2 mons 3 days 04:05:06"},
+		{input: "-90 minutes", parse: parseInterval, expect: Interval{Micros: -5400000000}, str: "-01:30:00"},
+		{input: "2 weeks 1 SECOND", parse: parseInterval, expect: Interval{Days: 14, Micros: 1000000}, str: "14 days 00:00:01"},
+		{input: "P1Y2M3DT4H5M6.5S", parse: parseInterval, expect: Interval{Months: 14, Days: 3, Micros: 14706500000}, str: "1 year 2 mons 3 days 04:05:06.5"},
+		{input: "0 days", parse: parseInterval, expect: Interval{}, str: "00:00:00"},
+		{input: "1 fortnight", parse: parseInterval, err: ErrInvalidDatetime},
+		{input: "1", parse: parseInterval, err: ErrInvalidDatetime},
+		{input: "PT1Y", parse: parseInterval, err: ErrInvalidDatetime},
+	}
+
+	for _, test := range tbl {
+		actual, err := test.parse(test.input)
+		if !errors.Is(err, test.err) {
+			t.Errorf("parsing %q: expected error %v, got %v", test.input, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if actual != test.expect {
+			t.Errorf("parsing %q: expected %#v, got %#v", test.input, test.expect, actual)
+		}
+		if str := actual.(interface{ String() string }).String(); str != test.str {
+			t.Errorf("parsing %q: expected %s, got %s", test.input, test.str, str)
+		}
+	}
+}
+
+func parseDate(s string) (interface{}, error)      { return ParseDate(s) }
+func parseTime(s string) (interface{}, error)      { return ParseTime(s) }
+func parseTimestamp(s string) (interface{}, error) { return ParseTimestamp(s) }
+func parseInterval(s string) (interface{}, error)  { return ParseInterval(s) }
+
+func TestTemporalArithmetic(t *testing.T) {
+
+	ts := func(s string) Timestamp {
+		val, err := ParseTimestamp(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return val
+	}
+
+	// adding a month to the last day of a month yields the last day of the
+	// next month
+	tbl := []struct {
+		ts     Timestamp
+		iv     Interval
+		expect Timestamp
+	}{
+		{ts("2026-01-31 10:00"), Interval{Months: 1}, ts("2026-02-28 10:00")},
+		{ts("2024-01-31 10:00"), Interval{Months: 1}, ts("2024-02-29 10:00")},
+		{ts("2026-03-31"), Interval{Months: -1, Days: 1}, ts("2026-03-01")},
+		{ts("2026-12-15"), Interval{Months: 13}, ts("2028-01-15")},
+		{ts("2026-01-01 23:00"), Interval{Micros: 2 * 3600 * 1000000}, ts("2026-01-02 01:00")},
+	}
+	for _, test := range tbl {
+		if actual := test.ts.AddInterval(test.iv); actual != test.expect {
+			t.Errorf("%s + %s: expected %s, got %s", test.ts, test.iv, test.expect, actual)
+		}
+	}
+
+	if iv := ts("2026-01-02 01:00").Sub(ts("2026-01-01 00:30")); iv != (Interval{Days: 1, Micros: 1800000000}) {
+		t.Errorf("expected 1 day 00:30:00, got %s", iv)
+	}
+	if tm := Time(23 * 3600 * 1000000).Add(Interval{Days: 1, Micros: 2 * 3600 * 1000000}); tm != Time(3600*1000000) {
+		t.Errorf("expected 01:00:00, got %s", tm)
+	}
+	if tm := Time(0).Add(Interval{Micros: -1}); tm.String() != "23:59:59.999999" {
+		t.Errorf("expected 23:59:59.999999, got %s", tm)
+	}
+
+	// a month counts as 30 days when intervals are compared
+	if cmp := (Interval{Months: 1}).Cmp(Interval{Days: 30}); cmp != 0 {
+		t.Errorf("expected 1 month to equal 30 days, got %d", cmp)
+	}
+	if cmp := (Interval{Days: 1}).Cmp(Interval{Micros: 25 * 3600 * 1000000}); cmp != -1 {
+		t.Errorf("expected 1 day to be less than 25 hours, got %d", cmp)
+	}
+	if cmp := (Interval{Micros: -1}).Cmp(Interval{}); cmp != -1 {
+		t.Errorf("expected a negative interval to be less than zero, got %d", cmp)
+	}
+
+	if d := DateFromTime(time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC)); d.String() != "2026-01-01" {
+		t.Errorf("expected 2026-01-01, got %s", d)
+	}
+}
@@ -0,0 +1,361 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Files written before index support was added key their cells by a uint32
+// row ID instead of a length-prefixed byte string. Their header has no
+// format version, which reads as version 0, and their leaves are laid out
+// like the other leaves of format version 0.
+
+// size (in bytes) of a key cell of a file with integer keys
+const intKeyInternalCellSize = 4 + // field: key
+	8 // field: fileOffset
+
+// upgradeFileSuffix is appended to the path of a file with integer keys to
+// name the file that its upgrade is written to
+const upgradeFileSuffix = ".upgrade"
+
+// hasIntKeys returns true if the file was written before cells were keyed by
+// byte strings. Both kinds of file have format version 0, so they're told
+// apart by the root of the page table, whose rows are keyed by row ID in
+// either. An internal root has cells of intKeyInternalCellSize bytes only if
+// its keys are integers. The first cell of a leaf root holds the page table's
+// own row, whose row ID is 1, whereas a byte string key starts with its size,
+// which is 4 for a row ID.
+func (f *fileStore) hasIntKeys() (bool, error) {
+	if f.formatVersion != 0 {
+		return false, nil
+	}
+	page := make([]byte, pageSize)
+	if _, err := f.file.ReadAt(page, int64(f.pageTableRoot)); err != nil {
+		return false, err
+	}
+	if page[0] != InternalNode && page[0] != LeafNode {
+		return false, nil
+	}
+	n, err := decodeIntKeyNode(page)
+	if err != nil {
+		// the cells aren't laid out the way integer keys are
+		return false, nil
+	}
+	if n.isLeaf {
+		return len(n.offsets) > 0 && bytes.Equal(n.cellKey(n.offsets[0]), rowIDToKey(1)), nil
+	}
+	return true, nil
+}
+
+// decodeIntKeyNode reads a page of a file with integer keys. The keys are
+// converted to byte strings with rowIDToKey, which keeps their order. An
+// error is returned unless the cells end exactly at the end of the page.
+func decodeIntKeyNode(page []byte) (*btreeNode, error) {
+	buf := bytes.NewBuffer(page)
+	n := &btreeNode{isLeaf: page[0] == LeafNode}
+
+	var cellType byte
+	if err := binary.Read(buf, binary.LittleEndian, &cellType); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &n.fileOffset); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &n.lastLSN); err != nil {
+		return nil, err
+	}
+	if n.isLeaf {
+		if err := binary.Read(buf, binary.LittleEndian, &n.hasLSib); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &n.hasRSib); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &n.lSibFileOffset); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &n.rSibFileOffset); err != nil {
+			return nil, err
+		}
+	} else if err := binary.Read(buf, binary.LittleEndian, &n.rightOffset); err != nil {
+		return nil, err
+	}
+
+	var cellCount uint32
+	if err := binary.Read(buf, binary.LittleEndian, &cellCount); err != nil {
+		return nil, err
+	}
+	if int(cellCount)*offsetElemSize > buf.Len() {
+		return nil, fmt.Errorf("decoding error: %d cells don't fit on a page", cellCount)
+	}
+	n.offsets = make([]uint16, cellCount)
+	for i := range n.offsets {
+		if err := binary.Read(buf, binary.LittleEndian, &n.offsets[i]); err != nil {
+			return nil, err
+		}
+		if uint32(n.offsets[i]) >= cellCount {
+			return nil, fmt.Errorf("decoding error: cell offset %d is out of bounds", n.offsets[i])
+		}
+	}
+
+	var freeSize uint16
+	if err := binary.Read(buf, binary.LittleEndian, &freeSize); err != nil {
+		return nil, err
+	}
+	if int(freeSize) > buf.Len() {
+		return nil, fmt.Errorf("decoding error: free space of %d bytes exceeds the page", freeSize)
+	}
+	buf.Next(int(freeSize))
+
+	if n.isLeaf {
+		n.leafCells = make([]*leafCell, cellCount)
+	} else {
+		if buf.Len() != int(cellCount)*intKeyInternalCellSize {
+			return nil, fmt.Errorf("decoding error: expected %d bytes of cells, got %d", int(cellCount)*intKeyInternalCellSize, buf.Len())
+		}
+		n.internalCells = make([]*internalCell, cellCount)
+	}
+	for i := uint32(0); i < cellCount; i++ {
+		var rowID uint32
+		if err := binary.Read(buf, binary.LittleEndian, &rowID); err != nil {
+			return nil, err
+		}
+		if !n.isLeaf {
+			cell := &internalCell{key: rowIDToKey(rowID)}
+			if err := binary.Read(buf, binary.LittleEndian, &cell.fileOffset); err != nil {
+				return nil, err
+			}
+			n.internalCells[n.offsets[i]] = cell
+			continue
+		}
+		cell := &leafCell{key: rowIDToKey(rowID)}
+		if err := binary.Read(buf, binary.LittleEndian, &cell.deleted); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(buf, binary.LittleEndian, &cell.valueSize); err != nil {
+			return nil, err
+		}
+		if cell.valueSize > maxValueSize {
+			return nil, fmt.Errorf("decoding error: value of %d bytes exceeds %d bytes", cell.valueSize, maxValueSize)
+		}
+		cell.valueBytes = make([]byte, cell.valueSize)
+		if _, err := io.ReadFull(buf, cell.valueBytes); err != nil {
+			return nil, err
+		}
+		n.leafCells[n.offsets[i]] = cell
+	}
+	if buf.Len() != 0 {
+		return nil, fmt.Errorf("decoding error: %d bytes follow the last cell", buf.Len())
+	}
+
+	return n, nil
+}
+
+// upgradeIntKeys rewrites a file with integer keys in the current format. The
+// upgrade is written to a new file, which replaces the old one once it's
+// complete, so an upgrade that is interrupted leaves the old file as it was.
+func (f *fileStore) upgradeIntKeys() error {
+	path := f.file.Name()
+	file, err := os.OpenFile(path+upgradeFileSuffix, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := &fileStore{
+		_nextLSN:       f._nextLSN,
+		cache:          NewLRU(1),
+		file:           file,
+		formatVersion:  currentFormatVersion,
+		lastKey:        f.lastKey,
+		nextFreeOffset: f.nextFreeOffset,
+		pageTableRoot:  f.pageTableRoot,
+	}
+
+	// the leaves keep their pages
+	internalNodes := make(map[uint64]*btreeNode)
+	page := make([]byte, pageSize)
+	for offset := uint64(pageSize); offset < f.nextFreeOffset; offset += pageSize {
+		if _, err := f.file.ReadAt(page, int64(offset)); err == io.EOF {
+			// the pages at the end of the file were never written
+			break
+		} else if err != nil {
+			return err
+		}
+		n, err := decodeIntKeyNode(page)
+		if err != nil {
+			return fmt.Errorf("error reading page %d: %w", offset, err)
+		}
+		if !n.isLeaf {
+			internalNodes[offset] = n
+			continue
+		}
+		if err := out.writeNode(n); err != nil {
+			return err
+		}
+	}
+
+	if err := out.rebuildInternalNodes(internalNodes); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+upgradeFileSuffix, path); err != nil {
+		return err
+	}
+
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.file, err = os.OpenFile(path, os.O_RDWR, 0644); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// rebuildInternalNodes links the leaves of each tree of a file with integer
+// keys under new internal nodes, since the cells of the old ones take more
+// room with byte string keys. The root of a tree keeps its page, so that the
+// page table stays the same, and the other internal nodes take the pages of
+// the old ones. The pages left over go on the free list.
+func (f *fileStore) rebuildInternalNodes(internalNodes map[uint64]*btreeNode) error {
+	children := make(map[uint64]bool)
+	for _, n := range internalNodes {
+		for _, cell := range n.internalCells {
+			children[cell.fileOffset] = true
+		}
+		children[n.rightOffset] = true
+	}
+	var roots []uint64
+	for offset := range internalNodes {
+		if !children[offset] {
+			roots = append(roots, offset)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i] < roots[j]
+	})
+
+	var spare []uint64
+	for _, root := range roots {
+		// collect the leaves in key order along with their lower bounds,
+		// which separate them from the leaves before them
+		var leaves []uint64
+		var keys [][]byte
+		var walk func(offset uint64, lowKey []byte)
+		walk = func(offset uint64, lowKey []byte) {
+			n, ok := internalNodes[offset]
+			if !ok {
+				leaves = append(leaves, offset)
+				keys = append(keys, lowKey)
+				return
+			}
+			if offset != root {
+				spare = append(spare, offset)
+			}
+			for _, o := range n.offsets {
+				cell := n.internalCells[o]
+				walk(cell.fileOffset, lowKey)
+				lowKey = cell.key
+			}
+			walk(n.rightOffset, lowKey)
+		}
+		walk(root, nil)
+
+		level, levelKeys := leaves, keys
+		for len(level) > 1 {
+			bounds := parentBounds(levelKeys)
+			parentCount := len(bounds) - 1
+			parents := make([]uint64, parentCount)
+			parentKeys := make([][]byte, parentCount)
+			for i := range parents {
+				parent := &btreeNode{}
+				switch {
+				case parentCount == 1:
+					parent.fileOffset = root
+				case len(spare) > 0:
+					parent.fileOffset = spare[len(spare)-1]
+					spare = spare[:len(spare)-1]
+				default:
+					offset, err := f.allocPage()
+					if err != nil {
+						return err
+					}
+					parent.fileOffset = offset
+				}
+				lo, hi := bounds[i], bounds[i+1]
+				for j := lo; j < hi-1; j++ {
+					if err := parent.appendInternalCell(levelKeys[j+1], level[j]); err != nil {
+						return err
+					}
+				}
+				parent.setRightMostKey(level[hi-1])
+				if err := f.writeNode(parent); err != nil {
+					return err
+				}
+				parents[i] = parent.fileOffset
+				parentKeys[i] = levelKeys[lo]
+			}
+			level, levelKeys = parents, parentKeys
+		}
+	}
+
+	// freePages writes the file header along with the free list
+	return f.freePages(spare)
+}
+
+// writeNode writes node n to its page without caching it.
+func (f *fileStore) writeNode(n *btreeNode) error {
+	buf, err := n.encode()
+	if err != nil {
+		return err
+	}
+	_, err = f.file.WriteAt(buf.Bytes(), int64(n.fileOffset))
+	return err
+}
+
+// upgradeCatalog brings the system tables of a database upgraded from a file
+// with integer keys up to date. The system tables that were added since are
+// created, and the columns that were added to the schema table are recorded
+// in it.
+func (rs *RelationService) upgradeCatalog() error {
+	rs.fs.lockShared()
+	err := rs.addCatalogTables()
+	rs.fs.unlockShared()
+	if err != nil {
+		return err
+	}
+	return rs.fs.flushPages()
+}
+
+// addCatalogTables adds the system tables and schema table columns that are
+// missing to the catalog without writing them to disk. The store is locked
+// like it is by createTable.
+func (rs *RelationService) addCatalogTables() error {
+	r, err := rs.getRelationSchema(schemaTableName)
+	if err != nil {
+		return err
+	}
+	if len(r.Fields) < len(schemaTableSchema.Fields) {
+		added := &Relation{Fields: schemaTableSchema.Fields[len(r.Fields):]}
+		if err := rs.insertSchemaTable(added, schemaTableName); err != nil {
+			return err
+		}
+	}
+
+	if err := rs.ensureIndexTable(); err != nil {
+		return err
+	}
+	if err := rs.ensureStatsTable(); err != nil {
+		return err
+	}
+	if err := rs.ensureCheckTable(); err != nil {
+		return err
+	}
+	return rs.ensureForeignKeyTable()
+}
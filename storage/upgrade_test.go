@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// restoreFixture copies the gzipped files of testdata/<fixture> to the
+// directory of database dbName.
+func restoreFixture(t *testing.T, fixture string, dbName string) {
+	t.Helper()
+	if err := makeDBDir(dbName); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tbl", "wal"} {
+		in, err := os.Open(filepath.Join("testdata", fixture, name+".gz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer in.Close()
+		r, err := gzip.NewReader(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.Create(filepath.Join(dataPath, dbName, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(out, r); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestUpgradeIntKeys opens a database written before cells were keyed by
+// byte strings. It holds table people(id int, name varchar(32), active
+// boolean, score bigint) with the rows 1 to 1700, less row 10, which was
+// deleted, and row 20, whose name was updated to "renamed". Table
+// notes(body varchar(255)) holds "first" and "second".
+func TestUpgradeIntKeys(t *testing.T) {
+
+	defer ClearDataDir()
+
+	restoreFixture(t, "intkeys", "legacy")
+
+	if err := InitStorage(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("legacy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.fs.formatVersion != currentFormatVersion {
+		t.Errorf("expected format version %d, got %d", currentFormatVersion, rs.fs.formatVersion)
+	}
+	if _, err := os.Stat(rs.fs.file.Name() + upgradeFileSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected the upgrade file to be gone, got %v", err)
+	}
+
+	checkRows := func(rs *RelationService, extra int) {
+		t.Helper()
+		rs.StartTxn()
+		defer rs.EndTxn()
+		rows, _, err := rs.Fetch("people")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1699+extra {
+			t.Fatalf("expected %d rows, got %d", 1699+extra, len(rows))
+		}
+		id := int64(1)
+		for _, row := range rows[:1699] {
+			if id == 10 {
+				id++
+			}
+			name := fmt.Sprintf("person %d", id)
+			if id == 20 {
+				name = "renamed"
+			}
+			expected := []interface{}{id, name, id%2 == 0, id * 1000000000}
+			for i, val := range expected {
+				if row.Vals[i] != val {
+					t.Fatalf("expected row %d to have %v in column %d, got %v", id, val, i, row.Vals[i])
+				}
+			}
+			id++
+		}
+
+		rows, _, err = rs.Fetch("notes")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0].Vals[0] != "first" || rows[1].Vals[0] != "second" {
+			t.Errorf("expected the notes first and second, got %v", rows)
+		}
+	}
+	checkRows(rs, 0)
+
+	// the system tables added since the file was written are created
+	rs.StartTxn()
+	for _, table := range []string{indexTableName, StatsTableName, checkTableName, foreignKeyTableName} {
+		if _, err := rs.getRelationFileOffset(table); err != nil {
+			t.Errorf("expected table %s to exist, got %v", table, err)
+		}
+	}
+	r, err := rs.getRelationSchema(schemaTableName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Fields) != len(schemaTableSchema.Fields) {
+		t.Errorf("expected %s to have %d fields, got %d", schemaTableName, len(schemaTableSchema.Fields), len(r.Fields))
+	}
+	rs.EndTxn()
+
+	// the upgraded file takes new rows and indexes
+	rs.StartTxn()
+	batch, err := rs.Insert("people", []string{"id", "name", "active", "score"}, []interface{}{int64(1701), "newcomer", true, int64(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.FlushWALBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	rs.EndTxn()
+
+	idx := &Index{Name: "people_name_idx", TableName: "people", Columns: []string{"name"}}
+	if err := rs.CreateIndex(idx); err != nil {
+		t.Fatal(err)
+	}
+	rs.StartTxn()
+	for _, name := range []string{"person 1234", "renamed", "newcomer"} {
+		rows, _, err := rs.FetchByIndex(idx, []interface{}{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Errorf("expected 1 row named %s, got %d", name, len(rows))
+		}
+	}
+	rs.EndTxn()
+
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = OpenRelation("legacy", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	checkRows(rs, 1)
+}
+
+func TestHasIntKeys(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	// files with byte string keys that predate format versions aren't
+	// mistaken for ones with integer keys
+	rs.fs.formatVersion = 0
+	defer func() {
+		rs.fs.formatVersion = currentFormatVersion
+	}()
+
+	pg, err := rs.fs.fetch(rs.fs.pageTableRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := encodeLegacyLeaf(pg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.fs.writeImage(pg.fileOffset, buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	intKeys, err := rs.fs.hasIntKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intKeys {
+		t.Error("expected a leaf root with byte string keys to be told apart")
+	}
+
+	root := &btreeNode{fileOffset: rs.fs.pageTableRoot}
+	for i := uint32(1); i < 100; i++ {
+		if err := root.appendInternalCell(rowIDToKey(i+1), uint64(i)*pageSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root.setRightMostKey(100 * pageSize)
+	if err := rs.fs.writeNode(root); err != nil {
+		t.Fatal(err)
+	}
+	intKeys, err = rs.fs.hasIntKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intKeys {
+		t.Error("expected an internal root with byte string keys to be told apart")
+	}
+}
@@ -0,0 +1,391 @@
+package storage
+
+const (
+	// autoVacuumMinDeleted is the number of deleted cells that a tree needs
+	// before AutoVacuum vacuums its table
+	autoVacuumMinDeleted = 50
+	// autoVacuumDeletedRatio is the fraction of a tree's cells that need to
+	// be deleted before AutoVacuum vacuums its table
+	autoVacuumDeletedRatio = 0.2
+)
+
+// Vacuum removes the deleted rows of table tableName and the deleted entries
+// of its indexes, or of all tables including the system tables if tableName
+// is empty. The trees of the tables are rebuilt with full leaves, the pages
+// they were stored in are put on the free list, and the file is truncated if
+// it ends with free pages. Vacuuming all tables also frees the overflow pages
+// of values that were replaced.
+func (rs *RelationService) Vacuum(tableName string) error {
+	pages, err := rs.vacuumTables(tableName)
+	if err != nil {
+		return err
+	}
+
+	if err := rs.releasePages(pages); err != nil {
+		return err
+	}
+	if tableName == "" {
+		orphans, err := rs.orphanPages()
+		if err != nil {
+			return err
+		}
+		if err := rs.fs.freePages(orphans); err != nil {
+			return err
+		}
+	}
+	return rs.fs.shrink()
+}
+
+// vacuumTables rebuilds the trees of table tableName, or of all tables if
+// tableName is empty, and returns the pages of the old trees. The store is
+// locked the way a statement locks it, so that its pages aren't flushed
+// while they're rewritten.
+func (rs *RelationService) vacuumTables(tableName string) ([]uint64, error) {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
+	tables := []string{tableName}
+	if tableName == "" {
+		var err error
+		if tables, err = rs.tableNames(); err != nil {
+			return nil, err
+		}
+		for _, sysTable := range []string{schemaTableName, indexTableName, StatsTableName, checkTableName, foreignKeyTableName} {
+			if _, err := rs.getRelationFileOffset(sysTable); err == nil {
+				tables = append(tables, sysTable)
+			}
+		}
+		// the page table goes last, since vacuuming the other tables updates
+		// it
+		tables = append(tables, pageTableName)
+	}
+
+	var pages []uint64
+	for _, table := range tables {
+		trees, err := rs.vacuumableTrees(table)
+		if err != nil {
+			return nil, err
+		}
+		oldPages, err := rs.vacuumTrees(trees)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, oldPages...)
+		logf("vacuumed table %s", table)
+	}
+	return pages, nil
+}
+
+// AutoVacuum vacuums the tables that have a tree in which many cells are
+// deleted. The system tables are left alone.
+func (rs *RelationService) AutoVacuum() error {
+	if rs.InTxn() {
+		return nil
+	}
+
+	pages, err := rs.autoVacuumTables()
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return nil
+	}
+	return rs.releaseVacuumedPages(pages)
+}
+
+// autoVacuumTables rebuilds the trees of the tables that AutoVacuum vacuums
+// and returns the pages of the old trees. The store is locked like it is by
+// vacuumTables.
+func (rs *RelationService) autoVacuumTables() ([]uint64, error) {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
+	tables, err := rs.tableNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []uint64
+	for _, table := range tables {
+		trees, err := rs.vacuumableTrees(table)
+		if err != nil {
+			return nil, err
+		}
+		vacuum := false
+		for _, root := range trees {
+			live, deleted, err := rs.countCells(root)
+			if err != nil {
+				return nil, err
+			}
+			if deleted >= autoVacuumMinDeleted && float64(deleted) >= autoVacuumDeletedRatio*float64(live+deleted) {
+				vacuum = true
+			}
+		}
+		if !vacuum {
+			continue
+		}
+		oldPages, err := rs.vacuumTrees(trees)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, oldPages...)
+		logf("auto-vacuumed table %s", table)
+	}
+	return pages, nil
+}
+
+// vacuumableTrees returns the root page offsets of the trees of table
+// tableName and its indexes by name.
+func (rs *RelationService) vacuumableTrees(tableName string) (map[string]uint64, error) {
+	if tableName == pageTableName {
+		// the page table's own entry isn't updated when its root changes
+		return map[string]uint64{pageTableName: rs.fs.pageTableRoot}, nil
+	}
+	fileOffset, err := rs.getRelationFileOffset(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return rs.tableTrees(tableName, uint64(fileOffset))
+}
+
+// vacuumTrees rebuilds trees without their deleted cells and returns the
+// pages of the old trees.
+func (rs *RelationService) vacuumTrees(trees map[string]uint64) ([]uint64, error) {
+	var pages []uint64
+	for name, root := range trees {
+		oldPages, err := rs.treePages(root)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, oldPages...)
+		// the live cells keep their overflow pages in the new tree
+		chainPages, err := rs.chainPages(root, true)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, chainPages...)
+
+		pg, err := rs.fs.fetch(root)
+		if err != nil {
+			return nil, err
+		}
+		bt := BTree{store: rs.fs}
+		bt.setRoot(pg)
+
+		b := &treeBuilder{store: rs.fs, lsn: rs.fs.nextLSN()}
+		err = bt.scanRight(func(cell *leafCell) (ScanAction, error) {
+			if err := b.add(cell); err != nil {
+				return StopScanning, err
+			}
+			return KeepScanning, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		newRoot, err := b.root()
+		if err != nil {
+			return nil, err
+		}
+		rs.fs.incrLSN()
+
+		if name == pageTableName {
+			if err := rs.fs.setPageTableRoot(newRoot); err != nil {
+				return nil, err
+			}
+		} else if _, err := rs.updatePageTable(newRoot.getFileOffset(), name); err != nil {
+			return nil, err
+		}
+	}
+	return pages, nil
+}
+
+// releaseVacuumedPages puts the pages of the old trees on the free list and
+// gives the free pages at the end of the file back to the file system.
+func (rs *RelationService) releaseVacuumedPages(pages []uint64) error {
+	if err := rs.releasePages(pages); err != nil {
+		return err
+	}
+	return rs.fs.shrink()
+}
+
+// orphanPages returns the offsets of the pages that are neither free nor
+// part of a tree, such as the overflow pages of values that were replaced.
+// There must be no dirty pages.
+func (rs *RelationService) orphanPages() ([]uint64, error) {
+	rs.fs.lockShared()
+	defer rs.fs.unlockShared()
+
+	used := make(map[uint64]bool)
+
+	roots := []uint64{rs.fs.pageTableRoot}
+	_, err := rs.updateSysRows(pageTableName, &pageTableSchema, func(vals map[string]interface{}) sysRowAction {
+		// the page table's own entry isn't updated when its root changes
+		if vals["table_name"] != pageTableName {
+			roots = append(roots, uint64(vals["file_offset"].(int64)))
+		}
+		return keepSysRow
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		pages, err := rs.treePages(root)
+		if err != nil {
+			return nil, err
+		}
+		chainPages, err := rs.chainPages(root, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, offset := range append(pages, chainPages...) {
+			used[offset] = true
+		}
+	}
+
+	free, err := rs.fs.freeList()
+	if err != nil {
+		return nil, err
+	}
+	for _, offset := range free {
+		used[offset] = true
+	}
+
+	var orphans []uint64
+	for offset := uint64(initialPageTableOffset); offset < rs.fs.nextFreeOffset; offset += pageSize {
+		if !used[offset] {
+			orphans = append(orphans, offset)
+		}
+	}
+	return orphans, nil
+}
+
+// countCells returns the number of live and deleted cells in the leaves of
+// the tree rooted at offset root.
+func (rs *RelationService) countCells(root uint64) (int, int, error) {
+	pages, err := rs.treePages(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	live, deleted := 0, 0
+	for _, offset := range pages {
+		pg, err := rs.fs.fetch(offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !pg.isLeaf {
+			continue
+		}
+		for _, cell := range pg.leafCells {
+			if cell.deleted {
+				deleted++
+			} else {
+				live++
+			}
+		}
+	}
+	return live, deleted, nil
+}
+
+// treeBuilder builds a tree out of cells added in key order. The leaves are
+// filled until the next cell would no longer fit on the page, so that the
+// tree takes as few pages as possible.
+type treeBuilder struct {
+	store
+	lsn    uint64
+	leaves []*btreeNode
+	// keys holds the first key of each leaf
+	keys [][]byte
+}
+
+// add appends a copy of cell to the tree.
+func (b *treeBuilder) add(cell *leafCell) error {
+	n := len(b.leaves)
+	if n == 0 || b.leaves[n-1].leafSize()+offsetElemSize+cell.size() > pageSize {
+		leaf := &btreeNode{isLeaf: true}
+		if err := b.store.append(leaf); err != nil {
+			return err
+		}
+		leaf.markDirty(b.lsn)
+		if n > 0 {
+			prev := b.leaves[n-1]
+			prev.hasRSib = true
+			prev.rSibFileOffset = leaf.fileOffset
+			leaf.hasLSib = true
+			leaf.lSibFileOffset = prev.fileOffset
+		}
+		b.leaves = append(b.leaves, leaf)
+		b.keys = append(b.keys, cell.key)
+	}
+	leaf := b.leaves[len(b.leaves)-1]
+	if err := leaf.appendLeafCell(cell.key, cell.valueBytes); err != nil {
+		return err
+	}
+	newCell := leaf.leafCells[len(leaf.leafCells)-1]
+	newCell.overflowPages = cell.overflowPages
+	newCell.overflowSaved = cell.overflowSaved
+	return nil
+}
+
+// root links the leaves under as few internal nodes as possible and returns
+// the root of the tree.
+func (b *treeBuilder) root() (*btreeNode, error) {
+	if len(b.leaves) == 0 {
+		leaf := &btreeNode{isLeaf: true}
+		if err := b.store.append(leaf); err != nil {
+			return nil, err
+		}
+		leaf.markDirty(b.lsn)
+		return leaf, nil
+	}
+
+	level, keys := b.leaves, b.keys
+	for len(level) > 1 {
+		bounds := parentBounds(keys)
+		parentCount := len(bounds) - 1
+		parents := make([]*btreeNode, parentCount)
+		parentKeys := make([][]byte, parentCount)
+		for i := range parents {
+			lo, hi := bounds[i], bounds[i+1]
+			parent := &btreeNode{}
+			if err := b.store.append(parent); err != nil {
+				return nil, err
+			}
+			parent.markDirty(b.lsn)
+			for j := lo; j < hi-1; j++ {
+				if err := parent.appendInternalCell(keys[j+1], level[j].fileOffset); err != nil {
+					return nil, err
+				}
+			}
+			parent.setRightMostKey(level[hi-1].fileOffset)
+			parents[i] = parent
+			parentKeys[i] = keys[lo]
+		}
+		level, keys = parents, parentKeys
+	}
+	return level[0], nil
+}
+
+// parentBounds groups the nodes of a tree level, whose first keys are keys,
+// under as few parents as possible. The nodes of parent i are the ones from
+// bounds[i] up to bounds[i+1]. Each parent has at least two children, and
+// its cells, which hold the first keys of all but its first child, fit on a
+// page.
+func parentBounds(keys [][]byte) []int {
+	bounds := []int{0}
+	size := internalNodeHeaderSize
+	for i := 1; i < len(keys); i++ {
+		cellSize := offsetElemSize + internalCellHeaderSize + len(keys[i])
+		if size+cellSize > pageSize {
+			bounds = append(bounds, i)
+			size = internalNodeHeaderSize
+			continue
+		}
+		size += cellSize
+	}
+	// a page holds several cells, so the parent before a last parent with
+	// a single child can spare one
+	if n := len(bounds); n > 1 && bounds[n-1] == len(keys)-1 {
+		bounds[n-1]--
+	}
+	return append(bounds, len(keys))
+}
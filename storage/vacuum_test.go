@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestVacuum(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{Name: "id", DataType: TypeInt},
+			{Name: "name", DataType: TypeVarchar, Len: 255},
+		},
+		Indexes: []*Index{
+			{Name: "people_name_idx", TableName: "people", Columns: []string{"name"}},
+		},
+	}
+	if err := rs.CreateTable(r, "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(f func() (WALBatch, error)) {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		batch, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 400; i++ {
+		exec(func() (WALBatch, error) {
+			name := "odd"
+			if i%2 == 0 {
+				name = "even"
+			}
+			return rs.Insert("people", []string{"id", "name"}, []interface{}{int64(i), name})
+		})
+	}
+
+	rs.StartTxn()
+	rows, _, err := rs.Fetch("people")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if row.Vals[0].(int64)%10 == 0 {
+			continue
+		}
+		exec(func() (WALBatch, error) {
+			return rs.MarkDeleted("people", row.Key)
+		})
+	}
+
+	// the deleted rows are enough to trigger a vacuum
+	rs.StartTxn()
+	tableRoot, err := rs.getRelationFileOffset("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pagesBefore, err := rs.treePages(uint64(tableRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileEnd := rs.fs.nextFreeOffset
+	rs.EndTxn()
+
+	if err := rs.AutoVacuum(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs.StartTxn()
+	tableRoot, err = rs.getRelationFileOffset("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pagesAfter, err := rs.treePages(uint64(tableRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pagesAfter) >= len(pagesBefore) {
+		t.Errorf("expected the table to take fewer than %d pages, got %d", len(pagesBefore), len(pagesAfter))
+	}
+	live, deleted, err := rs.countCells(uint64(tableRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if live != 40 || deleted != 0 {
+		t.Errorf("expected 40 live and 0 deleted cells, got %d and %d", live, deleted)
+	}
+	rs.EndTxn()
+	if rs.fs.nextFreeOffset >= fileEnd && rs.fs.freeListHead == 0 {
+		t.Error("expected the file to shrink or the old pages to be free")
+	}
+
+	// the rows survive reopening the database
+	if err := rs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	rs.StartTxn()
+	rows, _, err = rs.Fetch("people")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 40 {
+		t.Fatalf("expected 40 rows, got %d", len(rows))
+	}
+	for i, row := range rows {
+		if id := row.Vals[0].(int64); id != int64(i*10) {
+			t.Errorf("expected id %d, got %d", i*10, id)
+		}
+	}
+	rs.StartTxn()
+	rows, _, err = rs.FetchByIndex(r.Indexes[0], []interface{}{"even"})
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 40 {
+		t.Errorf("expected 40 index entries, got %d", len(rows))
+	}
+
+	// the table keeps working after a vacuum of all the tables
+	if err := rs.Vacuum(""); err != nil {
+		t.Fatal(err)
+	}
+	exec(func() (WALBatch, error) {
+		return rs.Insert("people", []string{"id", "name"}, []interface{}{int64(400), "even"})
+	})
+	rs.StartTxn()
+	rows, _, err = rs.Fetch("people")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 41 {
+		t.Errorf("expected 41 rows, got %d", len(rows))
+	}
+	rs.StartTxn()
+	if _, err := rs.Schema("people"); err != nil {
+		t.Error(err)
+	}
+	rs.EndTxn()
+}
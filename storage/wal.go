@@ -6,6 +6,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 )
@@ -19,8 +20,17 @@ const (
 	OpInsert WALOp = iota
 	OpUpdate
 	OpDelete
+	OpIndexInsert
+	OpBegin
+	OpCommit
+	OpAbort
+	OpUndoImage
 )
 
+// walCheckpointThreshold is the WAL size in bytes past which a checkpoint is
+// taken automatically
+const walCheckpointThreshold = 4 << 20
+
 func InitStorage() error {
 	if err := MakeDataDir(); err != nil {
 		return fmt.Errorf("error creating data dir: %w", err)
@@ -59,15 +69,42 @@ func InitStorage() error {
 
 			defer fs.close()
 
-			batch, err := wal.read()
+			// the log of a file with integer keys has records of a format
+			// that isn't read anymore. The pages of such a file were written
+			// when its database was closed, and the file is upgraded when
+			// it's opened.
+			intKeys, err := fs.hasIntKeys()
+			if err != nil {
+				return err
+			}
+			if intKeys {
+				return wal.truncate(0)
+			}
+
+			batch, size, err := wal.read()
 			if err != nil {
 				return err
 			}
 
+			// drop a torn or corrupted tail so that new records are not
+			// appended after it
+			if err := wal.truncate(size); err != nil {
+				return err
+			}
+
+			if err := batch.undo(fs); err != nil {
+				return fmt.Errorf("WAL undo error: %w", err)
+			}
+
 			if err := batch.replay(fs); err != nil {
 				return fmt.Errorf("WAL replay error: %w", err)
 			}
-			return nil
+
+			// the replayed changes are on disk now, start with an empty log
+			if err := fs.checkpoint(); err != nil {
+				return err
+			}
+			return wal.truncate(0)
 		}()
 		if err != nil {
 			return err
@@ -80,8 +117,9 @@ func InitStorage() error {
 type WALEntry struct {
 	WALOp
 	LSN    uint64
+	txnID  uint64
 	pageID uint64
-	cellID uint32
+	key    []byte
 	val    []byte
 }
 
@@ -94,10 +132,16 @@ func (w *WALEntry) encode() (*bytes.Buffer, error) {
 	if err := binary.Write(buf, binary.LittleEndian, w.LSN); err != nil {
 		return nil, err
 	}
+	if err := binary.Write(buf, binary.LittleEndian, w.txnID); err != nil {
+		return nil, err
+	}
 	if err := binary.Write(buf, binary.LittleEndian, w.pageID); err != nil {
 		return nil, err
 	}
-	if err := binary.Write(buf, binary.LittleEndian, w.cellID); err != nil {
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(w.key))); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, w.key); err != nil {
 		return nil, err
 	}
 	if err := binary.Write(buf, binary.LittleEndian, uint32(len(w.val))); err != nil {
@@ -117,12 +161,17 @@ func (w *WALEntry) decode(buf *bytes.Buffer) error {
 	if err := binary.Read(buf, binary.LittleEndian, &w.LSN); err != nil {
 		return err
 	}
+	if err := binary.Read(buf, binary.LittleEndian, &w.txnID); err != nil {
+		return err
+	}
 	if err := binary.Read(buf, binary.LittleEndian, &w.pageID); err != nil {
 		return err
 	}
-	if err := binary.Read(buf, binary.LittleEndian, &w.cellID); err != nil {
+	key, err := decodeCellKey(buf)
+	if err != nil {
 		return err
 	}
+	w.key = key
 
 	var newSize uint32
 	if err := binary.Read(buf, binary.LittleEndian, &newSize); err != nil {
@@ -130,7 +179,7 @@ func (w *WALEntry) decode(buf *bytes.Buffer) error {
 	}
 
 	strBuf := make([]byte, newSize)
-	if _, err := buf.Read(strBuf); err != nil {
+	if _, err := io.ReadFull(buf, strBuf); err != nil {
 		return err
 	}
 	w.val = strBuf
@@ -147,9 +196,14 @@ func newWal(db string, forceSync bool) (*wal, error) {
 	if err != nil {
 		return nil, err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
 	return &wal{
 		reader:    file,
 		forceSync: forceSync,
+		size:      info.Size(),
 	}, nil
 }
 
@@ -158,55 +212,70 @@ type readWriteSyncCloser interface {
 	io.Writer
 	io.Closer
 	Sync() error
+	Truncate(size int64) error
 }
 
 type wal struct {
 	reader    readWriteSyncCloser
 	forceSync bool
+	size      int64
 }
 
 func (w *wal) close() error {
 	return w.reader.Close()
 }
 
-func (w *wal) read() (WALBatch, error) {
+// read returns the entries in the log along with the size in bytes of the
+// valid part of the log. Each record is framed as a length, a CRC32 checksum
+// and the encoded entry. Reading stops at the first record that is
+// incomplete or fails its checksum, since that's what a write interrupted by
+// a crash leaves behind.
+func (w *wal) read() (WALBatch, int64, error) {
 	var ret WALBatch
+	var size int64
 	reader := bufio.NewReader(w.reader)
-	tupleLenBuf := make([]byte, 4)
+	headerBuf := make([]byte, 8)
 
 	for {
-		if n, err := io.ReadFull(reader, tupleLenBuf); err == io.EOF {
+		if _, err := io.ReadFull(reader, headerBuf); err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		} else if err != nil {
-			return ret, err
-		} else if n != len(tupleLenBuf) {
-			panic("bytes read differs from expected buffer length")
+			return ret, size, err
 		}
 
-		tupleLen := int(binary.LittleEndian.Uint32(tupleLenBuf))
+		tupleLen := binary.LittleEndian.Uint32(headerBuf)
 		if tupleLen == 0 {
 			break
 		}
+		checksum := binary.LittleEndian.Uint32(headerBuf[4:])
 
 		tupleBuf := make([]byte, tupleLen)
-		if n, err := io.ReadFull(reader, tupleBuf); err != nil {
-			return ret, err
-		} else if n != tupleLen {
-			panic("bytes read differs from expected buffer length")
+		if _, err := io.ReadFull(reader, tupleBuf); err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return ret, size, err
+		}
+
+		if crc32.ChecksumIEEE(tupleBuf) != checksum {
+			break
 		}
 
 		w := &WALEntry{}
 		if err := w.decode(bytes.NewBuffer(tupleBuf)); err != nil {
-			return ret, err
+			break
 		}
 		ret = append(ret, w)
+		size += int64(len(headerBuf)) + int64(tupleLen)
 	}
 
-	return ret, nil
+	return ret, size, nil
 }
 
+// flush appends batch to the log. The entries are written with a single write
+// so that a batch is persisted as one unit.
 func (w *wal) flush(batch WALBatch) error {
-	tupleLenBuf := make([]byte, 4)
+	buf := &bytes.Buffer{}
+	headerBuf := make([]byte, 8)
 
 	for _, tuple := range batch {
 		tupleBuf, err := tuple.encode()
@@ -214,34 +283,114 @@ func (w *wal) flush(batch WALBatch) error {
 			return err
 		}
 
-		tupleLen := len(tupleBuf.Bytes())
-		binary.LittleEndian.PutUint32(tupleLenBuf, uint32(tupleLen))
+		binary.LittleEndian.PutUint32(headerBuf, uint32(tupleBuf.Len()))
+		binary.LittleEndian.PutUint32(headerBuf[4:], crc32.ChecksumIEEE(tupleBuf.Bytes()))
+		buf.Write(headerBuf)
+		buf.Write(tupleBuf.Bytes())
+	}
 
-		if n, err := w.reader.Write(tupleLenBuf); err != nil {
+	if n, err := w.reader.Write(buf.Bytes()); err != nil {
+		return err
+	} else if n != buf.Len() {
+		panic("bytes written differs from expected buffer length")
+	}
+	w.size += int64(buf.Len())
+
+	if w.forceSync {
+		if err := w.reader.Sync(); err != nil {
 			return err
-		} else if n != len(tupleLenBuf) {
-			panic("bytes written differs from expected buffer length")
 		}
+	}
+
+	return nil
+}
 
-		if n, err := w.reader.Write(tupleBuf.Bytes()); err != nil {
+// truncate discards everything in the log past the first size bytes.
+func (w *wal) truncate(size int64) error {
+	if err := w.reader.Truncate(size); err != nil {
+		return err
+	}
+	w.size = size
+	return w.reader.Sync()
+}
+
+// withTxnID assigns the entries in the batch to transaction txnID.
+func (w WALBatch) withTxnID(txnID uint64) WALBatch {
+	for _, row := range w {
+		row.txnID = txnID
+	}
+	return w
+}
+
+// committedTxns returns the IDs of the transactions that have a commit
+// record in the batch.
+func (w WALBatch) committedTxns() map[uint64]bool {
+	return w.txnsWithRecord(OpCommit)
+}
+
+func (w WALBatch) txnsWithRecord(op WALOp) map[uint64]bool {
+	txns := make(map[uint64]bool)
+	for _, row := range w {
+		if row.WALOp == op {
+			txns[row.txnID] = true
+		}
+	}
+	return txns
+}
+
+// undo restores the before-images of the pages that transactions which
+// neither committed nor rolled back wrote to disk before the crash. Only the
+// oldest image of each page is restored, since later images already contain
+// changes made by the same transaction.
+func (w WALBatch) undo(fs *fileStore) error {
+	committed := w.committedTxns()
+	aborted := w.txnsWithRecord(OpAbort)
+	restored := make(map[uint64]bool)
+
+	for _, row := range w {
+		if row.WALOp != OpUndoImage || row.LSN < fs.checkpointLSN {
+			continue
+		}
+		// a rolled back transaction restored its images before logging its
+		// abort record
+		if committed[row.txnID] || aborted[row.txnID] || restored[row.pageID] {
+			continue
+		}
+		if err := fs.writeImage(row.pageID, row.val); err != nil {
 			return err
-		} else if n != tupleLen {
-			panic("bytes written differs from expected buffer length")
 		}
+		restored[row.pageID] = true
+	}
 
-		if w.forceSync {
-			if err := w.reader.Sync(); err != nil {
-				return err
-			}
+	if restored[0] {
+		// reload the file header restored from its before-image
+		if err := fs.open(); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// replay applies the entries of committed transactions to the pages they
+// modified. Entries of transactions that never committed are skipped, as are
+// entries that precede the last checkpoint.
 func (w WALBatch) replay(fs *fileStore) error {
+	committed := w.committedTxns()
+
 	for _, row := range w {
-		fs._nextLSN = row.LSN
+		// LSNs are not necessarily in log order, e.g. the commit record of
+		// a transaction is assigned its LSN after the entries it covers
+		if row.LSN >= fs._nextLSN {
+			fs._nextLSN = row.LSN + 1
+		}
+		switch row.WALOp {
+		case OpBegin, OpCommit, OpAbort, OpUndoImage:
+			continue
+		}
+		if !committed[row.txnID] || row.LSN < fs.checkpointLSN {
+			continue
+		}
 		node, err := fs.fetch(row.pageID)
 		if err != nil {
 			return err
@@ -254,37 +403,36 @@ func (w WALBatch) replay(fs *fileStore) error {
 		case OpInsert:
 			bt := &BTree{store: fs}
 			bt.setRoot(node)
-			err = bt.insertKey(row.cellID, row.LSN, row.val)
+			err = bt.insertKey(row.key, row.LSN, row.val)
 			if err != nil && !errors.Is(err, errKeyAlreadyExists) {
 				return err
 			}
 			if err := fs.incrementLastKey(); err != nil {
 				return err
 			}
+		case OpIndexInsert:
+			bt := &BTree{store: fs}
+			bt.setRoot(node)
+			err = bt.insertKey(row.key, row.LSN, row.val)
+			if err != nil && !errors.Is(err, errKeyAlreadyExists) {
+				return err
+			}
 
 		case OpUpdate:
-			tuple := Tuple{
-				Relation: &pageTableSchema,
-				Vals:     make(map[string]interface{}),
-			}
-			if err := tuple.Decode(bytes.NewBuffer(row.val)); err != nil {
+			bt := &BTree{store: fs}
+			bt.setRoot(node)
+			if err := bt.updateKey(row.key, row.LSN, row.val); err != nil {
 				return err
 			}
-			err = node.updateCell(row.cellID, row.val)
-			if err != nil {
-				return nil
-			}
-			node.markDirty(row.LSN)
 		case OpDelete:
-			offset, found := node.findCellOffsetByKey(row.cellID)
+			offset, found := node.findCellOffsetByKey(row.key)
 			if !found {
-				return fmt.Errorf("unable to find cell for rowID %d", row.cellID)
+				return fmt.Errorf("unable to find cell for key %v", row.key)
 			}
-			node.leafCells[offset].deleted = true
+			node.leafCells[node.offsets[offset]].deleted = true
 			node.markDirty(row.LSN)
 		}
 	}
 
-	fs._nextLSN++
 	return fs.flushPages()
 }
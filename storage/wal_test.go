@@ -2,10 +2,13 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 type mockFile struct {
@@ -20,45 +23,137 @@ func (m *mockFile) Sync() error {
 	return nil
 }
 
+func (m *mockFile) Truncate(size int64) error {
+	m.Buffer.Truncate(int(size))
+	return nil
+}
+
 func TestWal(t *testing.T) {
 	w := &wal{
 		reader: &mockFile{
-			bytes.NewBuffer(make([]byte, 100)),
+			&bytes.Buffer{},
 		},
 		forceSync: true,
 	}
 
 	expBatch := WALBatch{
+		{
+			WALOp: OpBegin,
+			LSN:   1,
+			txnID: 1,
+			key:   []byte{},
+			val:   []byte{},
+		},
 		{
 			WALOp:  OpUpdate,
 			pageID: 1234,
-			cellID: 5678,
+			key:    rowIDToKey(5678),
 			val:    []byte{1, 2, 3, 4},
 		},
 		{
 			WALOp:  OpInsert,
 			pageID: 9101112,
-			cellID: 13141516,
+			key:    rowIDToKey(13141516),
 			val:    []byte{1, 2, 3, 4},
 		},
 		{
 			WALOp:  OpDelete,
 			pageID: 17181920,
-			cellID: 21222324,
+			key:    rowIDToKey(21222324),
+			val:    []byte{},
+		},
+		{
+			WALOp: OpCommit,
+			LSN:   2,
+			txnID: 1,
+			key:   []byte{},
+			val:   []byte{},
 		},
 	}
 
 	if err := w.flush(expBatch); err != nil {
 		t.Fatalf("failed flushing WAL batch: %s", err.Error())
 	}
+	expSize := int64(w.reader.(*mockFile).Len())
 
-	actlBatch, err := w.read()
+	actlBatch, size, err := w.read()
 	if err != nil {
 		t.Fatalf("failed reading WAL batch: %s", err.Error())
 	}
 
-	if reflect.DeepEqual(expBatch, actlBatch) {
-		t.Fatal("actual WAL batch does not match expected WAL batch")
+	if !reflect.DeepEqual(expBatch, actlBatch) {
+		t.Fatalf("actual WAL batch does not match expected WAL batch. expected: %v actual: %v", expBatch, actlBatch)
+	}
+
+	if size != expSize {
+		t.Fatalf("expected valid log size to be %d, got %d", expSize, size)
+	}
+}
+
+func TestWalTornTail(t *testing.T) {
+	batch := WALBatch{
+		{
+			WALOp:  OpInsert,
+			LSN:    1,
+			txnID:  1,
+			pageID: 4096,
+			key:    rowIDToKey(1),
+			val:    []byte{1, 2, 3, 4},
+		},
+		{
+			WALOp: OpCommit,
+			LSN:   2,
+			txnID: 1,
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	w := &wal{reader: &mockFile{buf}}
+	if err := w.flush(batch); err != nil {
+		t.Fatal(err)
+	}
+	validSize := buf.Len()
+	if err := w.flush(batch); err != nil {
+		t.Fatal(err)
+	}
+	log := buf.Bytes()
+
+	tests := []struct {
+		name string
+		log  []byte
+	}{
+		{
+			name: "partial record header",
+			log:  log[:validSize+3],
+		},
+		{
+			name: "partial record payload",
+			log:  log[:validSize+12],
+		},
+		{
+			name: "corrupted record payload",
+			log: func() []byte {
+				corrupted := append([]byte{}, log...)
+				corrupted[validSize+10] ^= 0xFF
+				return corrupted
+			}(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &wal{reader: &mockFile{bytes.NewBuffer(test.log)}}
+			actual, size, err := w.read()
+			if err != nil {
+				t.Fatalf("expected torn tail to be ignored, got error: %s", err.Error())
+			}
+			if len(actual) != len(batch) {
+				t.Fatalf("expected %d entries, got %d", len(batch), len(actual))
+			}
+			if size != int64(validSize) {
+				t.Fatalf("expected valid log size to be %d, got %d", validSize, size)
+			}
+		})
 	}
 }
 
@@ -81,10 +176,10 @@ func TestWalReplay(t *testing.T) {
 	}
 
 	pg := &btreeNode{isLeaf: true}
-	if err := pg.appendLeafCell(0, []byte{0, 0, 0, 0}); err != nil {
+	if err := pg.appendLeafCell(rowIDToKey(0), []byte{0, 0, 0, 0}); err != nil {
 		return
 	}
-	if err := pg.appendLeafCell(1, []byte{5, 6, 7, 8}); err != nil {
+	if err := pg.appendLeafCell(rowIDToKey(1), []byte{5, 6, 7, 8}); err != nil {
 		return
 	}
 
@@ -93,25 +188,62 @@ func TestWalReplay(t *testing.T) {
 	}
 
 	batch := WALBatch{
+		{
+			WALOp: OpBegin,
+			txnID: 1,
+			LSN:   1,
+		},
 		{
 			WALOp:  OpUpdate,
+			txnID:  1,
 			pageID: pg.getFileOffset(),
-			cellID: 0,
+			key:    rowIDToKey(0),
 			val:    []byte{1, 2, 3, 4},
-			LSN:    1,
+			LSN:    2,
 		},
 		{
 			WALOp:  OpDelete,
+			txnID:  1,
 			pageID: pg.getFileOffset(),
-			cellID: 1,
-			LSN:    2,
+			key:    rowIDToKey(1),
+			LSN:    3,
+		},
+		{
+			WALOp: OpBegin,
+			txnID: 4,
+			LSN:   4,
 		},
 		{
 			WALOp:  OpInsert,
+			txnID:  4,
 			pageID: pg.getFileOffset(),
-			cellID: 2,
+			key:    rowIDToKey(2),
 			val:    []byte{5, 6, 7, 8},
-			LSN:    3,
+			LSN:    5,
+		},
+		{
+			WALOp: OpCommit,
+			txnID: 1,
+			LSN:   6,
+		},
+		{
+			WALOp: OpCommit,
+			txnID: 4,
+			LSN:   7,
+		},
+		// this transaction never committed and must not be replayed
+		{
+			WALOp: OpBegin,
+			txnID: 8,
+			LSN:   8,
+		},
+		{
+			WALOp:  OpUpdate,
+			txnID:  8,
+			pageID: pg.getFileOffset(),
+			key:    rowIDToKey(2),
+			val:    []byte{9, 9, 9, 9},
+			LSN:    9,
 		},
 	}
 
@@ -119,6 +251,10 @@ func TestWalReplay(t *testing.T) {
 		t.Fatalf("failed to replay WAL batch: %s", err.Error())
 	}
 
+	if fs.nextLSN() != 10 {
+		t.Fatalf("expected next LSN to be 10, got %d", fs.nextLSN())
+	}
+
 	if err := fs.close(); err != nil {
 		t.Fatal(err)
 	}
@@ -153,3 +289,466 @@ func TestWalReplay(t *testing.T) {
 		t.Fatalf("cell payload is not the same. expected: %v actual: %v", expected, actual)
 	}
 }
+
+func TestWalReplayAfterCheckpoint(t *testing.T) {
+
+	file, err := ioutil.TempFile("", "fs")
+	if err != nil {
+		t.Fatalf("error creating tmp file: %s", err.Error())
+	}
+	defer os.Remove(file.Name())
+
+	fs, err := newFileStore(file.Name(), false)
+	if err != nil {
+		t.Fatalf("error creating file store: %s", err.Error())
+	}
+	fs.nextFreeOffset = pageSize
+
+	pg := &btreeNode{isLeaf: true}
+	if err := pg.appendLeafCell(rowIDToKey(0), []byte{0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	pg.markDirty(0)
+	if err := fs.append(pg); err != nil {
+		t.Fatalf("failed to append page: %s", err.Error())
+	}
+
+	fs._nextLSN = 4
+	if err := fs.checkpoint(); err != nil {
+		t.Fatalf("failed to checkpoint: %s", err.Error())
+	}
+
+	// reopen the store to verify that the checkpoint LSN is persisted
+	if err := fs.close(); err != nil {
+		t.Fatal(err)
+	}
+	fs, err = newFileStore(file.Name(), false)
+	if err != nil {
+		t.Fatalf("error creating file store: %s", err.Error())
+	}
+	if err := fs.open(); err != nil {
+		t.Fatal(err)
+	}
+	if fs.checkpointLSN != 4 {
+		t.Fatalf("expected checkpoint LSN to be 4, got %d", fs.checkpointLSN)
+	}
+
+	batch := WALBatch{
+		{WALOp: OpBegin, txnID: 1, LSN: 1},
+		// precedes the checkpoint, must not be replayed
+		{WALOp: OpUpdate, txnID: 1, pageID: pg.getFileOffset(), key: rowIDToKey(0), val: []byte{1, 1, 1, 1}, LSN: 2},
+		{WALOp: OpCommit, txnID: 1, LSN: 3},
+		{WALOp: OpBegin, txnID: 4, LSN: 4},
+		{WALOp: OpInsert, txnID: 4, pageID: pg.getFileOffset(), key: rowIDToKey(1), val: []byte{2, 2, 2, 2}, LSN: 5},
+		{WALOp: OpCommit, txnID: 4, LSN: 6},
+	}
+
+	if err := batch.replay(fs); err != nil {
+		t.Fatalf("failed to replay WAL batch: %s", err.Error())
+	}
+
+	pg, err = fs.fetch(pg.getFileOffset())
+	if err != nil {
+		t.Fatalf("failed to fetch page: %s", err)
+	}
+
+	if !reflect.DeepEqual(pg.leafCells[0].valueBytes, []byte{0, 0, 0, 0}) {
+		t.Fatalf("expected entry preceding checkpoint to be skipped, got cell payload %v", pg.leafCells[0].valueBytes)
+	}
+	if len(pg.leafCells) != 2 || !reflect.DeepEqual(pg.leafCells[1].valueBytes, []byte{2, 2, 2, 2}) {
+		t.Fatal("expected entry following checkpoint to be replayed")
+	}
+}
+
+func TestStealRecovery(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{
+				Name:     "id",
+				DataType: TypeInt,
+			},
+			{
+				Name:     "bio",
+				DataType: TypeVarchar,
+				Len:      255,
+			},
+		},
+	}
+	if err := rs.CreateTable(r, "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	// shrink the cache so that inserts have to steal pages
+	rs.fs.cache.maxNodes = 4
+
+	insertRows := func(rs *RelationService, from, to int) {
+		for i := from; i < to; i++ {
+			rs.StartTxn()
+			// the rows are wide enough to fill a few pages
+			batch, err := rs.Insert("people", []string{"id", "bio"}, []interface{}{int64(i), strings.Repeat("x", 100)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rs.FlushWALBatch(batch); err != nil {
+				t.Fatal(err)
+			}
+			rs.EndTxn()
+		}
+	}
+
+	countRows := func(rs *RelationService) int {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		rows, _, err := rs.Fetch("people")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	// changes of a rolled back transaction are undone, including the ones
+	// written to disk
+	if err := rs.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	insertRows(rs, 0, 200)
+	if len(rs.undoImages) == 0 {
+		t.Fatal("expected transaction to steal pages")
+	}
+	if err := rs.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if count := countRows(rs); count != 0 {
+		t.Fatalf("expected 0 rows after rollback, got %d", count)
+	}
+
+	if err := rs.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	insertRows(rs, 0, 200)
+	if err := rs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// crash in the middle of a transaction that stole pages
+	if err := rs.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	insertRows(rs, 200, 400)
+	rs.fs.ticker.Stop()
+	rs.fs.tickerDone <- true
+	if err := rs.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.fs.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	if count := countRows(rs); count != 200 {
+		t.Fatalf("expected 200 committed rows after recovery, got %d", count)
+	}
+}
+
+func TestStealKeepsPagesInUse(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	idx := &Index{Name: "people_bio_idx", TableName: "people", Columns: []string{"bio"}}
+	r := &Relation{
+		Fields: []FieldDef{
+			{
+				Name:     "id",
+				DataType: TypeInt,
+			},
+			{
+				Name:     "bio",
+				DataType: TypeVarchar,
+				Len:      700,
+			},
+		},
+		Indexes: []*Index{idx},
+	}
+	if err := rs.CreateTable(r, "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	// shrink the cache so that the pages a split modifies don't all fit.
+	// the long keys make for a deep index tree.
+	rs.fs.cache.maxNodes = 4
+
+	bio := func(i int) string {
+		return fmt.Sprintf("%03d%s", i, strings.Repeat("x", 690))
+	}
+	const rowCount = 100
+	for i := 0; i < rowCount; i++ {
+		if err := rs.StartTxn(); err != nil {
+			t.Fatal(err)
+		}
+		batch, err := rs.Insert("people", []string{"id", "bio"}, []interface{}{int64(i), bio(i * 7 % rowCount)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+		rs.EndTxn()
+	}
+
+	for i := 0; i < rowCount; i++ {
+		rs.StartTxn()
+		rows, _, err := rs.FetchByIndex(idx, []interface{}{bio(i)})
+		rs.EndTxn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 {
+			t.Fatalf("expected 1 row for bio %d, got %d", i, len(rows))
+		}
+	}
+}
+
+func TestAbortStmt(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{Name: "id", DataType: TypeInt},
+			{Name: "bio", DataType: TypeVarchar, Len: 255},
+		},
+	}
+	if err := rs.CreateTable(r, "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	insert := func(i int) {
+		batch, err := rs.Insert("people", []string{"id", "bio"}, []interface{}{int64(i), strings.Repeat("x", 100)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	countRows := func(rs *RelationService) int {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		rows, _, err := rs.Fetch("people")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows)
+	}
+
+	rs.StartTxn()
+	insert(0)
+	rs.EndTxn()
+
+	// shrink the cache so that the statement has to steal pages
+	rs.fs.cache.maxNodes = 4
+
+	// a statement that fails part way leaves no changes behind, including the
+	// ones written to disk
+	if err := rs.StartTxn(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 200; i++ {
+		if _, err := rs.Insert("people", []string{"id", "bio"}, []interface{}{int64(i), strings.Repeat("x", 100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rs.undoImages) == 0 {
+		t.Fatal("expected statement to steal pages")
+	}
+	if err := rs.AbortStmt(); err != nil {
+		t.Fatal(err)
+	}
+	rs.EndTxn()
+
+	if count := countRows(rs); count != 1 {
+		t.Fatalf("expected 1 row after the aborted statement, got %d", count)
+	}
+
+	rs.StartTxn()
+	insert(200)
+	rs.EndTxn()
+
+	// give the background page flusher a chance to run, then crash
+	time.Sleep(300 * time.Millisecond)
+	rs.fs.ticker.Stop()
+	rs.fs.tickerDone <- true
+	if err := rs.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.fs.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	rs.StartTxn()
+	rows, _, err := rs.Fetch("people")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []interface{}
+	for _, row := range rows {
+		ids = append(ids, row.Vals[0])
+	}
+	if expected := []interface{}{int64(0), int64(200)}; !reflect.DeepEqual(expected, ids) {
+		t.Errorf("expected rows %v after recovery, got %v", expected, ids)
+	}
+}
+
+func TestAlterTableRecovery(t *testing.T) {
+
+	defer ClearDataDir()
+
+	if err := CreateDB("testdb"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Relation{
+		Fields: []FieldDef{
+			{Name: "id", DataType: TypeInt},
+			{Name: "name", DataType: TypeVarchar, Len: 255},
+		},
+	}
+	if err := rs.CreateTable(r, "people"); err != nil {
+		t.Fatal(err)
+	}
+
+	alter := func(f func() (WALBatch, error)) {
+		rs.StartTxn()
+		defer rs.EndTxn()
+		batch, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.FlushWALBatch(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs.StartTxn()
+	batch, err := rs.Insert("people", []string{"id", "name"}, []interface{}{int64(1), "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.FlushWALBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	rs.EndTxn()
+
+	alter(func() (WALBatch, error) {
+		return rs.AddColumn("people", FieldDef{Name: "age", DataType: TypeInt, Default: int64(20)})
+	})
+	alter(func() (WALBatch, error) {
+		return rs.DropColumn("people", "name")
+	})
+	alter(func() (WALBatch, error) {
+		return rs.RenameColumn("people", "age", "years")
+	})
+	alter(func() (WALBatch, error) {
+		return rs.RenameTable("people", "persons")
+	})
+
+	// crash before the catalog pages are written to disk
+	rs.fs.ticker.Stop()
+	rs.fs.tickerDone <- true
+	if err := rs.wal.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.fs.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitStorage(); err != nil {
+		t.Fatalf("error recovering storage: %s", err.Error())
+	}
+
+	rs, err = OpenRelation("testdb", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+
+	rs.StartTxn()
+	if _, err := rs.Schema("people"); err != ErrTableNotExist {
+		t.Errorf("expected ErrTableNotExist error, got %v", err)
+	}
+	rs.EndTxn()
+
+	rs.StartTxn()
+	rows, fields, err := rs.Fetch("persons")
+	rs.EndTxn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cols []string
+	for _, f := range fields {
+		cols = append(cols, f.Column.(string))
+	}
+	if expected := []string{"id", "years"}; !reflect.DeepEqual(expected, cols) {
+		t.Errorf("unexpected columns. expected: %v actual: %v", expected, cols)
+	}
+	if len(rows) != 1 || !reflect.DeepEqual([]interface{}{int64(1), int64(20)}, rows[0].Vals) {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+}
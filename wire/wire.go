@@ -0,0 +1,337 @@
+// Package wire implements the framed protocol spoken between cmd/server and
+// its clients.
+//
+// Every message is sent as a frame made of a one-byte message type, the
+// payload length as a big-endian uint32, and the payload itself:
+//
+//	+------+----------+-----------------+
+//	| type | length   | payload         |
+//	| 1B   | 4B       | length bytes    |
+//	+------+----------+-----------------+
+//
+// Messages sent by the client:
+//
+//	'Q' Query      payload: SQL text
+//	'X' Terminate  empty payload; the server closes the connection
+//
+// Messages sent by the server:
+//
+//	'Z' Ready      empty payload; the server is ready for the next query
+//	'T' Columns    payload: column count as a big-endian uint16, followed by
+//	               each column's name as a string and its type tag
+//	'D' Row        payload: value count as a big-endian uint16, followed by
+//	               each value
+//	'C' Complete   payload: status message text
+//	'E' Error      payload: error message text
+//
+// Strings inside the Columns payload are encoded as a big-endian uint32
+// length followed by the UTF-8 bytes. A column's type tag is the one-byte tag
+// that its non-NULL values are sent with, so clients know the type even of a
+// column that holds no values. Row values are a one-byte type tag followed by
+// the value's data:
+//
+//	0 NULL      no data
+//	1 INT       8 bytes, big-endian two's complement
+//	2 STRING    big-endian uint32 length followed by the UTF-8 bytes
+//	3 BOOL      1 byte, 0 for false and 1 for true
+//	4 FLOAT     8 bytes, big-endian IEEE 754 double
+//	5 DECIMAL   big-endian uint32 length followed by the decimal digits,
+//	            e.g. -12.50
+//	6 DATE      4 bytes, big-endian two's complement days since 1970-01-01
+//	7 TIME      8 bytes, big-endian two's complement microseconds since
+//	            midnight
+//	8 TIMESTAMP 8 bytes, big-endian two's complement microseconds since
+//	            1970-01-01 00:00:00 UTC
+//	9 INTERVAL  16 bytes: months and days as big-endian two's complement
+//	            int32s, followed by microseconds as a big-endian two's
+//	            complement int64
+//
+// The server sends Ready as soon as it accepts a connection. It answers each
+// Query with either Columns, zero or more Rows and Complete for statements
+// that return rows, Complete alone for statements that don't, or Error,
+// and then sends Ready again. An Error may follow Rows that were already
+// sent if a statement fails part way through.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/mk6i/mkdb/storage"
+)
+
+type MsgType byte
+
+const (
+	MsgQuery     MsgType = 'Q'
+	MsgTerminate MsgType = 'X'
+	MsgReady     MsgType = 'Z'
+	MsgColumns   MsgType = 'T'
+	MsgRow       MsgType = 'D'
+	MsgComplete  MsgType = 'C'
+	MsgError     MsgType = 'E'
+)
+
+// Type tags of row values and columns.
+const (
+	TypeNull byte = iota
+	TypeInt
+	TypeString
+	TypeBool
+	TypeFloat
+	TypeDecimal
+	TypeDate
+	TypeTime
+	TypeTimestamp
+	TypeInterval
+)
+
+// maxFrameSize bounds the payload a peer can make us allocate.
+const maxFrameSize = 16 << 20
+
+var (
+	ErrFrameTooLarge = errors.New("frame exceeds maximum size")
+	ErrMalformed     = errors.New("malformed message payload")
+)
+
+// WriteFrame writes a message of type t with the given payload to w.
+func WriteFrame(w io.Writer, t MsgType, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+	hdr := [5]byte{byte(t)}
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// ReadFrame reads the next message from r.
+func ReadFrame(r io.Reader) (MsgType, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > maxFrameSize {
+		return 0, nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return MsgType(hdr[0]), payload, nil
+}
+
+// Column describes a column of a result set.
+type Column struct {
+	Name string
+	// Type is the tag that the column's values are sent with, e.g. TypeInt.
+	Type byte
+}
+
+// EncodeColumns returns the payload of a Columns message.
+func EncodeColumns(cols []Column) ([]byte, error) {
+	if len(cols) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many columns: %d", len(cols))
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint16(len(cols)))
+	for _, col := range cols {
+		if col.Type > TypeInterval {
+			return nil, fmt.Errorf("unknown type tag %d of column %s", col.Type, col.Name)
+		}
+		writeString(buf, col.Name)
+		buf.WriteByte(col.Type)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeColumns parses the payload of a Columns message.
+func DecodeColumns(payload []byte) ([]Column, error) {
+	r := bytes.NewReader(payload)
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, ErrMalformed
+	}
+	cols := make([]Column, count)
+	for i := range cols {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		if tag > TypeInterval {
+			return nil, fmt.Errorf("%w: unknown column type %d", ErrMalformed, tag)
+		}
+		cols[i] = Column{Name: name, Type: tag}
+	}
+	if r.Len() > 0 {
+		return nil, ErrMalformed
+	}
+	return cols, nil
+}
+
+// EncodeRow returns the payload of a Row message. Values must be nil,
+// int64, float64, storage.Decimal, storage.Date, storage.Time,
+// storage.Timestamp, storage.Interval, string or bool.
+func EncodeRow(vals []interface{}) ([]byte, error) {
+	if len(vals) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many values: %d", len(vals))
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint16(len(vals)))
+	for _, val := range vals {
+		switch val := val.(type) {
+		case nil:
+			buf.WriteByte(TypeNull)
+		case int64:
+			buf.WriteByte(TypeInt)
+			binary.Write(buf, binary.BigEndian, val)
+		case float64:
+			buf.WriteByte(TypeFloat)
+			binary.Write(buf, binary.BigEndian, val)
+		case storage.Decimal:
+			buf.WriteByte(TypeDecimal)
+			writeString(buf, val.String())
+		case storage.Date:
+			buf.WriteByte(TypeDate)
+			binary.Write(buf, binary.BigEndian, val)
+		case storage.Time:
+			buf.WriteByte(TypeTime)
+			binary.Write(buf, binary.BigEndian, val)
+		case storage.Timestamp:
+			buf.WriteByte(TypeTimestamp)
+			binary.Write(buf, binary.BigEndian, val)
+		case storage.Interval:
+			buf.WriteByte(TypeInterval)
+			binary.Write(buf, binary.BigEndian, val)
+		case string:
+			buf.WriteByte(TypeString)
+			writeString(buf, val)
+		case bool:
+			buf.WriteByte(TypeBool)
+			if val {
+				buf.WriteByte(1)
+			} else {
+				buf.WriteByte(0)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported value type %T", val)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeRow parses the payload of a Row message.
+func DecodeRow(payload []byte) ([]interface{}, error) {
+	r := bytes.NewReader(payload)
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, ErrMalformed
+	}
+	vals := make([]interface{}, count)
+	for i := range vals {
+		tag, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		switch tag {
+		case TypeNull:
+		case TypeInt:
+			var v int64
+			if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+				return nil, ErrMalformed
+			}
+			vals[i] = v
+		case TypeString:
+			v, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+			vals[i] = v
+		case TypeBool:
+			b, err := r.ReadByte()
+			if err != nil || b > 1 {
+				return nil, ErrMalformed
+			}
+			vals[i] = b == 1
+		case TypeFloat:
+			var v float64
+			if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+				return nil, ErrMalformed
+			}
+			vals[i] = v
+		case TypeDecimal:
+			str, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := storage.ParseDecimal(str)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrMalformed, err)
+			}
+			vals[i] = v
+		case TypeDate:
+			var v storage.Date
+			if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+				return nil, ErrMalformed
+			}
+			vals[i] = v
+		case TypeTime:
+			var v storage.Time
+			if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+				return nil, ErrMalformed
+			}
+			vals[i] = v
+		case TypeTimestamp:
+			var v storage.Timestamp
+			if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+				return nil, ErrMalformed
+			}
+			vals[i] = v
+		case TypeInterval:
+			var v storage.Interval
+			if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+				return nil, ErrMalformed
+			}
+			vals[i] = v
+		default:
+			return nil, fmt.Errorf("%w: unknown value type %d", ErrMalformed, tag)
+		}
+	}
+	if r.Len() > 0 {
+		return nil, ErrMalformed
+	}
+	return vals, nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return "", ErrMalformed
+	}
+	if int64(size) > int64(r.Len()) {
+		return "", ErrMalformed
+	}
+	b := make([]byte, size)
+	r.Read(b)
+	return string(b), nil
+}
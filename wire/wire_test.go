@@ -0,0 +1,143 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/mk6i/mkdb/storage"
+)
+
+func TestFrame(t *testing.T) {
+	buf := &bytes.Buffer{}
+
+	if err := WriteFrame(buf, MsgQuery, []byte("SELECT 1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFrame(buf, MsgReady, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{'Q', 0, 0, 0, 8, 'S', 'E', 'L', 'E', 'C', 'T', ' ', '1', 'Z', 0, 0, 0, 0}
+	if !bytes.Equal(expected, buf.Bytes()) {
+		t.Fatalf("unexpected frames: %v", buf.Bytes())
+	}
+
+	typ, payload, err := ReadFrame(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != MsgQuery || string(payload) != "SELECT 1" {
+		t.Errorf("unexpected frame %q %q", typ, payload)
+	}
+
+	typ, payload, err = ReadFrame(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != MsgReady || len(payload) != 0 {
+		t.Errorf("unexpected frame %q %q", typ, payload)
+	}
+
+	if _, _, err := ReadFrame(buf); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{'C', 0, 0, 0, 8, 'd', 'o', 'n'})
+	if _, _, err := ReadFrame(buf); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{'Q', 0xFF, 0xFF, 0xFF, 0xFF})
+	if _, _, err := ReadFrame(buf); err != ErrFrameTooLarge {
+		t.Errorf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestColumns(t *testing.T) {
+	cols := []Column{
+		{Name: "person_id", Type: TypeInt},
+		{Name: "", Type: TypeNull},
+		{Name: "first_name", Type: TypeString},
+	}
+
+	payload, err := EncodeColumns(cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := DecodeColumns(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cols, actual) {
+		t.Errorf("expected %v, got %v", cols, actual)
+	}
+
+	if _, err := EncodeColumns([]Column{{Name: "a", Type: 99}}); err == nil {
+		t.Error("expected error encoding unknown type tag")
+	}
+}
+
+func TestRow(t *testing.T) {
+	vals := []interface{}{
+		nil, int64(math.MinInt64), int64(42), "", "hello", true, false, -1.5, storage.NewDecimal(-1250, 2),
+		storage.Date(-1), storage.Time(3600000000), storage.Timestamp(1767225600000000),
+		storage.Interval{Months: 1, Days: -2, Micros: 3},
+	}
+
+	payload, err := EncodeRow(vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := DecodeRow(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vals, actual) {
+		t.Errorf("expected %v, got %v", vals, actual)
+	}
+
+	if _, err := EncodeRow([]interface{}{int32(1)}); err == nil {
+		t.Error("expected error encoding unsupported type")
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	tbl := [][]byte{
+		{},
+		{0, 1},
+		{0, 1, 99},
+		{0, 1, TypeInt, 0, 0},
+		{0, 1, TypeString, 0, 0, 0, 5, 'a'},
+		{0, 1, TypeBool, 2},
+		{0, 1, TypeNull, 0},
+		{0, 1, TypeFloat, 0, 0, 0},
+		{0, 1, TypeDecimal, 0, 0, 0, 1, 'x'},
+		{0, 1, TypeDate, 0, 0},
+		{0, 1, TypeInterval, 0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	for _, payload := range tbl {
+		if _, err := DecodeRow(payload); !errors.Is(err, ErrMalformed) {
+			t.Errorf("expected ErrMalformed for %v, got %v", payload, err)
+		}
+	}
+
+	cols := [][]byte{
+		{0, 1, 0, 0, 0, 3, 'a'},
+		{0, 1, 0, 0, 0, 1, 'a'},
+		{0, 1, 0, 0, 0, 1, 'a', 99},
+		{0, 1, 0, 0, 0, 1, 'a', TypeInt, 0},
+	}
+	for _, payload := range cols {
+		if _, err := DecodeColumns(payload); !errors.Is(err, ErrMalformed) {
+			t.Errorf("expected ErrMalformed for %v, got %v", payload, err)
+		}
+	}
+}